@@ -5,28 +5,47 @@
 package libconfd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Application struct {
 	cfg    *Config
 	client BackendClient
+
+	reloader func() (*Config, BackendClient, error)
 }
 
 func NewApplication(cfg *Config, client BackendClient) *Application {
+	if cfg.Offline {
+		client = NewSnapshotBackendClient(cfg.SnapshotFile)
+	}
 	return &Application{
 		cfg:    cfg.Clone(),
 		client: client,
 	}
 }
 
+// SetReloader sets the function called by Run on SIGHUP, the returned
+// config and client are applied by Processor.Reload.
+func (p *Application) SetReloader(fn func() (*Config, BackendClient, error)) {
+	p.reloader = fn
+}
+
 func (p *Application) List(re string) {
-	_, paths, err := ListTemplateResource(p.cfg.ConfDir)
+	_, paths, err := ListLayeredTemplateResource(p.cfg.GetConfDirs()...)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -48,7 +67,7 @@ func (p *Application) List(re string) {
 
 func (p *Application) Info(names ...string) {
 	if len(names) == 0 {
-		_, paths, err := ListTemplateResource(p.cfg.ConfDir)
+		_, paths, err := ListLayeredTemplateResource(p.cfg.GetConfDirs()...)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -58,6 +77,9 @@ func (p *Application) Info(names ...string) {
 		if !strings.HasSuffix(name, ".toml") {
 			name += ".toml"
 		}
+		if !filepath.IsAbs(name) {
+			name = p.cfg.lookupFile("conf.d", name)
+		}
 		tc, err := LoadTemplateResourceFile(p.cfg.ConfDir, name)
 		if err != nil {
 			logger.Fatal(err)
@@ -68,7 +90,7 @@ func (p *Application) Info(names ...string) {
 
 func (p *Application) Make(names ...string) {
 	if len(names) == 0 {
-		_, paths, err := ListTemplateResource(p.cfg.ConfDir)
+		_, paths, err := ListLayeredTemplateResource(p.cfg.GetConfDirs()...)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -78,6 +100,9 @@ func (p *Application) Make(names ...string) {
 		if !strings.HasSuffix(name, ".toml") {
 			name += ".toml"
 		}
+		if !filepath.IsAbs(name) {
+			name = p.cfg.lookupFile("conf.d", name)
+		}
 
 		fmt.Print(filepath.Base(name), " ")
 
@@ -86,7 +111,10 @@ func (p *Application) Make(names ...string) {
 			logger.Fatal(err)
 		}
 
-		tcp := NewTemplateResourceProcessor(name, p.cfg, p.client, tc)
+		tcp, err := NewTemplateResourceProcessor(name, p.cfg, p.client, tc)
+		if err != nil {
+			logger.Fatal(err)
+		}
 
 		cfg := p.cfg.Clone()
 		cfg.Noop = true
@@ -100,6 +128,23 @@ func (p *Application) Make(names ...string) {
 	}
 }
 
+// Bundle renders the template resources of the names, or all of them,
+// into the tar.gz file of output, "-" is the stdout.
+func (p *Application) Bundle(output string, names ...string) {
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := RenderBundle(w, p.cfg, p.client, names...); err != nil {
+		logger.Fatal(err)
+	}
+}
+
 func (p *Application) GetValues(keys ...string) {
 	m, err := p.client.GetValues(keys)
 	if err != nil {
@@ -118,6 +163,526 @@ func (p *Application) GetValues(keys ...string) {
 	}
 }
 
+// Keys prints the key/values under prefix, format is "table" (default)
+// or "json". The values of Config.RedactKeys are masked.
+func (p *Application) Keys(prefix, format string) {
+	if prefix == "" {
+		prefix = "/"
+	}
+
+	m, err := p.client.GetValues([]string{prefix})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	redactor := NewRedactor(p.cfg.RedactKeys...)
+	keys := make([]string, 0, len(m))
+	for k, v := range m {
+		m[k] = redactor.Value(k, v)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(m, "", "\t")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(data))
+	case "", "table":
+		var maxLen = 1
+		for _, k := range keys {
+			if len(k) > maxLen {
+				maxLen = len(k)
+			}
+		}
+		for _, k := range keys {
+			fmt.Printf("%-*s  %s\n", maxLen, k, m[k])
+		}
+	default:
+		logger.Fatalf("unknown format %q", format)
+	}
+}
+
+// Rollback restores the target config files of the template resources
+// to the n-th previous archived version and runs the reload commands, if
+// archive-dir is set. Otherwise, the target config files of the symlink
+// strategy are flipped to their previous versions, no command is run.
+func (p *Application) Rollback(n int, names ...string) {
+	for _, name := range names {
+		if p.cfg.ArchiveDir != "" {
+			version, err := RollbackTemplateResource(p.cfg, p.client, name, n)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			fmt.Println(name, "=>", version)
+			continue
+		}
+
+		name = resourcePathOf(p.cfg, name)
+		tc, err := LoadTemplateResourceFile(p.cfg.ConfDir, name)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if tc.Strategy != SyncStrategySymlink {
+			logger.Fatalf("%s: strategy is not %s and archive-dir is not set", filepath.Base(name), SyncStrategySymlink)
+		}
+
+		tcp, err := NewTemplateResourceProcessor(name, p.cfg, p.client, tc)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		version, err := RollbackSymlink(tcp.Dest)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(tcp.Dest, "=>", version)
+	}
+}
+
+// Approve writes the approval token of the pending change of the template
+// resource name on host to the backend, host is the local hostname if
+// empty, see PendingChange.
+func (p *Application) Approve(host, name, token string) {
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	if err := ApproveTemplateResource(p.cfg, p.client, host, name, token); err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Println(host, name, "approved", token)
+}
+
+// Watch prints the changes of the key/values under prefix until killed,
+// format is "text" (default) or "json" (one object per line). The
+// backends without watch support are polled every Config.Interval
+// seconds. The values of Config.RedactKeys are masked.
+func (p *Application) Watch(prefix, format string) {
+	if prefix == "" {
+		prefix = "/"
+	}
+	switch format {
+	case "", "text", "json":
+	default:
+		logger.Fatalf("unknown format %q", format)
+	}
+
+	redactor := NewRedactor(p.cfg.RedactKeys...)
+	interval := time.Duration(p.cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	old, err := p.getStore(prefix)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var index uint64 = 1
+	for {
+		if p.client.WatchEnabled() {
+			i, err := p.client.WatchPrefix(prefix, []string{prefix}, index, nil)
+			if err != nil {
+				logger.Error(err)
+				time.Sleep(time.Second)
+				continue
+			}
+			index = i
+		} else {
+			time.Sleep(interval)
+		}
+
+		store, err := p.getStore(prefix)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+
+		now := time.Now().Format(time.RFC3339)
+		for _, c := range DiffStores(old, store) {
+			c = redactor.Change(c)
+			if format == "json" {
+				data, err := json.Marshal(watchEvent{
+					Time:     now,
+					Revision: index,
+					Type:     c.Type,
+					Key:      c.Key,
+					OldValue: c.OldValue,
+					NewValue: c.NewValue,
+				})
+				if err != nil {
+					logger.Fatal(err)
+				}
+				fmt.Println(string(data))
+			} else {
+				fmt.Printf("%s rev=%d %s\n", now, index, c)
+			}
+		}
+		old = store
+	}
+}
+
+type watchEvent struct {
+	Time     string        `json:"time"`
+	Revision uint64        `json:"revision"`
+	Type     KeyChangeType `json:"type"`
+	Key      string        `json:"key"`
+	OldValue string        `json:"old,omitempty"`
+	NewValue string        `json:"new,omitempty"`
+}
+
+func (p *Application) getStore(prefix string) (*KVStore, error) {
+	m, err := p.client.GetValues([]string{prefix})
+	if err != nil {
+		return nil, err
+	}
+	store := NewKVStore()
+	for k, v := range m {
+		if err := store.TrySet(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Init creates a commented template resource and a starter template of
+// name in the confdir, the existing files are not overwritten.
+func (p *Application) Init(name string) {
+	name = strings.TrimSuffix(name, ".toml")
+
+	files := []struct{ path, content string }{
+		{
+			path:    filepath.Join(p.cfg.GetConfigDir(), name+".toml"),
+			content: strings.Replace(initResourceContent, "{{name}}", name, -1),
+		},
+		{
+			path:    filepath.Join(p.cfg.GetTemplateDir(), name+".tmpl"),
+			content: initTemplateContent,
+		},
+	}
+
+	for _, f := range files {
+		if !fileNotExists(f.path) {
+			logger.Fatalf("%s already exists", f.path)
+		}
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			logger.Fatal(err)
+		}
+		if err := ioutil.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println("create", f.path)
+	}
+}
+
+const initResourceContent = `# template resource of {{name}}
+# the fields not set here are inherited from conf.d/_defaults.toml
+
+[template]
+# template file in the templates dir
+src = "{{name}}.tmpl"
+
+# or the backend key of the template instead of src, fetched on each
+# render and watched with the keys
+# src_key = "/templates/{{name}}.tmpl"
+
+# target config file, rel path is in the templates_output dir,
+# ${VAR} and ${VAR:-default} in dest, prefix, check_cmd and reload_cmd
+# are replaced by the environment variables
+dest = "{{name}}"
+
+# the string to prefix to keys
+prefix = "/{{name}}"
+
+# backend keys used by the template, relative to prefix
+keys = [
+	"/",
+]
+
+# file mode of dest, default is the mode of the existing dest or 0644
+# mode = "0644"
+
+# dir of the staged file, default is the stage-dir of config or the dir of dest
+# stage_dir = "/var/lib/confd/stage"
+
+# rename (default) or symlink, symlink writes the versioned files into
+# store_dir and flips the symlink dest to the new version
+# strategy = "symlink"
+# store_dir = "/var/lib/confd/versions"
+# keep_versions = 5
+
+# command to check the staged file, {{.src}} is the staged file path
+# check_cmd = "nginx -t -c {{.src}}"
+
+# command to reload the service after dest updated
+# reload_cmd = "nginx -s reload"
+
+# windows only: service restarted by the service manager after dest updated
+# reload_service = "MyService"
+
+# default or restricted, restricted disables the template funcs reading
+# the host, the restricted func-profile of config applies to all resources
+# func_profile = "restricted"
+
+# name of the hook set registered by WithHookSet, instead of the hooks of config
+# hooks = "nginx"
+
+# name of the group in config running this resource in its own mode
+# group = "secrets"
+
+# names of the notifiers in config or registered by WithNotifier,
+# sent when dest is updated or the check/reload fails
+# notify = ["ops-slack"]
+
+# dest is replaced only after the change is approved, see approval-prefix
+# of config and "miniconfd approve"
+# require_approval = true
+
+# watch the keys exactly, not the keys under them, for the backends
+# supporting it, the keys must be the leaf keys
+# watch_keys_exact = true
+
+# render and compare only, dest is not modified, like noop of config
+# noop = true
+
+# high/normal/low, the high priority resources are rendered first, see
+# concurrency of config
+# priority = "high"
+
+# log the store lookups of the template (func, key, result, duration),
+# and send them with the notifications
+# debug = true
+
+# create the missing parent dirs of dest, such as on the fresh hosts or
+# tmpfs, with the mode and the uid:gid owner (default is uid/gid)
+# create_dest_dirs = true
+# dest_dir_mode = "0750"
+# dest_dir_owner = "0:0"
+
+# max age in seconds of the values rendered, such as of the snapshot of
+# offline mode, dest is not replaced by the older values
+# max_staleness = 3600
+
+# the legacy keys read if the keys are missing, while the backends are
+# migrated to the new key layout, also for the keys under them
+# [template.key_aliases]
+# "/database/host" = "/db/host"
+
+# render with the values of the other keys if some keys failed to be
+# fetched, the status is flagged degraded
+# allow_partial = true
+
+# expected keys, checked before rendering
+# [[template.key_rules]]
+# key = "/port"
+# required = true
+# type = "int"    # string/int/float/bool/json
+# enum = ["80", "443"]
+# regex = "^[0-9]+$"
+# schema = "port.json"    # JSON Schema file in the schemas dir of confdir
+
+# steps applied to the values of the matched keys before the key rules
+# and rendering: trim/json-decode/base64-decode/template-expand
+# [[template.transforms]]
+# key = "/certs/*"
+# steps = ["base64-decode", "trim"]
+`
+
+const initTemplateContent = `# generated by libconfd from {{.ResourceName}}, do not edit
+{{range gets "/*"}}
+{{.Key}} = {{.Value}}
+{{- end}}
+`
+
+// Encrypt prints the secconf encoded value with the public keyring
+// file, or the aes-gcm encoded value with the AES key of config in the
+// aes-gcm crypt mode. value is read from stdin if empty.
+func (p *Application) Encrypt(pubkeyFile, value string) {
+	if p.cfg.CryptMode == CryptModeAESGCM {
+		key, err := p.cfg.GetAESKey()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		data, err := GCMEncode(readValueOrStdin(value), key)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	if pubkeyFile == "" {
+		logger.Fatal("missing PGP public keyring")
+	}
+	keyring, err := os.Open(pubkeyFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer keyring.Close()
+
+	data, err := SecconfEncode(readValueOrStdin(value), keyring)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+// Decrypt prints the secconf decoded value with the secret keyring
+// file, or Config.PGPPrivateKey if seckeyFile is empty, or the aes-gcm
+// decoded value with the AES key of config in the aes-gcm crypt mode.
+// value is read from stdin if empty.
+func (p *Application) Decrypt(seckeyFile, value string) {
+	if p.cfg.CryptMode == CryptModeAESGCM {
+		key, err := p.cfg.GetAESKey()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		data, err := GCMDecode(readValueOrStdin(value), key)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	keyring := []byte(p.cfg.PGPPrivateKey)
+	if seckeyFile != "" {
+		var err error
+		if keyring, err = ioutil.ReadFile(seckeyFile); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	if len(keyring) == 0 {
+		logger.Fatal("missing PGP secret keyring")
+	}
+
+	data, err := SecconfDecode(bytes.TrimSpace(readValueOrStdin(value)), bytes.NewReader(keyring))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+func readValueOrStdin(value string) []byte {
+	if value != "" {
+		return []byte(value)
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return data
+}
+
+func (p *Application) Check(valuesFile string) {
+	var values map[string]string
+	if valuesFile != "" {
+		var err error
+		if values, err = LoadKeyValuesFile(valuesFile); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	var errs []error
+	if err := p.cfg.Validate(); err != nil {
+		errs = append(errs, err.(*ConfigError).Errs...)
+	}
+	errs = append(errs, CheckTemplateResources(p.cfg, values)...)
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+}
+
+// ValidateBackend checks the backend config with a probe read and
+// watch open, prints the result of every step, and exits with non zero
+// code if any step failed.
+func (p *Application) ValidateBackend(cfg *BackendConfig, timeout time.Duration) {
+	failed := false
+	for _, check := range ValidateBackend(cfg, timeout) {
+		fmt.Println(check.String())
+		if check.Err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+}
+
+// Supervise runs the command as a child process by ExecMode, and
+// forwards the signals to it. The child gets reloadSignal (if not nil)
+// when a target config file is updated, or is restarted if restart is
+// set, killTimeout is the time to wait for it to exit before it is killed.
+// It exits with the exit code of the child.
+func (p *Application) Supervise(reloadSignal os.Signal, restart bool, killTimeout time.Duration, name string, args ...string) {
+	child := NewExecMode(p.cfg, p.client, ExecConfig{
+		Command:      name,
+		Args:         args,
+		ReloadSignal: reloadSignal,
+		Restart:      restart,
+		KillTimeout:  killTimeout,
+	})
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+		for sig := range c {
+			logger.Info("forward signal to child: ", sig)
+			child.Signal(sig)
+		}
+	}()
+
+	code, err := child.Run()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	os.Exit(code)
+}
+
+// RunInitContainer renders all the template resources once, and exits
+// with non zero code if any of them failed, for the init containers.
+// Use RunSidecar with the same config to keep them updated afterward.
+func (p *Application) RunInitContainer(opts ...Options) {
+	var failed int32
+	opts = append(opts, WithOnetimeMode(), func(cfg *Config) {
+		fn := cfg.HookOnError
+		cfg.HookOnError = func(trName string, err error) {
+			atomic.AddInt32(&failed, 1)
+			if fn != nil {
+				fn(trName, err)
+			}
+		}
+	})
+
+	service := NewProcessor()
+	defer service.Close()
+
+	if err := service.Run(p.cfg, p.client, opts...); err != nil {
+		logger.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&failed); n > 0 {
+		logger.Fatalf("%d template resources failed", n)
+	}
+}
+
+// RunSidecar is like Run, but never in onetime mode. It keeps the
+// template resources rendered by RunInitContainer updated.
+func (p *Application) RunSidecar(opts ...Options) {
+	p.Run(append(opts, func(cfg *Config) {
+		cfg.Onetime = false
+	})...)
+}
+
 func (p *Application) Run(opts ...Options) {
 	service := NewProcessor()
 
@@ -125,11 +690,35 @@ func (p *Application) Run(opts ...Options) {
 		defer service.Close()
 
 		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, os.Kill)
-		<-c
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		if p.reloader != nil {
+			signal.Notify(c, syscall.SIGHUP)
+		}
 
-		fmt.Println("quit")
+		for sig := range c {
+			if sig == syscall.SIGHUP {
+				p.reload(service, opts...)
+				continue
+			}
+			logger.Info("quit by signal: ", sig)
+			return
+		}
 	}()
 
 	service.Run(p.cfg, p.client, opts...)
 }
+
+func (p *Application) reload(service *Processor, opts ...Options) {
+	logger.Info("reload by signal: ", syscall.SIGHUP)
+
+	cfg, client, err := p.reloader()
+	if err != nil {
+		logger.Error("reload failed: ", err)
+		return
+	}
+	if err := service.Reload(cfg, client, opts...); err != nil {
+		logger.Error("reload failed: ", err)
+		return
+	}
+	p.cfg, p.client = cfg.Clone(), client
+}
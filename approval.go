@@ -0,0 +1,269 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PendingChange is the change of the target config file of a template
+// resource with require_approval = true, waiting for the approval token
+// before dest is replaced. The token is the ID of the change.
+//
+// If Config.ApprovalPrefix is set, the change is published to the backend
+// as JSON, and approved by writing the ID to the approve key:
+//
+//	<approval-prefix>/<hostname>/<resource name>/pending
+//	<approval-prefix>/<hostname>/<resource name>/approve
+//
+// The approve key is cleared once the change is applied, so the token is
+// never applied again, such as to the same change made again later. It
+// can also be approved by Processor.Approve, or "miniconfd approve".
+// Only the changed keys are published, the values of the secret keys are
+// masked, the rendered file itself never leaves the host.
+type PendingChange struct {
+	ID        string      `json:"id"`
+	Host      string      `json:"host"`
+	Resource  string      `json:"resource"`
+	Dest      string      `json:"dest"`
+	OldSHA256 string      `json:"old_sha256"` // empty if dest does not exist
+	NewSHA256 string      `json:"new_sha256"`
+	Changes   []KeyChange `json:"changes"`
+	Time      time.Time   `json:"time"`
+}
+
+// getApprovalKey returns the key of the pending change or the approval
+// token of the resource, name is "pending" or "approve".
+func getApprovalKey(prefix, hostname, trName, name string) string {
+	return path.Join("/", prefix, hostname, filepath.Base(trName), name)
+}
+
+// resourceApprovals are the approval tokens given by Processor.Approve,
+// and the IDs of the pending changes of the resources.
+type resourceApprovals struct {
+	mu      sync.Mutex
+	tokens  map[string]string
+	pending map[string]string
+}
+
+func newResourceApprovals() *resourceApprovals {
+	return &resourceApprovals{
+		tokens:  make(map[string]string),
+		pending: make(map[string]string),
+	}
+}
+
+// setPending keeps the ID of the pending change of the resource, an
+// empty id clears it.
+func (p *resourceApprovals) setPending(path, id string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id == "" {
+		delete(p.pending, path)
+	} else {
+		p.pending[path] = id
+	}
+}
+
+// isPending reports whether id is the pending change of the resource.
+func (p *resourceApprovals) isPending(path, id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending[path] == id
+}
+
+func (p *resourceApprovals) approve(path, token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens[path] = token
+}
+
+// take reports whether the token of the resource is id, the token is
+// consumed if so.
+func (p *resourceApprovals) take(path, id string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tokens[path] != id {
+		return false
+	}
+	delete(p.tokens, path)
+	return true
+}
+
+// newPendingChange returns the change of dest to the staged file, the ID
+// is bound to both the old and new contents, so an approval never applies
+// to another change.
+func (p *TemplateResourceProcessor) newPendingChange(staged string) (*PendingChange, error) {
+	oldSum, err := fileSHA256(p.Dest)
+	if err != nil {
+		return nil, err
+	}
+	newSum, err := fileSHA256(staged)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256([]byte(p.Dest + "\x00" + oldSum + "\x00" + newSum))
+	hostname, _ := os.Hostname()
+
+	c := &PendingChange{
+		ID:        hex.EncodeToString(h[:8]),
+		Host:      hostname,
+		Resource:  filepath.Base(p.path),
+		Dest:      p.Dest,
+		OldSHA256: oldSum,
+		NewSHA256: newSum,
+		Time:      time.Now(),
+	}
+	for _, x := range p.lastChanges {
+		c.Changes = append(c.Changes, p.redactor.Change(x))
+	}
+	return c, nil
+}
+
+// checkApproval reports whether the change of dest to the staged file is
+// approved. The new change is published and notified once, and waits
+// for the approval in the later cycles.
+func (p *TemplateResourceProcessor) checkApproval(call *Call, staged string) (bool, error) {
+	c, err := p.newPendingChange(staged)
+	if err != nil {
+		return false, err
+	}
+
+	if call.approvals.take(p.path, c.ID) {
+		p.logger.Infof("Change %s of %s approved", c.ID, p.Dest)
+		p.pending = nil
+		call.approvals.setPending(p.path, "")
+		return true, nil
+	}
+
+	prefix := call.Config.ApprovalPrefix
+	if prefix != "" {
+		key := getApprovalKey(prefix, c.Host, p.path, "approve")
+		ctx, cancel := call.backendContext()
+		values, _, err := getValuesContext(ctx, call.Client, []string{key})
+		err = contextError(ctx, err)
+		cancel()
+		if err != nil {
+			return false, err
+		}
+		if values[key] == c.ID {
+			if err := p.consumeApproval(key); err != nil {
+				return false, fmt.Errorf("clear approval %s: %v", key, err)
+			}
+			p.logger.Infof("Change %s of %s approved by %s", c.ID, p.Dest, key)
+			p.pending = nil
+			call.approvals.setPending(p.path, "")
+			return true, nil
+		}
+	}
+
+	if p.pending != nil && p.pending.ID == c.ID {
+		p.logger.Debugf("Change %s of %s waiting for approval", c.ID, p.Dest)
+		return false, nil
+	}
+
+	// keep the changed keys of the first cycle of the change
+	if p.pending != nil && len(c.Changes) == 0 {
+		c.Changes = p.pending.Changes
+	}
+	p.pending = c
+	call.approvals.setPending(p.path, c.ID)
+
+	p.logger.Infof("Change %s of %s waiting for approval", c.ID, p.Dest)
+	GetMetrics().Inc(fmt.Sprintf("libconfd_pending_approvals_total{resource=%q}", filepath.Base(p.path)))
+
+	if prefix != "" {
+		if err := p.publishPendingChange(call, c); err != nil {
+			p.logger.Warning("publish pending change failed: ", err)
+		}
+	}
+	p.notify(call, NotifyEventPendingApproval, fmt.Errorf("change %s waiting for approval", c.ID))
+	return false, nil
+}
+
+// consumeApproval clears the approve key of the backend.
+func (p *TemplateResourceProcessor) consumeApproval(key string) error {
+	w, ok := getStoreWriter(p.client)
+	if !ok {
+		return fmt.Errorf("backend %s does not support writing", p.client.Type())
+	}
+	return w.SetValues(map[string]string{key: ""})
+}
+
+// publishPendingChange writes the change to the pending key of the
+// backend.
+func (p *TemplateResourceProcessor) publishPendingChange(call *Call, c *PendingChange) error {
+	w, ok := getStoreWriter(p.client)
+	if !ok {
+		return fmt.Errorf("backend %s does not support writing", p.client.Type())
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	key := getApprovalKey(call.Config.ApprovalPrefix, c.Host, p.path, "pending")
+	return w.SetValues(map[string]string{key: string(data)})
+}
+
+// ApproveTemplateResource writes the approval token of the pending change
+// of the template resource name on host to the backend, see PendingChange.
+func ApproveTemplateResource(cfg *Config, client BackendClient, host, name, token string) error {
+	if cfg.ApprovalPrefix == "" {
+		return fmt.Errorf("libconfd: approval-prefix is not set")
+	}
+	w, ok := getStoreWriter(client)
+	if !ok {
+		return fmt.Errorf("libconfd: backend %s does not support writing", client.Type())
+	}
+	key := getApprovalKey(cfg.ApprovalPrefix, host, resourcePathOf(cfg, name), "approve")
+	return w.SetValues(map[string]string{key: token})
+}
+
+// Approve approves the pending change token of the template resource
+// name, and applies it at once with the config and client of the running
+// call of the resource. It fails if the change is no longer pending, such
+// as the values changed again, the new change waits for its own approval.
+func (p *Processor) Approve(name, token string) error {
+	call, res, path, err := p.runningCallOf(name)
+	if err != nil {
+		return err
+	}
+	if !p.approvals.isPending(path, token) {
+		return fmt.Errorf("libconfd: change %s of %s is not pending", token, name)
+	}
+
+	p.approvals.approve(path, token)
+
+	t := newTemplateResourceProcessor(path, call.Config, call.Client, res, call.aesKey)
+	err = t.Process(call)
+	p.approvals.take(path, token) // not consumed if the change is gone
+	if err != nil {
+		return err
+	}
+	if t.pending != nil {
+		return fmt.Errorf("libconfd: change %s is not pending, %s is pending now", token, t.pending.ID)
+	}
+	if t.updated {
+		p.publishBundle(call)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApprovalGate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-approval-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(dest, []byte("port=80"), 0644) == nil)
+
+	var ops tNotifier
+	cfg := newDefaultConfig().applyOptions(WithNotifier("ops", &ops))
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	cfg.ApprovalPrefix = "/confd/approval"
+
+	client := &tWriterClient{
+		mapBackendClient: mapBackendClient{"/app/port": "8080"},
+		written:          make(map[string]string),
+	}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:             src,
+		Dest:            dest,
+		Prefix:          "/app",
+		Keys:            []string{"/port"},
+		Notify:          []string{"ops"},
+		RequireApproval: true,
+	})
+	tAssert(t, err == nil, err)
+	call := &Call{Config: cfg, Client: client, approvals: newResourceApprovals()}
+
+	readDest := func() string {
+		data, err := ioutil.ReadFile(dest)
+		tAssert(t, err == nil, err)
+		return string(data)
+	}
+
+	// the change waits for the approval, published and notified once
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=80", readDest())
+	tAssert(t, p.pending != nil)
+	tAssert(t, len(ops) == 1 && ops[0].Type == NotifyEventPendingApproval, ops)
+
+	hostname, _ := os.Hostname()
+	var pending PendingChange
+	tAssert(t, json.Unmarshal([]byte(client.written[getApprovalKey("/confd/approval", hostname, "app.toml", "pending")]), &pending) == nil, client.written)
+	tAssert(t, pending.ID == p.pending.ID && pending.Resource == "app.toml", pending)
+	tAssert(t, len(pending.Changes) == 1 && pending.Changes[0].Key == "/port", pending.Changes)
+
+	// approved by the token in the backend, the token is cleared
+	approveKey := getApprovalKey("/confd/approval", hostname, "app.toml", "approve")
+	client.mapBackendClient[approveKey] = pending.ID
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=8080", readDest())
+	tAssert(t, p.pending == nil)
+	v, ok := client.written[approveKey]
+	tAssert(t, ok && v == "", client.written)
+	client.mapBackendClient[approveKey] = v
+
+	// approved by Processor.Approve, the token of another change is ignored
+	client.mapBackendClient["/app/port"] = "9090"
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, p.pending != nil && p.pending.ID != pending.ID)
+
+	call.approvals.approve(p.path, pending.ID)
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=8080", readDest())
+
+	call.approvals.approve(p.path, p.pending.ID)
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=9090", readDest())
+	tAssert(t, p.pending == nil)
+
+	// the same change made again waits for a new approval
+	client.mapBackendClient["/app/port"] = "80"
+	tAssert(t, p.Process(call) == nil)
+	call.approvals.approve(p.path, p.pending.ID)
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=80", readDest())
+
+	client.mapBackendClient["/app/port"] = "8080"
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=80", readDest())
+	tAssert(t, p.pending != nil && p.pending.ID == pending.ID)
+}
+
+// tCountNotifier counts the events sent by the running processors.
+type tCountNotifier int32
+
+func (p *tCountNotifier) Notify(e *NotifyEvent) error {
+	atomic.AddInt32((*int32)(p), 1)
+	return nil
+}
+
+func TestProcessorApprove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-approve-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(dest, []byte("port=80"), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "app.toml"), []byte(fmt.Sprintf(`
+[template]
+src = "app.tmpl"
+dest = %q
+prefix = "/app"
+keys = ["/port"]
+notify = ["ops"]
+require_approval = true
+`, filepath.ToSlash(dest))), 0644) == nil)
+
+	var ops tCountNotifier
+	cfg := newDefaultConfig().applyOptions(WithNotifier("ops", &ops))
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	cfg.Onetime = false
+	cfg.Interval = 3600
+
+	p := NewProcessor()
+	defer p.Close()
+	p.Go(cfg, mapBackendClient{"/app/port": "8080"})
+
+	path := resourcePathOf(cfg, "app")
+	var id string
+	for i := 0; i < 100 && id == ""; i++ {
+		time.Sleep(time.Millisecond * 50)
+		p.approvals.mu.Lock()
+		id = p.approvals.pending[path]
+		p.approvals.mu.Unlock()
+	}
+	tAssert(t, id != "")
+
+	// the wrong token is rejected before the change is rendered again
+	tAssert(t, p.Approve("app", "bad") != nil)
+	tAssert(t, atomic.LoadInt32((*int32)(&ops)) == 1, ops)
+
+	tAssert(t, p.Approve("app", id) == nil)
+	data, _ := ioutil.ReadFile(dest)
+	tAssert(t, string(data) == "port=8080", string(data))
+}
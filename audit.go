@@ -0,0 +1,68 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is written to Config.AuditLog, one JSON object per line,
+// whenever a target config file is modified.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Resource  string    `json:"resource"`
+	Path      string    `json:"path"`
+	OldSHA256 string    `json:"old_sha256"` // empty if the file was created
+	NewSHA256 string    `json:"new_sha256"`
+	Reload    string    `json:"reload"` // ok/skipped or the reload error
+}
+
+var auditLogMutex sync.Mutex
+
+// WriteAuditRecord appends r to the audit log file.
+func WriteAuditRecord(filename string, r *AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fileSHA256 returns the hex SHA256 of the file, or empty string if the
+// file does not exist.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAuditRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-audit-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "nginx.conf")
+	sum, err := fileSHA256(dest)
+	tAssert(t, err == nil && sum == "", err)
+
+	tAssert(t, ioutil.WriteFile(dest, []byte("hello"), 0644) == nil)
+	sum, err = fileSHA256(dest)
+	tAssert(t, err == nil, err)
+	tAssert(t, sum == "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", sum)
+
+	logFile := filepath.Join(dir, "audit.log")
+	for _, reload := range []string{"ok", "skipped"} {
+		err := WriteAuditRecord(logFile, &AuditRecord{
+			Resource: "nginx.toml", Path: dest, NewSHA256: sum, Reload: reload,
+		})
+		tAssert(t, err == nil, err)
+	}
+
+	f, err := os.Open(logFile)
+	tAssert(t, err == nil, err)
+	defer f.Close()
+
+	var records []AuditRecord
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var r AuditRecord
+		tAssert(t, json.Unmarshal(s.Bytes(), &r) == nil, s.Text())
+		records = append(records, r)
+	}
+	tAssert(t, len(records) == 2, records)
+	tAssert(t, records[0].Reload == "ok" && records[1].Reload == "skipped", records)
+	tAssert(t, records[0].OldSHA256 == "" && records[0].NewSHA256 == sum, records[0])
+}
@@ -5,9 +5,13 @@
 package libconfd
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/BurntSushi/toml"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type BackendConfig struct {
@@ -17,17 +21,105 @@ type BackendConfig struct {
 	UserName string `toml:"user" json:"user"`
 	Password string `toml:"password" json:"password"`
 
+	// ACL token of consul, or the token of the token auth of vault
+	Token string `toml:"token" json:"token"`
+
+	// auth of vault: token (default), approle or kubernetes, the auth
+	// method is mounted at auth-path, default is the auth type
+	AuthType string `toml:"auth-type" json:"auth-type"`
+	AuthPath string `toml:"auth-path" json:"auth-path"`
+	AuthRole string `toml:"auth-role" json:"auth-role"` // role of kubernetes auth
+	RoleID   string `toml:"role-id" json:"role-id"`     // approle
+	SecretID string `toml:"secret-id" json:"secret-id"` // approle
+
+	// env backend: the namespace of the variables, such as "MYAPP_", the
+	// separator of the key levels, default is "_", and the .env files
+	// loaded before the environment variables
+	EnvPrefix    string   `toml:"env-prefix" json:"env-prefix"`
+	EnvSeparator string   `toml:"env-separator" json:"env-separator"`
+	EnvFiles     []string `toml:"env-files" json:"env-files"`
+
 	ClientCAKeys string `toml:"client-ca-keys" json:"client-ca-keys"`
 	ClientCert   string `toml:"client-cert" json:"client-cert"`
 	ClientKey    string `toml:"client-key" json:"client-key"`
+
+	// timeouts in seconds of a backend call, the dial, and the keepalive
+	// probes of the connection, the defaults are used if 0
+	Timeout              int `toml:"timeout" json:"timeout"`
+	DialTimeout          int `toml:"dial-timeout" json:"dial-timeout"`
+	DialKeepAliveTime    int `toml:"dial-keepalive-time" json:"dial-keepalive-time"`
+	DialKeepAliveTimeout int `toml:"dial-keepalive-timeout" json:"dial-keepalive-timeout"`
+
+	// max GetValues and WatchPrefix calls per minute of the backend, and
+	// the burst (default is the limit), 0 means no limit
+	RateLimit int `toml:"rate-limit" json:"rate-limit"`
+	RateBurst int `toml:"rate-burst" json:"rate-burst"`
 }
 
+// The default timeouts of the backends, see BackendConfig.
+const (
+	DefaultBackendTimeout              = 3 * time.Second
+	DefaultBackendDialTimeout          = 5 * time.Second
+	DefaultBackendDialKeepAliveTime    = 10 * time.Second
+	DefaultBackendDialKeepAliveTimeout = 3 * time.Second
+)
+
 func (p *BackendConfig) Clone() *BackendConfig {
 	var q = *p
 	q.Host = append([]string{}, p.Host...)
+	q.EnvFiles = append([]string(nil), p.EnvFiles...)
 	return &q
 }
 
+// Valid checks the timeouts and the rate limit of the backend config.
+func (p *BackendConfig) Valid() error {
+	for _, x := range []struct {
+		name  string
+		value int
+	}{
+		{"timeout", p.Timeout},
+		{"dial-timeout", p.DialTimeout},
+		{"dial-keepalive-time", p.DialKeepAliveTime},
+		{"dial-keepalive-timeout", p.DialKeepAliveTimeout},
+		{"rate-limit", p.RateLimit},
+		{"rate-burst", p.RateBurst},
+	} {
+		if x.value < 0 {
+			return fmt.Errorf("invalid %s: %d", x.name, x.value)
+		}
+	}
+	return nil
+}
+
+// GetTimeout returns the timeout of a backend call.
+func (p *BackendConfig) GetTimeout() time.Duration {
+	return backendSeconds(p.Timeout, DefaultBackendTimeout)
+}
+
+// GetDialTimeout returns the timeout of dialing the backend.
+func (p *BackendConfig) GetDialTimeout() time.Duration {
+	return backendSeconds(p.DialTimeout, DefaultBackendDialTimeout)
+}
+
+// GetDialKeepAliveTime returns the interval of the keepalive probes.
+func (p *BackendConfig) GetDialKeepAliveTime() time.Duration {
+	return backendSeconds(p.DialKeepAliveTime, DefaultBackendDialKeepAliveTime)
+}
+
+// GetDialKeepAliveTimeout returns the timeout of a keepalive probe.
+func (p *BackendConfig) GetDialKeepAliveTimeout() time.Duration {
+	return backendSeconds(p.DialKeepAliveTimeout, DefaultBackendDialKeepAliveTimeout)
+}
+
+func backendSeconds(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// BackendClient is the client of a backend. The clients holding the
+// connections also implement io.Closer, which is called by Processor.Stop.
 type BackendClient interface {
 	Type() string
 	GetValues(keys []string) (map[string]string, error)
@@ -35,6 +127,138 @@ type BackendClient interface {
 	WatchEnabled() bool
 }
 
+// closeBackendClient closes the client if it is an io.Closer, through the
+// wrappers of the client, and all the layers of a MultiBackendClient.
+func closeBackendClient(client BackendClient) error {
+	if c, ok := client.(io.Closer); ok {
+		return c.Close()
+	}
+	if x, ok := client.(*MultiBackendClient); ok {
+		var lastErr error
+		for _, c := range x.layers {
+			if err := closeBackendClient(c); err != nil {
+				lastErr = err
+			}
+		}
+		return lastErr
+	}
+	if c := unwrapBackendClient(client); c != nil {
+		return closeBackendClient(c)
+	}
+	return nil
+}
+
+// unwrapBackendClient returns the client wrapped by client, or nil if
+// client is not one of the wrappers of libconfd, such as the rate limiter
+// of BackendConfig.RateLimit.
+func unwrapBackendClient(client BackendClient) BackendClient {
+	switch x := client.(type) {
+	case *snapshotRecorder:
+		return x.BackendClient
+	case *cycleBackendClient:
+		return x.BackendClient
+	case *rateLimitedClient:
+		return x.BackendClient
+	}
+	return nil
+}
+
+// backendClientImpl returns the value of client checked for the optional
+// interfaces, the BackendClientV2 of NewBackendClientFromV2 or client.
+func backendClientImpl(client BackendClient) interface{} {
+	if x, ok := client.(*backendClientV2Adapter); ok {
+		return x.BackendClientV2
+	}
+	return client
+}
+
+// BackendTTLClient is an optional interface implemented by backends whose
+// values may be bound to a lease. GetValuesWithTTL is like GetValues, and
+// also returns the remaining time to live of the leased keys.
+type BackendTTLClient interface {
+	GetValuesWithTTL(keys []string) (values map[string]string, ttls map[string]time.Duration, err error)
+}
+
+// BackendSnapshotClient is an optional interface implemented by backends
+// serving the values of a copy of the store, such as the snapshot file of
+// offline mode. SnapshotTime returns the time the copy was taken, it is
+// checked against max_staleness of the template resources.
+type BackendSnapshotClient interface {
+	SnapshotTime() (time.Time, error)
+}
+
+// StoreWriter is an optional interface implemented by backends accepting
+// writes, such as the RenderStatus published under Config.StatusPrefix.
+// SetValues writes the absolute keys and their values.
+type StoreWriter interface {
+	SetValues(values map[string]string) error
+}
+
+// BackendExactWatchClient is an optional interface implemented by backends
+// able to filter the watch events by the exact keys. WatchKeys is like
+// WatchPrefix, but returns only if one of the keys itself changes, not the
+// keys under them. It is used by the template resources setting
+// watch_keys_exact, the other backends watch the keys by prefix.
+type BackendExactWatchClient interface {
+	WatchKeys(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error)
+}
+
+// The types of KVEvent.
+const (
+	KVEventPut    = "PUT"
+	KVEventDelete = "DELETE"
+)
+
+// KVEvent is the change of a key sent by BackendEventClient.
+type KVEvent struct {
+	Type  string `json:"type"` // KVEventPut or KVEventDelete
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"` // the new value of KVEventPut
+}
+
+// BackendEventClient is an optional interface implemented by backends
+// streaming the changes of the keys, instead of the waitIndex contract of
+// WatchPrefix. WatchEvents sends the events of the keys under prefix
+// matching one of keys by prefix, until ctx is done or the stream fails,
+// and the channel is closed then. In watch mode, a template resource is
+// rendered again only if the events match its keys.
+type BackendEventClient interface {
+	WatchEvents(ctx context.Context, prefix string, keys []string) (<-chan KVEvent, error)
+}
+
+// MatchWatchKey reports whether the changed key matches one of the
+// watched keys, exactly or by prefix. The prefix match picks up the false
+// positives, such as /app/port2 of /app/port.
+func MatchWatchKey(key string, keys []string, exact bool) bool {
+	for _, k := range keys {
+		if exact && key == k {
+			return true
+		}
+		if !exact && strings.HasPrefix(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// getExactWatchClient returns the BackendExactWatchClient of the client,
+// through the wrappers of the client.
+func getExactWatchClient(client BackendClient) (BackendExactWatchClient, bool) {
+	for ; client != nil; client = unwrapBackendClient(client) {
+		if x, ok := client.(*rateLimitedClient); ok {
+			// the exact watch of the wrapped client, rate limited
+			if _, ok := getExactWatchClient(x.BackendClient); !ok {
+				return nil, false
+			}
+			return x, true
+		}
+		if c, ok := backendClientImpl(client).(BackendExactWatchClient); ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
 func MustNewBackendClient(cfg *BackendConfig, opts ...func(*BackendConfig)) BackendClient {
 	p, err := NewBackendClient(cfg, opts...)
 	if err != nil {
@@ -43,18 +267,35 @@ func MustNewBackendClient(cfg *BackendConfig, opts ...func(*BackendConfig)) Back
 	return p
 }
 
+// NewBackendClient is like NewBackendClientFromConfig, but the options
+// modify a copy of cfg first, such as the flags overriding the file.
 func NewBackendClient(cfg *BackendConfig, opts ...func(*BackendConfig)) (BackendClient, error) {
 	cfg = cfg.Clone()
 	for _, fn := range opts {
 		fn(cfg)
 	}
+	return NewBackendClientFromConfig(cfg)
+}
+
+// NewBackendClientFromConfig creates the client of the backend registered
+// by cfg.Type, see RegisterBackendClient. The error of an unregistered
+// type is *ErrUnknownBackend.
+func NewBackendClientFromConfig(cfg *BackendConfig) (BackendClient, error) {
+	cfg = cfg.Clone()
+	if err := cfg.Valid(); err != nil {
+		return nil, fmt.Errorf("libconfd: %v", err)
+	}
 
-	newClient := _BackendClientMap[cfg.Type]
-	if newClient == nil {
-		return nil, fmt.Errorf("libconfd: unknown backend type %q", cfg.Type)
+	newClient, ok := lookupBackendClient(cfg.Type)
+	if !ok {
+		return nil, &ErrUnknownBackend{Type: cfg.Type, Registered: BackendClientTypes()}
 	}
 
-	return newClient(cfg)
+	client, err := newClient(cfg)
+	if err != nil || cfg.RateLimit == 0 {
+		return client, err
+	}
+	return newRateLimitedClient(client, newRateLimiter(cfg.RateLimit, cfg.RateBurst)), nil
 }
 
 func MustLoadBackendConfig(path string) *BackendConfig {
@@ -65,20 +306,62 @@ func MustLoadBackendConfig(path string) *BackendConfig {
 	return p
 }
 
+// LoadBackendConfig loads the backend config file, in the formats of
+// LoadConfig.
 func LoadBackendConfig(path string) (p *BackendConfig, err error) {
 	p = new(BackendConfig)
-	_, err = toml.DecodeFile(path, p)
-	if err != nil {
+	if err = decodeConfigFile(path, p); err != nil {
 		return nil, err
 	}
 	return p, nil
 }
 
+// RegisterBackendClient registers the constructor of the backend type,
+// usually by the init of the backend package, a later registration of the
+// same type replaces the earlier one. It panics if typeName is empty or
+// newClient is nil.
 func RegisterBackendClient(
 	typeName string,
 	newClient func(cfg *BackendConfig) (BackendClient, error),
 ) {
+	if typeName == "" || newClient == nil {
+		logger.Panic("libconfd: invalid backend registration: ", typeName)
+	}
+
+	_BackendClientMu.Lock()
+	defer _BackendClientMu.Unlock()
+
 	_BackendClientMap[typeName] = newClient
 }
 
-var _BackendClientMap = map[string]func(cfg *BackendConfig) (BackendClient, error){}
+// IsBackendClientRegistered reports whether the backend type is registered.
+func IsBackendClientRegistered(typeName string) bool {
+	_, ok := lookupBackendClient(typeName)
+	return ok
+}
+
+// BackendClientTypes returns the sorted types of the registered backends.
+func BackendClientTypes() []string {
+	_BackendClientMu.RLock()
+	defer _BackendClientMu.RUnlock()
+
+	var types []string
+	for typeName := range _BackendClientMap {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func lookupBackendClient(typeName string) (func(cfg *BackendConfig) (BackendClient, error), bool) {
+	_BackendClientMu.RLock()
+	defer _BackendClientMu.RUnlock()
+
+	newClient, ok := _BackendClientMap[typeName]
+	return newClient, ok
+}
+
+var (
+	_BackendClientMu  sync.RWMutex
+	_BackendClientMap = map[string]func(cfg *BackendConfig) (BackendClient, error){}
+)
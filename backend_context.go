@@ -0,0 +1,188 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BackendContextClient is an optional interface implemented by backends
+// supporting the cancellation and the deadlines of context. The calls
+// return ctx.Err() once ctx is done, the Processor cancels them when it
+// is stopped, and at the deadline of backend-call-timeout.
+type BackendContextClient interface {
+	GetValuesContext(ctx context.Context, keys []string) (map[string]string, error)
+	WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error)
+}
+
+// BackendTTLContextClient is an optional interface implemented by the
+// BackendTTLClient backends supporting the cancellation of context, as
+// BackendContextClient. It is preferred to the other GetValues methods.
+type BackendTTLContextClient interface {
+	GetValuesWithTTLContext(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error)
+}
+
+// BackendClientV2 is the context-aware BackendClient. A BackendClientV2
+// is used as a BackendClient by NewBackendClientFromV2, and a BackendClient
+// is used as a BackendClientV2 by AsBackendClientV2.
+type BackendClientV2 interface {
+	Type() string
+	WatchEnabled() bool
+	BackendContextClient
+}
+
+// AsBackendClientV2 returns the client as a BackendClientV2. The GetValues
+// of the old clients is not interrupted, ctx is checked before and after
+// it. The stopChan of WatchPrefix is closed when ctx is done.
+func AsBackendClientV2(client BackendClient) BackendClientV2 {
+	if c, ok := client.(BackendClientV2); ok {
+		return c
+	}
+	return &backendClientV1Adapter{BackendClient: client}
+}
+
+// NewBackendClientFromV2 returns the BackendClient of the client, such as
+// to register a BackendClientV2 by RegisterBackendClient. WatchPrefix
+// cancels the call when stopChan is closed. The client is closed by
+// Processor.Stop if it implements io.Closer.
+func NewBackendClientFromV2(client BackendClientV2) BackendClient {
+	if c, ok := client.(BackendClient); ok {
+		return c
+	}
+	return &backendClientV2Adapter{BackendClientV2: client}
+}
+
+// backendClientV1Adapter is a BackendClient used as a BackendClientV2.
+type backendClientV1Adapter struct {
+	BackendClient
+}
+
+func (p *backendClientV1Adapter) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	values, _, err := getValuesContext(ctx, p.BackendClient, keys)
+	return values, err
+}
+
+func (p *backendClientV1Adapter) WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return waitIndex, err
+	}
+	stopChan, cancel := stopChanOf(ctx)
+	defer cancel()
+
+	index, err := p.BackendClient.WatchPrefix(prefix, keys, waitIndex, stopChan)
+	if ctx.Err() != nil {
+		return waitIndex, ctx.Err()
+	}
+	return index, err
+}
+
+// backendClientV2Adapter is a BackendClientV2 used as a BackendClient.
+type backendClientV2Adapter struct {
+	BackendClientV2
+}
+
+func (p *backendClientV2Adapter) GetValues(keys []string) (map[string]string, error) {
+	return p.BackendClientV2.GetValuesContext(context.Background(), keys)
+}
+
+// WatchPrefix returns waitIndex without error if stopChan is closed, as
+// the other backends.
+func (p *backendClientV2Adapter) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	ctx, cancel := contextWithStop(context.Background(), stopChan)
+	defer cancel()
+
+	index, err := p.BackendClientV2.WatchPrefixContext(ctx, prefix, keys, waitIndex)
+	if isStopped(stopChan) {
+		return waitIndex, nil
+	}
+	return index, err
+}
+
+func (p *backendClientV2Adapter) Close() error {
+	if c, ok := p.BackendClientV2.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// contextWithStop returns a context of parent, which is also canceled
+// when stopChan is closed.
+func contextWithStop(parent context.Context, stopChan chan bool) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if stopChan == nil {
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// stopChanOf returns a stopChan closed when ctx is done, cancel must be
+// called to release it.
+func stopChanOf(ctx context.Context) (stopChan chan bool, cancel func()) {
+	stopChan = make(chan bool)
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stopChan)
+		case <-done:
+		}
+	}()
+	return stopChan, func() { close(done) }
+}
+
+// getValuesContext gets the values of keys, it returns ctx.Err() if ctx
+// is done. The BackendTTLContextClient is called first, then the TTL
+// clients by GetValuesWithTTL, the other BackendContextClient by
+// GetValuesContext, and the others by GetValues.
+func getValuesContext(ctx context.Context, client BackendClient, keys []string) (map[string]string, map[string]time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var values map[string]string
+	var ttls map[string]time.Duration
+	var err error
+	switch c := client.(type) {
+	case BackendTTLContextClient:
+		values, ttls, err = c.GetValuesWithTTLContext(ctx, keys)
+	case BackendTTLClient:
+		values, ttls, err = c.GetValuesWithTTL(keys)
+	case BackendContextClient:
+		values, err = c.GetValuesContext(ctx, keys)
+	default:
+		// not interrupted, the result is dropped if ctx is done
+		values, err = client.GetValues(keys)
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return values, ttls, err
+}
+
+// contextError returns err of a backend call of ctx, errProcessorStopped
+// if ctx is canceled, or the timeout error if its deadline is exceeded.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch ctx.Err() {
+	case nil:
+		return err
+	case context.DeadlineExceeded:
+		return fmt.Errorf("libconfd: backend call timeout: %w", context.DeadlineExceeded)
+	default:
+		return errProcessorStopped
+	}
+}
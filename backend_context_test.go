@@ -0,0 +1,101 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// tContextClient is a BackendClientV2, GetValuesContext blocks until ctx
+// is done if block is set, WatchPrefixContext blocks until ctx is done.
+type tContextClient struct {
+	values mapBackendClient
+	block  bool
+}
+
+func (_ *tContextClient) Type() string       { return "context" }
+func (_ *tContextClient) WatchEnabled() bool { return true }
+
+func (p *tContextClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	if p.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return p.values.GetValues(keys)
+}
+
+func (_ *tContextClient) WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestNewBackendClientFromV2(t *testing.T) {
+	client := NewBackendClientFromV2(&tContextClient{values: mapBackendClient{"/app/port": "80"}})
+	tAssert(t, client.Type() == "context")
+
+	m, err := client.GetValues([]string{"/app"})
+	tAssert(t, err == nil && m["/app/port"] == "80", err, m)
+
+	// stopped, not an error
+	stopChan := make(chan bool)
+	go func() {
+		time.Sleep(time.Second / 20)
+		close(stopChan)
+	}()
+	index, err := client.WatchPrefix("/app", []string{"/app"}, 7, stopChan)
+	tAssert(t, err == nil && index == 7, err, index)
+
+	// the V2 client is used as is
+	_, ok := AsBackendClientV2(client).(*backendClientV2Adapter)
+	tAssert(t, ok)
+}
+
+func TestAsBackendClientV2(t *testing.T) {
+	blocking := &tBlockingClient{mapBackendClient: mapBackendClient{"/app/port": "80"}, release: make(chan bool)}
+
+	// the call is not interrupted, its result is dropped
+	client := AsBackendClientV2(blocking)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		blocking.release <- true
+	}()
+	_, err := client.GetValuesContext(ctx, []string{"/app"})
+	tAssert(t, err == context.DeadlineExceeded, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = client.WatchPrefixContext(ctx, "/app", []string{"/app"}, 1)
+	tAssert(t, err == context.Canceled, err)
+
+	m, err := AsBackendClientV2(mapBackendClient{"/app/port": "80"}).GetValuesContext(context.Background(), []string{"/app"})
+	tAssert(t, err == nil && m["/app/port"] == "80", err, m)
+}
+
+func TestProcessorBackendCallTimeout(t *testing.T) {
+	cfg := newDefaultConfig()
+	cfg.Prefix = ""
+	cfg.BackendCallTimeout = 1
+	cfg.TemplateFS = fstest.MapFS{"app.tmpl": {Data: []byte(`{{getv "/app/port"}}`)}}
+
+	client := NewBackendClientFromV2(&tContextClient{block: true})
+	p, err := NewTemplateResourceProcessor("app.toml", cfg, client, &TemplateResource{
+		Src:  "app.tmpl",
+		Dest: "app.conf",
+		Keys: []string{"/app"},
+	})
+	tAssert(t, err == nil, err)
+
+	start := time.Now()
+	err = p.Process(&Call{Config: cfg, Client: client, ctx: context.Background()})
+	tAssert(t, errors.Is(err, context.DeadlineExceeded), err)
+	tAssert(t, errors.Is(err, ErrBackendUnavailable), err)
+	tAssert(t, time.Since(start) < 3*time.Second, time.Since(start))
+}
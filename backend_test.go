@@ -0,0 +1,67 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackendConfigTimeouts(t *testing.T) {
+	cfg := &BackendConfig{Type: TomlBackendType}
+	tAssert(t, cfg.GetTimeout() == DefaultBackendTimeout)
+	tAssert(t, cfg.GetDialTimeout() == DefaultBackendDialTimeout)
+	tAssert(t, cfg.GetDialKeepAliveTime() == DefaultBackendDialKeepAliveTime)
+	tAssert(t, cfg.GetDialKeepAliveTimeout() == DefaultBackendDialKeepAliveTimeout)
+
+	cfg.Timeout, cfg.DialTimeout = 30, 1
+	tAssert(t, cfg.GetTimeout() == 30*time.Second && cfg.GetDialTimeout() == time.Second)
+
+	_, err := NewBackendClient(cfg, func(cfg *BackendConfig) {
+		cfg.DialKeepAliveTimeout = -1
+	})
+	tAssert(t, err != nil)
+}
+
+func TestNewBackendClientFromConfig(t *testing.T) {
+	client, err := NewBackendClientFromConfig(&BackendConfig{Type: EnvBackendType})
+	tAssert(t, err == nil, err)
+	tAssert(t, client.Type() == EnvBackendType, client.Type())
+
+	tAssert(t, IsBackendClientRegistered(EnvBackendType))
+	tAssert(t, !IsBackendClientRegistered("unknown"))
+
+	_, err = NewBackendClientFromConfig(&BackendConfig{Type: "unknown"})
+	var unknown *ErrUnknownBackend
+	tAssert(t, errors.As(err, &unknown), err)
+	tAssert(t, unknown.Type == "unknown", unknown.Type)
+	tAssert(t, reflect.DeepEqual(unknown.Registered, BackendClientTypes()), unknown.Registered)
+}
+
+type tClosingClient struct {
+	mapBackendClient
+	closed int
+}
+
+func (p *tClosingClient) Close() error {
+	p.closed++
+	return nil
+}
+
+func TestCloseBackendClient(t *testing.T) {
+	a, b := new(tClosingClient), new(tClosingClient)
+	client := newRateLimitedClient(NewMultiBackendClient(a, mapBackendClient{}, b), newRateLimiter(0, 0))
+	tAssert(t, closeBackendClient(client) == nil)
+	tAssert(t, a.closed == 1 && b.closed == 1, a.closed, b.closed)
+
+	// closed by Stop once
+	p := NewProcessor()
+	p.addClient(a)
+	p.addClient(a)
+	tAssert(t, p.Stop() == nil)
+	tAssert(t, a.closed == 2, a.closed)
+}
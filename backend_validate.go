@@ -0,0 +1,223 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// BackendCheck is the result of a step of ValidateBackend.
+type BackendCheck struct {
+	Step  string // type/tls/dns/read/watch
+	Cause string // short description of the failure, empty if ok
+	Err   error
+}
+
+func (p *BackendCheck) String() string {
+	if p.Err == nil {
+		return p.Step + ": ok"
+	}
+	return fmt.Sprintf("%s: %s: %v", p.Step, p.Cause, p.Err)
+}
+
+// ValidateBackend checks the backend config step by step: the backend
+// type, the TLS files, the DNS of the hosts, a probe read of "/" and
+// a watch open (if the backend supports watch). Each read or watch
+// waits at most timeout. It stops at the first failed step.
+func ValidateBackend(cfg *BackendConfig, timeout time.Duration) []BackendCheck {
+	var checks []BackendCheck
+	check := func(step string, err error) bool {
+		checks = append(checks, BackendCheck{
+			Step:  step,
+			Cause: describeBackendError(err),
+			Err:   err,
+		})
+		return err == nil
+	}
+
+	if !IsBackendClientRegistered(cfg.Type) {
+		check("type", &ErrUnknownBackend{Type: cfg.Type, Registered: BackendClientTypes()})
+		return checks
+	}
+	check("type", nil)
+
+	if cfg.ClientCAKeys != "" || cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if !check("tls", checkBackendTLS(cfg, time.Now())) {
+			return checks
+		}
+	}
+
+	for _, host := range cfg.Host {
+		name, ok := backendHostName(host)
+		if !ok || net.ParseIP(name) != nil {
+			continue
+		}
+		if _, err := net.LookupHost(name); !check("dns "+name, err) {
+			return checks
+		}
+	}
+
+	client, err := NewBackendClient(cfg)
+	if !check("client", err) {
+		return checks
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.GetValues([]string{"/"})
+		errCh <- err
+	}()
+	select {
+	case err = <-errCh:
+	case <-time.After(timeout):
+		err = fmt.Errorf("libconfd: read timeout after %v", timeout)
+	}
+	if !check("read", err) {
+		return checks
+	}
+
+	if !client.WatchEnabled() {
+		return checks
+	}
+
+	stopChan := make(chan bool)
+	go func() {
+		_, err := client.WatchPrefix("/", []string{"/"}, 1, stopChan)
+		errCh <- err
+	}()
+	select {
+	case err = <-errCh:
+	case <-time.After(timeout):
+		close(stopChan)
+		err = nil
+	}
+	check("watch", err)
+
+	return checks
+}
+
+// checkBackendTLS checks the CA and client cert files, and the validity
+// period of the certificates.
+func checkBackendTLS(cfg *BackendConfig, now time.Time) error {
+	var certs []*x509.Certificate
+
+	if cfg.ClientCAKeys != "" {
+		data, err := ioutil.ReadFile(cfg.ClientCAKeys)
+		if err != nil {
+			return err
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("%s: %v", cfg.ClientCAKeys, err)
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return fmt.Errorf("%s: no certificate found", cfg.ClientCAKeys)
+		}
+	}
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return fmt.Errorf("libconfd: client-cert and client-key must be set together")
+	}
+	if cfg.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return err
+		}
+		for _, der := range pair.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("%s: %v", cfg.ClientCert, err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+
+	for _, cert := range certs {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return x509.CertificateInvalidError{
+				Cert:   cert,
+				Reason: x509.Expired,
+			}
+		}
+	}
+	return nil
+}
+
+// backendHostName returns the host name of the backend address,
+// e.g. "https://etcd:2379" or "etcd:2379". ok is false if host is
+// not a network address (e.g. a file path).
+func backendHostName(host string) (name string, ok bool) {
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil || u.Hostname() == "" {
+			return "", false
+		}
+		return u.Hostname(), true
+	}
+	name, _, err := net.SplitHostPort(host)
+	if err != nil || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// describeBackendError returns a short description of the cause of err.
+func describeBackendError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch err := err.(type) {
+	case *net.DNSError:
+		return "DNS lookup failed"
+	case x509.CertificateInvalidError:
+		if err.Reason == x509.Expired {
+			return "certificate expired or not yet valid"
+		}
+		return "invalid certificate"
+	case x509.UnknownAuthorityError:
+		return "certificate signed by unknown authority"
+	case x509.HostnameError:
+		return "certificate hostname mismatch"
+	case net.Error:
+		if err.Timeout() {
+			return "connection timeout"
+		}
+	}
+
+	s := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(s, "authentication failed"),
+		strings.Contains(s, "invalid auth token"),
+		strings.Contains(s, "permission denied"),
+		strings.Contains(s, "unauthorized"):
+		return "authentication rejected"
+	case strings.Contains(s, "certificate has expired"):
+		return "certificate expired or not yet valid"
+	case strings.Contains(s, "certificate signed by unknown authority"):
+		return "certificate signed by unknown authority"
+	case strings.Contains(s, "no such host"):
+		return "DNS lookup failed"
+	case strings.Contains(s, "connection refused"):
+		return "connection refused"
+	case strings.Contains(s, "timeout"), strings.Contains(s, "deadline exceeded"):
+		return "connection timeout"
+	}
+	return "error"
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateBackend(t *testing.T) {
+	checks := ValidateBackend(&BackendConfig{Type: EnvBackendType}, time.Second)
+	tAssert(t, len(checks) == 3, checks)
+	for _, check := range checks {
+		tAssert(t, check.Err == nil, check.String())
+	}
+
+	checks = ValidateBackend(&BackendConfig{Type: "unknown"}, time.Second)
+	tAssert(t, len(checks) == 1, checks)
+	tAssert(t, checks[0].Step == "type" && checks[0].Err != nil, checks[0].String())
+}
+
+func TestCheckBackendTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-tls-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tAssert(t, err == nil, err)
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "libconfd-test"},
+		NotBefore:    now.Add(-2 * time.Hour),
+		NotAfter:     now.Add(-time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	tAssert(t, err == nil, err)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	tAssert(t, ioutil.WriteFile(caFile, data, 0644) == nil)
+
+	cfg := &BackendConfig{ClientCAKeys: caFile}
+	err = checkBackendTLS(cfg, now)
+	tAssert(t, err != nil)
+	tAssert(t, describeBackendError(err) == "certificate expired or not yet valid", err)
+
+	err = checkBackendTLS(cfg, now.Add(-90*time.Minute))
+	tAssert(t, err == nil, err)
+
+	cfg = &BackendConfig{ClientCert: caFile}
+	tAssert(t, checkBackendTLS(cfg, now) != nil)
+}
+
+func TestBackendHostName(t *testing.T) {
+	for host, expect := range map[string]string{
+		"127.0.0.1:2379":        "127.0.0.1",
+		"etcd:2379":             "etcd",
+		"https://etcd.svc:2379": "etcd.svc",
+		"./backend-file.toml":   "",
+	} {
+		name, ok := backendHostName(host)
+		tAssert(t, ok == (expect != ""), host)
+		tAssert(t, name == expect, host, name)
+	}
+}
+
+func TestDescribeBackendError(t *testing.T) {
+	tAssert(t, describeBackendError(nil) == "")
+	tAssert(t, describeBackendError(&net.DNSError{Err: "no such host", Name: "etcd"}) == "DNS lookup failed")
+	tAssert(t, describeBackendError(errors.New("etcdserver: authentication failed, invalid user ID or password")) == "authentication rejected")
+	tAssert(t, describeBackendError(errors.New("context deadline exceeded")) == "connection timeout")
+}
@@ -0,0 +1,13 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package all registers all the backends of the backends dir.
+//
+// Each backend can be excluded by the build tag no_<backend>, to build
+// a slim binary without the backend dependencies, e.g.
+//
+//	go build -tags no_etcdv3
+//
+// The env, toml and snapshot backends are built into libconfd.
+package all
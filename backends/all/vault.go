@@ -0,0 +1,11 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// +build !no_vault
+
+package all
+
+import (
+	_ "openpitrix.io/libconfd/backends/vault"
+)
@@ -0,0 +1,384 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package consul provides consul backends client for libconfd.
+package consul
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openpitrix.io/libconfd"
+)
+
+const BackendType = "libconfd-backend-consul"
+
+var logger = libconfd.GetLoggerFor(libconfd.LogBackend)
+
+func init() {
+	libconfd.RegisterBackendClient(
+		(*_ConsulClient)(nil).Type(),
+		func(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+			return NewConsulClient(cfg)
+		},
+	)
+}
+
+var _ libconfd.StoreWriter = (*_ConsulClient)(nil)
+var _ libconfd.BackendExactWatchClient = (*_ConsulClient)(nil)
+var _ libconfd.BackendContextClient = (*_ConsulClient)(nil)
+
+// the max wait of a blocking query, the watch is restarted after it
+var watchWaitTime = 5 * time.Minute
+
+// _ConsulClient is a client of the KV API of consul, the keys of libconfd
+// are the consul keys with the leading "/".
+type _ConsulClient struct {
+	addrs    []string // scheme://host:port
+	token    string   // ACL token
+	user     string
+	password string
+	timeout  time.Duration // of a call, not the blocking queries
+	client   *http.Client
+}
+
+// NewConsulClient creates the consul client of the agents of cfg.Host,
+// such as "127.0.0.1:8500" or "https://consul.example.com:8501". The
+// agents are tried in order. The ACL token is cfg.Token, and the TLS is
+// enabled by the client-ca-keys or the client-cert/client-key.
+func NewConsulClient(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+	tlsEnabled := false
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+
+	if cfg.ClientCAKeys != "" {
+		certBytes, err := ioutil.ReadFile(cfg.ClientCAKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		ok := caCertPool.AppendCertsFromPEM(certBytes)
+
+		if ok {
+			tlsConfig.RootCAs = caCertPool
+		}
+		tlsEnabled = true
+	}
+
+	if cfg.ClientCert != "" && cfg.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+		tlsEnabled = true
+	}
+
+	scheme := "http"
+	dialer := &net.Dialer{
+		Timeout:   cfg.GetDialTimeout(),
+		KeepAlive: cfg.GetDialKeepAliveTime(),
+	}
+	transport := &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: dialer.DialContext,
+	}
+	if tlsEnabled {
+		scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	var addrs []string
+	for _, host := range cfg.Host {
+		if !strings.Contains(host, "://") {
+			host = scheme + "://" + host
+		}
+		addrs = append(addrs, strings.TrimSuffix(host, "/"))
+	}
+	if len(addrs) == 0 {
+		addrs = []string{scheme + "://127.0.0.1:8500"}
+	}
+
+	return &_ConsulClient{
+		addrs:    addrs,
+		token:    cfg.Token,
+		user:     cfg.UserName,
+		password: cfg.Password,
+		timeout:  cfg.GetTimeout(),
+		client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+func (c *_ConsulClient) Type() string {
+	return BackendType
+}
+
+func (c *_ConsulClient) WatchEnabled() bool {
+	return true
+}
+
+// Close closes the idle connections of the client.
+func (c *_ConsulClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// kvPair is the entry of the KV API.
+type kvPair struct {
+	Key         string
+	Value       []byte // base64 in JSON
+	ModifyIndex uint64
+}
+
+// GetValues queries consul for keys prefixed by prefix.
+func (c *_ConsulClient) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is like GetValues, the requests are canceled with ctx.
+func (c *_ConsulClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		pairs, _, err := c.list(reqCtx, key, 0)
+		cancel()
+		if err != nil {
+			return vars, err
+		}
+		for _, p := range pairs {
+			vars["/"+p.Key] = string(p.Value)
+		}
+	}
+	return vars, nil
+}
+
+// SetValues puts the keys and values in a single transaction.
+func (c *_ConsulClient) SetValues(values map[string]string) error {
+	type txnKV struct {
+		Verb  string
+		Key   string
+		Value []byte
+	}
+	var ops []map[string]txnKV
+	for k, v := range values {
+		ops = append(ops, map[string]txnKV{
+			"KV": {Verb: "set", Key: strings.TrimPrefix(k, "/"), Value: []byte(v)},
+		})
+	}
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	resp, err := c.do(ctx, "PUT", "/v1/txn", nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *_ConsulClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return c.watchStop(prefix, keys, false, waitIndex, stopChan)
+}
+
+// WatchPrefixContext is like WatchPrefix, it returns ctx.Err() once ctx
+// is done.
+func (c *_ConsulClient) WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	return c.watch(ctx, prefix, keys, false, waitIndex)
+}
+
+// WatchKeys is like WatchPrefix, but returns only if the pairs of the
+// keys themselves are modified, added or deleted.
+func (c *_ConsulClient) WatchKeys(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return c.watchStop(prefix, keys, true, waitIndex, stopChan)
+}
+
+// watchStop is watch canceled by stopChan, it returns waitIndex without
+// error then.
+func (c *_ConsulClient) watchStop(prefix string, keys []string, exact bool, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	index, err := c.watch(ctx, prefix, keys, exact, waitIndex)
+	if ctx.Err() != nil {
+		return waitIndex, nil
+	}
+	return index, err
+}
+
+func (c *_ConsulClient) watch(ctx context.Context, prefix string, keys []string, exact bool, waitIndex uint64) (uint64, error) {
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	// the index of the prefix changes with any key under it, the exact
+	// watch returns if a pair of the keys is modified after waitIndex, or
+	// the pairs of the keys differ from the previous response
+	var last map[string]uint64
+
+	for {
+		// the blocking query returns when the index of the prefix
+		// changes, or the wait time is over with the same index
+		pairs, index, err := c.list(ctx, prefix, waitIndex)
+		if err != nil {
+			if ctx.Err() != nil {
+				return waitIndex, ctx.Err()
+			}
+			return waitIndex, err
+		}
+
+		// the index goes backwards if the raft state of consul is
+		// restored, start over to render the values again
+		if index < waitIndex {
+			return 0, nil
+		}
+		if index == waitIndex {
+			continue
+		}
+		if exact {
+			m := exactModifyIndexes(pairs, keys)
+			if modifiedAfter(m, waitIndex) || (last != nil && !sameKeys(m, last)) {
+				logger.Debugf("Keys updated under %s, index %d", prefix, index)
+				return index, nil
+			}
+			last, waitIndex = m, index
+			continue
+		}
+		logger.Debugf("Prefix updated %s, index %d", prefix, index)
+		return index, nil
+	}
+}
+
+// exactModifyIndexes returns the modify indexes of the pairs of the keys.
+func exactModifyIndexes(pairs []kvPair, keys []string) map[string]uint64 {
+	m := make(map[string]uint64)
+	for _, p := range pairs {
+		if libconfd.MatchWatchKey("/"+p.Key, keys, true) {
+			m[p.Key] = p.ModifyIndex
+		}
+	}
+	return m
+}
+
+// modifiedAfter reports whether a pair is modified after index.
+func modifiedAfter(m map[string]uint64, index uint64) bool {
+	for _, v := range m {
+		if v > index {
+			return true
+		}
+	}
+	return false
+}
+
+// sameKeys reports whether a and b have the same keys, the deleted pairs
+// have no modify index.
+func sameKeys(a, b map[string]uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// list returns the pairs of the keys prefixed by prefix, and the index of
+// the response. The query blocks until the index is greater than
+// waitIndex, if waitIndex > 0.
+func (c *_ConsulClient) list(ctx context.Context, prefix string, waitIndex uint64) ([]kvPair, uint64, error) {
+	query := url.Values{"recurse": {"true"}}
+	if waitIndex > 0 {
+		query.Set("index", strconv.FormatUint(waitIndex, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(watchWaitTime/time.Second)))
+	}
+
+	resp, err := c.do(ctx, "GET", "/v1/kv/"+strings.TrimPrefix(prefix, "/"), query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, index, nil
+	}
+
+	var pairs []kvPair
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, 0, err
+	}
+	return pairs, index, nil
+}
+
+// do sends the request to the agents in order, until one of them answers.
+// The status other than 2xx and 404 is an error.
+func (c *_ConsulClient) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	var lastErr error
+	for _, addr := range c.addrs {
+		u := addr + path
+		if len(query) > 0 {
+			u += "?" + query.Encode()
+		}
+
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, u, r)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if c.token != "" {
+			req.Header.Set("X-Consul-Token", c.token)
+		}
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("consul: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
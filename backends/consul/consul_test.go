@@ -0,0 +1,298 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openpitrix.io/libconfd"
+)
+
+// tAgent is a fake consul agent of the KV API.
+type tAgent struct {
+	sync.Mutex
+	kvs     map[string]string
+	mods    map[string]uint64 // modify indexes of kvs
+	index   uint64
+	changed chan bool
+	tokens  []string
+}
+
+func (p *tAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	p.tokens = append(p.tokens, r.Header.Get("X-Consul-Token"))
+	p.Unlock()
+
+	switch {
+	case r.Method == "PUT" && r.URL.Path == "/v1/txn":
+		var ops []map[string]kvPair
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.Lock()
+		for _, op := range ops {
+			p.kvs[op["KV"].Key] = string(op["KV"].Value)
+			p.mods[op["KV"].Key] = p.index + 1
+		}
+		p.Unlock()
+		p.bump()
+
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		if s := r.URL.Query().Get("index"); s != "" {
+			index, _ := strconv.ParseUint(s, 10, 64)
+			p.Lock()
+			current, changed := p.index, p.changed
+			p.Unlock()
+			if current <= index {
+				select {
+				case <-changed:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		var pairs []kvPair
+		p.Lock()
+		for k, v := range p.kvs {
+			if strings.HasPrefix(k, prefix) {
+				pairs = append(pairs, kvPair{Key: k, Value: []byte(v), ModifyIndex: p.mods[k]})
+			}
+		}
+		w.Header().Set("X-Consul-Index", fmt.Sprint(p.index))
+		p.Unlock()
+
+		if len(pairs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *tAgent) bump() {
+	p.Lock()
+	defer p.Unlock()
+	p.index++
+	close(p.changed)
+	p.changed = make(chan bool)
+}
+
+func TestConsulClient(t *testing.T) {
+	agent := &tAgent{
+		kvs:     map[string]string{"app/port": "80", "app/host": "a.example.com", "web/port": "8080"},
+		mods:    map[string]uint64{},
+		index:   10,
+		changed: make(chan bool),
+	}
+	ts := httptest.NewServer(agent)
+	defer ts.Close()
+
+	// the first agent is down
+	client, err := libconfd.NewBackendClient(&libconfd.BackendConfig{
+		Type:  BackendType,
+		Host:  []string{"127.0.0.1:1", ts.URL},
+		Token: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := client.GetValues([]string{"/app", "/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["/app/port"] != "80" || values["/app/host"] != "a.example.com" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	agent.Lock()
+	for _, token := range agent.tokens {
+		if token != "secret" {
+			t.Fatalf("unexpected token: %q", token)
+		}
+	}
+	agent.Unlock()
+
+	// the watch returns the new index after the values are set
+	index, err := client.WatchPrefix("/app", []string{"/app/port"}, 0, nil)
+	if err != nil || index != 1 {
+		t.Fatalf("unexpected index: %d, %v", index, err)
+	}
+
+	type result struct {
+		index uint64
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		index, err := client.WatchPrefix("/app", []string{"/app/port"}, 10, make(chan bool))
+		done <- result{index, err}
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	if err := client.(libconfd.StoreWriter).SetValues(map[string]string{"/app/port": "81"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.index != 11 {
+			t.Fatalf("unexpected index: %d, %v", r.index, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	values, _ = client.GetValues([]string{"/app/port"})
+	if values["/app/port"] != "81" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	// the watch is canceled by stopChan
+	stopChan := make(chan bool)
+	go func() {
+		index, err := client.WatchPrefix("/app", nil, 11, stopChan)
+		done <- result{index, err}
+	}()
+	close(stopChan)
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop timeout")
+	}
+}
+
+func TestConsulClientWatchKeys(t *testing.T) {
+	agent := &tAgent{
+		kvs:     map[string]string{"app/port": "80", "app/port2": "81"},
+		mods:    map[string]uint64{"app/port": 1, "app/port2": 1},
+		index:   10,
+		changed: make(chan bool),
+	}
+	ts := httptest.NewServer(agent)
+	defer ts.Close()
+
+	client, err := NewConsulClient(&libconfd.BackendConfig{Host: []string{ts.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan uint64, 1)
+	go func() {
+		index, err := client.(libconfd.BackendExactWatchClient).WatchKeys("/app", []string{"/app/port"}, 10, make(chan bool))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- index
+	}()
+
+	// the keys under the prefix, but not the watched key
+	set := func(key, value string) {
+		if err := client.(libconfd.StoreWriter).SetValues(map[string]string{key: value}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	set("/app/port2", "82")
+	set("/app/host", "a.example.com")
+
+	select {
+	case index := <-done:
+		t.Fatalf("unexpected index: %d", index)
+	default:
+	}
+
+	set("/app/port", "8080")
+	select {
+	case index := <-done:
+		if index != 13 {
+			t.Fatalf("unexpected index: %d", index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	// changed after the render of index 13, before the next watch
+	set("/app/port", "8081")
+	go func() {
+		index, err := client.(libconfd.BackendExactWatchClient).WatchKeys("/app", []string{"/app/port"}, 13, make(chan bool))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- index
+	}()
+	select {
+	case index := <-done:
+		if index != 14 {
+			t.Fatalf("unexpected index: %d", index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+}
+
+func TestConsulClientContext(t *testing.T) {
+	agent := &tAgent{
+		kvs:     map[string]string{"app/port": "80"},
+		mods:    map[string]uint64{},
+		index:   10,
+		changed: make(chan bool),
+	}
+	ts := httptest.NewServer(agent)
+	defer ts.Close()
+
+	client, err := NewConsulClient(&libconfd.BackendConfig{Host: []string{ts.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.(libconfd.BackendContextClient)
+
+	values, err := c.GetValuesContext(context.Background(), []string{"/app"})
+	if err != nil || values["/app/port"] != "80" {
+		t.Fatalf("unexpected values: %v, %v", values, err)
+	}
+
+	// the blocking query is canceled with ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.WatchPrefixContext(ctx, "/app", []string{"/app/port"}, 10)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel timeout")
+	}
+
+	if _, err := c.GetValuesContext(ctx, []string{"/app"}); err == nil {
+		t.Fatal("expect the error of the canceled ctx")
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -20,7 +20,7 @@ import (
 
 const BackendType = "libconfd-backend-etcdv3"
 
-var logger = libconfd.GetLogger()
+var logger = libconfd.GetLoggerFor(libconfd.LogBackend)
 
 func init() {
 	libconfd.RegisterBackendClient(
@@ -31,17 +31,28 @@ func init() {
 	)
 }
 
+var _ libconfd.BackendTTLClient = (*_EtcdClient)(nil)
+var _ libconfd.StoreWriter = (*_EtcdClient)(nil)
+var _ libconfd.BackendExactWatchClient = (*_EtcdClient)(nil)
+var _ libconfd.BackendEventClient = (*_EtcdClient)(nil)
+var _ libconfd.BackendContextClient = (*_EtcdClient)(nil)
+var _ libconfd.BackendTTLContextClient = (*_EtcdClient)(nil)
+
 // _EtcdClient is a wrapper around the etcd client
 type _EtcdClient struct {
-	cfg clientv3.Config
+	cfg     clientv3.Config
+	timeout time.Duration // of a Get/Txn call
+
+	mu     sync.Mutex
+	client *clientv3.Client // shared by all the calls, dialed on first use
 }
 
 func NewEtcdClient(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
 	etcdConfig := clientv3.Config{
 		Endpoints:            cfg.Host,
-		DialTimeout:          5 * time.Second,
-		DialKeepAliveTime:    10 * time.Second,
-		DialKeepAliveTimeout: 3 * time.Second,
+		DialTimeout:          cfg.GetDialTimeout(),
+		DialKeepAliveTime:    cfg.GetDialKeepAliveTime(),
+		DialKeepAliveTimeout: cfg.GetDialKeepAliveTimeout(),
 	}
 
 	etcdConfig.Username = cfg.UserName
@@ -80,7 +91,7 @@ func NewEtcdClient(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error)
 		etcdConfig.TLS = tlsConfig
 	}
 
-	return &_EtcdClient{etcdConfig}, nil
+	return &_EtcdClient{cfg: etcdConfig, timeout: cfg.GetTimeout()}, nil
 }
 
 func (c *_EtcdClient) Type() string {
@@ -91,57 +102,207 @@ func (c *_EtcdClient) WatchEnabled() bool {
 	return true
 }
 
+// getClient returns the shared client, it is dialed by the first call, so
+// the etcd unavailable at start fails the calls, not NewEtcdClient. The
+// client reconnects by itself once dialed.
+func (c *_EtcdClient) getClient() (*clientv3.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		client, err := clientv3.New(c.cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+// Close closes the shared client, the watches in progress are canceled,
+// and the next call dials again.
+func (c *_EtcdClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
 // GetValues queries etcd for keys prefixed by prefix.
 func (c *_EtcdClient) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is like GetValues, the calls are canceled with ctx.
+func (c *_EtcdClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	vars, _, err := c.getValues(ctx, keys, false)
+	return vars, err
+}
+
+// GetValuesWithTTL is like GetValues, and also returns the remaining TTL
+// of the keys attached to a lease.
+func (c *_EtcdClient) GetValuesWithTTL(keys []string) (map[string]string, map[string]time.Duration, error) {
+	return c.getValues(context.Background(), keys, true)
+}
+
+// GetValuesWithTTLContext is like GetValuesWithTTL, the calls are
+// canceled with ctx.
+func (c *_EtcdClient) GetValuesWithTTLContext(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error) {
+	return c.getValues(ctx, keys, true)
+}
+
+func (c *_EtcdClient) getValues(ctx context.Context, keys []string, withTTL bool) (map[string]string, map[string]time.Duration, error) {
 	vars := make(map[string]string)
+	ttls := make(map[string]time.Duration)
 
-	client, err := clientv3.New(c.cfg)
+	client, err := c.getClient()
 	if err != nil {
-		return vars, err
+		return vars, ttls, err
 	}
-	defer client.Close()
 
+	leaseTTLs := make(map[int64]time.Duration)
 	for _, key := range keys {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
-		resp, err := client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
+		callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		resp, err := client.Get(callCtx, key, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
 		cancel()
 		if err != nil {
-			return vars, err
+			return vars, ttls, err
 		}
 		for _, ev := range resp.Kvs {
 			vars[string(ev.Key)] = string(ev.Value)
+
+			if !withTTL || ev.Lease == 0 {
+				continue
+			}
+			ttl, ok := leaseTTLs[ev.Lease]
+			if !ok {
+				callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+				lresp, err := client.TimeToLive(callCtx, clientv3.LeaseID(ev.Lease))
+				cancel()
+				if err != nil {
+					return vars, ttls, err
+				}
+				ttl = time.Duration(lresp.TTL) * time.Second
+				leaseTTLs[ev.Lease] = ttl
+			}
+			if ttl > 0 {
+				ttls[string(ev.Key)] = ttl
+			}
 		}
 	}
-	return vars, nil
+	return vars, ttls, nil
+}
+
+// SetValues puts the keys and values in a single transaction.
+func (c *_EtcdClient) SetValues(values map[string]string) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	var ops []clientv3.Op
+	for k, v := range values {
+		ops = append(ops, clientv3.OpPut(k, v))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err = client.Txn(ctx).Then(ops...).Commit()
+	return err
 }
 
 func (c *_EtcdClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	var err error
+	return c.watchStop(prefix, keys, false, waitIndex, stopChan)
+}
 
-	// return something > 0 to trigger a key retrieval from the store
-	if waitIndex == 0 {
-		return 1, err
+// WatchPrefixContext is like WatchPrefix, it returns ctx.Err() once ctx
+// is done.
+func (c *_EtcdClient) WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	index, err := c.watch(ctx, prefix, keys, false, waitIndex)
+	if ctx.Err() != nil {
+		return waitIndex, ctx.Err()
 	}
+	return index, err
+}
 
-	client, err := clientv3.New(c.cfg)
+// WatchKeys is like WatchPrefix, but matches the keys exactly.
+func (c *_EtcdClient) WatchKeys(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return c.watchStop(prefix, keys, true, waitIndex, stopChan)
+}
+
+// WatchEvents sends the put and delete events of the keys under prefix,
+// by the watch of etcd.
+func (c *_EtcdClient) WatchEvents(ctx context.Context, prefix string, keys []string) (<-chan libconfd.KVEvent, error) {
+	client, err := c.getClient()
 	if err != nil {
-		return 1, err
+		return nil, err
 	}
-	defer client.Close()
 
+	events := make(chan libconfd.KVEvent, 16)
+	go func() {
+		defer close(events)
+
+		rch := client.Watch(ctx, prefix, clientv3.WithPrefix())
+		for wresp := range rch {
+			if err := wresp.Err(); err != nil {
+				logger.Warning("etcd watch events: ", err)
+				return
+			}
+			for _, ev := range wresp.Events {
+				key := string(ev.Kv.Key)
+				if !libconfd.MatchWatchKey(key, keys, false) {
+					continue
+				}
+
+				e := libconfd.KVEvent{Type: libconfd.KVEventPut, Key: key, Value: string(ev.Kv.Value)}
+				if ev.Type == clientv3.EventTypeDelete {
+					e = libconfd.KVEvent{Type: libconfd.KVEventDelete, Key: key}
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// watchStop is watch canceled by stopChan.
+func (c *_EtcdClient) watchStop(prefix string, keys []string, exact bool, waitIndex uint64, stopChan chan bool) (uint64, error) {
 	ctx, cancel := context.WithCancel(context.Background())
-	cancelRoutine := make(chan bool)
-	defer close(cancelRoutine)
+	defer cancel()
 
 	go func() {
 		select {
 		case <-stopChan:
 			cancel()
-		case <-cancelRoutine:
-			return
+		case <-ctx.Done():
 		}
 	}()
 
+	return c.watch(ctx, prefix, keys, exact, waitIndex)
+}
+
+func (c *_EtcdClient) watch(ctx context.Context, prefix string, keys []string, exact bool, waitIndex uint64) (uint64, error) {
+	var err error
+
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return 1, err
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return 1, err
+	}
+
 	rch := client.Watch(ctx, prefix, clientv3.WithPrefix())
 
 	for wresp := range rch {
@@ -149,13 +310,12 @@ func (c *_EtcdClient) WatchPrefix(prefix string, keys []string, waitIndex uint64
 			logger.Debugf("Key updated %s", string(ev.Kv.Key))
 
 			// Only return if we have a key prefix we care about.
-			// This is not an exact match on the key so there is a chance
-			// we will still pickup on false positives. The net win here
-			// is reducing the scope of keys that can trigger updates.
-			for _, k := range keys {
-				if strings.HasPrefix(string(ev.Kv.Key), k) {
-					return uint64(ev.Kv.Version), err
-				}
+			// This is not an exact match on the key unless exact is set,
+			// so there is a chance we will still pickup on false
+			// positives. The net win here is reducing the scope of keys
+			// that can trigger updates.
+			if libconfd.MatchWatchKey(string(ev.Kv.Key), keys, exact) {
+				return uint64(ev.Kv.Version), err
 			}
 		}
 	}
@@ -0,0 +1,212 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package grpc provides the backend client of the KVStore gRPC service
+// for libconfd, see kvpb/kvstore.proto.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	"openpitrix.io/libconfd"
+	"openpitrix.io/libconfd/backends/grpc/kvpb"
+)
+
+const BackendType = "libconfd-backend-grpc"
+
+var logger = libconfd.GetLoggerFor(libconfd.LogBackend)
+
+func init() {
+	libconfd.RegisterBackendClient(
+		(*_GrpcClient)(nil).Type(),
+		func(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+			return NewGrpcClient(cfg)
+		},
+	)
+}
+
+type _GrpcClient struct {
+	conn    *grpclib.ClientConn
+	client  kvpb.KVStoreClient
+	timeout time.Duration
+}
+
+// NewGrpcClient creates the client of the KVStore service at cfg.Host[0],
+// such as "127.0.0.1:9611", or "dns:///kv.example.com:9611" to balance the
+// addresses of the name. TLS is used if the client certs or CA are set.
+// cfg.Token is sent as the bearer token of the calls.
+func NewGrpcClient(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+	if len(cfg.Host) == 0 {
+		return nil, fmt.Errorf("grpc: missing host")
+	}
+
+	tlsEnabled := false
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+
+	if cfg.ClientCAKeys != "" {
+		certBytes, err := ioutil.ReadFile(cfg.ClientCAKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		ok := caCertPool.AppendCertsFromPEM(certBytes)
+
+		if ok {
+			tlsConfig.RootCAs = caCertPool
+		}
+		tlsEnabled = true
+	}
+
+	if cfg.ClientCert != "" && cfg.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+		tlsEnabled = true
+	}
+
+	opts := []grpclib.DialOption{
+		grpclib.WithTransportCredentials(insecure.NewCredentials()),
+		grpclib.WithConnectParams(grpclib.ConnectParams{
+			MinConnectTimeout: cfg.GetDialTimeout(),
+		}),
+	}
+	if tlsEnabled {
+		opts[0] = grpclib.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, grpclib.WithPerRPCCredentials(tokenCredentials{
+			token:      cfg.Token,
+			requireTLS: tlsEnabled,
+		}))
+	}
+	// the servers reject the pings more frequent than their enforcement
+	// policy, 5 minutes by default, so they are sent only if set
+	if cfg.DialKeepAliveTime > 0 {
+		opts = append(opts, grpclib.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    cfg.GetDialKeepAliveTime(),
+			Timeout: cfg.GetDialKeepAliveTimeout(),
+		}))
+	}
+
+	conn, err := grpclib.NewClient(cfg.Host[0], opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &_GrpcClient{
+		conn:    conn,
+		client:  kvpb.NewKVStoreClient(conn),
+		timeout: cfg.GetTimeout(),
+	}, nil
+}
+
+func (c *_GrpcClient) Type() string {
+	return BackendType
+}
+
+func (c *_GrpcClient) WatchEnabled() bool {
+	return true
+}
+
+// Close closes the connection, the watches in progress are canceled.
+func (c *_GrpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// GetValues reads the keys, and the keys under them.
+func (c *_GrpcClient) GetValues(keys []string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.client.GetValues(ctx, &kvpb.GetValuesRequest{Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+
+	vars := resp.GetValues()
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	return vars, nil
+}
+
+// WatchPrefix waits for an index different from waitIndex on the stream
+// of the watch, the stream is closed when the call returns.
+func (c *_GrpcClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := c.client.WatchPrefix(ctx, &kvpb.WatchPrefixRequest{
+		Prefix:    prefix,
+		Keys:      keys,
+		WaitIndex: waitIndex,
+	})
+	if err != nil {
+		return c.watchResult(waitIndex, err, stopChan)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return c.watchResult(waitIndex, err, stopChan)
+		}
+		if index := resp.GetIndex(); index != 0 && index != waitIndex {
+			logger.Debugf("Keys of %s updated, index %d", prefix, index)
+			return index, nil
+		}
+	}
+}
+
+// watchResult returns the error of the watch, nil if the watch is
+// canceled by stopChan.
+func (c *_GrpcClient) watchResult(waitIndex uint64, err error, stopChan chan bool) (uint64, error) {
+	select {
+	case <-stopChan:
+		return waitIndex, nil
+	default:
+		return waitIndex, err
+	}
+}
+
+// tokenCredentials sends the token as the bearer token.
+type tokenCredentials struct {
+	token      string
+	requireTLS bool
+}
+
+func (p tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + p.token}, nil
+}
+
+func (p tokenCredentials) RequireTransportSecurity() bool {
+	return p.requireTLS
+}
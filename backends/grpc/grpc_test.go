@@ -0,0 +1,184 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"openpitrix.io/libconfd"
+	"openpitrix.io/libconfd/backends/grpc/kvpb"
+)
+
+// tServer is the KVStore service of a map, the index is increased by
+// every set.
+type tServer struct {
+	kvpb.UnimplementedKVStoreServer
+
+	token string
+
+	mu      sync.Mutex
+	values  map[string]string
+	index   uint64
+	changed chan struct{}
+}
+
+func newTServer(t *testing.T, values map[string]string) (*tServer, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &tServer{values: values, index: 1, changed: make(chan struct{})}
+
+	s := grpclib.NewServer(grpclib.UnaryInterceptor(p.checkToken))
+	kvpb.RegisterKVStoreServer(s, p)
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+
+	return p, ln.Addr().String()
+}
+
+func (p *tServer) checkToken(ctx context.Context, req interface{}, info *grpclib.UnaryServerInfo, handler grpclib.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if p.token != "" && strings.Join(md.Get("authorization"), "") != "Bearer "+p.token {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return handler(ctx, req)
+}
+
+func (p *tServer) set(key, value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.values[key] = value
+	p.index++
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *tServer) GetValues(ctx context.Context, req *kvpb.GetValuesRequest) (*kvpb.GetValuesResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	values := make(map[string]string)
+	for k, v := range p.values {
+		if libconfd.MatchWatchKey(k, req.Keys, false) {
+			values[k] = v
+		}
+	}
+	return &kvpb.GetValuesResponse{Values: values}, nil
+}
+
+func (p *tServer) WatchPrefix(req *kvpb.WatchPrefixRequest, stream kvpb.KVStore_WatchPrefixServer) error {
+	for {
+		p.mu.Lock()
+		index, changed := p.index, p.changed
+		p.mu.Unlock()
+
+		if index != req.WaitIndex {
+			if err := stream.Send(&kvpb.WatchPrefixResponse{Index: index}); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func TestGrpcClient(t *testing.T) {
+	server, addr := newTServer(t, map[string]string{
+		"/app/port": "80",
+		"/app/host": "a.b",
+		"/db/user":  "root",
+	})
+	server.token = "secret"
+
+	client, err := libconfd.NewBackendClient(&libconfd.BackendConfig{
+		Type:  BackendType,
+		Host:  []string{addr},
+		Token: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := client.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["/app/port"] != "80" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	client, _ = NewGrpcClient(&libconfd.BackendConfig{Host: []string{addr}, Token: "bad"})
+	if _, err := client.GetValues([]string{"/app"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expect the error of invalid token, got %v", err)
+	}
+}
+
+func TestGrpcClientWatchPrefix(t *testing.T) {
+	server, addr := newTServer(t, map[string]string{"/app/port": "80"})
+
+	client, err := NewGrpcClient(&libconfd.BackendConfig{Host: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"/app/port"}
+	index, err := client.WatchPrefix("/app", keys, 0, nil)
+	if err != nil || index != 1 {
+		t.Fatalf("unexpected index %d: %v", index, err)
+	}
+
+	done := make(chan uint64, 1)
+	go func() {
+		i, _ := client.WatchPrefix("/app", keys, index, make(chan bool))
+		done <- i
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	server.set("/app/port", "81")
+
+	select {
+	case i := <-done:
+		if i != 2 {
+			t.Fatalf("unexpected index %d", i)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	// the watch is canceled by stopChan
+	stopChan := make(chan bool)
+	go func() {
+		i, err := client.WatchPrefix("/app", keys, 2, stopChan)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- i
+	}()
+	time.Sleep(time.Millisecond * 50)
+	close(stopChan)
+	select {
+	case i := <-done:
+		if i != 2 {
+			t.Fatalf("unexpected index %d", i)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop timeout")
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package redis provides redis backends client for libconfd.
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/libconfd"
+)
+
+const BackendType = "libconfd-backend-redis"
+
+var logger = libconfd.GetLoggerFor(libconfd.LogBackend)
+
+func init() {
+	libconfd.RegisterBackendClient(
+		(*_RedisClient)(nil).Type(),
+		func(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+			return NewRedisClient(cfg)
+		},
+	)
+}
+
+var _ libconfd.StoreWriter = (*_RedisClient)(nil)
+
+// the max wait for the keyspace notifications, the values are polled
+// after it, or every interval if the notifications are disabled
+var watchPollInterval = 30 * time.Second
+
+// redisAddr is a redis server of cfg.Host.
+type redisAddr struct {
+	addr string // host:port
+	db   int
+	tls  bool
+}
+
+// _RedisClient maps the redis keys to the keys of libconfd: a string key
+// is a key, and the fields of a hash key are the child keys, such as
+// /app/db/password of the field password of the hash /app/db.
+type _RedisClient struct {
+	addrs       []redisAddr
+	user        string
+	password    string
+	timeout     time.Duration
+	dialTimeout time.Duration
+	keepAlive   time.Duration
+	tlsConfig   *tls.Config
+
+	mu   sync.Mutex
+	conn *respConn // shared by the calls other than the watches
+}
+
+// NewRedisClient creates the redis client of the servers of cfg.Host,
+// such as "127.0.0.1:6379", "127.0.0.1:6379/2" of the db 2, or
+// "rediss://redis.example.com:6380" of TLS. The servers are tried in
+// order. The password is cfg.Password, with cfg.UserName of the ACL
+// of redis 6.
+func NewRedisClient(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+	tlsEnabled := false
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+
+	if cfg.ClientCAKeys != "" {
+		certBytes, err := ioutil.ReadFile(cfg.ClientCAKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		ok := caCertPool.AppendCertsFromPEM(certBytes)
+
+		if ok {
+			tlsConfig.RootCAs = caCertPool
+		}
+		tlsEnabled = true
+	}
+
+	if cfg.ClientCert != "" && cfg.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+		tlsEnabled = true
+	}
+
+	var addrs []redisAddr
+	for _, host := range cfg.Host {
+		addr, err := parseRedisAddr(host, tlsEnabled)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		addrs = []redisAddr{{addr: "127.0.0.1:6379", tls: tlsEnabled}}
+	}
+
+	return &_RedisClient{
+		addrs:       addrs,
+		user:        cfg.UserName,
+		password:    cfg.Password,
+		timeout:     cfg.GetTimeout(),
+		dialTimeout: cfg.GetDialTimeout(),
+		keepAlive:   cfg.GetDialKeepAliveTime(),
+		tlsConfig:   tlsConfig,
+	}, nil
+}
+
+// parseRedisAddr parses "[redis://|rediss://]host:port[/db]".
+func parseRedisAddr(host string, tlsEnabled bool) (redisAddr, error) {
+	p := redisAddr{tls: tlsEnabled}
+	switch {
+	case strings.HasPrefix(host, "rediss://"):
+		host, p.tls = strings.TrimPrefix(host, "rediss://"), true
+	case strings.HasPrefix(host, "redis://"):
+		host = strings.TrimPrefix(host, "redis://")
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		db, err := strconv.Atoi(strings.Trim(host[i:], "/"))
+		if err != nil || db < 0 {
+			return p, fmt.Errorf("redis: invalid db of host %q", host)
+		}
+		host, p.db = host[:i], db
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "6379")
+	}
+	p.addr = host
+	return p, nil
+}
+
+func (c *_RedisClient) Type() string {
+	return BackendType
+}
+
+func (c *_RedisClient) WatchEnabled() bool {
+	return true
+}
+
+// Close closes the shared connection, the next call dials again.
+func (c *_RedisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// GetValues reads the keys, and the keys under them.
+func (c *_RedisClient) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	err := c.withConn(func(conn *respConn) error {
+		for _, key := range keys {
+			if err := readKey(conn, key, vars); err != nil {
+				return err
+			}
+
+			pattern := escapePattern(strings.TrimSuffix(key, "/")) + "/*"
+			for cursor := "0"; ; {
+				reply, err := conn.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+				if err != nil {
+					return err
+				}
+				values, ok := reply.([]interface{})
+				if !ok || len(values) != 2 {
+					return fmt.Errorf("redis: unexpected reply of SCAN")
+				}
+				if cursor, err = replyString(values[0]); err != nil {
+					return err
+				}
+				found, err := replyStrings(values[1])
+				if err != nil {
+					return err
+				}
+				for _, k := range found {
+					if err := readKey(conn, k, vars); err != nil {
+						return err
+					}
+				}
+				if cursor == "0" {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return vars, err
+}
+
+// readKey reads the string or the hash of key, the other types are
+// ignored.
+func readKey(conn *respConn, key string, vars map[string]string) error {
+	reply, err := conn.do("TYPE", key)
+	if err != nil {
+		return err
+	}
+	switch reply {
+	case "string":
+		reply, err := conn.do("GET", key)
+		if err != nil || reply == nil {
+			return err
+		}
+		vars[key], err = replyString(reply)
+		return err
+	case "hash":
+		reply, err := conn.do("HGETALL", key)
+		if err != nil {
+			return err
+		}
+		fields, err := replyStrings(reply)
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			vars[strings.TrimSuffix(key, "/")+"/"+fields[i]] = fields[i+1]
+		}
+	}
+	return nil
+}
+
+// escapePattern escapes the special chars of the glob pattern of redis.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SetValues sets the keys and values as the string keys by MSET.
+func (c *_RedisClient) SetValues(values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	args := []string{"MSET"}
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+	return c.withConn(func(conn *respConn) error {
+		_, err := conn.do(args...)
+		return err
+	})
+}
+
+// WatchPrefix waits for the keyspace notifications of the keys under
+// prefix, or polls the values every watchPollInterval if the
+// notifications are disabled by notify-keyspace-events of the server.
+// The index is the hash of the values, so a change is never missed
+// between the calls.
+func (c *_RedisClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	// subscribe before the values are read, so no change is missed
+	sub, err := c.subscribe(prefix)
+	if err != nil {
+		return waitIndex, err
+	}
+	events := make(chan error, 1)
+	if sub != nil {
+		done := make(chan bool)
+		defer sub.Close()
+		defer close(done)
+		go readEvents(sub, keys, events, done)
+	}
+
+	for {
+		values, err := c.GetValues(keys)
+		if err != nil {
+			return waitIndex, err
+		}
+		if index := hashValues(values); index != waitIndex {
+			return index, nil
+		}
+
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case err := <-events:
+			if err != nil {
+				return waitIndex, err
+			}
+		case <-time.After(watchPollInterval):
+		}
+	}
+}
+
+// readEvents reads the notifications of sub until it is closed. The
+// events of the keys are sent to events as nil, at most one is pending,
+// and the error of the connection is sent unless done is closed.
+func readEvents(sub *respConn, keys []string, events chan error, done chan bool) {
+	for {
+		reply, err := sub.receive()
+		if err != nil {
+			select {
+			case events <- err:
+			case <-done:
+			}
+			return
+		}
+		// ["pmessage", pattern, "__keyspace@<db>__:<key>", event]
+		msg, err := replyStrings(reply)
+		if err != nil || len(msg) != 4 || msg[0] != "pmessage" {
+			continue
+		}
+		key := msg[2][strings.Index(msg[2], ":")+1:]
+		if libconfd.MatchWatchKey(key, keys, false) {
+			logger.Debugf("Key %s updated by %s", key, msg[3])
+			select {
+			case events <- nil:
+			default:
+			}
+		}
+	}
+}
+
+// subscribe subscribes the keyspace notifications of the keys under
+// prefix by a new connection. It returns nil if the notifications are
+// disabled, or CONFIG is not allowed to check it.
+func (c *_RedisClient) subscribe(prefix string) (*respConn, error) {
+	conn, addr, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do("CONFIG", "GET", "notify-keyspace-events")
+	if err != nil {
+		conn.Close()
+		if _, ok := err.(redisError); ok {
+			logger.Debugf("keyspace notifications unknown, polling %s: %v", prefix, err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	if config, _ := replyStrings(reply); len(config) != 2 || !keyspaceEventsEnabled(config[1]) {
+		conn.Close()
+		logger.Debugf("keyspace notifications disabled, polling %s", prefix)
+		return nil, nil
+	}
+
+	pattern := fmt.Sprintf("__keyspace@%d__:%s*", addr.db, escapePattern(prefix))
+	if _, err := conn.do("PSUBSCRIBE", pattern); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.timeout = 0
+	return conn, nil
+}
+
+// keyspaceEventsEnabled reports whether the notify-keyspace-events flags
+// enable the keyspace events of the strings and the hashes.
+func keyspaceEventsEnabled(flags string) bool {
+	if !strings.Contains(flags, "K") {
+		return false
+	}
+	if strings.Contains(flags, "A") {
+		return true
+	}
+	return strings.Contains(flags, "$") && strings.Contains(flags, "h") && strings.Contains(flags, "g")
+}
+
+func hashValues(values map[string]string) uint64 {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", k, values[k])
+	}
+	if index := h.Sum64(); index > 1 {
+		return index
+	}
+	return 2 // 0 and 1 are the indexes before the first read
+}
+
+// withConn calls fn with the shared connection, the connection is closed
+// and dialed again by the next call if fn failed.
+func (c *_RedisClient) withConn(fn func(conn *respConn) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, _, err := c.dial()
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	if err := fn(c.conn); err != nil {
+		if _, ok := err.(redisError); !ok {
+			c.conn.Close()
+			c.conn = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// dial connects to the servers in order, until one of them answers, then
+// authenticates and selects the db.
+func (c *_RedisClient) dial() (*respConn, redisAddr, error) {
+	var lastErr error
+	for _, addr := range c.addrs {
+		conn, err := c.dialAddr(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, addr, nil
+	}
+	return nil, redisAddr{}, lastErr
+}
+
+func (c *_RedisClient) dialAddr(addr redisAddr) (*respConn, error) {
+	dialer := &net.Dialer{
+		Timeout:   c.dialTimeout,
+		KeepAlive: c.keepAlive,
+	}
+	var netConn net.Conn
+	var err error
+	if addr.tls {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", addr.addr, c.tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", addr.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newRespConn(netConn, c.timeout)
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.user != "" {
+			args = []string{"AUTH", c.user, c.password}
+		}
+		if _, err := conn.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if addr.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(addr.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
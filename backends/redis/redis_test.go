@@ -0,0 +1,281 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package redis
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openpitrix.io/libconfd"
+)
+
+// tServer is a fake redis server of the commands used by the client.
+type tServer struct {
+	sync.Mutex
+	ln       net.Listener
+	password string
+	notify   string // notify-keyspace-events
+	strings  map[string]string
+	hashes   map[string]map[string]string
+	subs     []*respConn
+	cmds     []string
+}
+
+func newTServer(t *testing.T) *tServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tServer{
+		ln:      ln,
+		strings: make(map[string]string),
+		hashes:  make(map[string]map[string]string),
+	}
+}
+
+// start serves the connections, after the fields are set.
+func (p *tServer) start() {
+	go func() {
+		for {
+			conn, err := p.ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(newRespConn(conn, 0))
+		}
+	}()
+}
+
+func (p *tServer) serve(c *respConn) {
+	defer c.Close()
+	authed := p.password == ""
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return
+		}
+		args, _ := replyStrings(reply)
+
+		p.Lock()
+		p.cmds = append(p.cmds, args[0])
+		resp := "-ERR unknown command\r\n"
+		switch {
+		case args[0] == "AUTH":
+			authed = args[len(args)-1] == p.password
+			resp = "+OK\r\n"
+			if !authed {
+				resp = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			resp = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			resp = "+OK\r\n"
+		case args[0] == "TYPE":
+			resp = "+none\r\n"
+			if _, ok := p.strings[args[1]]; ok {
+				resp = "+string\r\n"
+			} else if _, ok := p.hashes[args[1]]; ok {
+				resp = "+hash\r\n"
+			}
+		case args[0] == "GET":
+			resp = tBulk(p.strings[args[1]])
+		case args[0] == "HGETALL":
+			var fields []string
+			for k, v := range p.hashes[args[1]] {
+				fields = append(fields, k, v)
+			}
+			resp = tArray(fields...)
+		case args[0] == "SCAN":
+			prefix := strings.Replace(strings.TrimSuffix(args[3], "*"), `\`, "", -1)
+			var keys []string
+			for k := range p.strings {
+				if strings.HasPrefix(k, prefix) {
+					keys = append(keys, k)
+				}
+			}
+			for k := range p.hashes {
+				if strings.HasPrefix(k, prefix) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			resp = "*2\r\n" + tBulk("0") + tArray(keys...)
+		case args[0] == "MSET":
+			for i := 1; i+1 < len(args); i += 2 {
+				p.strings[args[i]] = args[i+1]
+				if strings.Contains(p.notify, "K") {
+					for _, sub := range p.subs {
+						sub.w.WriteString(tArray("pmessage", "*", "__keyspace@0__:"+args[i], "set"))
+						sub.w.Flush()
+					}
+				}
+			}
+			resp = "+OK\r\n"
+		case args[0] == "CONFIG":
+			resp = tArray("notify-keyspace-events", p.notify)
+		case args[0] == "PSUBSCRIBE":
+			p.subs = append(p.subs, c)
+			resp = "*3\r\n" + tBulk("psubscribe") + tBulk(args[1]) + ":1\r\n"
+		}
+		c.w.WriteString(resp)
+		c.w.Flush()
+		p.Unlock()
+	}
+}
+
+func tBulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func tArray(ss ...string) string {
+	resp := fmt.Sprintf("*%d\r\n", len(ss))
+	for _, s := range ss {
+		resp += tBulk(s)
+	}
+	return resp
+}
+
+func TestParseRedisAddr(t *testing.T) {
+	for host, expected := range map[string]redisAddr{
+		"127.0.0.1:6379":            {addr: "127.0.0.1:6379"},
+		"127.0.0.1:6379/2":          {addr: "127.0.0.1:6379", db: 2},
+		"redis://redis.example.com": {addr: "redis.example.com:6379"},
+		"rediss://[::1]:6380/1":     {addr: "[::1]:6380", db: 1, tls: true},
+	} {
+		addr, err := parseRedisAddr(host, false)
+		if err != nil || addr != expected {
+			t.Fatalf("%s: unexpected %v, %v", host, addr, err)
+		}
+	}
+	if _, err := parseRedisAddr("127.0.0.1:6379/x", false); err == nil {
+		t.Fatal("expect the error of invalid db")
+	}
+}
+
+func TestRedisClient(t *testing.T) {
+	server := newTServer(t)
+	defer server.ln.Close()
+	server.password = "secret"
+	server.strings["/app/port"] = "80"
+	server.strings["/app[1]/port"] = "81"
+	server.strings["/web/port"] = "8080"
+	server.hashes["/app/db"] = map[string]string{"user": "app", "password": "p@ss"}
+	server.start()
+
+	// the first server is down
+	client, err := libconfd.NewBackendClient(&libconfd.BackendConfig{
+		Type:     BackendType,
+		Host:     []string{"127.0.0.1:1", server.ln.Addr().String() + "/1"},
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := client.GetValues([]string{"/app", "/app[1]", "/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/app/port":        "80",
+		"/app/db/user":     "app",
+		"/app/db/password": "p@ss",
+		"/app[1]/port":     "81",
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Fatalf("%s: expected %q, got %q", k, v, values[k])
+		}
+	}
+
+	if err := client.(libconfd.StoreWriter).SetValues(map[string]string{"/app/port": "8080"}); err != nil {
+		t.Fatal(err)
+	}
+	values, _ = client.GetValues([]string{"/app/port"})
+	if values["/app/port"] != "8080" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	server.Lock()
+	if server.cmds[0] != "AUTH" || server.cmds[1] != "SELECT" {
+		t.Fatalf("unexpected commands: %v", server.cmds)
+	}
+	server.Unlock()
+}
+
+func TestRedisClientWatchPrefix(t *testing.T) {
+	interval := watchPollInterval
+	defer func() { watchPollInterval = interval }()
+
+	for _, notify := range []string{"KEA", ""} {
+		server := newTServer(t)
+		server.notify = notify
+		server.strings["/app/port"] = "80"
+		server.start()
+
+		// the notifications, or the polling if they are disabled
+		watchPollInterval = time.Hour
+		if notify == "" {
+			watchPollInterval = time.Millisecond * 10
+		}
+
+		client, err := NewRedisClient(&libconfd.BackendConfig{Host: []string{server.ln.Addr().String()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := []string{"/app/port"}
+		index, err := client.WatchPrefix("/app", keys, 0, nil)
+		if err != nil || index != 1 {
+			t.Fatalf("unexpected index %d: %v", index, err)
+		}
+		index, err = client.WatchPrefix("/app", keys, index, nil)
+		if err != nil || index <= 1 {
+			t.Fatalf("unexpected index %d: %v", index, err)
+		}
+
+		done := make(chan uint64, 1)
+		go func() {
+			i, _ := client.WatchPrefix("/app", keys, index, make(chan bool))
+			done <- i
+		}()
+
+		time.Sleep(time.Millisecond * 50)
+		if err := client.(libconfd.StoreWriter).SetValues(map[string]string{"/app/port": "81"}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case i := <-done:
+			if i == index {
+				t.Fatalf("%q: unexpected index %d", notify, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: watch timeout", notify)
+		}
+
+		// the watch is canceled by stopChan
+		stopChan := make(chan bool)
+		go func() {
+			i, _ := client.WatchPrefix("/app", keys, 2, stopChan)
+			done <- i
+		}()
+		close(stopChan)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: stop timeout", notify)
+		}
+		server.ln.Close()
+	}
+}
@@ -0,0 +1,596 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package vault provides vault backends client for libconfd.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openpitrix.io/libconfd"
+)
+
+const BackendType = "libconfd-backend-vault"
+
+// The auth types of vault.
+const (
+	AuthTypeToken      = "token"
+	AuthTypeAppRole    = "approle"
+	AuthTypeKubernetes = "kubernetes"
+)
+
+var logger = libconfd.GetLoggerFor(libconfd.LogBackend)
+
+func init() {
+	libconfd.RegisterBackendClient(
+		(*_VaultClient)(nil).Type(),
+		func(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+			return NewVaultClient(cfg)
+		},
+	)
+}
+
+var _ libconfd.BackendContextClient = (*_VaultClient)(nil)
+
+var (
+	// vault has no watch, the values are polled
+	watchPollInterval = 30 * time.Second
+
+	// the service account token of the kubernetes auth
+	kubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// _VaultClient reads the secrets of the KV v1 and v2 mounts of vault. The
+// key of a secret is the path of the secret, such as /secret/app/db, its
+// value is the JSON of the secret, and the fields of the secret are the
+// child keys, such as /secret/app/db/password.
+type _VaultClient struct {
+	cfg     *libconfd.BackendConfig
+	addrs   []string // scheme://host:port
+	timeout time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	token     string
+	renewable bool
+	ttl       time.Duration // 0 if the token never expires
+	issued    time.Time     // of the token or the last renewal
+	mounts    map[string]*kvMount
+}
+
+// kvMount is the KV secrets engine mounted at path.
+type kvMount struct {
+	path    string // with the trailing "/"
+	version string // "1" or "2"
+}
+
+// NewVaultClient creates the vault client of the servers of cfg.Host,
+// such as "https://vault.example.com:8200", tried in order. The token is
+// renewed on use, or the client logs in again by the approle or the
+// kubernetes auth, so the token auth needs the interval shorter than the
+// TTL of the token.
+func NewVaultClient(cfg *libconfd.BackendConfig) (libconfd.BackendClient, error) {
+	switch cfg.AuthType {
+	case "", AuthTypeToken:
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("vault: missing token")
+		}
+	case AuthTypeAppRole:
+		if cfg.RoleID == "" {
+			return nil, fmt.Errorf("vault: missing role-id of approle auth")
+		}
+	case AuthTypeKubernetes:
+		if cfg.AuthRole == "" {
+			return nil, fmt.Errorf("vault: missing auth-role of kubernetes auth")
+		}
+	default:
+		return nil, fmt.Errorf("vault: invalid auth-type %q", cfg.AuthType)
+	}
+
+	tlsEnabled := false
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+
+	if cfg.ClientCAKeys != "" {
+		certBytes, err := ioutil.ReadFile(cfg.ClientCAKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		ok := caCertPool.AppendCertsFromPEM(certBytes)
+
+		if ok {
+			tlsConfig.RootCAs = caCertPool
+		}
+		tlsEnabled = true
+	}
+
+	if cfg.ClientCert != "" && cfg.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+		tlsEnabled = true
+	}
+
+	scheme := "http"
+	dialer := &net.Dialer{
+		Timeout:   cfg.GetDialTimeout(),
+		KeepAlive: cfg.GetDialKeepAliveTime(),
+	}
+	transport := &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: dialer.DialContext,
+	}
+	if tlsEnabled {
+		scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	var addrs []string
+	for _, host := range cfg.Host {
+		if !strings.Contains(host, "://") {
+			host = scheme + "://" + host
+		}
+		addrs = append(addrs, strings.TrimSuffix(host, "/"))
+	}
+	if len(addrs) == 0 {
+		addrs = []string{scheme + "://127.0.0.1:8200"}
+	}
+
+	return &_VaultClient{
+		cfg:     cfg.Clone(),
+		addrs:   addrs,
+		timeout: cfg.GetTimeout(),
+		client:  &http.Client{Transport: transport},
+		mounts:  make(map[string]*kvMount),
+	}, nil
+}
+
+func (c *_VaultClient) Type() string {
+	return BackendType
+}
+
+func (c *_VaultClient) WatchEnabled() bool {
+	return true
+}
+
+// Close closes the idle connections of the client.
+func (c *_VaultClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// GetValues reads the secrets of the keys, and the secrets under them.
+func (c *_VaultClient) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is like GetValues, the requests are canceled with ctx.
+func (c *_VaultClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		p := strings.Trim(key, "/")
+		if p == "" {
+			return vars, fmt.Errorf("vault: the key %q is not under a mount", key)
+		}
+		m, err := c.getMount(ctx, p)
+		if err != nil {
+			return vars, err
+		}
+		if err := c.walk(ctx, m, strings.TrimPrefix(p+"/", m.path), vars); err != nil {
+			return vars, err
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix polls the values of the keys, the index is the hash of the
+// values, so a change is never missed between the calls.
+func (c *_VaultClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	index, err := c.WatchPrefixContext(ctx, prefix, keys, waitIndex)
+	if ctx.Err() != nil {
+		return waitIndex, nil
+	}
+	return index, err
+}
+
+// WatchPrefixContext is like WatchPrefix, it returns ctx.Err() once ctx
+// is done.
+func (c *_VaultClient) WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	for {
+		values, err := c.GetValuesContext(ctx, keys)
+		if ctx.Err() != nil {
+			return waitIndex, ctx.Err()
+		}
+		if err != nil {
+			return waitIndex, err
+		}
+		if index := hashValues(values); index != waitIndex {
+			return index, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return waitIndex, ctx.Err()
+		case <-time.After(watchPollInterval):
+		}
+	}
+}
+
+func hashValues(values map[string]string) uint64 {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", k, values[k])
+	}
+	if index := h.Sum64(); index > 1 {
+		return index
+	}
+	return 2 // 0 and 1 are the indexes before the first poll
+}
+
+// walk reads the secret of sub in the mount, and the secrets under it,
+// sub is "" or ends with "/".
+func (c *_VaultClient) walk(ctx context.Context, m *kvMount, sub string, vars map[string]string) error {
+	if name := strings.TrimSuffix(sub, "/"); name != "" {
+		if err := c.readSecret(ctx, m, name, vars); err != nil {
+			return err
+		}
+	}
+
+	children, err := c.listSecrets(ctx, m, sub)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if strings.HasSuffix(child, "/") {
+			err = c.walk(ctx, m, sub+child, vars)
+		} else {
+			err = c.readSecret(ctx, m, sub+child, vars)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *_VaultClient) readSecret(ctx context.Context, m *kvMount, name string, vars map[string]string) error {
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	api := m.path + name
+	if m.version == "2" {
+		api = m.path + "data/" + name
+	}
+	found, err := c.request(ctx, "GET", api, nil, &resp)
+	if err != nil || !found {
+		return err
+	}
+
+	data := resp.Data
+	if m.version == "2" {
+		var v2 struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(resp.Data, &v2); err != nil {
+			return err
+		}
+		data = v2.Data
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return err // deleted secret of v2
+	}
+
+	key := "/" + m.path + name
+	vars[key] = string(data)
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			vars[path.Join(key, k)] = s
+			continue
+		}
+		js, _ := json.Marshal(v)
+		vars[path.Join(key, k)] = string(js)
+	}
+	return nil
+}
+
+func (c *_VaultClient) listSecrets(ctx context.Context, m *kvMount, sub string) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	api := m.path + sub
+	if m.version == "2" {
+		api = m.path + "metadata/" + sub
+	}
+	if _, err := c.request(ctx, "LIST", api, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data.Keys, nil
+}
+
+// getMount returns the KV mount of the path, the KV v1 mount of the first
+// path element is assumed if vault does not tell.
+func (c *_VaultClient) getMount(ctx context.Context, p string) (*kvMount, error) {
+	c.mu.Lock()
+	for prefix, m := range c.mounts {
+		if strings.HasPrefix(p+"/", prefix) {
+			c.mu.Unlock()
+			return m, nil
+		}
+	}
+	c.mu.Unlock()
+
+	var resp struct {
+		Data struct {
+			Path    string            `json:"path"`
+			Options map[string]string `json:"options"`
+		} `json:"data"`
+	}
+	found, err := c.request(ctx, "GET", "sys/internal/ui/mounts/"+p, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &kvMount{path: resp.Data.Path, version: resp.Data.Options["version"]}
+	if !found || m.path == "" {
+		m.path = strings.SplitN(p, "/", 2)[0] + "/"
+	}
+	if m.version != "2" {
+		m.version = "1"
+	}
+
+	c.mu.Lock()
+	c.mounts[m.path] = m
+	c.mu.Unlock()
+	return m, nil
+}
+
+// request calls the API of vault with the token, out is the decoded JSON
+// response. It returns false if the API answers 404. The token is renewed
+// or the client logs in again if needed.
+func (c *_VaultClient) request(ctx context.Context, method, api string, in, out interface{}) (bool, error) {
+	token, err := c.getToken(ctx, false)
+	if err != nil {
+		return false, err
+	}
+	status, err := c.do(ctx, method, api, token, in, out)
+	if status == http.StatusForbidden && c.canLogin() {
+		// the token is revoked or expired before renewed
+		if token, err = c.getToken(ctx, true); err != nil {
+			return false, err
+		}
+		status, err = c.do(ctx, method, api, token, in, out)
+	}
+	return status != http.StatusNotFound, err
+}
+
+func (c *_VaultClient) canLogin() bool {
+	return c.cfg.AuthType == AuthTypeAppRole || c.cfg.AuthType == AuthTypeKubernetes
+}
+
+// getToken returns the token, it renews the token after 2/3 of its TTL,
+// or logs in again if the renewal fails or relogin is set.
+func (c *_VaultClient) getToken(ctx context.Context, relogin bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && !relogin {
+		if c.ttl == 0 || time.Since(c.issued) < c.ttl*2/3 {
+			return c.token, nil
+		}
+		if c.renewable {
+			err := c.renew(ctx)
+			if err == nil {
+				return c.token, nil
+			}
+			logger.Warningf("vault: renew token failed: %v", err)
+		}
+	}
+	if err := c.login(ctx); err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
+
+// vaultAuth is the auth of the login and renew responses.
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+func (c *_VaultClient) setAuth(auth *vaultAuth) {
+	if auth.ClientToken != "" {
+		c.token = auth.ClientToken
+	}
+	c.ttl = time.Duration(auth.LeaseDuration) * time.Second
+	c.renewable = auth.Renewable
+	c.issued = time.Now()
+}
+
+// login gets the token of the auth type, the token of the token auth is
+// looked up for its TTL.
+func (c *_VaultClient) login(ctx context.Context) error {
+	authPath := c.cfg.AuthPath
+	if authPath == "" {
+		authPath = c.cfg.AuthType
+	}
+
+	var body map[string]string
+	switch c.cfg.AuthType {
+	case AuthTypeAppRole:
+		body = map[string]string{"role_id": c.cfg.RoleID, "secret_id": c.cfg.SecretID}
+	case AuthTypeKubernetes:
+		jwt, err := ioutil.ReadFile(kubernetesTokenFile)
+		if err != nil {
+			return err
+		}
+		body = map[string]string{"role": c.cfg.AuthRole, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		var resp struct {
+			Data struct {
+				TTL       int  `json:"ttl"`
+				Renewable bool `json:"renewable"`
+			} `json:"data"`
+		}
+		if _, err := c.do(ctx, "GET", "auth/token/lookup-self", c.cfg.Token, nil, &resp); err != nil {
+			return fmt.Errorf("vault: lookup token: %v", err)
+		}
+		c.setAuth(&vaultAuth{
+			ClientToken:   c.cfg.Token,
+			LeaseDuration: resp.Data.TTL,
+			Renewable:     resp.Data.Renewable,
+		})
+		return nil
+	}
+
+	var resp struct {
+		Auth vaultAuth `json:"auth"`
+	}
+	if _, err := c.do(ctx, "POST", "auth/"+strings.Trim(authPath, "/")+"/login", "", body, &resp); err != nil {
+		return fmt.Errorf("vault: %s login: %v", c.cfg.AuthType, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault: %s login: no token", c.cfg.AuthType)
+	}
+	c.setAuth(&resp.Auth)
+	logger.Debugf("vault: logged in by %s, ttl %v", c.cfg.AuthType, c.ttl)
+	return nil
+}
+
+func (c *_VaultClient) renew(ctx context.Context) error {
+	var resp struct {
+		Auth vaultAuth `json:"auth"`
+	}
+	if _, err := c.do(ctx, "POST", "auth/token/renew-self", c.token, map[string]string{}, &resp); err != nil {
+		return err
+	}
+	// the max TTL is reached if the TTL is not extended
+	if time.Duration(resp.Auth.LeaseDuration)*time.Second < c.ttl/3 && c.canLogin() {
+		return fmt.Errorf("max ttl reached")
+	}
+	c.setAuth(&resp.Auth)
+	return nil
+}
+
+// do sends the request to the servers in order, until one of them
+// answers. It returns the status, the status other than 2xx and 404 is an
+// error.
+func (c *_VaultClient) do(ctx context.Context, method, api, token string, in, out interface{}) (int, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+
+	// LIST is GET with list=true, for the proxies rejecting LIST
+	query := ""
+	if method == "LIST" {
+		method, query = "GET", "?list=true"
+	}
+
+	var lastErr error
+	for _, addr := range c.addrs {
+		u := addr + "/v1/" + api + query
+
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, u, r)
+		if err != nil {
+			return 0, err
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		req = req.WithContext(reqCtx)
+		if token != "" {
+			req.Header.Set("X-Vault-Token", token)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			cancel()
+			if ctx.Err() != nil {
+				return 0, err
+			}
+			lastErr = err
+			continue
+		}
+		status, err := decodeResponse(resp, out)
+		cancel()
+		if err != nil {
+			return status, fmt.Errorf("vault: %s %s: %v", method, api, err)
+		}
+		return status, nil
+	}
+	return 0, lastErr
+}
+
+func decodeResponse(resp *http.Response, out interface{}) (int, error) {
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, nil
+	case resp.StatusCode/100 != 2:
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+			return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.Join(e.Errors, "; "))
+		}
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	case resp.StatusCode == http.StatusNoContent || out == nil:
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,337 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openpitrix.io/libconfd"
+)
+
+// tVault is a fake vault with the KV v2 mount secret/ and the KV v1
+// mount kv/, the tokens expire after ttl seconds.
+type tVault struct {
+	sync.Mutex
+	secrets map[string]map[string]interface{} // by mount/path
+	tokens  map[string]time.Time              // expire time
+	ttl     int
+	logins  int
+	renews  int
+}
+
+func (p *tVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	defer p.Unlock()
+
+	api := strings.TrimPrefix(r.URL.Path, "/v1/")
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	auth := func(token string) map[string]interface{} {
+		return map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": token, "lease_duration": p.ttl, "renewable": true,
+		}}
+	}
+
+	if api == "auth/approle/login" {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			http.Error(w, `{"errors":["invalid role"]}`, http.StatusBadRequest)
+			return
+		}
+		p.logins++
+		token := "t" + string(rune('0'+p.logins))
+		p.tokens[token] = time.Now().Add(time.Duration(p.ttl) * time.Second)
+		reply(auth(token))
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if expire, ok := p.tokens[token]; !ok || time.Now().After(expire) {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case api == "auth/token/renew-self":
+		p.renews++
+		p.tokens[token] = time.Now().Add(time.Duration(p.ttl) * time.Second)
+		reply(auth(token))
+
+	case strings.HasPrefix(api, "sys/internal/ui/mounts/"):
+		mount, version := "kv/", "1"
+		if strings.HasPrefix(api, "sys/internal/ui/mounts/secret") {
+			mount, version = "secret/", "2"
+		}
+		reply(map[string]interface{}{"data": map[string]interface{}{
+			"path": mount, "type": "kv", "options": map[string]string{"version": version},
+		}})
+
+	case r.URL.Query().Get("list") == "true":
+		dir := strings.Replace(api, "secret/metadata/", "secret/", 1)
+		seen := make(map[string]bool)
+		for name := range p.secrets {
+			if strings.HasPrefix(name, dir) {
+				child := strings.TrimPrefix(name, dir)
+				if i := strings.Index(child, "/"); i >= 0 {
+					child = child[:i+1]
+				}
+				seen[child] = true
+			}
+		}
+		if len(seen) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		var keys []string
+		for k := range seen {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		reply(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+
+	default:
+		name := strings.Replace(api, "secret/data/", "secret/", 1)
+		data, ok := p.secrets[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(api, "secret/") {
+			reply(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+		} else {
+			reply(map[string]interface{}{"data": data})
+		}
+	}
+}
+
+func TestVaultClient(t *testing.T) {
+	vault := &tVault{
+		secrets: map[string]map[string]interface{}{
+			"secret/app/db":      {"user": "app", "password": "p@ss"},
+			"secret/app/tls/key": {"pem": "KEY"},
+			"kv/web":             {"port": 8080},
+		},
+		tokens: map[string]time.Time{"root": time.Now().Add(time.Hour)},
+		ttl:    3,
+	}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+
+	// the token auth
+	client, err := libconfd.NewBackendClient(&libconfd.BackendConfig{
+		Type:  BackendType,
+		Host:  []string{ts.URL},
+		Token: "root",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := client.GetValues([]string{"/secret/app", "/kv/web", "/secret/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/secret/app/db/user":     "app",
+		"/secret/app/db/password": "p@ss",
+		"/secret/app/tls/key/pem": "KEY",
+		"/kv/web/port":            "8080",
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Fatalf("%s: expected %q, got %q", k, v, values[k])
+		}
+	}
+	if values["/secret/app/db"] == "" || len(values) != len(expected)+3 {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	_, err = libconfd.NewBackendClient(&libconfd.BackendConfig{Type: BackendType, Host: []string{ts.URL}})
+	if err == nil {
+		t.Fatal("expect the error of missing token")
+	}
+}
+
+func TestVaultClientAppRole(t *testing.T) {
+	vault := &tVault{
+		secrets: map[string]map[string]interface{}{"kv/web": {"port": "80"}},
+		tokens:  map[string]time.Time{},
+		ttl:     1,
+	}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+
+	client, err := NewVaultClient(&libconfd.BackendConfig{
+		Host:     []string{ts.URL},
+		AuthType: AuthTypeAppRole,
+		RoleID:   "role",
+		SecretID: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() {
+		values, err := client.GetValues([]string{"/kv/web"})
+		if err != nil || values["/kv/web/port"] != "80" {
+			t.Fatalf("unexpected values: %v, %v", values, err)
+		}
+	}
+
+	// renewed after 2/3 of the ttl
+	get()
+	time.Sleep(time.Millisecond * 800)
+	get()
+	vault.Lock()
+	if vault.logins != 1 || vault.renews != 1 {
+		t.Fatalf("unexpected logins %d, renews %d", vault.logins, vault.renews)
+	}
+
+	// logs in again if the token is revoked
+	vault.tokens = map[string]time.Time{}
+	vault.Unlock()
+	get()
+	vault.Lock()
+	if vault.logins != 2 {
+		t.Fatalf("unexpected logins %d", vault.logins)
+	}
+	vault.Unlock()
+}
+
+func TestVaultClientKubernetes(t *testing.T) {
+	f, err := ioutil.TempFile("", "libconfd-vault-jwt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("jwt-token\n")
+	f.Close()
+
+	tokenFile := kubernetesTokenFile
+	kubernetesTokenFile = f.Name()
+	defer func() { kubernetesTokenFile = tokenFile }()
+
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/k8s/login" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"auth":{"client_token":"k","lease_duration":60}}`))
+	}))
+	defer ts.Close()
+
+	client, err := NewVaultClient(&libconfd.BackendConfig{
+		Host:     []string{ts.URL},
+		AuthType: AuthTypeKubernetes,
+		AuthPath: "k8s",
+		AuthRole: "web",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.(*_VaultClient).getToken(context.Background(), false)
+	if err != nil || token != "k" {
+		t.Fatalf("unexpected token %q: %v", token, err)
+	}
+	if body["role"] != "web" || body["jwt"] != "jwt-token" {
+		t.Fatalf("unexpected login: %v", body)
+	}
+}
+
+func TestVaultClientWatchPrefix(t *testing.T) {
+	interval := watchPollInterval
+	watchPollInterval = time.Millisecond * 10
+	defer func() { watchPollInterval = interval }()
+
+	vault := &tVault{
+		secrets: map[string]map[string]interface{}{"kv/web": {"port": "80"}},
+		tokens:  map[string]time.Time{"root": time.Now().Add(time.Hour)},
+	}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+
+	client, err := NewVaultClient(&libconfd.BackendConfig{Host: []string{ts.URL}, Token: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"/kv/web"}
+	index, err := client.WatchPrefix("/kv", keys, 0, nil)
+	if err != nil || index != 1 {
+		t.Fatalf("unexpected index %d: %v", index, err)
+	}
+	index, err = client.WatchPrefix("/kv", keys, index, nil)
+	if err != nil || index <= 1 {
+		t.Fatalf("unexpected index %d: %v", index, err)
+	}
+
+	done := make(chan uint64, 1)
+	go func() {
+		i, _ := client.WatchPrefix("/kv", keys, index, make(chan bool))
+		done <- i
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	vault.Lock()
+	vault.secrets["kv/web"] = map[string]interface{}{"port": "81"}
+	vault.Unlock()
+
+	select {
+	case i := <-done:
+		if i == index {
+			t.Fatalf("unexpected index %d", i)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+}
+
+func TestVaultClientContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // a vault never answering
+	}))
+	defer ts.Close()
+
+	client, err := NewVaultClient(&libconfd.BackendConfig{Host: []string{ts.URL}, Token: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.(libconfd.BackendContextClient)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetValuesContext(ctx, []string{"/kv/web"})
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expect the error of the canceled ctx")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel timeout")
+	}
+
+	// the poll of the watch is canceled too
+	if _, err := c.WatchPrefixContext(ctx, "/kv", []string{"/kv/web"}, 1); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
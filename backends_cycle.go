@@ -0,0 +1,115 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cycleBackendClient shares the GetValues results of the wrapped client
+// between the template resources of one processing cycle, every unique
+// key is fetched once per cycle. The concurrent calls of a key being
+// fetched wait for its result. The failed keys are not retried in the
+// cycle, the values of the other keys are returned with *PartialError.
+type cycleBackendClient struct {
+	BackendClient
+
+	mu      sync.Mutex
+	entries map[string]*cycleEntry
+}
+
+// cycleEntry is the result of a key, done is closed once it is fetched.
+type cycleEntry struct {
+	done   chan struct{}
+	values map[string]string
+	ttls   map[string]time.Duration
+	err    error
+}
+
+func newCycleBackendClient(client BackendClient) *cycleBackendClient {
+	return &cycleBackendClient{
+		BackendClient: client,
+		entries:       make(map[string]*cycleEntry),
+	}
+}
+
+func (p *cycleBackendClient) GetValues(keys []string) (map[string]string, error) {
+	m, _, err := p.getValues(context.Background(), keys, false)
+	return m, err
+}
+
+func (p *cycleBackendClient) GetValuesWithTTL(keys []string) (map[string]string, map[string]time.Duration, error) {
+	return p.getValues(context.Background(), keys, true)
+}
+
+// GetValuesWithTTLContext is like GetValuesWithTTL, the calls of the
+// wrapped client are canceled with ctx.
+func (p *cycleBackendClient) GetValuesWithTTLContext(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error) {
+	return p.getValues(ctx, keys, true)
+}
+
+func (p *cycleBackendClient) getValues(ctx context.Context, keys []string, withTTL bool) (map[string]string, map[string]time.Duration, error) {
+	values := make(map[string]string)
+	ttls := make(map[string]time.Duration)
+	failed := make(map[string]error)
+
+	for _, key := range keys {
+		e, err := p.getEntry(ctx, key, withTTL)
+		if err == nil {
+			err = e.err
+		}
+		if err != nil {
+			failed[key] = err
+			continue
+		}
+
+		for k, v := range e.values {
+			values[k] = v
+		}
+		for k, v := range e.ttls {
+			ttls[k] = v
+		}
+	}
+
+	if len(failed) == 0 {
+		return values, ttls, nil
+	}
+	if len(failed) == len(keys) {
+		return values, ttls, failed[keys[0]]
+	}
+	return values, ttls, &PartialError{Failed: failed}
+}
+
+// getEntry returns the entry of the key, the first caller fetches it
+// without holding p.mu, the others wait for it until ctx is done.
+func (p *cycleBackendClient) getEntry(ctx context.Context, key string, withTTL bool) (*cycleEntry, error) {
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if !ok {
+		e = &cycleEntry{done: make(chan struct{})}
+		p.entries[key] = e
+	}
+	p.mu.Unlock()
+
+	if ok {
+		GetMetrics().Inc("libconfd_backend_dedup_hits_total")
+		select {
+		case <-e.done:
+			return e, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if withTTL {
+		e.values, e.ttls, e.err = getValuesContext(ctx, p.BackendClient, []string{key})
+	} else {
+		e.values, e.err = p.BackendClient.GetValues([]string{key})
+	}
+	close(e.done)
+	return e, nil
+}
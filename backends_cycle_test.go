@@ -0,0 +1,154 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tCountingClient counts the keys fetched by GetValues.
+type tCountingClient struct {
+	mapBackendClient
+	keys []string
+	errs map[string]error
+}
+
+func (p *tCountingClient) GetValues(keys []string) (map[string]string, error) {
+	p.keys = append(p.keys, keys...)
+	for _, key := range keys {
+		if err := p.errs[key]; err != nil {
+			return nil, err
+		}
+	}
+	return p.mapBackendClient.GetValues(keys)
+}
+
+func TestCycleBackendClient(t *testing.T) {
+	client := &tCountingClient{mapBackendClient: mapBackendClient{
+		"/app/port": "80",
+		"/app/host": "a.b",
+		"/db/user":  "root",
+	}}
+	cycle := newCycleBackendClient(client)
+
+	m, err := cycle.GetValues([]string{"/app"})
+	tAssert(t, err == nil, err)
+	tAssert(t, len(m) == 2 && m["/app/port"] == "80", m)
+
+	m, _, err = cycle.GetValuesWithTTL([]string{"/app", "/db"})
+	tAssert(t, err == nil, err)
+	tAssert(t, len(m) == 3 && m["/db/user"] == "root", m)
+
+	m, err = cycle.GetValues([]string{"/db", "/app"})
+	tAssert(t, err == nil, err)
+	tAssert(t, len(m) == 3, m)
+
+	tAssert(t, len(client.keys) == 2, client.keys)
+	tAssert(t, client.keys[0] == "/app" && client.keys[1] == "/db", client.keys)
+}
+
+func TestCycleBackendClientPartial(t *testing.T) {
+	client := &tCountingClient{
+		mapBackendClient: mapBackendClient{"/app/port": "80", "/db/user": "root"},
+		errs:             map[string]error{"/db": errors.New("timeout")},
+	}
+	cycle := newCycleBackendClient(client)
+
+	m, err := cycle.GetValues([]string{"/app", "/db"})
+	partial, ok := err.(*PartialError)
+	tAssert(t, ok, err)
+	tAssert(t, len(partial.Keys()) == 1 && partial.Keys()[0] == "/db", partial.Keys())
+	tAssert(t, errors.Is(err, ErrBackendUnavailable))
+	tAssert(t, len(m) == 1 && m["/app/port"] == "80", m)
+
+	// the failed key is not retried in the cycle, and fails alone
+	_, err = cycle.GetValues([]string{"/db"})
+	tAssert(t, err != nil && err.Error() == "timeout", err)
+	tAssert(t, len(client.keys) == 2, client.keys)
+}
+
+func TestCycleBackendClientConcurrent(t *testing.T) {
+	client := &tSyncingClient{
+		mapBackendClient: mapBackendClient{"/app/port": "80", "/db/user": "root"},
+		started:          make(chan bool, 2),
+		release:          make(chan bool),
+	}
+	cycle := newCycleBackendClient(client)
+
+	done := make(chan error, 3)
+	get := func(key string) {
+		m, err := cycle.GetValues([]string{key})
+		if err == nil && len(m) != 1 {
+			err = errors.New("unexpected values")
+		}
+		done <- err
+	}
+	waitStarted := func() {
+		select {
+		case <-client.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("fetch not started")
+		}
+	}
+
+	// the key being fetched is waited for, the others are not blocked
+	go get("/app")
+	waitStarted()
+	go get("/app")
+	go get("/db")
+	waitStarted()
+
+	close(client.release)
+	for i := 0; i < 3; i++ {
+		tAssert(t, <-done == nil)
+	}
+	tAssert(t, len(client.started) == 0, len(client.started))
+}
+
+func TestTemplateResourceAllowPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-partial-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "templates"), 0755) == nil)
+	src := filepath.Join(dir, "templates", "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/app/port"}} user={{getv "/db/user" "none"}}`), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := &tCountingClient{
+		mapBackendClient: mapBackendClient{"/app/port": "80", "/db/user": "root"},
+		errs:             map[string]error{"/db": errors.New("timeout")},
+	}
+	for _, allow := range []bool{false, true} {
+		p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+			Src:          "app.tmpl",
+			Dest:         dest,
+			Keys:         []string{"/app", "/db"},
+			AllowPartial: allow,
+		})
+		tAssert(t, err == nil, err)
+
+		call := &Call{Config: cfg, Client: client, cycle: newCycleBackendClient(client)}
+		err = p.Process(call)
+		if !allow {
+			tAssert(t, err != nil && errors.Is(err, ErrBackendUnavailable), err)
+			continue
+		}
+		tAssert(t, err == nil, err)
+		tAssert(t, p.partial != nil && p.partial.Keys()[0] == "/db", p.partial)
+
+		data, _ := ioutil.ReadFile(dest)
+		tAssert(t, string(data) == "port=80 user=none", string(data))
+	}
+}
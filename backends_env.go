@@ -0,0 +1,114 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const EnvBackendType = "libconfd-backend-env"
+
+var _ BackendClient = (*EnvBackend)(nil)
+
+// EnvBackend reads key/values from the environment variables.
+// The key "/app/db-host" is read from the variable "APP_DB-HOST",
+// and the variables are mapped back to lower case keys.
+//
+// The variables may be in the namespace of Prefix, and the levels of
+// the keys may be separated by Separator, such as "MYAPP_APP__DB_HOST"
+// of the key "/app/db_host" by the prefix "MYAPP_" and the separator
+// "__". The variables of EnvFiles are overridden by the environment.
+type EnvBackend struct {
+	Prefix    string
+	Separator string // default is "_"
+	EnvFiles  []string
+}
+
+func init() {
+	RegisterBackendClient(
+		(*EnvBackend)(nil).Type(),
+		func(cfg *BackendConfig) (BackendClient, error) {
+			p := NewEnvBackendClient()
+			p.Prefix = cfg.EnvPrefix
+			p.Separator = cfg.EnvSeparator
+			p.EnvFiles = append([]string(nil), cfg.EnvFiles...)
+			return p, nil
+		},
+	)
+}
+
+func NewEnvBackendClient() *EnvBackend {
+	return new(EnvBackend)
+}
+
+func (_ *EnvBackend) Type() string {
+	return EnvBackendType
+}
+
+func (_ *EnvBackend) WatchEnabled() bool {
+	return false
+}
+
+func (_ *EnvBackend) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return 0, fmt.Errorf("do not support watch")
+}
+
+func (p *EnvBackend) GetValues(keys []string) (map[string]string, error) {
+	env, err := p.environ()
+	if err != nil {
+		return nil, err
+	}
+
+	sep := p.Separator
+	if sep == "" {
+		sep = "_"
+	}
+	var (
+		toEnvReplacer = strings.NewReplacer("/", strings.ToUpper(sep))
+		toKeyReplacer = strings.NewReplacer(strings.ToLower(sep), "/")
+	)
+
+	m := make(map[string]string)
+	for envKey, envValue := range env {
+		if !strings.HasPrefix(envKey, p.Prefix) || len(envKey) == len(p.Prefix) {
+			continue
+		}
+		name := envKey[len(p.Prefix):]
+
+		for _, key := range keys {
+			prefix := strings.ToUpper(toEnvReplacer.Replace(strings.TrimPrefix(key, "/")))
+			if strings.HasPrefix(name, prefix) {
+				m[toKeyReplacer.Replace("/"+strings.ToLower(name))] = envValue
+				break
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// environ returns the variables of the env files and the environment.
+func (p *EnvBackend) environ() (map[string]string, error) {
+	env := make(map[string]string)
+	for _, path := range p.EnvFiles {
+		vars, err := LoadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	for _, e := range os.Environ() {
+		idx := strings.Index(e, "=")
+		if idx <= 0 {
+			continue
+		}
+		env[e[:idx]] = e[idx+1:]
+	}
+	return env, nil
+}
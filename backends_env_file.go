@@ -0,0 +1,130 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// LoadEnvFile loads the variables of a .env file:
+//
+//	# comment
+//	export HOST=127.0.0.1 # comment
+//	KEY='literal value, may span lines'
+//	CERT="escaped value\n, may span lines, \x00 and é are allowed"
+//
+// The double quoted values are unescaped like the Go strings, and \$ is $.
+func LoadEnvFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseEnvFile(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func parseEnvFile(s string) (map[string]string, error) {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+
+	m := make(map[string]string)
+	for lineno := 1; s != ""; lineno++ {
+		text, more := s, false
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			text, s, more = s[:i], s[i+1:], true
+		} else {
+			s = ""
+		}
+
+		text = strings.TrimLeft(text, " \t")
+		if t := strings.TrimSpace(text); t == "" || t[0] == '#' {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		idx := strings.Index(text, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("line %d: invalid variable", lineno)
+		}
+		key := strings.TrimSpace(text[:idx])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable %q", lineno, key)
+		}
+		value := strings.TrimLeft(text[idx+1:], " \t")
+
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			m[key] = strings.TrimSpace(value)
+			continue
+		}
+
+		// the quoted value may span lines, continue with the rest
+		rest := value
+		if more {
+			rest += "\n" + s
+		}
+		v, n, err := unquoteEnvValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		start := lineno
+		lineno += strings.Count(rest[:n], "\n")
+
+		tail := rest[n:]
+		if i := strings.IndexByte(tail, '\n'); i >= 0 {
+			tail, s = tail[:i], tail[i+1:]
+		} else {
+			s = ""
+		}
+		if t := strings.TrimSpace(tail); t != "" && t[0] != '#' {
+			return nil, fmt.Errorf("line %d: unexpected %q after the value of line %d", lineno, t, start)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// unquoteEnvValue returns the value of the quoted s, and the length of
+// the quoted value in s.
+func unquoteEnvValue(s string) (value string, n int, err error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case quote == '"' && c == '\\' && strings.HasPrefix(s[i:], `\$`):
+			b.WriteByte('$')
+			i += 2
+		case quote == '"' && c == '\\':
+			r, multibyte, tail, err := strconv.UnquoteChar(s[i:], '"')
+			if err != nil {
+				esc := s[i:]
+				if len(esc) > 2 {
+					esc = esc[:2]
+				}
+				return "", 0, fmt.Errorf("invalid escape %q", esc)
+			}
+			if multibyte {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte(byte(r))
+			}
+			i = len(s) - len(tail)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, fmt.Errorf("missing the closing quote %c", quote)
+}
@@ -5,8 +5,11 @@
 package libconfd
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"testing"
 )
 
 // tEnvClient provides a shell for the env client
@@ -79,3 +82,60 @@ func (_ *tEnvClient) WatchPrefix(prefix string, keys []string, waitIndex uint64,
 	<-stopChan
 	return 0, nil
 }
+
+func TestEnvBackend(t *testing.T) {
+	os.Setenv("LIBCONFD_TEST_DB_HOST", "127.0.0.1")
+	defer os.Unsetenv("LIBCONFD_TEST_DB_HOST")
+
+	m, err := NewEnvBackendClient().GetValues([]string{"/libconfd/test/db"})
+	tAssert(t, err == nil, err)
+	tAssert(t, len(m) == 1, m)
+	tAssert(t, m["/libconfd/test/db/host"] == "127.0.0.1", m)
+}
+
+func TestEnvBackendNamespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-env-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, ".env")
+	tAssert(t, ioutil.WriteFile(envFile, []byte(`# local
+export LIBCONFD_TEST_APP__DB_HOST=localhost # comment
+LIBCONFD_TEST_APP__DB_PORT = 5432
+LIBCONFD_TEST_APP__TLS__KEY="-----BEGIN KEY-----
+a\x00b\u00e9\$
+-----END KEY-----"
+LIBCONFD_TEST_APP__NAME='my "app"'
+LIBCONFD_TEST_WEB__PORT=8080
+`), 0644) == nil)
+
+	os.Setenv("LIBCONFD_TEST_APP__DB_PORT", "6432")
+	defer os.Unsetenv("LIBCONFD_TEST_APP__DB_PORT")
+
+	client, err := NewBackendClient(&BackendConfig{
+		Type:         EnvBackendType,
+		EnvPrefix:    "LIBCONFD_TEST_",
+		EnvSeparator: "__",
+		EnvFiles:     []string{envFile},
+	})
+	tAssert(t, err == nil, err)
+
+	m, err := client.GetValues([]string{"/app"})
+	tAssert(t, err == nil, err)
+	tAssert(t, len(m) == 4, m)
+	tAssert(t, m["/app/db_host"] == "localhost", m)
+	tAssert(t, m["/app/db_port"] == "6432", m)
+	tAssert(t, m["/app/tls/key"] == "-----BEGIN KEY-----\na\x00b\u00e9$\n-----END KEY-----", m)
+	tAssert(t, m["/app/name"] == `my "app"`, m)
+
+	for _, s := range []string{
+		"A=\"unterminated\n",
+		"A=\"bad \\q\"",
+		"A='x' y",
+		"=x",
+		"A B=x",
+	} {
+		_, err := parseEnvFile(s)
+		tAssert(t, err != nil, s)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The name of the backend of the processor in Config.BackendLayers.
+const BackendLayerDefault = "backend"
+
+// MultiBackendClient layers several backends into one key space, such as
+// the secrets of vault over the topology of etcd. The layers are in the
+// order of precedence, the value of a key is read from the first layer
+// having it. GetValues fails if any layer fails, the values of a layer
+// are never hidden by the lower ones silently.
+//
+// WatchPrefix watches all the layers supporting watch, and returns when
+// one of them changes. The index returned is of the MultiBackendClient,
+// not of the layers.
+type MultiBackendClient struct {
+	layers []BackendClient
+
+	mu      sync.Mutex
+	watches map[string]*multiWatchState // by the prefix and the keys
+}
+
+// multiWatchState is the index of every layer at the index of a watch.
+type multiWatchState struct {
+	index   uint64
+	indexes []uint64
+}
+
+// NewMultiBackendClient layers the clients, the first has the highest
+// precedence.
+func NewMultiBackendClient(layers ...BackendClient) *MultiBackendClient {
+	return &MultiBackendClient{
+		layers:  append([]BackendClient{}, layers...),
+		watches: make(map[string]*multiWatchState),
+	}
+}
+
+// newLayeredBackendClient layers client and the named backends by the
+// names of Config.BackendLayers, client is the lowest if not named by
+// BackendLayerDefault.
+func newLayeredBackendClient(client BackendClient, names []string, backends map[string]BackendClient) (BackendClient, error) {
+	var layers []BackendClient
+	var hasDefault bool
+	for _, name := range names {
+		if name == BackendLayerDefault {
+			layers = append(layers, client)
+			hasDefault = true
+			continue
+		}
+		c, ok := backends[name]
+		if !ok {
+			return nil, fmt.Errorf("libconfd: unknown backend layer %q", name)
+		}
+		layers = append(layers, c)
+	}
+	if !hasDefault {
+		layers = append(layers, client)
+	}
+	return NewMultiBackendClient(layers...), nil
+}
+
+func (p *MultiBackendClient) Type() string {
+	var types []string
+	for _, c := range p.layers {
+		types = append(types, c.Type())
+	}
+	return "multi(" + strings.Join(types, ",") + ")"
+}
+
+// WatchEnabled reports whether any layer supports watch.
+func (p *MultiBackendClient) WatchEnabled() bool {
+	for _, c := range p.layers {
+		if c.WatchEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *MultiBackendClient) GetValues(keys []string) (map[string]string, error) {
+	values, _, err := p.getValues(context.Background(), keys, false)
+	return values, err
+}
+
+func (p *MultiBackendClient) GetValuesWithTTL(keys []string) (map[string]string, map[string]time.Duration, error) {
+	return p.getValues(context.Background(), keys, true)
+}
+
+// GetValuesWithTTLContext is like GetValuesWithTTL, the calls of the
+// layers are canceled with ctx.
+func (p *MultiBackendClient) GetValuesWithTTLContext(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error) {
+	return p.getValues(ctx, keys, true)
+}
+
+// getValues merges the values of the layers from the lowest, the ttl of
+// a key is of the layer of its value.
+func (p *MultiBackendClient) getValues(ctx context.Context, keys []string, withTTL bool) (map[string]string, map[string]time.Duration, error) {
+	values := make(map[string]string)
+	ttls := make(map[string]time.Duration)
+
+	for i := len(p.layers) - 1; i >= 0; i-- {
+		var m map[string]string
+		var t map[string]time.Duration
+		var err error
+		if withTTL {
+			m, t, err = getValuesContext(ctx, p.layers[i], keys)
+		} else {
+			m, err = p.layers[i].GetValues(keys)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("libconfd: backend layer %d (%s): %v", i, p.layers[i].Type(), err)
+		}
+		for k, v := range m {
+			values[k] = v
+			if ttl, ok := t[k]; ok {
+				ttls[k] = ttl
+			} else {
+				delete(ttls, k)
+			}
+		}
+	}
+	return values, ttls, nil
+}
+
+// WatchPrefix watches the layers at their indexes of waitIndex, and
+// returns a new index when any of them changes. The other watches are
+// stopped then.
+func (p *MultiBackendClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	state, index, indexes := p.getWatchState(prefix, keys)
+
+	// the first call or an old index, restart from the current indexes
+	if waitIndex == 0 || waitIndex != index {
+		if index == 0 {
+			return p.initWatch(state, prefix, keys, stopChan)
+		}
+		return index, nil
+	}
+
+	type result struct {
+		layer int
+		index uint64
+		err   error
+	}
+
+	stop := make(chan bool)
+	results := make(chan result, len(p.layers))
+	watching := 0
+	for i, c := range p.layers {
+		if !c.WatchEnabled() {
+			continue
+		}
+		watching++
+		go func(i int, c BackendClient, index uint64) {
+			index, err := c.WatchPrefix(prefix, keys, index, stop)
+			results <- result{layer: i, index: index, err: err}
+		}(i, c, indexes[i])
+	}
+
+	var (
+		changed  bool
+		firstErr error
+		stopped  bool
+	)
+	for ; watching > 0; watching-- {
+		var r result
+		select {
+		case r = <-results:
+		case <-stopChan:
+			if !stopped {
+				close(stop)
+				stopped = true
+			}
+			r = <-results
+		}
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+		if r.err == nil && r.index != indexes[r.layer] {
+			indexes[r.layer] = r.index
+			changed = true
+		}
+		if !stopped && (changed || firstErr != nil) {
+			close(stop)
+			stopped = true
+		}
+	}
+
+	if !changed {
+		return waitIndex, firstErr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state.index == waitIndex {
+		state.index++
+		state.indexes = indexes
+	}
+	return state.index, nil
+}
+
+// initWatch gets the current indexes of the layers.
+func (p *MultiBackendClient) initWatch(state *multiWatchState, prefix string, keys []string, stopChan chan bool) (uint64, error) {
+	indexes := make([]uint64, len(p.layers))
+	for i, c := range p.layers {
+		if !c.WatchEnabled() {
+			continue
+		}
+		index, err := c.WatchPrefix(prefix, keys, 0, stopChan)
+		if err != nil {
+			return 0, err
+		}
+		indexes[i] = index
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state.index == 0 {
+		state.index = 1
+		state.indexes = indexes
+	}
+	return state.index, nil
+}
+
+// getWatchState returns the state of the watch, and a copy of its indexes.
+func (p *MultiBackendClient) getWatchState(prefix string, keys []string) (*multiWatchState, uint64, []uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := prefix + "\x00" + strings.Join(keys, "\x00")
+	state, ok := p.watches[id]
+	if !ok {
+		state = new(multiWatchState)
+		p.watches[id] = state
+	}
+	return state, state.index, append([]uint64{}, state.indexes...)
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// tWatchClient is a map backend, the watch returns the index sent to
+// changed.
+type tWatchClient struct {
+	mapBackendClient
+	changed chan uint64
+}
+
+func (p *tWatchClient) WatchEnabled() bool {
+	return true
+}
+
+func (p *tWatchClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	select {
+	case index := <-p.changed:
+		return index, nil
+	case <-stopChan:
+		return waitIndex, nil
+	}
+}
+
+type tFailingClient struct {
+	mapBackendClient
+}
+
+func (p tFailingClient) GetValues(keys []string) (map[string]string, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestMultiBackendClientGetValues(t *testing.T) {
+	vault := mapBackendClient{"/app/password": "s3cret"}
+	etcd := mapBackendClient{"/app/password": "default", "/app/port": "80"}
+
+	client := NewMultiBackendClient(vault, etcd)
+	values, err := client.GetValues([]string{"/app"})
+	tAssert(t, err == nil, err)
+	tAssert(t, len(values) == 2, values)
+	tAssert(t, values["/app/password"] == "s3cret" && values["/app/port"] == "80", values)
+
+	// the lower layers never hide the failure of a layer
+	client = NewMultiBackendClient(tFailingClient{}, etcd)
+	_, err = client.GetValues([]string{"/app"})
+	tAssert(t, err != nil, "expect the error of the failed layer")
+}
+
+func TestMultiBackendClientWatchPrefix(t *testing.T) {
+	a := &tWatchClient{changed: make(chan uint64)}
+	b := &tWatchClient{changed: make(chan uint64)}
+	client := NewMultiBackendClient(a, mapBackendClient{}, b)
+	tAssert(t, client.WatchEnabled())
+
+	index, err := client.WatchPrefix("/app", nil, 0, nil)
+	tAssert(t, err == nil && index == 1, index, err)
+
+	for i, layer := range []*tWatchClient{b, a, b} {
+		done := make(chan uint64, 1)
+		go func() {
+			i, _ := client.WatchPrefix("/app", nil, index, make(chan bool))
+			done <- i
+		}()
+		layer.changed <- uint64(10 + i)
+
+		select {
+		case i := <-done:
+			tAssert(t, i == index+1, i)
+			index = i
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch timeout")
+		}
+	}
+
+	// an old index returns the current one at once
+	index, err = client.WatchPrefix("/app", nil, 1, nil)
+	tAssert(t, err == nil && index == 4, index, err)
+
+	// stopped
+	stopChan := make(chan bool)
+	close(stopChan)
+	index, err = client.WatchPrefix("/app", nil, 4, stopChan)
+	tAssert(t, err == nil && index == 4, index, err)
+}
+
+func TestLayeredBackendClient(t *testing.T) {
+	backend := mapBackendClient{"/app/port": "80", "/app/host": "a.b"}
+	backends := map[string]BackendClient{
+		"env":   mapBackendClient{"/app/port": "8080", "/app/host": "env"},
+		"vault": mapBackendClient{"/app/port": "443"},
+	}
+
+	for _, tc := range []struct {
+		layers []string
+		port   string
+		host   string
+	}{
+		{[]string{"vault"}, "443", "a.b"},
+		{[]string{"vault", "env"}, "443", "env"},
+		{[]string{"vault", BackendLayerDefault, "env"}, "443", "a.b"},
+		{[]string{BackendLayerDefault, "vault"}, "80", "a.b"},
+	} {
+		client, err := newLayeredBackendClient(backend, tc.layers, backends)
+		tAssert(t, err == nil, err)
+		values, err := client.GetValues([]string{"/app"})
+		tAssert(t, err == nil, err)
+		tAssert(t, values["/app/port"] == tc.port && values["/app/host"] == tc.host, tc.layers, values)
+	}
+
+	_, err := newLayeredBackendClient(backend, []string{"consul"}, backends)
+	tAssert(t, err != nil, "expect the error of unknown layer")
+
+	cfg := newDefaultConfig()
+	cfg.BackendLayers = []string{"vault", "vault"}
+	tAssert(t, cfg.Valid() != nil, "expect the error of duplicate layer")
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket, refilled by limit tokens per minute up
+// to burst tokens. The callers exceeding the tokens wait in turn.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, 0 is unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit, burst int) *rateLimiter {
+	p := new(rateLimiter)
+	p.set(limit, burst)
+	return p
+}
+
+func (p *rateLimiter) set(limit, burst int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if burst <= 0 {
+		burst = limit
+	}
+	rate := float64(limit) / 60
+	if rate == p.rate && float64(burst) == p.burst {
+		return // keep the tokens, such as on reload
+	}
+	p.rate = rate
+	p.burst = float64(burst)
+	p.tokens = p.burst
+	p.last = time.Now()
+}
+
+// reserve takes a token, and returns the delay until it is available.
+func (p *rateLimiter) reserve() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	p.tokens = math.Min(p.burst, p.tokens+now.Sub(p.last).Seconds()*p.rate)
+	p.last = now
+
+	p.tokens--
+	if p.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-p.tokens / p.rate * float64(time.Second))
+}
+
+// wait blocks until a token is available, it returns false at once if
+// stopChan is closed.
+func (p *rateLimiter) wait(stopChan chan bool) bool {
+	return p.waitChan(stopChan, nil)
+}
+
+// waitContext is like wait, it returns ctx.Err() at once if ctx is done.
+func (p *rateLimiter) waitContext(ctx context.Context) error {
+	if !p.waitChan(nil, ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (p *rateLimiter) waitChan(stopChan chan bool, done <-chan struct{}) bool {
+	delay := p.reserve()
+	if delay <= 0 {
+		return true
+	}
+
+	GetMetrics().Inc("libconfd_backend_rate_limited_total")
+	backendLogger.Debugf("backend call rate limited, wait %v\n", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stopChan:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// rateLimitedClient limits the GetValues and WatchPrefix calls of the
+// wrapped client, by all the limiters.
+type rateLimitedClient struct {
+	BackendClient
+	limiters []*rateLimiter
+}
+
+func newRateLimitedClient(client BackendClient, limiter *rateLimiter) *rateLimitedClient {
+	if x, ok := client.(*rateLimitedClient); ok {
+		limiters := append([]*rateLimiter{}, x.limiters...)
+		return &rateLimitedClient{BackendClient: x.BackendClient, limiters: append(limiters, limiter)}
+	}
+	return &rateLimitedClient{BackendClient: client, limiters: []*rateLimiter{limiter}}
+}
+
+func (p *rateLimitedClient) wait(stopChan chan bool) bool {
+	for _, limiter := range p.limiters {
+		if !limiter.wait(stopChan) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *rateLimitedClient) waitContext(ctx context.Context) error {
+	for _, limiter := range p.limiters {
+		if err := limiter.waitContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *rateLimitedClient) GetValues(keys []string) (map[string]string, error) {
+	p.wait(nil)
+	return p.BackendClient.GetValues(keys)
+}
+
+func (p *rateLimitedClient) GetValuesWithTTL(keys []string) (map[string]string, map[string]time.Duration, error) {
+	p.wait(nil)
+	if c, ok := p.BackendClient.(BackendTTLClient); ok {
+		return c.GetValuesWithTTL(keys)
+	}
+	values, err := p.BackendClient.GetValues(keys)
+	return values, nil, err
+}
+
+// GetValuesWithTTLContext waits for the tokens until ctx is done, the
+// call of the wrapped client is canceled with ctx.
+func (p *rateLimitedClient) GetValuesWithTTLContext(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error) {
+	if err := p.waitContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	return getValuesContext(ctx, p.BackendClient, keys)
+}
+
+func (p *rateLimitedClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if !p.wait(stopChan) {
+		return waitIndex, nil
+	}
+	return p.BackendClient.WatchPrefix(prefix, keys, waitIndex, stopChan)
+}
+
+// WatchKeys is used only if the wrapped client supports the exact watch,
+// see getExactWatchClient.
+func (p *rateLimitedClient) WatchKeys(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	c, _ := getExactWatchClient(p.BackendClient)
+	if !p.wait(stopChan) {
+		return waitIndex, nil
+	}
+	return c.WatchKeys(prefix, keys, waitIndex, stopChan)
+}
+
+// WatchEvents is used only if the wrapped client streams the events, see
+// getEventWatchClient. The streams are opened at the limited rate.
+func (p *rateLimitedClient) WatchEvents(ctx context.Context, prefix string, keys []string) (<-chan KVEvent, error) {
+	c, _ := getEventWatchClient(p.BackendClient)
+	if err := p.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return c.WatchEvents(ctx, prefix, keys)
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(60, 2)
+
+	// the burst, then a token per second
+	tAssert(t, limiter.reserve() == 0)
+	tAssert(t, limiter.reserve() == 0)
+	d := limiter.reserve()
+	tAssert(t, d > time.Second*9/10 && d <= time.Second, d)
+	d = limiter.reserve()
+	tAssert(t, d > time.Second*19/10 && d <= 2*time.Second, d)
+
+	// the same limit keeps the tokens
+	limiter.set(60, 2)
+	tAssert(t, limiter.reserve() > 2*time.Second)
+
+	limiter.set(0, 0)
+	tAssert(t, limiter.reserve() == 0)
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	client := &tCountingClient{mapBackendClient: mapBackendClient{"/app/port": "80"}}
+	limited := newRateLimitedClient(client, newRateLimiter(60, 1))
+
+	m, err := limited.GetValues([]string{"/app"})
+	tAssert(t, err == nil && m["/app/port"] == "80", m, err)
+
+	// the watch waits for a token, until stopChan is closed
+	stopChan := make(chan bool)
+	done := make(chan uint64, 1)
+	go func() {
+		index, _ := limited.WatchPrefix("/app", []string{"/app/port"}, 7, stopChan)
+		done <- index
+	}()
+	time.Sleep(time.Millisecond * 50)
+	close(stopChan)
+	select {
+	case index := <-done:
+		tAssert(t, index == 7, index)
+	case <-time.After(time.Second / 2):
+		t.Fatal("watch is not stopped")
+	}
+	tAssert(t, GetMetrics().Get("libconfd_backend_rate_limited_total") > 0)
+
+	// the limiters of the wrapped clients are combined
+	global := newRateLimitedClient(limited, newRateLimiter(0, 0))
+	tAssert(t, global.BackendClient == client && len(global.limiters) == 2)
+	_, ok := getExactWatchClient(global)
+	tAssert(t, !ok)
+	_, ok = getEventWatchClient(global)
+	tAssert(t, !ok)
+}
+
+func TestRateLimitedEventClient(t *testing.T) {
+	client := &tEventClient{mapBackendClient: mapBackendClient{}, events: make(chan KVEvent)}
+	limited := newRateLimitedClient(&snapshotRecorder{BackendClient: client}, newRateLimiter(60, 1))
+
+	// the events of the wrapped client, through the limiter
+	c, ok := getEventWatchClient(&cycleBackendClient{BackendClient: limited})
+	tAssert(t, ok && c == limited, c)
+	events, err := c.WatchEvents(context.Background(), "/app", []string{"/app/port"})
+	tAssert(t, err == nil && events == (<-chan KVEvent)(client.events), err)
+
+	// the next stream waits for a token, until ctx is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.WatchEvents(ctx, "/app", []string{"/app/port"})
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	select {
+	case err := <-done:
+		tAssert(t, err == context.Canceled, err)
+	case <-time.After(time.Second / 2):
+		t.Fatal("watch is not canceled")
+	}
+}
+
+func TestBackendRateLimitConfig(t *testing.T) {
+	client, err := NewBackendClient(&BackendConfig{Type: EnvBackendType, RateLimit: 600})
+	tAssert(t, err == nil, err)
+	_, ok := client.(*rateLimitedClient)
+	tAssert(t, ok, client)
+
+	_, err = NewBackendClient(&BackendConfig{Type: EnvBackendType, RateLimit: -1})
+	tAssert(t, err != nil)
+}
+
+func TestRateLimitedClientContext(t *testing.T) {
+	client := &tCountingClient{mapBackendClient: mapBackendClient{"/app/port": "80"}}
+	limited := newRateLimitedClient(newCycleBackendClient(client), newRateLimiter(60, 1))
+
+	m, _, err := getValuesContext(context.Background(), limited, []string{"/app"})
+	tAssert(t, err == nil && m["/app/port"] == "80", m, err)
+
+	// the wait for a token is canceled with ctx
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	_, _, err = getValuesContext(ctx, limited, []string{"/app"})
+	tAssert(t, err == context.DeadlineExceeded, err)
+	tAssert(t, time.Since(start) < time.Second/2, time.Since(start))
+}
@@ -0,0 +1,263 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const SnapshotBackendType = "libconfd-backend-snapshot"
+
+var _ BackendClient = (*SnapshotBackend)(nil)
+
+var _SnapshotBucketName = []byte("kvstore")
+
+// the bucket of the snapshot time, set by SaveValues
+var (
+	_SnapshotMetaBucketName = []byte("meta")
+	_SnapshotTimeKey        = []byte("time")
+)
+
+// SnapshotBackend reads key/values from a bbolt snapshot file written by a
+// previous run with Config.SnapshotFile set. It allows offline rendering.
+// The file is opened by the first call, read-only until SaveValues, and
+// kept open until Close.
+type SnapshotBackend struct {
+	DBFile string
+
+	mu sync.Mutex
+	db *bolt.DB
+}
+
+func init() {
+	RegisterBackendClient(
+		(*SnapshotBackend)(nil).Type(),
+		func(cfg *BackendConfig) (BackendClient, error) {
+			if len(cfg.Host) == 0 || cfg.Host[0] == "" {
+				return nil, fmt.Errorf("libconfd: snapshot backend requires a file path")
+			}
+			return NewSnapshotBackendClient(cfg.Host[0]), nil
+		},
+	)
+}
+
+func NewSnapshotBackendClient(dbFile string) *SnapshotBackend {
+	return &SnapshotBackend{DBFile: dbFile}
+}
+
+func (_ *SnapshotBackend) Type() string {
+	return SnapshotBackendType
+}
+
+func (_ *SnapshotBackend) WatchEnabled() bool {
+	return false
+}
+
+func (_ *SnapshotBackend) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return 0, fmt.Errorf("do not support watch")
+}
+
+func (p *SnapshotBackend) GetValues(keys []string) (m map[string]string, err error) {
+	db, err := p.getDB(false)
+	if err != nil {
+		return nil, err
+	}
+
+	m = make(map[string]string)
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(_SnapshotBucketName)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for _, key := range keys {
+			prefix := []byte(key)
+			for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), key); k, v = c.Next() {
+				m[string(k)] = string(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SaveValues replaces all keys prefixed by one of keys with values.
+func (p *SnapshotBackend) SaveValues(keys []string, values map[string]string) error {
+	db, err := p.getDB(true)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(_SnapshotBucketName)
+		if err != nil {
+			return err
+		}
+
+		var staleKeys [][]byte
+		c := b.Cursor()
+		for _, key := range keys {
+			for k, _ := c.Seek([]byte(key)); k != nil && strings.HasPrefix(string(k), key); k, _ = c.Next() {
+				staleKeys = append(staleKeys, append([]byte{}, k...))
+			}
+		}
+		for _, k := range staleKeys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for k, v := range values {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(_SnapshotMetaBucketName)
+		if err != nil {
+			return err
+		}
+		return meta.Put(_SnapshotTimeKey, []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+	})
+}
+
+// SnapshotTime returns the time of the last SaveValues, or the modified
+// time of the file written by an older version.
+func (p *SnapshotBackend) SnapshotTime() (time.Time, error) {
+	db, err := p.getDB(false)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var t time.Time
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(_SnapshotMetaBucketName)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(_SnapshotTimeKey); v != nil {
+			var err error
+			t, err = time.Parse(time.RFC3339Nano, string(v))
+			return err
+		}
+		return nil
+	})
+	if err != nil || !t.IsZero() {
+		return t, err
+	}
+
+	fi, err := os.Stat(p.DBFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// getDB returns the open file, the read-only file is opened again for
+// writing if write is set.
+func (p *SnapshotBackend) getDB(write bool) (*bolt.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db != nil && (!write || !p.db.IsReadOnly()) {
+		return p.db, nil
+	}
+	if !write && fileNotExists(p.DBFile) {
+		return nil, fmt.Errorf("libconfd: snapshot file %q does not exist", p.DBFile)
+	}
+	if p.db != nil {
+		p.db.Close()
+		p.db = nil
+	}
+
+	db, err := bolt.Open(p.DBFile, 0600, &bolt.Options{
+		Timeout:  3 * time.Second,
+		ReadOnly: !write,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.db = db
+	return db, nil
+}
+
+// Close closes the file, the next call opens it again.
+func (p *SnapshotBackend) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		return nil
+	}
+	err := p.db.Close()
+	p.db = nil
+	return err
+}
+
+// snapshotRecorder saves every successful GetValues result of the
+// wrapped client into a snapshot file.
+type snapshotRecorder struct {
+	BackendClient
+	snapshot *SnapshotBackend
+}
+
+func newSnapshotRecorder(client BackendClient, snapshot *SnapshotBackend) BackendClient {
+	return &snapshotRecorder{
+		BackendClient: client,
+		snapshot:      snapshot,
+	}
+}
+
+func (p *snapshotRecorder) GetValues(keys []string) (map[string]string, error) {
+	m, err := p.BackendClient.GetValues(keys)
+	if err != nil {
+		return m, err
+	}
+	if err := p.snapshot.SaveValues(keys, m); err != nil {
+		backendLogger.Warningf("libconfd: save snapshot failed: %v", err)
+	}
+	return m, nil
+}
+
+// GetValuesWithTTLContext is like GetValuesWithTTL, the call of the
+// wrapped client is canceled with ctx.
+func (p *snapshotRecorder) GetValuesWithTTLContext(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error) {
+	m, ttls, err := getValuesContext(ctx, p.BackendClient, keys)
+	if err != nil {
+		return m, ttls, err
+	}
+	if err := p.snapshot.SaveValues(keys, m); err != nil {
+		backendLogger.Warningf("libconfd: save snapshot failed: %v", err)
+	}
+	return m, ttls, nil
+}
+
+func (p *snapshotRecorder) GetValuesWithTTL(keys []string) (map[string]string, map[string]time.Duration, error) {
+	c, ok := p.BackendClient.(BackendTTLClient)
+	if !ok {
+		m, err := p.GetValues(keys)
+		return m, nil, err
+	}
+
+	m, ttls, err := c.GetValuesWithTTL(keys)
+	if err != nil {
+		return m, ttls, err
+	}
+	if err := p.snapshot.SaveValues(keys, m); err != nil {
+		backendLogger.Warningf("libconfd: save snapshot failed: %v", err)
+	}
+	return m, ttls, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotBackend(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "libconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	c := NewSnapshotBackendClient(filepath.Join(tempDir, "snapshot.db"))
+	defer c.Close()
+
+	if _, err := c.GetValues([]string{"/"}); err == nil {
+		t.Fatal("expect error for missing snapshot file")
+	}
+
+	err = c.SaveValues([]string{"/app", "/db"}, map[string]string{
+		"/app/port": "80",
+		"/app/host": "localhost",
+		"/db/user":  "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replace everything under /app
+	err = c.SaveValues([]string{"/app"}, map[string]string{
+		"/app/port": "8080",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := c.GetValues([]string{"/app", "/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"/app/port": "8080",
+		"/db/user":  "admin",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("GetValues = %v, want %v", m, want)
+	}
+
+	// the file is kept open, and opened again after Close
+	db := c.db
+	if _, err := c.GetValues([]string{"/app"}); err != nil || c.db != db {
+		t.Fatalf("the file is opened again: %v", err)
+	}
+	if err := c.Close(); err != nil || c.db != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if m, err := c.GetValues([]string{"/db"}); err != nil || m["/db/user"] != "admin" {
+		t.Fatalf("GetValues = %v, %v", m, err)
+	}
+}
+
+func TestSnapshotBackendConfig(t *testing.T) {
+	_, err := NewBackendClient(&BackendConfig{Type: SnapshotBackendType})
+	if err == nil || err.Error() != "libconfd: snapshot backend requires a file path" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestProcessorSnapshot(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "libconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// shared by the calls, closed by Stop
+	p := NewProcessor()
+	file := filepath.Join(tempDir, "snapshot.db")
+	c := p.getSnapshot(file)
+	if p.getSnapshot(file) != c {
+		t.Fatal("the snapshot is not shared")
+	}
+	if err := c.SaveValues([]string{"/app"}, map[string]string{"/app/port": "80"}); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if c.db != nil {
+		t.Fatal("the snapshot is not closed")
+	}
+}
@@ -5,18 +5,42 @@
 package libconfd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const TomlBackendType = "libconfd-backend-toml"
 
 var _ BackendClient = (*TomlBackend)(nil)
+var _ BackendEventClient = (*TomlBackend)(nil)
 
+// TomlBackend is the file backend, it reads the TOML, YAML and JSON files
+// of the hosts, the files of the dirs are read recursively. The tables of
+// the files are flattened into the keys, such as
+//
+//	"/key" = "foobar"
+//	[database]
+//	host = "127.0.0.1" # /database/host
+//	ports = [3306]     # /database/ports/0
+//
+// The later files override the same keys of the earlier files.
 type TomlBackend struct {
 	TOMLFile string
+	Paths    []string // the files and dirs, TOMLFile if empty
+
+	mu      sync.Mutex
+	watcher *fileWatcher
 }
 
 func init() {
@@ -31,7 +55,10 @@ func init() {
 
 func NewTomlBackendClient(cfg *BackendConfig) *TomlBackend {
 	logger.Assert(cfg.Type == (*TomlBackend)(nil).Type())
-	return &TomlBackend{TOMLFile: cfg.Host[0]}
+	return &TomlBackend{
+		TOMLFile: cfg.Host[0],
+		Paths:    append([]string(nil), cfg.Host...),
+	}
 }
 
 func (_ *TomlBackend) Type() string {
@@ -39,27 +66,118 @@ func (_ *TomlBackend) Type() string {
 }
 
 func (_ *TomlBackend) WatchEnabled() bool {
-	return false
+	return true
+}
+
+func (p *TomlBackend) GetValues(keys []string) (m map[string]string, err error) {
+	m = make(map[string]string)
+	for _, name := range p.getPaths() {
+		files, err := listBackendFiles(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err := loadBackendFile(file, m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
 }
 
-func (_ *TomlBackend) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	return 0, fmt.Errorf("do not support watch")
+func (p *TomlBackend) getPaths() []string {
+	if len(p.Paths) > 0 {
+		return p.Paths
+	}
+	return []string{p.TOMLFile}
 }
 
-func (p *TomlBackend) GetValues(keys []string) (m map[string]string, err error) {
-	var dataMap map[string]string
-	_, err = toml.DecodeFile(p.TOMLFile, &dataMap)
+// listBackendFiles returns the file, or the TOML, YAML and JSON files
+// under the dir in lexical order.
+func listBackendFiles(name string) ([]string, error) {
+	fi, err := os.Stat(name)
 	if err != nil {
 		return nil, err
 	}
+	if !fi.IsDir() {
+		return []string{name}, nil
+	}
 
-	// skip invalid key
-	m = make(map[string]string)
-	for k, v := range dataMap {
-		if strings.HasPrefix(k, "/") {
-			m[k] = v
+	var files []string
+	err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isBackendFile(path) {
+			files = append(files, path)
 		}
+		return nil
+	})
+	return files, err
+}
+
+func isBackendFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml", ".yaml", ".yml", ".json":
+		return true
 	}
+	return false
+}
 
-	return m, nil
+// loadBackendFile flattens the values of the file into m, the format is
+// by the extension, TOML if unknown.
+func loadBackendFile(name string, m map[string]string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var v interface{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &v); err == nil {
+			v, err = yamlToJSONValue(v)
+		}
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		err = d.Decode(&v)
+	default:
+		var x map[string]interface{}
+		_, err = toml.Decode(string(data), &x)
+		v = x
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	flattenBackendValue("/", v, m)
+	return nil
+}
+
+// flattenBackendValue sets the values of v under the key to m, the map
+// and array elements are the child keys.
+func flattenBackendValue(key string, v interface{}, m map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, x := range v {
+			flattenBackendValue(path.Join(key, k), x, m)
+		}
+	case []interface{}:
+		for i, x := range v {
+			flattenBackendValue(path.Join(key, strconv.Itoa(i)), x, m)
+		}
+	case []map[string]interface{}: // the array of tables of TOML
+		for i, x := range v {
+			flattenBackendValue(path.Join(key, strconv.Itoa(i)), x, m)
+		}
+	case nil:
+		m[key] = ""
+	case string:
+		m[key] = v
+	case float64:
+		m[key] = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		m[key] = fmt.Sprint(v)
+	}
 }
@@ -5,7 +5,12 @@
 package libconfd
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestTomlBackend(t *testing.T) {
@@ -19,3 +24,128 @@ func TestTomlBackend(t *testing.T) {
 		t.Fatal(v)
 	}
 }
+
+func TestTomlBackendFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-file-backend")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "b"), 0755) == nil)
+	for name, content := range map[string]string{
+		"a.toml":   "\"/key\" = \"foobar\"\n[database]\nhost = \"127.0.0.1\"\nports = [3306, 3307]\n",
+		"b/c.yaml": "database:\n  host: 10.0.0.1\n  user: root\nratio: 0.5\nenabled: true\n",
+		"d.json":   `{"upstream": [{"addr": "10.0.1.10"}], "size": 12345678901}`,
+		"e.txt":    "skipped",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		tAssert(t, err == nil, err)
+	}
+
+	client := NewTomlBackendClient(&BackendConfig{Type: TomlBackendType, Host: []string{dir}})
+	m, err := client.GetValues([]string{"/"})
+	tAssert(t, err == nil, err)
+	for k, v := range map[string]string{
+		"/key":              "foobar",
+		"/database/host":    "10.0.0.1", // b/c.yaml overrides a.toml
+		"/database/user":    "root",
+		"/database/ports/1": "3307",
+		"/ratio":            "0.5",
+		"/enabled":          "true",
+		"/upstream/0/addr":  "10.0.1.10",
+		"/size":             "12345678901",
+	} {
+		tAssert(t, m[k] == v, k, m[k])
+	}
+	tAssert(t, len(m) == 9, m)
+
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0644) == nil)
+	_, err = client.GetValues([]string{"/"})
+	tAssert(t, err != nil)
+}
+
+func TestTomlBackendWatchPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-file-backend")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.yaml")
+	tAssert(t, ioutil.WriteFile(file, []byte("app:\n  port: 80\n"), 0644) == nil)
+
+	client := NewTomlBackendClient(&BackendConfig{Type: TomlBackendType, Host: []string{file}})
+	keys := []string{"/app"}
+
+	index, err := client.WatchPrefix("/", keys, 0, nil)
+	tAssert(t, err == nil && index == 1, index, err)
+	index, err = client.WatchPrefix("/", keys, index, nil)
+	tAssert(t, err == nil && index > 1, index, err)
+
+	done := make(chan uint64, 1)
+	go func() {
+		i, _ := client.WatchPrefix("/", keys, index, make(chan bool))
+		done <- i
+	}()
+
+	// replaced by rename, like the editors
+	time.Sleep(time.Millisecond * 50)
+	tmp := filepath.Join(dir, ".app.yaml.tmp")
+	tAssert(t, ioutil.WriteFile(tmp, []byte("app:\n  port: 8080\n"), 0644) == nil)
+	tAssert(t, os.Rename(tmp, file) == nil)
+
+	select {
+	case i := <-done:
+		tAssert(t, i != index, i)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch timeout")
+	}
+
+	stopChan := make(chan bool)
+	go func() {
+		i, _ := client.WatchPrefix("/", keys, 2, stopChan)
+		done <- i
+	}()
+	close(stopChan)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop timeout")
+	}
+}
+
+func TestTomlBackendWatchEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-file-backend")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.yaml")
+	tAssert(t, ioutil.WriteFile(file, []byte("app:\n  host: a\n  port: 80\n"), 0644) == nil)
+
+	client := NewTomlBackendClient(&BackendConfig{Type: TomlBackendType, Host: []string{file}})
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := client.WatchEvents(ctx, "/", []string{"/app"})
+	tAssert(t, err == nil, err)
+
+	tmp := filepath.Join(dir, ".app.yaml.tmp")
+	tAssert(t, ioutil.WriteFile(tmp, []byte("app:\n  port: 8080\n"), 0644) == nil)
+	tAssert(t, os.Rename(tmp, file) == nil)
+
+	var got []KVEvent
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch timeout", got)
+		}
+	}
+	tAssert(t, got[0] == KVEvent{Type: KVEventDelete, Key: "/app/host"}, got)
+	tAssert(t, got[1] == KVEvent{Type: KVEventPut, Key: "/app/port", Value: "8080"}, got)
+
+	// closed when canceled
+	cancel()
+	select {
+	case _, ok := <-events:
+		tAssert(t, !ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop timeout")
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchPrefix waits for the changes of the files by fsnotify, and returns
+// the hash of the values of keys as the index.
+func (p *TomlBackend) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	// return something > 0 to trigger a key retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	w, err := p.getWatcher()
+	if err != nil {
+		return waitIndex, err
+	}
+
+	for {
+		// take the channel before the values are read, so no change is missed
+		changed := w.changedChan()
+
+		values, err := p.GetValues(keys)
+		if err != nil {
+			return waitIndex, err
+		}
+		if index := hashWatchValues(values, keys); index != waitIndex {
+			return index, nil
+		}
+
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-changed:
+		}
+	}
+}
+
+// WatchEvents sends the changes of the values of keys, by the diff of
+// the values read on every change of the files.
+func (p *TomlBackend) WatchEvents(ctx context.Context, prefix string, keys []string) (<-chan KVEvent, error) {
+	w, err := p.getWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// take the channel before the values are read, so no change is missed
+	changed := w.changedChan()
+	values, err := p.GetValues(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan KVEvent, 16)
+	go func() {
+		defer close(events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+
+			changed = w.changedChan()
+			newValues, err := p.GetValues(keys)
+			if err != nil {
+				backendLogger.Warning("file backend watch events: ", err)
+				return
+			}
+			for _, e := range diffWatchValues(values, newValues, keys) {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			values = newValues
+		}
+	}()
+	return events, nil
+}
+
+// diffWatchValues returns the events of the values of keys changed from
+// old to values, sorted by the key.
+func diffWatchValues(old, values map[string]string, keys []string) []KVEvent {
+	var events []KVEvent
+	for k, v := range values {
+		if ov, ok := old[k]; (!ok || ov != v) && MatchWatchKey(k, keys, false) {
+			events = append(events, KVEvent{Type: KVEventPut, Key: k, Value: v})
+		}
+	}
+	for k := range old {
+		if _, ok := values[k]; !ok && MatchWatchKey(k, keys, false) {
+			events = append(events, KVEvent{Type: KVEventDelete, Key: k})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Key < events[j].Key
+	})
+	return events
+}
+
+// getWatcher returns the watcher of the files, shared by all the watches
+// of the backend.
+func (p *TomlBackend) getWatcher() (*fileWatcher, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.watcher == nil {
+		w, err := newFileWatcher(p.getPaths())
+		if err != nil {
+			return nil, err
+		}
+		p.watcher = w
+	}
+	return p.watcher, nil
+}
+
+// hashWatchValues returns the hash of the values of keys, 0 and 1 are the
+// indexes before the first read.
+func hashWatchValues(values map[string]string, keys []string) uint64 {
+	var names []string
+	for k := range values {
+		if MatchWatchKey(k, keys, false) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, k := range names {
+		fmt.Fprintf(h, "%s\x00%s\x00", k, values[k])
+	}
+	if index := h.Sum64(); index > 1 {
+		return index
+	}
+	return 2
+}
+
+// fileWatcher watches the dirs of the files, and the dirs recursively,
+// the dir of a file is watched to see the file replaced by rename.
+type fileWatcher struct {
+	watcher *fsnotify.Watcher
+
+	mu      sync.Mutex
+	changed chan struct{} // closed on the next event
+}
+
+func newFileWatcher(paths []string) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	p := &fileWatcher{watcher: watcher, changed: make(chan struct{})}
+
+	for _, name := range paths {
+		fi, err := os.Stat(name)
+		if err == nil && fi.IsDir() {
+			err = p.addDir(name)
+		} else {
+			err = watcher.Add(filepath.Dir(name))
+		}
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go p.run()
+	return p, nil
+}
+
+func (p *fileWatcher) addDir(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return p.watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+func (p *fileWatcher) run() {
+	for {
+		select {
+		case event, ok := <-p.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+					if err := p.addDir(event.Name); err != nil {
+						backendLogger.Warning("watch ", event.Name, ": ", err)
+					}
+				}
+			}
+			backendLogger.Debugln("file backend event:", event)
+
+			p.mu.Lock()
+			close(p.changed)
+			p.changed = make(chan struct{})
+			p.mu.Unlock()
+
+		case err, ok := <-p.watcher.Errors:
+			if !ok {
+				return
+			}
+			backendLogger.Warning("file backend watch: ", err)
+		}
+	}
+}
+
+// changedChan returns the channel closed on the next event.
+func (p *fileWatcher) changedChan() chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.changed
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RenderBundle renders the template resources into a tar.gz written to w,
+// instead of the dest files, for building the config bundles of the
+// immutable images. The entries are named by the dest paths without the
+// leading "/", and keep the modes and owners of the resources.
+//
+// Only the resources of the names are rendered if names are given, such
+// as "nginx" or "nginx.toml". The check and reload commands are never run,
+// and nothing is written to the dest files.
+func RenderBundle(w io.Writer, cfg *Config, client BackendClient, names ...string) error {
+	stageDir, err := ioutil.TempDir("", "libconfd-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stageDir)
+
+	cfg = cfg.Clone()
+	cfg.DestRoot = ""
+	cfg.StageDir = stageDir
+
+	processors, err := MakeAllTemplateResourceProcessor(cfg, client)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		processors = filterBundleProcessors(processors, names)
+		if len(processors) == 0 {
+			return fmt.Errorf("libconfd: no template resource of %s", strings.Join(names, ", "))
+		}
+	}
+	sort.Slice(processors, func(i, j int) bool {
+		return processors[i].Dest < processors[j].Dest
+	})
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	call := &Call{Config: cfg, Client: client}
+	seen := make(map[string]string)
+	for _, p := range processors {
+		if name, ok := seen[p.Dest]; ok {
+			return fmt.Errorf("libconfd: %s and %s have the same dest %s", name, filepath.Base(p.path), p.Dest)
+		}
+		seen[p.Dest] = filepath.Base(p.path)
+
+		p.StageDir = stageDir
+		if err := p.renderBundleEntry(call, tw); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(p.path), err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func filterBundleProcessors(processors []*TemplateResourceProcessor, names []string) []*TemplateResourceProcessor {
+	var matched []*TemplateResourceProcessor
+	for _, p := range processors {
+		base := strings.TrimSuffix(filepath.Base(p.path), ".toml")
+		for _, name := range names {
+			if strings.TrimSuffix(name, ".toml") == base {
+				matched = append(matched, p)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// renderBundleEntry renders the stage file, like Process before sync, and
+// writes it to tw as the entry of Dest.
+func (p *TemplateResourceProcessor) renderBundleEntry(call *Call, tw *tar.Writer) error {
+	p.templateFunc.changes.set(nil)
+	p.updateFuncMap(call)
+
+	if err := p.setFileMode(call); err != nil {
+		return err
+	}
+	if p.Mode == "" {
+		// the mode of the local dest file does not belong to the bundle
+		p.FileMode = 0644
+	}
+	if err := p.setVars(call); err != nil {
+		return err
+	}
+	if err := p.checkKeyRules(); err != nil {
+		return err
+	}
+	if err := p.createStageFile(call); err != nil {
+		return err
+	}
+	defer os.Remove(p.stageFile.Name())
+
+	data, err := ioutil.ReadFile(p.stageFile.Name())
+	if err != nil {
+		return err
+	}
+
+	name := p.Dest[len(filepath.VolumeName(p.Dest)):]
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     strings.TrimPrefix(filepath.ToSlash(name), "/"),
+		Mode:     int64(p.FileMode.Perm()),
+		Uid:      maxInt(p.Uid, 0),
+		Gid:      maxInt(p.Gid, 0),
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-bundle-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	write := func(name, s string) {
+		tAssert(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644) == nil)
+	}
+	write("templates/app.tmpl", `port={{getv "/port"}}`)
+	write("conf.d/app.toml", `
+[template]
+src = "app.tmpl"
+dest = "/etc/app/app.conf"
+prefix = "/app"
+keys = ["/port"]
+uid = 0
+gid = 0
+mode = "0600"
+`)
+	write("conf.d/web.toml", `
+[template]
+src = "app.tmpl"
+dest = "/etc/web.conf"
+prefix = "/web"
+keys = ["/port"]
+`)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := mapBackendClient{"/app/port": "8080", "/web/port": "80"}
+	contents := make(map[string]string)
+	read := func(names ...string) map[string]*tar.Header {
+		var buf bytes.Buffer
+		tAssert(t, RenderBundle(&buf, cfg, client, names...) == nil)
+
+		gr, err := gzip.NewReader(&buf)
+		tAssert(t, err == nil, err)
+		tr := tar.NewReader(gr)
+
+		headers := make(map[string]*tar.Header)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			tAssert(t, err == nil, err)
+			data, err := ioutil.ReadAll(tr)
+			tAssert(t, err == nil, err)
+			contents[hdr.Name] = string(data)
+			headers[hdr.Name] = hdr
+		}
+		return headers
+	}
+
+	headers := read()
+	tAssert(t, len(headers) == 2, headers)
+
+	hdr := headers["etc/app/app.conf"]
+	tAssert(t, hdr != nil && contents[hdr.Name] == "port=8080", hdr)
+	tAssert(t, hdr.Mode == 0600 && hdr.Uid == 0 && hdr.Gid == 0, hdr)
+	hdr = headers["etc/web.conf"]
+	tAssert(t, hdr != nil && contents[hdr.Name] == "port=80" && hdr.Mode == 0644, hdr)
+
+	// nothing is written to the dest files
+	tAssert(t, fileNotExists("/etc/app/app.conf"))
+
+	headers = read("web.toml")
+	tAssert(t, len(headers) == 1 && headers["etc/web.conf"] != nil, headers)
+
+	tAssert(t, RenderBundle(ioutil.Discard, cfg, client, "missing") != nil)
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxCommandOutput is the default limit of the captured stdout
+// and stderr of check/reload commands.
+const DefaultMaxCommandOutput = 64 << 10
+
+// CommandResult is the captured result of a check or reload command.
+type CommandResult struct {
+	Cmd       string
+	Stdout    string
+	Stderr    string
+	Truncated bool // Stdout or Stderr exceeded the limit
+	ExitCode  int  // -1 if the command did not start or was killed
+	Duration  time.Duration
+}
+
+// CommandError is the error returned by a failed check or reload command,
+// the hooks can get the captured output by a type assertion.
+type CommandError struct {
+	*CommandResult
+	Err error
+}
+
+func (p *CommandError) Error() string {
+	if p.Stderr != "" {
+		return fmt.Sprintf("%v: %q", p.Err, p.Stderr)
+	}
+	return p.Err.Error()
+}
+
+func (p *CommandError) Unwrap() error {
+	return p.Err
+}
+
+// CommandRunner runs the check and reload commands, it can be replaced
+// by Config.CommandRunner to run the commands by SSH, inside containers,
+// or by a fake runner in tests.
+//
+// RunCommand keeps at most limit bytes of the stdout and stderr, it
+// returns a *CommandError if the command failed.
+type CommandRunner interface {
+	RunCommand(cmd string, limit int) (*CommandResult, error)
+}
+
+// CommandRunnerFunc is an adapter to use a function as CommandRunner.
+type CommandRunnerFunc func(cmd string, limit int) (*CommandResult, error)
+
+// RunCommand calls fn(cmd, limit).
+func (fn CommandRunnerFunc) RunCommand(cmd string, limit int) (*CommandResult, error) {
+	return fn(cmd, limit)
+}
+
+// ShellRunner runs the commands by the local shell.
+type ShellRunner struct {
+	// Shell is the shell and its args before cmd, such as
+	// []string{"/bin/bash", "-c"}, default is "/bin/sh -c" on unix
+	// and "cmd /C" on windows.
+	Shell []string
+}
+
+// DefaultCommandRunner is the CommandRunner used if Config.CommandRunner is nil.
+var DefaultCommandRunner CommandRunner = &ShellRunner{}
+
+// runCommand runs cmd by DefaultCommandRunner.
+func runCommand(cmd string, limit int) (*CommandResult, error) {
+	return DefaultCommandRunner.RunCommand(cmd, limit)
+}
+
+// RunCommand runs cmd by the shell.
+func (p *ShellRunner) RunCommand(cmd string, limit int) (*CommandResult, error) {
+	if limit <= 0 {
+		limit = DefaultMaxCommandOutput
+	}
+
+	shell := p.Shell
+	if len(shell) == 0 {
+		if runtime.GOOS == "windows" {
+			shell = []string{"cmd", "/C"}
+		} else {
+			shell = []string{"/bin/sh", "-c"}
+		}
+	}
+	args := append(append([]string{}, shell[1:]...), cmd)
+	c := exec.Command(shell[0], args...)
+
+	stdout := &limitedBuffer{limit: limit}
+	stderr := &limitedBuffer{limit: limit}
+	c.Stdout = stdout
+	c.Stderr = stderr
+
+	start := time.Now()
+	err := c.Run()
+
+	result := &CommandResult{
+		Cmd:       cmd,
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+		ExitCode:  -1,
+		Duration:  time.Since(start),
+	}
+	if c.ProcessState != nil {
+		if status, ok := c.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+		}
+	}
+	if err != nil {
+		return result, &CommandError{CommandResult: result, Err: err}
+	}
+	return result, nil
+}
+
+// limitedBuffer drops the writes beyond limit.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (p *limitedBuffer) Write(b []byte) (int, error) {
+	if n := p.limit - p.buf.Len(); n < len(b) {
+		p.truncated = true
+		if n > 0 {
+			p.buf.Write(b[:n])
+		}
+		return len(b), nil
+	}
+	return p.buf.Write(b)
+}
+
+func (p *limitedBuffer) String() string {
+	return p.buf.String()
+}
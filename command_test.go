@@ -0,0 +1,73 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package libconfd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	result, err := runCommand("echo hello; echo world >&2", 0)
+	tAssert(t, err == nil, err)
+	tAssert(t, result.ExitCode == 0, result)
+	tAssert(t, result.Stdout == "hello\n", result.Stdout)
+	tAssert(t, result.Stderr == "world\n", result.Stderr)
+	tAssert(t, !result.Truncated)
+
+	result, err = runCommand("echo 0123456789; exit 3", 4)
+	tAssert(t, err != nil)
+	tAssert(t, result.ExitCode == 3, result)
+	tAssert(t, result.Stdout == "0123", result.Stdout)
+	tAssert(t, result.Truncated)
+
+	cmdErr, ok := err.(*CommandError)
+	tAssert(t, ok, err)
+	tAssert(t, cmdErr.ExitCode == 3)
+}
+
+func TestShellRunner(t *testing.T) {
+	runner := &ShellRunner{Shell: []string{"/bin/sh", "-e", "-c"}}
+	result, err := runner.RunCommand("false; echo unreachable", 0)
+	tAssert(t, err != nil)
+	tAssert(t, result.ExitCode == 1, result)
+	tAssert(t, result.Stdout == "", result.Stdout)
+}
+
+func TestCommandRunner(t *testing.T) {
+	var cmds []string
+	cfg := newDefaultConfig()
+	cfg.CommandRunner = CommandRunnerFunc(func(cmd string, limit int) (*CommandResult, error) {
+		cmds = append(cmds, cmd)
+		return &CommandResult{Cmd: cmd}, nil
+	})
+
+	p, err := NewTemplateResourceProcessor("app.toml", cfg, mapBackendClient(nil), &TemplateResource{
+		ReloadCmd: "systemctl reload app",
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.doReloadCmd(&Call{Config: cfg}) == nil)
+	tAssert(t, len(cmds) == 1 && cmds[0] == "systemctl reload app", cmds)
+}
+
+func TestCommandRunnerNilResult(t *testing.T) {
+	var results []*CommandResult
+	cfg := newDefaultConfig().applyOptions(WithHookOnCommand(func(trName string, result *CommandResult) {
+		results = append(results, result)
+	}))
+	cfg.CommandRunner = CommandRunnerFunc(func(cmd string, limit int) (*CommandResult, error) {
+		return nil, errors.New("not started")
+	})
+
+	p, err := NewTemplateResourceProcessor("app.toml", cfg, mapBackendClient(nil), &TemplateResource{
+		ReloadCmd: "systemctl reload app",
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.doReloadCmd(&Call{Config: cfg}) != nil)
+	tAssert(t, len(results) == 1 && results[0].Cmd == "systemctl reload app" && results[0].ExitCode == -1, results)
+}
@@ -6,9 +6,14 @@ package libconfd
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"text/template"
 
 	"github.com/BurntSushi/toml"
@@ -22,6 +27,11 @@ type Config struct {
 	//
 	ConfDir string `toml:"confdir" json:"confdir"`
 
+	// More confdirs layered over ConfDir, a template resource or template
+	// of the later dir overrides the same file name of the earlier dirs.
+	// The rel paths are converted to abs paths like ConfDir.
+	ConfDirs []string `toml:"confdirs" json:"confdirs"`
+
 	// The backend polling interval in seconds. (10)
 	Interval int `toml:"interval" json:"interval"`
 
@@ -38,6 +48,22 @@ type Config struct {
 	// DEBUG/INFO/WARN/ERROR/PANIC
 	LogLevel string `toml:"log-level" json:"log-level"`
 
+	// per component log levels, overriding LogLevel
+	// processor/backend/template/command
+	LogLevels map[string]string `toml:"log-levels" json:"log-levels"`
+
+	// verbosity of the DEBUG output, 0-4
+	// 2 logs raw backend responses, 3 logs key TTLs
+	Verbosity int `toml:"verbosity" json:"verbosity"`
+
+	// repeated WARN/ERROR messages are summarized within the interval in seconds
+	// 0 disables the deduplication
+	LogRepeatInterval int `toml:"log-repeat-interval" json:"log-repeat-interval"`
+
+	// values of the matched keys are masked in logs and diffs
+	// glob patterns matched against the key and its last element
+	RedactKeys []string `toml:"redact-keys" json:"redact-keys"`
+
 	// the TOML backend file to watch for changes
 	//File string `toml:"file" json:"file"`
 
@@ -50,9 +76,134 @@ type Config struct {
 	// keep staged files
 	KeepStageFile bool `toml:"keep-stage-file" json:"keep-stage-file"`
 
+	// take the advisory lock (flock of .<dest>.lock) while syncing the
+	// target file, wait at most lock-timeout seconds for the lock
+	LockDest    bool `toml:"lock-dest" json:"lock-dest"`
+	LockTimeout int  `toml:"lock-timeout" json:"lock-timeout"`
+
+	// dir of the staged files, default is the dir of the target file
+	// can be overridden by the stage_dir of template resource
+	StageDir string `toml:"stage-dir" json:"stage-dir"`
+
+	// render all the target files under the dir, the writes never escape
+	// the dir, usually with sync-only for building images or chroots
+	DestRoot string `toml:"dest-root" json:"dest-root"`
+
+	// append a JSON record (path, old/new SHA256, resource, reload result)
+	// to the file whenever a target config file is modified
+	AuditLog string `toml:"audit-log" json:"audit-log"`
+
+	// dir of the archived versions of the target config files, for
+	// Processor.Rollback, and the number of the versions kept (5)
+	ArchiveDir      string `toml:"archive-dir" json:"archive-dir"`
+	ArchiveVersions int    `toml:"archive-versions" json:"archive-versions"`
+
+	// max bytes of the captured stdout/stderr of check/reload commands
+	// 0 uses the default limit (64KB)
+	MaxCommandOutput int `toml:"max-command-output" json:"max-command-output"`
+
 	// PGP secret keyring (for use with crypt functions)
 	PGPPrivateKey string `toml:"pgp-private-key" json:"pgp-private-key"`
 
+	// scheme of the secure values read by the crypt functions,
+	// pgp (default) or aes-gcm
+	CryptMode string `toml:"crypt-mode" json:"crypt-mode"`
+
+	// AES key file of the aes-gcm crypt mode, the key is the raw
+	// 16/24/32 bytes, or encoded by hex or base64
+	AESKeyFile string `toml:"aes-key-file" json:"aes-key-file"`
+
+	// treat keys case-insensitively (keys are lower-cased in the store)
+	IgnoreKeyCase bool `toml:"ignore-key-case" json:"ignore-key-case"`
+
+	// max size in bytes of a single value, 0 means no limit
+	MaxValueSize int `toml:"max-value-size" json:"max-value-size"`
+
+	// max size in bytes of all keys and values of a resource, 0 means no limit
+	MaxStoreSize int `toml:"max-store-size" json:"max-store-size"`
+
+	// bbolt file to persist the fetched key/values snapshot
+	SnapshotFile string `toml:"snapshot-file" json:"snapshot-file"`
+
+	// render from the snapshot file, never contact the backend
+	Offline bool `toml:"offline" json:"offline"`
+
+	// if the backend is unavailable at start, keep the target files
+	// untouched and retry every interval instead of failing
+	// (interval and watch mode only)
+	StartStale bool `toml:"start-stale" json:"start-stale"`
+
+	// max GetValues and WatchPrefix calls per minute of all the backends
+	// of the call and its groups, and the burst (default is the limit), 0
+	// means no limit
+	BackendRateLimit int `toml:"backend-rate-limit" json:"backend-rate-limit"`
+	BackendRateBurst int `toml:"backend-rate-burst" json:"backend-rate-burst"`
+
+	// deadline in seconds of the GetValues calls of a render, the calls
+	// of the BackendClientV2 are canceled at the deadline, 0 means none
+	BackendCallTimeout int `toml:"backend-call-timeout" json:"backend-call-timeout"`
+
+	// the named backends layered with the backend into one key space, in
+	// the order of precedence, "backend" is the backend of the processor,
+	// the lowest if not listed, see MultiBackendClient
+	BackendLayers []string `toml:"backend-layers" json:"backend-layers"`
+
+	// shell and its args to run the check/reload commands,
+	// default is ["/bin/sh", "-c"] on unix and ["cmd", "/C"] on windows
+	Shell []string `toml:"shell" json:"shell"`
+
+	// DNS server (host:port) of the lookupIP/lookupSRV template funcs,
+	// default is the system resolver
+	DNSServer string `toml:"dns-server" json:"dns-server"`
+
+	// timeout in seconds of a DNS lookup, default is 5
+	DNSTimeout int `toml:"dns-timeout" json:"dns-timeout"`
+
+	// default or restricted, restricted disables the template funcs
+	// reading the host, such as getenv, fileExists and the DNS lookups
+	FuncProfile string `toml:"func-profile" json:"func-profile"`
+
+	// dir of the rendered outputs cached by the hash of the template and
+	// the values, the unchanged resources skip rendering after restart
+	RenderCacheDir string `toml:"render-cache-dir" json:"render-cache-dir"`
+
+	// seconds to wait for the target file syncs and reload commands in
+	// progress when the processor is stopped, default is 30
+	StopGracePeriod int `toml:"stop-grace-period" json:"stop-grace-period"`
+
+	// max template resources processed at the same time, the high
+	// priority ones are processed first, and one slot is kept for them,
+	// 0 means no limit in watch mode, and one by one in the other modes
+	Concurrency int `toml:"concurrency" json:"concurrency"`
+
+	// consecutive restarts of a failed watcher in watch mode before it is
+	// given up and reported by Processor.Status, default is 10
+	WatchMaxRestarts int `toml:"watch-max-restarts" json:"watch-max-restarts"`
+
+	// publish the RenderStatus of every resource to the backend under the
+	// prefix, <prefix>/<hostname>/<resource name>, the backend must be a
+	// StoreWriter
+	StatusPrefix string `toml:"status-prefix" json:"status-prefix"`
+
+	// publish the pending changes of the resources with require_approval
+	// to the backend under the prefix, and read their approval tokens,
+	// see PendingChange
+	ApprovalPrefix string `toml:"approval-prefix" json:"approval-prefix"`
+
+	// the modes of the resource groups, see ResourceGroup
+	Groups map[string]ResourceGroup `toml:"groups" json:"groups"`
+
+	// the builtin notifiers named by notify of the template resources,
+	// see NotifierConfig
+	Notifiers map[string]NotifierConfig `toml:"notifiers" json:"notifiers"`
+
+	// the builtin publishers of the rendered bundle, see PublisherConfig
+	Publishers map[string]PublisherConfig `toml:"publishers" json:"publishers"`
+
+	// the builtin pushers of the metrics after every cycle, see
+	// MetricsPushConfig
+	MetricsPush map[string]MetricsPushConfig `toml:"metrics-push" json:"metrics-push"`
+
 	// ----------------------------------------------------
 
 	FuncMap        template.FuncMap                               `toml:"-" json:"-"`
@@ -62,6 +213,48 @@ type Config struct {
 	HookOnCheckCmdError  func(trName, cmd string, err error)  `toml:"-" json:"-"`
 	HookOnReloadCmdError func(trName, cmd string, err error)  `toml:"-" json:"-"`
 	HookOnError          func(trName string, err error)       `toml:"-" json:"-"`
+
+	// called after every check/reload command, err of the cmd error
+	// hooks wraps a *CommandError, and err of HookOnCheckCmdError is
+	// an *ErrCheckFailed
+	HookOnCommand func(trName string, result *CommandResult) `toml:"-" json:"-"`
+
+	// called after a target config file is updated and reloaded
+	HookOnUpdated func(trName, dest string) `toml:"-" json:"-"`
+
+	// the hooks used by the template resources naming them, instead of
+	// the hooks above
+	HookSets map[string]HookSet `toml:"-" json:"-"`
+
+	// the notifiers registered by WithNotifier, override the notifiers
+	// of the same name above
+	NotifierPlugins map[string]Notifier `toml:"-" json:"-"`
+
+	// the publishers registered by WithPublisher, override the publishers
+	// of the same name above
+	PublisherPlugins map[string]Publisher `toml:"-" json:"-"`
+
+	// the metrics pushers registered by WithMetricsPusher, override the
+	// pushers of the same name above
+	MetricsPusherPlugins map[string]MetricsPusher `toml:"-" json:"-"`
+
+	// runs the check/reload commands instead of the local Shell
+	CommandRunner CommandRunner `toml:"-" json:"-"`
+
+	// the secondary backends read by the getFrom/getsFrom template funcs
+	NamedBackends map[string]BackendClient `toml:"-" json:"-"`
+
+	// the conf.d and templates dirs compiled into the binary, such as the
+	// sub dirs of an embed.FS, used instead of the dirs of ConfDir and
+	// ConfDirs. ConfDir is still the dir of the outputs.
+	ConfFS     fs.FS `toml:"-" json:"-"`
+	TemplateFS fs.FS `toml:"-" json:"-"`
+
+	LogHooks []LogHook `toml:"-" json:"-"`
+
+	// returns the AES key of the aes-gcm crypt mode, such as a data key
+	// fetched from a KMS, AESKeyFile is not used if set
+	AESKeyProvider func() ([]byte, error) `toml:"-" json:"-"`
 }
 
 const defaultConfigContent = `
@@ -72,6 +265,10 @@ const defaultConfigContent = `
 #
 confdir = "confd"
 
+# More confdirs layered over confdir, a template resource or template
+# of the later dir overrides the same file name of the earlier dirs.
+# confdirs = ["confd-prod"]
+
 # The backend polling interval in seconds. (10)
 interval = 10
 
@@ -87,6 +284,23 @@ sync-only = true
 # level which confd should log messages ("DEBUG")
 log-level = "DEBUG"
 
+# per component log levels, overriding log-level
+# processor/backend/template/command
+# log-levels = { backend = "DEBUG", template = "WARN" }
+
+# verbosity of the DEBUG output, 0-4
+# 2 logs raw backend responses, 3 logs key TTLs
+verbosity = 0
+
+# repeated WARN/ERROR messages are summarized within the interval in seconds
+# 0 disables the deduplication
+log-repeat-interval = 600
+
+# values of the matched keys are masked in logs and diffs
+# glob patterns matched against the key and its last element
+# keys read by cget/cgets/cgetv/cgetvs are always masked
+# redact-keys = ["*password*", "/secrets/*"]
+
 # run once and exit
 onetime = true
 
@@ -99,8 +313,199 @@ file = "./confd/backend-file.toml"
 # keep staged files
 keep-stage-file = false
 
+# take the advisory lock (flock of .<dest>.lock) while syncing the
+# target file, wait at most lock-timeout seconds for the lock
+lock-dest = false
+lock-timeout = 0
+
+# dir of the staged files, default is the dir of the target file
+# can be overridden by the stage_dir of template resource
+# stage-dir = "/var/lib/confd/stage"
+
+# render all the target files under the dir, such as /etc/nginx/nginx.conf
+# to <dest-root>/etc/nginx/nginx.conf, the writes never escape the dir,
+# usually with sync-only for building images or chroots
+# dest-root = "/build/rootfs"
+
+# append a JSON record (path, old/new SHA256, resource, reload result)
+# to the file whenever a target config file is modified
+# audit-log = "/var/log/confd-audit.log"
+
+# dir of the archived versions of the target config files, for
+# rollback, and the number of the versions kept (5)
+# archive-dir = "/var/lib/confd/archive"
+# archive-versions = 5
+
+# max bytes of the captured stdout/stderr of check/reload commands
+# 0 uses the default limit (64KB)
+max-command-output = 0
+
 # PGP secret keyring (for use with crypt functions)
 pgp-private-key = ""
+
+# scheme of the secure values read by the crypt functions,
+# pgp (default) or aes-gcm
+# crypt-mode = "aes-gcm"
+
+# AES key file of the aes-gcm crypt mode, the key is the raw
+# 16/24/32 bytes, or encoded by hex or base64
+# aes-key-file = "/etc/confd/aes.key"
+
+# treat keys case-insensitively (keys are lower-cased in the store)
+ignore-key-case = false
+
+# max size in bytes of a single value, 0 means no limit
+max-value-size = 0
+
+# max size in bytes of all keys and values of a resource, 0 means no limit
+max-store-size = 0
+
+# bbolt file to persist the fetched key/values snapshot
+snapshot-file = ""
+
+# render from the snapshot file, never contact the backend
+offline = false
+
+# if the backend is unavailable at start, keep the target files
+# untouched and retry every interval instead of failing
+# (interval and watch mode only)
+start-stale = false
+
+# max GetValues and WatchPrefix calls per minute of all the backends,
+# and the burst (default is the limit), 0 means no limit, the backends
+# may have their own limits, see rate-limit of the backend config
+# backend-rate-limit = 600
+# backend-rate-burst = 100
+
+# deadline in seconds of the GetValues calls of a render, the calls
+# of the BackendClientV2 are canceled at the deadline, 0 means none
+# backend-call-timeout = 10
+
+# the named backends layered with the backend into one key space, in
+# the order of precedence, the value of a key is read from the first
+# layer having it, "backend" is the backend above, the lowest if not
+# listed, see [backends.<name>] of the master config
+# backend-layers = ["vault", "backend", "env"]
+
+# shell and its args to run the check/reload commands,
+# default is ["/bin/sh", "-c"] on unix and ["cmd", "/C"] on windows
+# shell = ["/bin/bash", "-c"]
+
+# DNS server (host:port) of the lookupIP/lookupSRV template funcs,
+# default is the system resolver
+# dns-server = "10.0.0.2:53"
+
+# timeout in seconds of a DNS lookup, default is 5
+# dns-timeout = 5
+
+# default or restricted, restricted disables the template funcs
+# reading the host, such as getenv, fileExists and the DNS lookups
+# func-profile = "restricted"
+
+# dir of the rendered outputs cached by the hash of the template and
+# the values, the unchanged resources skip rendering after restart
+# render-cache-dir = "/var/cache/confd/render"
+
+# seconds to wait for the target file syncs and reload commands in
+# progress when the processor is stopped, default is 30
+# stop-grace-period = 30
+
+# max template resources processed at the same time, the resources of
+# priority = "high" are processed first, and one slot is kept for them,
+# 0 means no limit in watch mode, and one by one in the other modes
+# concurrency = 4
+
+# consecutive restarts of a failed watcher in watch mode before it is
+# given up and reported by Processor.Status, default is 10
+# watch-max-restarts = 10
+
+# publish the render status (last success time, checksum of dest and
+# version) of every resource to the backend under the prefix,
+# <prefix>/<hostname>/<resource name>, the backend must support writing
+# status-prefix = "/confd/status"
+
+# publish the pending changes of the resources with require_approval to
+# the backend under the prefix, dest is replaced after the change id is
+# written to <prefix>/<hostname>/<resource name>/approve
+# approval-prefix = "/confd/approval"
+
+# the resources of a group, by group = "name" of the template resource,
+# run in the mode of the group instead of the mode above
+#
+# [groups.secrets]
+# mode = "watch"
+#
+# [groups.logs]
+# mode = "interval"
+# interval = 3600
+
+# the notifiers of the change events, by notify = ["name"] of the
+# template resource, type is slack, http or email, all the events
+# (updated/check_failed/reload_failed/pending_approval/stale) are sent
+# if events is empty
+#
+# [notifiers.ops-slack]
+# type = "slack"
+# url = "https://hooks.slack.com/services/T000/B000/XXXX"
+# events = ["check_failed", "reload_failed"]
+#
+# [notifiers.ops-mail]
+# type = "email"
+# smtp-addr = "smtp.example.com:587"
+# username = "confd"
+# password = "secret"
+# from = "confd@example.com"
+# to = ["ops@example.com"]
+
+# the publishers of the tar.gz bundle of all the target files, see
+# RenderBundle, the bundle is uploaded whenever a target file is updated,
+# type is http (PUT by default) or sftp (the host key is verified by
+# known-hosts)
+#
+# [publishers.artifacts]
+# type = "http"
+# url = "https://artifacts.example.com/confd/web.tar.gz"
+# headers = { Authorization = "Bearer xxx" }
+#
+# [publishers.config-server]
+# type = "sftp"
+# url = "sftp://deploy@config.example.com/srv/confd/web.tar.gz"
+# key-file = "/etc/confd/id_ed25519"
+# known-hosts = "/etc/confd/known_hosts"
+
+# the pushers of the metrics after every cycle, for the onetime and cron
+# runs having no process to scrape, type is pushgateway (the group of
+# the job and the hostname is replaced), statsd (udp, the labels are sent
+# as DogStatsD tags) or otlp (OTLP/HTTP JSON)
+#
+# [metrics-push.gateway]
+# type = "pushgateway"
+# url = "http://pushgateway.example.com:9091"
+# job = "confd-web"
+#
+# [metrics-push.statsd]
+# type = "statsd"
+# addr = "127.0.0.1:8125"
+# prefix = "confd."
+#
+# [metrics-push.otel]
+# type = "otlp"
+# url = "http://otel-collector.example.com:4318/v1/metrics"
+
+# miniconfd only: PGP secret keyring file and the backend,
+# instead of pgp-private-key and the backend config file
+#
+# pgp-private-key-file = "/etc/miniconfd/secring.gpg"
+#
+# [backend]
+# type = "libconfd-backend-etcdv3"
+# host = ["127.0.0.1:2379"]
+#
+# the named backends read by getFrom/getsFrom, and layered by backend-layers
+#
+# [backends.vault]
+# type = "libconfd-backend-etcdv3"
+# host = ["10.0.0.2:2379"]
 `
 
 func newDefaultConfig() (p *Config) {
@@ -127,10 +532,13 @@ func MustLoadConfig(path string) *Config {
 	return p
 }
 
+// LoadConfig loads the config file in TOML, YAML (by the .yaml/.yml
+// extension) or JSON (by the .json extension) format, the keys of all
+// formats are the same. The ${VAR} and ${VAR:-default} in the file are
+// replaced by the environment variables before decoding.
 func LoadConfig(path string) (p *Config, err error) {
 	p = new(Config)
-	_, err = toml.DecodeFile(path, p)
-	if err != nil {
+	if err = decodeConfigFile(path, p); err != nil {
 		return nil, err
 	}
 	if !filepath.IsAbs(p.ConfDir) {
@@ -140,9 +548,62 @@ func LoadConfig(path string) (p *Config, err error) {
 		}
 		p.ConfDir = filepath.Clean(filepath.Join(absdir, p.ConfDir))
 	}
+	if err := p.absPaths(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
+// decodeConfigFile decodes the TOML, YAML or JSON file into v by the
+// extension of path, after the env vars are expanded.
+func decodeConfigFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data = []byte(expandEnvVars(string(data), "LIBCONFD_CONFDIR"))
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = decodeYAML(data, v)
+	case ".json":
+		err = json.Unmarshal(data, v)
+	default:
+		_, err = toml.Decode(string(data), v)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// absPaths makes the relative ConfDirs, AESKeyFile, RenderCacheDir,
+// ArchiveDir and DestRoot relative to basedir.
+func (p *Config) absPaths(basedir string) error {
+	absdir, err := filepath.Abs(basedir)
+	if err != nil {
+		return err
+	}
+	for i, dir := range p.ConfDirs {
+		if !filepath.IsAbs(dir) {
+			p.ConfDirs[i] = filepath.Clean(filepath.Join(absdir, dir))
+		}
+	}
+	if p.AESKeyFile != "" && !filepath.IsAbs(p.AESKeyFile) {
+		p.AESKeyFile = filepath.Join(absdir, p.AESKeyFile)
+	}
+	if p.RenderCacheDir != "" && !filepath.IsAbs(p.RenderCacheDir) {
+		p.RenderCacheDir = filepath.Join(absdir, p.RenderCacheDir)
+	}
+	if p.ArchiveDir != "" && !filepath.IsAbs(p.ArchiveDir) {
+		p.ArchiveDir = filepath.Join(absdir, p.ArchiveDir)
+	}
+	if p.DestRoot != "" && !filepath.IsAbs(p.DestRoot) {
+		p.DestRoot = filepath.Join(absdir, p.DestRoot)
+	}
+	return nil
+}
+
 func (p *Config) Valid() error {
 	if !filepath.IsAbs(p.ConfDir) {
 		return fmt.Errorf("ConfDir is not abs path: %s", p.ConfDir)
@@ -151,13 +612,119 @@ func (p *Config) Valid() error {
 	if !dirExists(p.ConfDir) {
 		return fmt.Errorf("ConfDir not exists: %s", p.ConfDir)
 	}
+	for _, dir := range p.ConfDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("ConfDirs is not abs path: %s", dir)
+		}
+		if !dirExists(dir) {
+			return fmt.Errorf("ConfDirs not exists: %s", dir)
+		}
+	}
 
 	if p.Interval < 0 {
 		return fmt.Errorf("invalid Interval: %d", p.Interval)
 	}
+	if p.Verbosity < 0 || p.Verbosity > MaxVerbosity {
+		return fmt.Errorf("invalid Verbosity: %d", p.Verbosity)
+	}
+	if p.MaxCommandOutput < 0 {
+		return fmt.Errorf("invalid MaxCommandOutput: %d", p.MaxCommandOutput)
+	}
+	if p.LogRepeatInterval < 0 {
+		return fmt.Errorf("invalid LogRepeatInterval: %d", p.LogRepeatInterval)
+	}
+	switch p.CryptMode {
+	case "", CryptModePGP:
+	case CryptModeAESGCM:
+		if p.AESKeyFile == "" && p.AESKeyProvider == nil {
+			return fmt.Errorf("missing AESKeyFile of crypt mode %s", p.CryptMode)
+		}
+	default:
+		return fmt.Errorf("invalid CryptMode: %s", p.CryptMode)
+	}
+	if err := validFuncProfile(p.FuncProfile); err != nil {
+		return err
+	}
+	if p.DNSTimeout < 0 {
+		return fmt.Errorf("invalid DNSTimeout: %d", p.DNSTimeout)
+	}
+	if p.LockTimeout < 0 {
+		return fmt.Errorf("invalid LockTimeout: %d", p.LockTimeout)
+	}
+	if p.ArchiveVersions < 0 {
+		return fmt.Errorf("invalid ArchiveVersions: %d", p.ArchiveVersions)
+	}
+	if p.Concurrency < 0 {
+		return fmt.Errorf("invalid Concurrency: %d", p.Concurrency)
+	}
+	if p.StopGracePeriod < 0 {
+		return fmt.Errorf("invalid StopGracePeriod: %d", p.StopGracePeriod)
+	}
+	if p.WatchMaxRestarts < 0 {
+		return fmt.Errorf("invalid WatchMaxRestarts: %d", p.WatchMaxRestarts)
+	}
+	if p.MaxValueSize < 0 {
+		return fmt.Errorf("invalid MaxValueSize: %d", p.MaxValueSize)
+	}
+	if p.MaxStoreSize < 0 {
+		return fmt.Errorf("invalid MaxStoreSize: %d", p.MaxStoreSize)
+	}
+	if p.BackendRateLimit < 0 {
+		return fmt.Errorf("invalid BackendRateLimit: %d", p.BackendRateLimit)
+	}
+	if p.BackendRateBurst < 0 {
+		return fmt.Errorf("invalid BackendRateBurst: %d", p.BackendRateBurst)
+	}
+	if p.BackendCallTimeout < 0 {
+		return fmt.Errorf("invalid BackendCallTimeout: %d", p.BackendCallTimeout)
+	}
+	for i, name := range p.BackendLayers {
+		if name == "" {
+			return fmt.Errorf("invalid BackendLayers[%d]: empty name", i)
+		}
+		for _, x := range p.BackendLayers[:i] {
+			if x == name {
+				return fmt.Errorf("invalid BackendLayers[%d]: duplicate %s", i, name)
+			}
+		}
+	}
 	if !newLogLevel(p.LogLevel).Valid() {
 		return fmt.Errorf("invalid LogLevel: %s", p.LogLevel)
 	}
+	for component, level := range p.LogLevels {
+		if level != "" && !newLogLevel(level).Valid() {
+			return fmt.Errorf("invalid LogLevels[%s]: %s", component, level)
+		}
+	}
+	if p.Offline && p.SnapshotFile == "" {
+		return fmt.Errorf("Offline mode requires SnapshotFile")
+	}
+	if p.Offline && p.Watch && !p.Onetime {
+		return fmt.Errorf("Offline mode does not support Watch")
+	}
+	for name, g := range p.Groups {
+		if err := g.Valid(); err != nil {
+			return fmt.Errorf("invalid Groups[%s]: %v", name, err)
+		}
+		if p.Offline && g.Mode == "watch" && !p.Onetime {
+			return fmt.Errorf("Offline mode does not support Watch of Groups[%s]", name)
+		}
+	}
+	for name, n := range p.Notifiers {
+		if err := n.Valid(); err != nil {
+			return fmt.Errorf("invalid Notifiers[%s]: %v", name, err)
+		}
+	}
+	for name, c := range p.Publishers {
+		if err := c.Valid(); err != nil {
+			return fmt.Errorf("invalid Publishers[%s]: %v", name, err)
+		}
+	}
+	for name, c := range p.MetricsPush {
+		if err := c.Valid(); err != nil {
+			return fmt.Errorf("invalid MetricsPush[%s]: %v", name, err)
+		}
+	}
 
 	return nil
 }
@@ -183,7 +750,83 @@ func (p *Config) Save(name string) error {
 func (p *Config) Clone() *Config {
 	q := *p
 
+	if p.LogHooks != nil {
+		q.LogHooks = append([]LogHook{}, p.LogHooks...)
+	}
+	if p.ConfDirs != nil {
+		q.ConfDirs = append([]string{}, p.ConfDirs...)
+	}
+	if p.RedactKeys != nil {
+		q.RedactKeys = append([]string{}, p.RedactKeys...)
+	}
+	if p.BackendLayers != nil {
+		q.BackendLayers = append([]string{}, p.BackendLayers...)
+	}
+	if p.Shell != nil {
+		q.Shell = append([]string{}, p.Shell...)
+	}
+
 	// clone map
+	if p.LogLevels != nil {
+		q.LogLevels = make(map[string]string)
+		for k, v := range p.LogLevels {
+			q.LogLevels[k] = v
+		}
+	}
+	if p.NamedBackends != nil {
+		q.NamedBackends = make(map[string]BackendClient)
+		for k, v := range p.NamedBackends {
+			q.NamedBackends[k] = v
+		}
+	}
+	if p.Groups != nil {
+		q.Groups = make(map[string]ResourceGroup)
+		for k, v := range p.Groups {
+			q.Groups[k] = v
+		}
+	}
+	if p.HookSets != nil {
+		q.HookSets = make(map[string]HookSet)
+		for k, v := range p.HookSets {
+			q.HookSets[k] = v
+		}
+	}
+	if p.Notifiers != nil {
+		q.Notifiers = make(map[string]NotifierConfig)
+		for k, v := range p.Notifiers {
+			q.Notifiers[k] = v
+		}
+	}
+	if p.NotifierPlugins != nil {
+		q.NotifierPlugins = make(map[string]Notifier)
+		for k, v := range p.NotifierPlugins {
+			q.NotifierPlugins[k] = v
+		}
+	}
+	if p.Publishers != nil {
+		q.Publishers = make(map[string]PublisherConfig)
+		for k, v := range p.Publishers {
+			q.Publishers[k] = v
+		}
+	}
+	if p.PublisherPlugins != nil {
+		q.PublisherPlugins = make(map[string]Publisher)
+		for k, v := range p.PublisherPlugins {
+			q.PublisherPlugins[k] = v
+		}
+	}
+	if p.MetricsPush != nil {
+		q.MetricsPush = make(map[string]MetricsPushConfig)
+		for k, v := range p.MetricsPush {
+			q.MetricsPush[k] = v
+		}
+	}
+	if p.MetricsPusherPlugins != nil {
+		q.MetricsPusherPlugins = make(map[string]MetricsPusher)
+		for k, v := range p.MetricsPusherPlugins {
+			q.MetricsPusherPlugins[k] = v
+		}
+	}
 	if p.FuncMap != nil {
 		q.FuncMap = make(template.FuncMap)
 		for k, v := range p.FuncMap {
@@ -194,6 +837,50 @@ func (p *Config) Clone() *Config {
 	return &q
 }
 
+// sameProcessConfig reports whether p and q process the template
+// resources in the same way, the log settings, hooks, FuncMap,
+// CommandRunner, AESKeyProvider, NotifierPlugins, PublisherPlugins and
+// MetricsPusherPlugins are not compared.
+func (p *Config) sameProcessConfig(q *Config) bool {
+	a, b := p.Clone(), q.Clone()
+	for _, c := range []*Config{a, b} {
+		c.LogLevel, c.LogLevels, c.Verbosity, c.LogRepeatInterval = "", nil, 0, 0
+		c.FuncMap, c.FuncMapUpdater, c.LogHooks = nil, nil, nil
+		c.HookAbsKeyAdjuster, c.HookOnCheckCmdError, c.HookOnReloadCmdError = nil, nil, nil
+		c.HookOnError, c.HookOnCommand, c.HookOnUpdated, c.HookSets = nil, nil, nil, nil
+		c.CommandRunner, c.AESKeyProvider, c.NotifierPlugins = nil, nil, nil
+		c.PublisherPlugins, c.MetricsPusherPlugins = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// GetAESKey returns the AES key of the aes-gcm crypt mode.
+func (p *Config) GetAESKey() ([]byte, error) {
+	if p.AESKeyProvider != nil {
+		key, err := p.AESKeyProvider()
+		if err != nil {
+			return nil, err
+		}
+		return parseAESKey(key)
+	}
+	if p.AESKeyFile == "" {
+		return nil, fmt.Errorf("missing AESKeyFile")
+	}
+	return LoadAESKeyFile(p.AESKeyFile)
+}
+
+// resolveAESKey returns the AES key if the crypt mode is aes-gcm, or nil.
+func (p *Config) resolveAESKey() ([]byte, error) {
+	if p.CryptMode != CryptModeAESGCM {
+		return nil, nil
+	}
+	key, err := p.GetAESKey()
+	if err != nil {
+		return nil, fmt.Errorf("libconfd: load AES key: %v", err)
+	}
+	return key, nil
+}
+
 func (p *Config) GetConfigDir() string {
 	return filepath.Join(p.ConfDir, "conf.d")
 }
@@ -205,3 +892,27 @@ func (p *Config) GetTemplateDir() string {
 func (p *Config) GetDefaultTemplateOutputDir() string {
 	return filepath.Join(p.ConfDir, "templates_output")
 }
+
+// GetConfDirs returns ConfDir and ConfDirs, the later dir overrides
+// the earlier ones.
+func (p *Config) GetConfDirs() []string {
+	dirs := []string{p.ConfDir}
+	for _, dir := range p.ConfDirs {
+		if dir != "" && dir != p.ConfDir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// lookupFile returns the path of name in the subdir of the last confdir
+// which has it, or the path in ConfDir if none has it.
+func (p *Config) lookupFile(subdir, name string) string {
+	dirs := p.GetConfDirs()
+	for i := len(dirs) - 1; i > 0; i-- {
+		if path := filepath.Join(dirs[i], subdir, name); !fileNotExists(path) {
+			return path
+		}
+	}
+	return filepath.Join(p.ConfDir, subdir, name)
+}
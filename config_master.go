@@ -0,0 +1,151 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MasterConfig is a single config file with the Config fields, the
+// backend settings and the PGP key path, in TOML, YAML or JSON format,
+// see LoadConfig.
+//
+// Example:
+//
+//	confdir = "confd"
+//	interval = 10
+//	log-level = "INFO"
+//	pgp-private-key-file = "/etc/miniconfd/secring.gpg"
+//
+//	[backend]
+//	type = "libconfd-backend-etcdv3"
+//	host = ["127.0.0.1:2379"]
+//
+//	[backends.vault]
+//	type = "libconfd-backend-etcdv3"
+//	host = ["10.0.0.2:2379"]
+type MasterConfig struct {
+	Config
+
+	// the backend, empty type means not set
+	Backend BackendConfig `toml:"backend" json:"backend"`
+
+	// the named backends read by getFrom/getsFrom and layered by
+	// backend-layers, see NewNamedBackends
+	Backends map[string]BackendConfig `toml:"backends" json:"backends"`
+
+	// PGP secret keyring file, loaded into Config.PGPPrivateKey
+	PGPPrivateKeyFile string `toml:"pgp-private-key-file" json:"pgp-private-key-file"`
+}
+
+func MustLoadMasterConfig(path string) *MasterConfig {
+	p, err := LoadMasterConfig(path)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return p
+}
+
+func LoadMasterConfig(path string) (p *MasterConfig, err error) {
+	p = new(MasterConfig)
+	if err := decodeConfigFile(path, p); err != nil {
+		return nil, err
+	}
+
+	absdir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(p.ConfDir) {
+		p.ConfDir = filepath.Clean(filepath.Join(absdir, p.ConfDir))
+	}
+	if err := p.absPaths(absdir); err != nil {
+		return nil, err
+	}
+
+	if p.PGPPrivateKeyFile != "" {
+		if !filepath.IsAbs(p.PGPPrivateKeyFile) {
+			p.PGPPrivateKeyFile = filepath.Join(absdir, p.PGPPrivateKeyFile)
+		}
+		key, err := ioutil.ReadFile(p.PGPPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		p.PGPPrivateKey = string(key)
+	}
+
+	return p, nil
+}
+
+// NewNamedBackends creates the clients of Backends, for Config.NamedBackends.
+func (p *MasterConfig) NewNamedBackends() (map[string]BackendClient, error) {
+	if len(p.Backends) == 0 {
+		return nil, nil
+	}
+	clients := make(map[string]BackendClient)
+	for name, cfg := range p.Backends {
+		cfg := cfg
+		client, err := NewBackendClient(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %v", name, err)
+		}
+		clients[name] = client
+	}
+	return clients, nil
+}
+
+// decodeYAML decodes YAML into v by the json tags.
+func decodeYAML(data []byte, v interface{}) error {
+	var m interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	m, err := yamlToJSONValue(m)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, v)
+}
+
+// yamlToJSONValue converts the map[interface{}]interface{} of yaml to
+// map[string]interface{}.
+func yamlToJSONValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("libconfd: invalid yaml key: %v", k)
+			}
+			x, err := yamlToJSONValue(x)
+			if err != nil {
+				return nil, err
+			}
+			m[s] = x
+		}
+		return m, nil
+	case []interface{}:
+		for i := range v {
+			x, err := yamlToJSONValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = x
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMasterConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-master-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "secring.gpg"), []byte("key"), 0600) == nil)
+
+	files := map[string]string{
+		"miniconfd.toml": `
+confdir = "confd"
+interval = 30
+log-level = "INFO"
+pgp-private-key-file = "secring.gpg"
+
+[backend]
+type = "libconfd-backend-etcdv3"
+host = ["127.0.0.1:2379"]
+`,
+		"miniconfd.yaml": `
+confdir: confd
+interval: 30
+log-level: INFO
+pgp-private-key-file: secring.gpg
+backend:
+  type: libconfd-backend-etcdv3
+  host:
+    - 127.0.0.1:2379
+`,
+		"miniconfd.json": `{
+	"confdir": "confd",
+	"interval": ${LIBCONFD_TEST_INTERVAL:-30},
+	"log-level": "${LIBCONFD_TEST_LOG_LEVEL}",
+	"pgp-private-key-file": "secring.gpg",
+	"backend": {
+		"type": "libconfd-backend-etcdv3",
+		"host": ["127.0.0.1:2379"]
+	}
+}`,
+	}
+
+	os.Setenv("LIBCONFD_TEST_LOG_LEVEL", "INFO")
+	defer os.Unsetenv("LIBCONFD_TEST_LOG_LEVEL")
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		tAssert(t, ioutil.WriteFile(path, []byte(content), 0644) == nil)
+
+		p, err := LoadMasterConfig(path)
+		tAssert(t, err == nil, name, err)
+		tAssert(t, p.ConfDir == filepath.Join(dir, "confd"), name, p.ConfDir)
+		tAssert(t, p.Interval == 30, name, p.Interval)
+		tAssert(t, p.LogLevel == "INFO", name, p.LogLevel)
+		tAssert(t, p.PGPPrivateKey == "key", name, p.PGPPrivateKey)
+		tAssert(t, p.Backend.Type == "libconfd-backend-etcdv3", name, p.Backend)
+		tAssert(t, len(p.Backend.Host) == 1 && p.Backend.Host[0] == "127.0.0.1:2379", name, p.Backend)
+	}
+}
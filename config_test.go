@@ -5,6 +5,9 @@
 package libconfd
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -25,3 +28,82 @@ func TestConfig(t *testing.T) {
 		t.Fatalf("expect = %#v, got = %#v", tConfig, p)
 	}
 }
+
+func TestConfigConfDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-confdirs-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base/conf.d/a.toml":    "[template]\nsrc = \"a.tmpl\"\ndest = \"a\"\n",
+		"base/conf.d/b.toml":    "[template]\nsrc = \"b.tmpl\"\ndest = \"b\"\n",
+		"base/templates/a.tmpl": "a",
+		"base/templates/b.tmpl": "b",
+		"prod/conf.d/b.toml":    "[template]\nsrc = \"b.tmpl\"\ndest = \"b-prod\"\n",
+		"prod/conf.d/c.toml":    "[template]\nsrc = \"a.tmpl\"\ndest = \"c\"\n",
+		"prod/templates/b.tmpl": "b-prod",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		tAssert(t, os.MkdirAll(filepath.Dir(path), 0755) == nil)
+		tAssert(t, ioutil.WriteFile(path, []byte(content), 0644) == nil)
+	}
+
+	base, prod := filepath.Join(dir, "base"), filepath.Join(dir, "prod")
+	cfg := &Config{ConfDir: base, ConfDirs: []string{prod}, LogLevel: "INFO"}
+	tAssert(t, cfg.Valid() == nil, cfg.Valid())
+	tAssert(t, reflect.DeepEqual(cfg.GetConfDirs(), []string{base, prod}), cfg.GetConfDirs())
+
+	tAssert(t, cfg.lookupFile("templates", "a.tmpl") == filepath.Join(base, "templates", "a.tmpl"))
+	tAssert(t, cfg.lookupFile("templates", "b.tmpl") == filepath.Join(prod, "templates", "b.tmpl"))
+	tAssert(t, cfg.lookupFile("templates", "x.tmpl") == filepath.Join(base, "templates", "x.tmpl"))
+
+	tcs, paths, err := ListLayeredTemplateResource(cfg.GetConfDirs()...)
+	tAssert(t, err == nil, err)
+	tAssert(t, reflect.DeepEqual(paths, []string{
+		filepath.Join(base, "conf.d", "a.toml"),
+		filepath.Join(prod, "conf.d", "b.toml"),
+		filepath.Join(prod, "conf.d", "c.toml"),
+	}), paths)
+	tAssert(t, tcs[1].Dest == "b-prod", tcs[1].Dest)
+}
+
+func TestConfigSameProcessConfig(t *testing.T) {
+	p := &Config{ConfDir: "/etc/confd", LogLevel: "INFO", Interval: 10}
+
+	q := p.Clone()
+	q.LogLevel = "DEBUG"
+	q.HookOnError = func(trName string, err error) {}
+	tAssert(t, p.sameProcessConfig(q))
+
+	q.Interval = 20
+	tAssert(t, !p.sameProcessConfig(q))
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-load-config-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("LIBCONFD_TEST_CONFDIR", "confd-prod")
+	defer os.Unsetenv("LIBCONFD_TEST_CONFDIR")
+
+	for name, content := range map[string]string{
+		"confd.toml": "confdir = \"${LIBCONFD_TEST_CONFDIR}\"\ninterval = 30\n",
+		"confd.yml":  "confdir: ${LIBCONFD_TEST_CONFDIR}\ninterval: 30\n",
+		"confd.json": `{"confdir": "${LIBCONFD_TEST_CONFDIR}", "interval": 30}`,
+	} {
+		path := filepath.Join(dir, name)
+		tAssert(t, ioutil.WriteFile(path, []byte(content), 0644) == nil)
+
+		p, err := LoadConfig(path)
+		tAssert(t, err == nil, name, err)
+		tAssert(t, p.ConfDir == filepath.Join(dir, "confd-prod"), name, p.ConfDir)
+		tAssert(t, p.Interval == 30, name, p.Interval)
+	}
+
+	path := filepath.Join(dir, "broken.json")
+	tAssert(t, ioutil.WriteFile(path, []byte(`{"interval": "30"}`), 0644) == nil)
+	_, err = LoadConfig(path)
+	tAssert(t, err != nil, err)
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// DefaultInterval is the backend polling interval in seconds set by
+// SetDefaults.
+const DefaultInterval = 10
+
+// DefaultLogLevel is the log level set by SetDefaults.
+const DefaultLogLevel = "INFO"
+
+// ConfigError is the error of Config.Validate, it holds all the problems
+// found in the config.
+type ConfigError struct {
+	Errs []error
+}
+
+func (p *ConfigError) Error() string {
+	msgs := make([]string, len(p.Errs))
+	for i, err := range p.Errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// SetDefaults sets the zero fields of the config to their defaults: the
+// confdir is "confd" in the working dir, the interval is DefaultInterval
+// and so on. The fields defaulted at use, such as DNSTimeout, are set to
+// the values in use.
+func (p *Config) SetDefaults() {
+	if p.ConfDir == "" {
+		p.ConfDir = "confd"
+	}
+	if !filepath.IsAbs(p.ConfDir) {
+		if absdir, err := filepath.Abs(p.ConfDir); err == nil {
+			p.ConfDir = absdir
+		}
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultInterval
+	}
+	if p.LogLevel == "" {
+		p.LogLevel = DefaultLogLevel
+	}
+	if p.CryptMode == "" {
+		p.CryptMode = CryptModePGP
+	}
+	if p.FuncProfile == "" {
+		p.FuncProfile = FuncProfileDefault
+	}
+	if p.MaxCommandOutput == 0 {
+		p.MaxCommandOutput = DefaultMaxCommandOutput
+	}
+	if p.DNSTimeout == 0 {
+		p.DNSTimeout = int(DefaultDNSTimeout.Seconds())
+	}
+	if p.StopGracePeriod == 0 {
+		p.StopGracePeriod = int(DefaultStopGracePeriod.Seconds())
+	}
+	if p.WatchMaxRestarts == 0 {
+		p.WatchMaxRestarts = DefaultWatchMaxRestarts
+	}
+}
+
+// Validate checks the config up front, so the mistakes are reported
+// before running instead of failing mid-run. Besides the checks of Valid,
+// the conf.d and templates dirs must exist, Onetime and Watch must not
+// be both set, the polling interval must be positive in interval mode,
+// and the PGP or AES key must be usable.
+// It returns a *ConfigError holding all the problems found.
+func (p *Config) Validate() error {
+	var errs []error
+
+	if err := p.Valid(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if filepath.IsAbs(p.ConfDir) && dirExists(p.ConfDir) {
+		hasConfigDir, hasTemplateDir := p.ConfFS != nil, p.TemplateFS != nil
+		for _, dir := range p.GetConfDirs() {
+			hasConfigDir = hasConfigDir || dirExists(filepath.Join(dir, "conf.d"))
+			hasTemplateDir = hasTemplateDir || dirExists(filepath.Join(dir, "templates"))
+		}
+		if !hasConfigDir {
+			errs = append(errs, fmt.Errorf("conf.d dir not exists: %s", p.GetConfigDir()))
+		}
+		if !hasTemplateDir {
+			errs = append(errs, fmt.Errorf("templates dir not exists: %s", p.GetTemplateDir()))
+		}
+	}
+
+	if p.Onetime && p.Watch {
+		errs = append(errs, fmt.Errorf("Onetime and Watch are mutually exclusive"))
+	}
+	if !p.Onetime && !p.Watch && p.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("Interval must be positive in interval mode, got %d", p.Interval))
+	}
+
+	if len(p.PGPPrivateKey) > 0 {
+		if err := checkPGPPrivateKey([]byte(p.PGPPrivateKey)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid PGPPrivateKey: %v", err))
+		}
+	}
+	if p.CryptMode == CryptModeAESGCM && p.AESKeyProvider == nil && p.AESKeyFile != "" {
+		if _, err := LoadAESKeyFile(p.AESKeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid AESKeyFile: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ConfigError{Errs: errs}
+	}
+	return nil
+}
+
+// checkPGPPrivateKey checks the armored keyring has a private key.
+func checkPGPPrivateKey(keyring []byte) error {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+	if err != nil {
+		return err
+	}
+	for _, e := range entities {
+		if e.PrivateKey != nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no private key in keyring")
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-validate-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{ConfDir: dir}
+	cfg.SetDefaults()
+	tAssert(t, cfg.Interval == DefaultInterval && cfg.LogLevel == DefaultLogLevel, cfg.Interval, cfg.LogLevel)
+	tAssert(t, cfg.DNSTimeout == 5 && cfg.StopGracePeriod == 30, cfg.DNSTimeout, cfg.StopGracePeriod)
+	tAssert(t, cfg.Valid() == nil)
+
+	// the conf.d and templates dirs are missing
+	err = cfg.Validate()
+	tAssert(t, err != nil, err)
+	tAssert(t, len(err.(*ConfigError).Errs) == 2, err)
+	tAssert(t, strings.Contains(err.Error(), "conf.d dir not exists"), err)
+
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "conf.d"), 0755) == nil)
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "templates"), 0755) == nil)
+	tAssert(t, cfg.Validate() == nil)
+
+	cfg.Onetime, cfg.Watch = true, true
+	err = cfg.Validate()
+	tAssert(t, err != nil && strings.Contains(err.Error(), "mutually exclusive"), err)
+
+	cfg.Onetime, cfg.Watch, cfg.Interval = false, false, 0
+	err = cfg.Validate()
+	tAssert(t, err != nil && strings.Contains(err.Error(), "Interval must be positive"), err)
+	cfg.Interval = DefaultInterval
+
+	cfg.PGPPrivateKey = "not a key"
+	err = cfg.Validate()
+	tAssert(t, err != nil && strings.Contains(err.Error(), "invalid PGPPrivateKey"), err)
+
+	cfg.PGPPrivateKey = tSecconf_pubring
+	err = cfg.Validate()
+	tAssert(t, err != nil && strings.Contains(err.Error(), "no private key"), err)
+
+	cfg.PGPPrivateKey = tSecconf_secring
+	tAssert(t, cfg.Validate() == nil)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ErrBackendUnavailable is matched by the errors of the backend calls,
+// such as GetValues and WatchPrefix, check it by errors.Is.
+var ErrBackendUnavailable = errors.New("libconfd: backend unavailable")
+
+// ErrCheckFailed is the error of a failed check_cmd, the target config
+// file is not replaced.
+type ErrCheckFailed struct {
+	Resource string // the name of the template resource
+	Output   string // the stdout and stderr of the command
+	ExitCode int    // -1 if the command did not run
+	Err      error
+}
+
+func (p *ErrCheckFailed) Error() string {
+	return fmt.Sprintf("Config check failed: %v", p.Err)
+}
+
+func (p *ErrCheckFailed) Unwrap() error {
+	return p.Err
+}
+
+// ErrTemplateExec is the error of executing the template of a resource.
+type ErrTemplateExec struct {
+	Resource string // the name of the template resource
+	Line     int    // the line of the template, 0 if unknown
+	Err      error
+}
+
+func (p *ErrTemplateExec) Error() string {
+	return p.Err.Error()
+}
+
+func (p *ErrTemplateExec) Unwrap() error {
+	return p.Err
+}
+
+// ErrStaleValues is the error of the values older than max_staleness of
+// the resource, the target config file is not replaced.
+type ErrStaleValues struct {
+	Resource     string    // the name of the template resource
+	Time         time.Time // the time of the values, see BackendSnapshotClient
+	Age          time.Duration
+	MaxStaleness time.Duration
+}
+
+func (p *ErrStaleValues) Error() string {
+	return fmt.Sprintf("libconfd: values of %s are %v old, max_staleness is %v",
+		p.Resource, p.Age.Round(time.Second), p.MaxStaleness,
+	)
+}
+
+// ErrUnknownBackend is the error of creating the client of a backend type
+// not registered, see RegisterBackendClient.
+type ErrUnknownBackend struct {
+	Type       string
+	Registered []string // the sorted registered types
+}
+
+func (p *ErrUnknownBackend) Error() string {
+	return fmt.Sprintf("libconfd: unknown backend type %q, registered: %s",
+		p.Type, strings.Join(p.Registered, ", "),
+	)
+}
+
+// PartialError is returned with the values by GetValues of multiple keys,
+// if some of the keys failed, such as by the cycle shared fetches or the
+// composite backends. The template resources setting allow_partial render
+// with the values of the other keys, and are flagged degraded.
+type PartialError struct {
+	Failed map[string]error // the errors of the failed keys
+}
+
+func (p *PartialError) Error() string {
+	var msgs []string
+	for _, key := range p.Keys() {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", key, p.Failed[key]))
+	}
+	return "partial values, failed keys: " + strings.Join(msgs, "; ")
+}
+
+// Keys returns the sorted failed keys.
+func (p *PartialError) Keys() []string {
+	keys := make([]string, 0, len(p.Failed))
+	for k := range p.Failed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (p *PartialError) Is(target error) bool {
+	return target == ErrBackendUnavailable
+}
+
+// backendCallError is an error of a backend call, it keeps the message
+// of err, and matches ErrBackendUnavailable by errors.Is.
+type backendCallError struct {
+	err error
+}
+
+func (p *backendCallError) Error() string {
+	return p.err.Error()
+}
+
+func (p *backendCallError) Unwrap() error {
+	return p.err
+}
+
+func (p *backendCallError) Is(target error) bool {
+	return target == ErrBackendUnavailable
+}
+
+// backendError wraps err of a backend call with ErrBackendUnavailable.
+func backendError(err error) error {
+	if err == nil || err == errProcessorStopped || errors.Is(err, ErrBackendUnavailable) {
+		return err
+	}
+	return &backendCallError{err: err}
+}
+
+// checkFailedError returns the *ErrCheckFailed of err of the check_cmd.
+func checkFailedError(resource string, err error) error {
+	e := &ErrCheckFailed{Resource: resource, ExitCode: -1, Err: err}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && cmdErr.CommandResult != nil {
+		e.Output = cmdErr.Stdout + cmdErr.Stderr
+		e.ExitCode = cmdErr.ExitCode
+	}
+	return e
+}
+
+// the location in the message of template.ExecError, "template: name:line:col: ..."
+var reTemplateExecLine = regexp.MustCompile(`^template: [^:]*:(\d+):`)
+
+// templateExecError returns the *ErrTemplateExec of err of the template
+// execution, the other errors such as the write errors are returned as is.
+func templateExecError(resource string, err error) error {
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return err
+	}
+	e := &ErrTemplateExec{Resource: resource, Err: err}
+	if m := reTemplateExecLine.FindStringSubmatch(execErr.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+	}
+	return e
+}
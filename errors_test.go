@@ -0,0 +1,64 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-errors-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte("# app\nport={{getv \"/port\"}}\nhost={{getv \"/host\"}}\n"), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	cfg.SyncOnly = false
+	cfg.CommandRunner = CommandRunnerFunc(func(cmd string, limit int) (*CommandResult, error) {
+		result := &CommandResult{Cmd: cmd, Stderr: "bad port", ExitCode: 3}
+		return result, &CommandError{CommandResult: result, Err: errors.New("exit status 3")}
+	})
+
+	process := func(client BackendClient) error {
+		p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+			Src:      src,
+			Dest:     dest,
+			Prefix:   "/app",
+			Keys:     []string{"/"},
+			CheckCmd: "check {{.src}}",
+		})
+		tAssert(t, err == nil, err)
+		return p.Process(&Call{Config: cfg, Client: client})
+	}
+
+	// the backend call failed
+	err = process(&tFlakyClient{})
+	tAssert(t, errors.Is(err, ErrBackendUnavailable), err)
+
+	// the template failed at line 3
+	var execErr *ErrTemplateExec
+	err = process(mapBackendClient{"/app/port": "8080"})
+	tAssert(t, errors.As(err, &execErr), err)
+	tAssert(t, execErr.Resource == "app.toml" && execErr.Line == 3, execErr)
+	tAssert(t, !errors.Is(err, ErrBackendUnavailable), err)
+
+	// the check command failed
+	var checkErr *ErrCheckFailed
+	var cmdErr *CommandError
+	err = process(mapBackendClient{"/app/port": "8080", "/app/host": "a"})
+	tAssert(t, errors.As(err, &checkErr), err)
+	tAssert(t, checkErr.ExitCode == 3 && checkErr.Output == "bad port", checkErr)
+	tAssert(t, errors.As(err, &cmdErr) && cmdErr.ExitCode == 3, err)
+	tAssert(t, fileNotExists(dest))
+}
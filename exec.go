@@ -0,0 +1,241 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultKillTimeout is the time to wait for the child of ExecMode to
+// exit after KillSignal, before it is killed.
+const DefaultKillTimeout = 30 * time.Second
+
+// wait for the updates of the other resources of the same cycle
+const execChangeDelay = time.Second / 2
+
+// ExecConfig is the child process of ExecMode.
+type ExecConfig struct {
+	Command string
+	Args    []string
+	Env     []string // default is the env of the current process
+
+	Stdin  io.Reader // default is os.Stdin
+	Stdout io.Writer // default is os.Stdout
+	Stderr io.Writer // default is os.Stderr
+
+	// sent to the child after the target config files updated
+	ReloadSignal os.Signal
+
+	// restart the child after the target config files updated,
+	// instead of sending ReloadSignal
+	Restart bool
+
+	// sent to the child to stop it, default is SIGTERM,
+	// the child is killed if the signal is not supported (windows)
+	KillSignal os.Signal
+
+	// time to wait for the child to exit after KillSignal, before it is
+	// killed, default is DefaultKillTimeout
+	KillTimeout time.Duration
+}
+
+// ExecMode couples the Processor with a child process: the template
+// resources are rendered once, then the child is started. Unless
+// Config.Onetime is set, the resources are re-rendered in watch or
+// interval mode, and the child is signaled or restarted after the target
+// config files updated.
+type ExecMode struct {
+	cfg    *Config
+	client BackendClient
+	exec   ExecConfig
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	exitChan chan error
+
+	changeChan chan bool
+	stopChan   chan bool
+	stopOnce   sync.Once
+}
+
+// NewExecMode creates an ExecMode of the config, client and child.
+func NewExecMode(cfg *Config, client BackendClient, child ExecConfig) *ExecMode {
+	if child.KillSignal == nil {
+		child.KillSignal = syscall.SIGTERM
+	}
+	if child.KillTimeout <= 0 {
+		child.KillTimeout = DefaultKillTimeout
+	}
+	return &ExecMode{
+		cfg:        cfg.Clone(),
+		client:     client,
+		exec:       child,
+		changeChan: make(chan bool, 1),
+		stopChan:   make(chan bool),
+	}
+}
+
+// Run renders the template resources, starts the child and keeps it
+// updated until the child exits or Stop is called.
+// It returns the exit code of the child, or -1 with the error if the
+// child did not start.
+func (p *ExecMode) Run(opts ...Options) (exitCode int, err error) {
+	service := NewProcessor()
+	defer service.Close()
+
+	onceOpts := append(append([]Options{}, opts...), WithOnetimeMode())
+	if err := service.Run(p.cfg, p.client, onceOpts...); err != nil {
+		return -1, err
+	}
+
+	if err := p.start(); err != nil {
+		return -1, err
+	}
+
+	cfg := p.cfg.Clone().applyOptions(opts...)
+	if !cfg.Onetime {
+		hook := cfg.HookOnUpdated
+		service.Go(cfg, p.client, WithHookOnUpdated(func(trName, dest string) {
+			if hook != nil {
+				hook(trName, dest)
+			}
+			select {
+			case p.changeChan <- true:
+			default:
+			}
+		}))
+	}
+
+	for {
+		select {
+		case err := <-p.exitChannel():
+			return exitCodeOf(err)
+
+		case <-p.stopChan:
+			return exitCodeOf(p.stop())
+
+		case <-p.changeChan:
+			select {
+			case <-time.After(execChangeDelay):
+			case <-p.stopChan:
+				return exitCodeOf(p.stop())
+			}
+			// drop the changes of the same cycle
+			select {
+			case <-p.changeChan:
+			default:
+			}
+
+			if err := p.onChange(); err != nil {
+				return -1, err
+			}
+		}
+	}
+}
+
+// Signal sends sig to the child.
+func (p *ExecMode) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd == nil || p.cmd.Process == nil {
+		return errors.New("libconfd: child not started")
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+// Stop stops the child with KillSignal and KillTimeout, and makes Run
+// return.
+func (p *ExecMode) Stop() {
+	p.stopOnce.Do(func() { close(p.stopChan) })
+}
+
+func (p *ExecMode) onChange() error {
+	if p.exec.Restart {
+		logger.Info("restart child, target config updated")
+		if err := p.stop(); err != nil {
+			logger.Info("child exited: ", err)
+		}
+		return p.start()
+	}
+	if p.exec.ReloadSignal != nil {
+		logger.Infof("send %v to child, target config updated", p.exec.ReloadSignal)
+		return p.Signal(p.exec.ReloadSignal)
+	}
+	return nil
+}
+
+func (p *ExecMode) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cmd := exec.Command(p.exec.Command, p.exec.Args...)
+	cmd.Env = p.exec.Env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = p.exec.Stdin, p.exec.Stdout, p.exec.Stderr
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exitChan := make(chan error, 1)
+	go func() { exitChan <- cmd.Wait() }()
+
+	p.cmd, p.exitChan = cmd, exitChan
+	return nil
+}
+
+func (p *ExecMode) exitChannel() chan error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitChan
+}
+
+// stop sends KillSignal to the child, and kills it after KillTimeout.
+// It returns the error of the child exit.
+func (p *ExecMode) stop() error {
+	p.mu.Lock()
+	cmd, exitChan := p.cmd, p.exitChan
+	p.mu.Unlock()
+
+	if err := cmd.Process.Signal(p.exec.KillSignal); err != nil {
+		cmd.Process.Kill()
+	}
+
+	select {
+	case err := <-exitChan:
+		return err
+	case <-time.After(p.exec.KillTimeout):
+		logger.Warningf("child not exited in %v, kill it", p.exec.KillTimeout)
+		cmd.Process.Kill()
+		return <-exitChan
+	}
+}
+
+// exitCodeOf returns the exit code of the child exit error.
+func exitCodeOf(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), nil
+		}
+	}
+	return -1, err
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package libconfd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExecMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-exec-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR"}
+	client := mapBackendClient{}
+
+	var stdout bytes.Buffer
+	code, err := NewExecMode(cfg, client, ExecConfig{
+		Command: "/bin/sh",
+		Args:    []string{"-c", "echo hello; exit 3"},
+		Stdout:  &stdout,
+	}).Run(WithOnetimeMode())
+	tAssert(t, err == nil, err)
+	tAssert(t, code == 3, code)
+	tAssert(t, stdout.String() == "hello\n", stdout.String())
+
+	_, err = NewExecMode(cfg, client, ExecConfig{
+		Command: "/libconfd/not/exists",
+	}).Run(WithOnetimeMode())
+	tAssert(t, err != nil)
+
+	// the child ignoring SIGTERM is killed after KillTimeout
+	child := NewExecMode(cfg, client, ExecConfig{
+		Command:     "/bin/sh",
+		Args:        []string{"-c", "trap '' TERM; exec sleep 10"},
+		KillTimeout: time.Second / 2,
+	})
+	go func() {
+		time.Sleep(time.Second / 2)
+		child.Stop()
+	}()
+
+	start := time.Now()
+	code, err = child.Run(WithOnetimeMode())
+	tAssert(t, err == nil, err)
+	tAssert(t, code == -1, code)
+	tAssert(t, time.Since(start) < 5*time.Second, time.Since(start))
+}
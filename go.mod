@@ -7,6 +7,14 @@ module "openpitrix.io/libconfd"
 require (
 	"github.com/BurntSushi/toml" v0.3.0
 	"github.com/coreos/etcd/clientv3" v3.3.0
+	"github.com/fsnotify/fsnotify" v1.4.7
+	"github.com/pkg/sftp" v1.13.5
+	"github.com/sirupsen/logrus" v1.2.0
 	"github.com/urfave/cli" v1.20.0
+	"go.etcd.io/bbolt" v1.3.5
+	"go.uber.org/zap" v1.9.1
 	"golang.org/x/crypto" v0.0.0-20180219163459-432090b8f568
+	"google.golang.org/grpc" v1.64.0
+	"google.golang.org/protobuf" v1.34.2
+	"gopkg.in/yaml.v2" v2.2.1
 )
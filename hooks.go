@@ -0,0 +1,74 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+)
+
+// HookSet is a named set of hooks registered by WithHookSet, the template
+// resources naming it by hooks = "name" use it instead of the hooks of
+// Config, so different resources can route their errors and notifications
+// to different handlers. The nil hooks of the set fall back to Config.
+//
+// Example:
+//
+//	service.Run(cfg, client, libconfd.WithHookSet("nginx", libconfd.HookSet{
+//		OnError: func(trName string, err error) { alertNginxTeam(trName, err) },
+//	}))
+//
+// and in nginx.toml:
+//
+//	[template]
+//	hooks = "nginx"
+type HookSet struct {
+	OnCheckCmdError  func(trName, cmd string, err error)
+	OnReloadCmdError func(trName, cmd string, err error)
+	OnError          func(trName string, err error)
+	OnCommand        func(trName string, result *CommandResult)
+	OnUpdated        func(trName, dest string)
+}
+
+// getHookSet returns the hooks of the template resource, the hooks of the
+// named set override the hooks of Config.
+func (p *TemplateResourceProcessor) getHookSet(call *Call) (*HookSet, error) {
+	hooks := &HookSet{
+		OnCheckCmdError:  call.Config.HookOnCheckCmdError,
+		OnReloadCmdError: call.Config.HookOnReloadCmdError,
+		OnError:          call.Config.HookOnError,
+		OnCommand:        call.Config.HookOnCommand,
+		OnUpdated:        call.Config.HookOnUpdated,
+	}
+	if p.Hooks == "" {
+		return hooks, nil
+	}
+
+	set, ok := call.Config.HookSets[p.Hooks]
+	if !ok {
+		return hooks, fmt.Errorf("hook set %q not registered", p.Hooks)
+	}
+	if set.OnCheckCmdError != nil {
+		hooks.OnCheckCmdError = set.OnCheckCmdError
+	}
+	if set.OnReloadCmdError != nil {
+		hooks.OnReloadCmdError = set.OnReloadCmdError
+	}
+	if set.OnError != nil {
+		hooks.OnError = set.OnError
+	}
+	if set.OnCommand != nil {
+		hooks.OnCommand = set.OnCommand
+	}
+	if set.OnUpdated != nil {
+		hooks.OnUpdated = set.OnUpdated
+	}
+	return hooks, nil
+}
+
+// hookSet is like getHookSet, the unregistered set is reported by Process.
+func (p *TemplateResourceProcessor) hookSet(call *Call) *HookSet {
+	hooks, _ := p.getHookSet(call)
+	return hooks
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHookSet(t *testing.T) {
+	var global, nginx []string
+
+	cfg := newDefaultConfig().applyOptions(
+		WithHookOnError(func(trName string, err error) {
+			global = append(global, "error:"+trName)
+		}),
+		WithHookOnUpdated(func(trName, dest string) {
+			global = append(global, "updated:"+trName)
+		}),
+		WithHookSet("nginx", HookSet{
+			OnError: func(trName string, err error) {
+				nginx = append(nginx, "error:"+trName)
+			},
+		}),
+	)
+	call := &Call{Config: cfg}
+
+	p := &TemplateResourceProcessor{path: "app.toml"}
+	hooks, err := p.getHookSet(call)
+	tAssert(t, err == nil, err)
+	hooks.OnError(p.path, errors.New("failed"))
+	hooks.OnUpdated(p.path, "")
+	tAssert(t, strings.Join(global, ",") == "error:app.toml,updated:app.toml", global)
+
+	// the nil hooks of the set fall back to config
+	global = nil
+	p = &TemplateResourceProcessor{path: "nginx.toml"}
+	p.Hooks = "nginx"
+	hooks, err = p.getHookSet(call)
+	tAssert(t, err == nil, err)
+	hooks.OnError(p.path, errors.New("failed"))
+	hooks.OnUpdated(p.path, "")
+	tAssert(t, strings.Join(nginx, ",") == "error:nginx.toml", nginx)
+	tAssert(t, strings.Join(global, ",") == "updated:nginx.toml", global)
+
+	p.Hooks = "redis"
+	_, err = p.getHookSet(call)
+	tAssert(t, err != nil && strings.Contains(err.Error(), `"redis"`), err)
+	tAssert(t, p.hookSet(call).OnError != nil)
+}
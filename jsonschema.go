@@ -0,0 +1,256 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the subset of JSON Schema used to check the JSON values:
+// type, enum, required, properties, additionalProperties, items,
+// minItems/maxItems, minimum/maximum, minLength/maxLength and pattern.
+type jsonSchema struct {
+	Type                 interface{}            `json:"type"` // string or []string
+	Enum                 []interface{}          `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"` // bool or schema
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+
+	additional   *jsonSchema
+	noAdditional bool
+	re           *regexp.Regexp
+}
+
+// loadJSONSchemaFile loads the JSON Schema file.
+func loadJSONSchemaFile(path string) (*jsonSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := parseJSONSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return schema, nil
+}
+
+func parseJSONSchema(data []byte) (*jsonSchema, error) {
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.init(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// init checks the schema and prepares the additionalProperties and pattern.
+func (p *jsonSchema) init() error {
+	for _, t := range p.types() {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("invalid type %q", t)
+		}
+	}
+
+	if s := strings.TrimSpace(string(p.AdditionalProperties)); s != "" {
+		switch s {
+		case "true":
+		case "false":
+			p.noAdditional = true
+		default:
+			additional, err := parseJSONSchema(p.AdditionalProperties)
+			if err != nil {
+				return err
+			}
+			p.additional = additional
+		}
+	}
+	if p.Pattern != "" {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return err
+		}
+		p.re = re
+	}
+
+	for _, v := range p.Properties {
+		if err := v.init(); err != nil {
+			return err
+		}
+	}
+	if p.Items != nil {
+		if err := p.Items.init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *jsonSchema) types() []string {
+	switch t := p.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var ss []string
+		for _, v := range t {
+			ss = append(ss, fmt.Sprint(v))
+		}
+		return ss
+	}
+	return nil
+}
+
+// ValidateJSON validates the JSON document data.
+func (p *jsonSchema) ValidateJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return p.validate("", v)
+}
+
+func (p *jsonSchema) validate(where string, v interface{}) error {
+	if where == "" {
+		where = "/"
+	}
+
+	if types := p.types(); len(types) > 0 {
+		if t := jsonTypeOf(v); !jsonTypeMatch(t, v, types) {
+			return fmt.Errorf("%s: must be %s, got %s", where, strings.Join(types, "/"), t)
+		}
+	}
+
+	if len(p.Enum) > 0 {
+		var found bool
+		for _, x := range p.Enum {
+			if reflect.DeepEqual(x, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not in enum", where)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range p.Required {
+			if _, ok := v[k]; !ok {
+				return fmt.Errorf("%s: missing required property %q", where, k)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub := strings.TrimSuffix(where, "/") + "/" + k
+			if s, ok := p.Properties[k]; ok {
+				if err := s.validate(sub, v[k]); err != nil {
+					return err
+				}
+				continue
+			}
+			if p.noAdditional {
+				return fmt.Errorf("%s: additional property is not allowed", sub)
+			}
+			if p.additional != nil {
+				if err := p.additional.validate(sub, v[k]); err != nil {
+					return err
+				}
+			}
+		}
+
+	case []interface{}:
+		if p.MinItems != nil && len(v) < *p.MinItems {
+			return fmt.Errorf("%s: must have at least %d items", where, *p.MinItems)
+		}
+		if p.MaxItems != nil && len(v) > *p.MaxItems {
+			return fmt.Errorf("%s: must have at most %d items", where, *p.MaxItems)
+		}
+		if p.Items != nil {
+			for i, x := range v {
+				sub := fmt.Sprintf("%s/%d", strings.TrimSuffix(where, "/"), i)
+				if err := p.Items.validate(sub, x); err != nil {
+					return err
+				}
+			}
+		}
+
+	case float64:
+		if p.Minimum != nil && v < *p.Minimum {
+			return fmt.Errorf("%s: must be >= %v, got %v", where, *p.Minimum, v)
+		}
+		if p.Maximum != nil && v > *p.Maximum {
+			return fmt.Errorf("%s: must be <= %v, got %v", where, *p.Maximum, v)
+		}
+
+	case string:
+		n := len([]rune(v))
+		if p.MinLength != nil && n < *p.MinLength {
+			return fmt.Errorf("%s: length must be >= %d", where, *p.MinLength)
+		}
+		if p.MaxLength != nil && n > *p.MaxLength {
+			return fmt.Errorf("%s: length must be <= %d", where, *p.MaxLength)
+		}
+		if p.re != nil && !p.re.MatchString(v) {
+			return fmt.Errorf("%s: must match %s", where, p.Pattern)
+		}
+	}
+
+	return nil
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func jsonTypeMatch(t string, v interface{}, types []string) bool {
+	for _, x := range types {
+		if x == t {
+			return true
+		}
+		if x == "integer" && t == "number" {
+			if f := v.(float64); f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
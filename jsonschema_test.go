@@ -0,0 +1,74 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema, err := parseJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["host", "port"],
+		"additionalProperties": false,
+		"properties": {
+			"host": {"type": "string", "pattern": "^[a-z.]+$"},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"mode": {"enum": ["dev", "prod"]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}}
+		}
+	}`))
+	tAssert(t, err == nil, err)
+
+	for i, v := range []struct {
+		doc string
+		err string
+	}{
+		{`{"host": "a.b", "port": 80}`, ""},
+		{`{"host": "a.b", "port": 80, "mode": "prod", "tags": ["x"]}`, ""},
+		{`{"host": "a.b"}`, `missing required property "port"`},
+		{`{"host": "a.b", "port": 8.5}`, "/port: must be integer"},
+		{`{"host": "a.b", "port": 0}`, "/port: must be >= 1"},
+		{`{"host": "A.B", "port": 80}`, "/host: must match"},
+		{`{"host": "a.b", "port": 80, "mode": "test"}`, "/mode: value is not in enum"},
+		{`{"host": "a.b", "port": 80, "tags": ["x", ""]}`, "/tags/1: length must be >= 1"},
+		{`{"host": "a.b", "port": 80, "tags": ["x", "y", "z"]}`, "/tags: must have at most 2 items"},
+		{`{"host": "a.b", "port": 80, "user": "root"}`, "/user: additional property is not allowed"},
+		{`[]`, "/: must be object, got array"},
+		{`{`, "unexpected end"},
+	} {
+		err := schema.ValidateJSON([]byte(v.doc))
+		if v.err == "" {
+			tAssert(t, err == nil, i, err)
+			continue
+		}
+		tAssert(t, err != nil && strings.Contains(err.Error(), v.err), i, err)
+	}
+
+	_, err = parseJSONSchema([]byte(`{"type": "int"}`))
+	tAssert(t, err != nil)
+	_, err = parseJSONSchema([]byte(`{"pattern": "("}`))
+	tAssert(t, err != nil)
+}
+
+func TestKeyRuleSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-schema-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "port.json")
+	err = ioutil.WriteFile(path, []byte(`{"type": "object", "required": ["port"]}`), 0644)
+	tAssert(t, err == nil, err)
+
+	rule := &KeyRule{Key: "/app/upstream", Schema: path}
+	tAssert(t, rule.Check(`{"port": 80}`, true, nil) == nil)
+
+	err = rule.Check(`{"host": "a"}`, true, nil)
+	tAssert(t, err != nil && strings.Contains(err.Error(), "does not match schema port.json"), err)
+}
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type KVPair struct {
@@ -24,21 +25,82 @@ func (p KVPair) String() string {
 // A KVStore represents an in-memory key-value store safe for
 // concurrent access.
 type KVStore struct {
-	mu sync.RWMutex
-	m  map[string]KVPair
+	mu      sync.RWMutex
+	m       map[string]KVPair
+	expires map[string]time.Time
+	size    int
+
+	ignoreCase   bool
+	aliases      map[string]string // see WithKeyAliases
+	maxValueSize int
+	maxStoreSize int
+
+	metrics      *Metrics
+	metricLabels string
+}
+
+// KVStoreOption configures a KVStore created by NewKVStore.
+type KVStoreOption func(*KVStore)
+
+// WithIgnoreCaseKeys makes the store treat keys case-insensitively.
+// Keys are lower-cased on Set, and lookups lower-case the key or
+// pattern before matching.
+func WithIgnoreCaseKeys() KVStoreOption {
+	return func(p *KVStore) {
+		p.ignoreCase = true
+	}
+}
+
+// WithValueSizeLimit limits the size in bytes of a single value.
+// Zero means no limit.
+func WithValueSizeLimit(n int) KVStoreOption {
+	return func(p *KVStore) {
+		p.maxValueSize = n
+	}
+}
+
+// WithStoreSizeLimit limits the total size in bytes of all keys and
+// values held by the store. Zero means no limit.
+func WithStoreSizeLimit(n int) KVStoreOption {
+	return func(p *KVStore) {
+		p.maxStoreSize = n
+	}
+}
+
+// WithStoreMetrics reports lookup hits/misses, purges and the store size
+// to m, labelled with the resource name.
+func WithStoreMetrics(m *Metrics, resource string) KVStoreOption {
+	return func(p *KVStore) {
+		p.metrics = m
+		p.metricLabels = fmt.Sprintf("{resource=%q}", resource)
+	}
 }
 
 // New creates and initializes a new KVStore.
-func NewKVStore() *KVStore {
-	return &KVStore{m: make(map[string]KVPair)}
+func NewKVStore(opts ...KVStoreOption) *KVStore {
+	p := &KVStore{
+		m:       make(map[string]KVPair),
+		expires: make(map[string]time.Time),
+	}
+	for _, fn := range opts {
+		fn(p)
+	}
+	return p
 }
 
 // Delete deletes the KVPair associated with key.
 func (p *KVStore) Del(key string) {
+	key = p.normalizeKey(key)
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	delete(p.m, key)
+	if kv, ok := p.m[key]; ok {
+		p.size -= len(kv.Key) + len(kv.Value)
+		delete(p.m, key)
+		delete(p.expires, key)
+		p.updateSizeMetrics()
+	}
 }
 
 // Exists checks for the existence of key in the store.
@@ -50,10 +112,30 @@ func (p *KVStore) Exists(key string) bool {
 // Get gets the KVPair associated with key. If there is no KVPair
 // associated with key, Get returns KVPair{}, false.
 func (p *KVStore) Get(key string) (kv KVPair, ok bool) {
+	key = p.normalizeKey(key)
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	kv, ok = p.get(key)
+	if !ok {
+		if alias, _, _, found := p.aliasKey(key); found {
+			if kv, ok = p.get(alias); ok {
+				kv.Key = key
+				p.countLookup("alias", true)
+			}
+		}
+	}
+	p.countLookup("get", ok)
+	return
+}
+
+// get must be called with the lock held.
+func (p *KVStore) get(key string) (kv KVPair, ok bool) {
 	kv, ok = p.m[key]
+	if ok && p.isExpired(key, time.Now()) {
+		kv, ok = KVPair{}, false
+	}
 	return
 }
 
@@ -71,26 +153,10 @@ func (p *KVStore) GetValue(key string, v ...string) (s string, ok bool) {
 	return kv.Value, true
 }
 
-// GetAll returns a KVPair for all nodes with keys matching pattern.
-// The syntax of patterns is the same as in path.Match.
+// GetAll returns a KVPair for all nodes with keys matching pattern,
+// sorted by key. The syntax of patterns is the same as in path.Match.
 func (p *KVStore) GetAll(pattern string) ([]KVPair, error) {
-	ks, err := func() ([]KVPair, error) {
-		p.mu.RLock()
-		defer p.mu.RUnlock()
-
-		ks := make([]KVPair, 0)
-		for _, kv := range p.m {
-			matched, err := path.Match(pattern, kv.Key)
-			if err != nil {
-				return nil, err
-			}
-			if matched {
-				ks = append(ks, kv)
-			}
-		}
-		return ks, nil
-	}()
-
+	ks, err := p.GetAllUnsorted(pattern)
 	if err != nil {
 		return nil, err
 	}
@@ -101,8 +167,69 @@ func (p *KVStore) GetAll(pattern string) ([]KVPair, error) {
 	return ks, nil
 }
 
+// GetAllUnsorted is like GetAll, but the KVPairs are returned in no
+// particular order. It avoids the sort for callers that do not need a
+// deterministic order.
+func (p *KVStore) GetAllUnsorted(pattern string) ([]KVPair, error) {
+	pattern = p.normalizeKey(pattern)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ks, err := p.getAll(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(ks) == 0 {
+		if alias, newKey, legacy, found := p.aliasKey(pattern); found {
+			if ks, err = p.getAll(alias); err != nil {
+				return nil, err
+			}
+			for i := range ks {
+				ks[i].Key = newKey + strings.TrimPrefix(ks[i].Key, legacy)
+			}
+			p.countLookup("alias", len(ks) > 0)
+		}
+	}
+
+	p.countLookup("getall", len(ks) > 0)
+	return ks, nil
+}
+
+// getAll must be called with the lock held.
+func (p *KVStore) getAll(pattern string) ([]KVPair, error) {
+	ks := make([]KVPair, 0)
+	now := time.Now()
+	for _, kv := range p.m {
+		if p.isExpired(kv.Key, now) {
+			continue
+		}
+		matched, err := path.Match(pattern, kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			ks = append(ks, kv)
+		}
+	}
+	return ks, nil
+}
+
+// GetAllValues returns the values of all nodes with keys matching pattern.
+// The values are sorted (by value, not by key), the same as confd.
 func (p *KVStore) GetAllValues(pattern string) ([]string, error) {
-	ks, err := p.GetAll(pattern)
+	vs, err := p.GetAllValuesUnsorted(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(vs)
+	return vs, nil
+}
+
+// GetAllValuesUnsorted is like GetAllValues, but the values are returned
+// in no particular order.
+func (p *KVStore) GetAllValuesUnsorted(pattern string) ([]string, error) {
+	ks, err := p.GetAllUnsorted(pattern)
 	if err != nil {
 		return nil, err
 	}
@@ -114,18 +241,45 @@ func (p *KVStore) GetAllValues(pattern string) ([]string, error) {
 	for i, kv := range ks {
 		vs[i] = kv.Value
 	}
-	sort.Strings(vs)
 	return vs, nil
 }
 
+// Range calls fn for each KVPair with key prefixed by prefix, in no
+// particular order. If fn returns false, Range stops the iteration.
+// Range holds the store's read lock, fn must not modify the store.
+func (p *KVStore) Range(prefix string, fn func(kv KVPair) bool) {
+	prefix = p.normalizeKey(prefix)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	for _, kv := range p.m {
+		if !strings.HasPrefix(kv.Key, prefix) || p.isExpired(kv.Key, now) {
+			continue
+		}
+		if !fn(kv) {
+			return
+		}
+	}
+}
+
+// List returns the sorted names of the direct children of filePath,
+// or the base name of filePath if it is a key itself.
 func (p *KVStore) List(filePath string) []string {
+	filePath = p.normalizeKey(filePath)
+
 	m := func() map[string]bool {
 		p.mu.RLock()
 		defer p.mu.RUnlock()
 
 		m := make(map[string]bool)
 		prefix := p.pathToTerms(filePath)
+		now := time.Now()
 		for _, kv := range p.m {
+			if p.isExpired(kv.Key, now) {
+				continue
+			}
 			if kv.Key == filePath {
 				m[path.Base(kv.Key)] = true
 				continue
@@ -139,6 +293,8 @@ func (p *KVStore) List(filePath string) []string {
 		return m
 	}()
 
+	p.countLookup("list", len(m) > 0)
+
 	vs := make([]string, 0, len(m))
 	for k := range m {
 		vs = append(vs, k)
@@ -148,14 +304,22 @@ func (p *KVStore) List(filePath string) []string {
 	return vs
 }
 
+// ListDir returns the sorted names of the direct children of filePath
+// which have children themselves.
 func (p *KVStore) ListDir(filePath string) []string {
+	filePath = p.normalizeKey(filePath)
+
 	m := func() map[string]bool {
 		p.mu.RLock()
 		defer p.mu.RUnlock()
 
 		m := make(map[string]bool)
 		prefix := p.pathToTerms(filePath)
+		now := time.Now()
 		for _, kv := range p.m {
+			if p.isExpired(kv.Key, now) {
+				continue
+			}
 			if strings.HasPrefix(kv.Key, filePath) {
 				items := p.pathToTerms(path.Dir(kv.Key))
 				if p.samePrefixTerms(prefix, items) && (len(items)-len(prefix) >= 1) {
@@ -166,6 +330,8 @@ func (p *KVStore) ListDir(filePath string) []string {
 		return m
 	}()
 
+	p.countLookup("listdir", len(m) > 0)
+
 	vs := make([]string, 0, len(m))
 	for k := range m {
 		vs = append(vs, k)
@@ -175,11 +341,41 @@ func (p *KVStore) ListDir(filePath string) []string {
 }
 
 // Set sets the KVPair entry associated with key to value.
+// The value exceeding the size limits is not set, the error is logged,
+// see TrySet.
 func (s *KVStore) Set(key string, value string) {
+	if err := s.TrySet(key, value); err != nil {
+		templateLogger.Error(err)
+	}
+}
+
+// TrySet sets the KVPair entry associated with key to value.
+// It returns an error if the value or the store exceeds its size limit.
+func (s *KVStore) TrySet(key string, value string) error {
+	key = s.normalizeKey(key)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.m[key] = KVPair{key, value}
+	return s.set(key, value)
+}
+
+// SetWithTTL sets the KVPair entry associated with key to value, the
+// entry expires after ttl. A ttl <= 0 means the entry never expires.
+// The expired entries are skipped by the lookups, and released by Purge.
+func (s *KVStore) SetWithTTL(key string, value string, ttl time.Duration) error {
+	key = s.normalizeKey(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.set(key, value); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		s.expires[key] = time.Now().Add(ttl)
+	}
+	return nil
 }
 
 func (s *KVStore) Purge() {
@@ -189,6 +385,108 @@ func (s *KVStore) Purge() {
 	for k := range s.m {
 		delete(s.m, k)
 	}
+	for k := range s.expires {
+		delete(s.expires, k)
+	}
+	s.size = 0
+
+	if s.metrics != nil {
+		s.metrics.Inc("libconfd_store_purges_total" + s.metricLabels)
+		s.updateSizeMetrics()
+	}
+}
+
+// Len returns the number of keys in the store, the expired ones are not
+// counted.
+func (s *KVStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.m)
+	now := time.Now()
+	for k := range s.expires {
+		if s.isExpired(k, now) {
+			n--
+		}
+	}
+	return n
+}
+
+// Size returns the total size in bytes of all keys and values, the
+// expired ones are not counted.
+func (s *KVStore) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	size := s.size
+	now := time.Now()
+	for k := range s.expires {
+		if s.isExpired(k, now) {
+			kv := s.m[k]
+			size -= len(kv.Key) + len(kv.Value)
+		}
+	}
+	return size
+}
+
+// set must be called with the lock held, key must be normalized.
+func (s *KVStore) set(key, value string) error {
+	if s.maxValueSize > 0 && len(value) > s.maxValueSize {
+		return fmt.Errorf(
+			"libconfd: value of key %q is %d bytes, exceeds the limit of %d bytes",
+			key, len(value), s.maxValueSize,
+		)
+	}
+
+	size := s.size + len(key) + len(value)
+	if kv, ok := s.m[key]; ok {
+		size -= len(kv.Key) + len(kv.Value)
+	}
+	if s.maxStoreSize > 0 && size > s.maxStoreSize {
+		return fmt.Errorf(
+			"libconfd: store size would be %d bytes after setting key %q, exceeds the limit of %d bytes",
+			size, key, s.maxStoreSize,
+		)
+	}
+
+	s.m[key] = KVPair{key, value}
+	delete(s.expires, key)
+	s.size = size
+	s.updateSizeMetrics()
+	return nil
+}
+
+// isExpired must be called with the lock held.
+func (p *KVStore) isExpired(key string, now time.Time) bool {
+	t, ok := p.expires[key]
+	return ok && !now.Before(t)
+}
+
+func (p *KVStore) countLookup(op string, hit bool) {
+	if p.metrics == nil {
+		return
+	}
+	if hit {
+		p.metrics.Inc("libconfd_store_" + op + "_hits_total" + p.metricLabels)
+	} else {
+		p.metrics.Inc("libconfd_store_" + op + "_misses_total" + p.metricLabels)
+	}
+}
+
+// updateSizeMetrics must be called with the lock held.
+func (p *KVStore) updateSizeMetrics() {
+	if p.metrics == nil {
+		return
+	}
+	p.metrics.Set("libconfd_store_keys"+p.metricLabels, int64(len(p.m)))
+	p.metrics.Set("libconfd_store_size_bytes"+p.metricLabels, int64(p.size))
+}
+
+func (p *KVStore) normalizeKey(key string) string {
+	if p.ignoreCase {
+		return strings.ToLower(key)
+	}
+	return key
 }
 
 func (_ *KVStore) stripKey(key, prefix string) string {
@@ -0,0 +1,69 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// WithKeyAliases makes the store look up the legacy key of a key missing
+// in the store, such as "/new/path" -> "/legacy/path", so the templates
+// read the new key layout while the backends are migrated gradually. The
+// alias of a key also applies to the keys under it, and the KVPairs found
+// by the legacy keys are returned by the new keys. The aliases are not
+// chained, and apply to Get, GetValue, Exists and GetAll.
+func WithKeyAliases(aliases map[string]string) KVStoreOption {
+	return func(p *KVStore) {
+		if len(aliases) == 0 {
+			return
+		}
+		p.aliases = make(map[string]string, len(aliases))
+		for k, v := range aliases {
+			p.aliases[p.normalizeKey(path.Clean(k))] = p.normalizeKey(path.Clean(v))
+		}
+	}
+}
+
+// aliasKey returns the legacy key of key by the alias of the key or the
+// longest alias of its parents, and the new and legacy keys of the alias.
+func (p *KVStore) aliasKey(key string) (alias, newKey, legacy string, ok bool) {
+	if len(p.aliases) == 0 {
+		return "", "", "", false
+	}
+	if legacy, ok := p.aliases[key]; ok {
+		return legacy, key, legacy, true
+	}
+	for k, v := range p.aliases {
+		if strings.HasPrefix(key, strings.TrimSuffix(k, "/")+"/") && len(k) > len(newKey) {
+			newKey, legacy = k, v
+		}
+	}
+	if newKey == "" {
+		return "", "", "", false
+	}
+	return path.Join(legacy, strings.TrimPrefix(key, newKey)), newKey, legacy, true
+}
+
+// validKeyAlias reports whether the alias of key_aliases is of the
+// absolute keys, and not of the key itself.
+func validKeyAlias(key, legacy string) bool {
+	return strings.HasPrefix(key, "/") && strings.HasPrefix(legacy, "/") && path.Clean(key) != path.Clean(legacy)
+}
+
+// getAliasKeys returns the absolute legacy keys of key_aliases, not under
+// the keys of the resource, they are fetched with the keys.
+func (p *TemplateResource) getAliasKeys(keys []string) []string {
+	var legacy []string
+	for _, v := range p.KeyAliases {
+		k := path.Join(p.Prefix, v)
+		if !MatchWatchKey(k, keys, false) && !strInStrList(k, legacy) {
+			legacy = append(legacy, k)
+		}
+	}
+	sort.Strings(legacy)
+	return legacy
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKVStoreKeyAliases(t *testing.T) {
+	store := NewKVStore(WithKeyAliases(map[string]string{
+		"/database/host": "/db/host",
+		"/cache":         "/legacy/redis",
+	}))
+	store.Set("/db/host", "10.0.0.1")
+	store.Set("/legacy/redis/addr", "10.0.0.2")
+	store.Set("/legacy/redis/port", "6379")
+
+	kv, ok := store.Get("/database/host")
+	tAssert(t, ok && kv.Key == "/database/host" && kv.Value == "10.0.0.1", kv)
+	tAssert(t, store.Exists("/cache/addr"))
+	v, ok := store.GetValue("/cache/port")
+	tAssert(t, ok && v == "6379", v)
+
+	ks, err := store.GetAll("/cache/*")
+	tAssert(t, err == nil && len(ks) == 2, ks, err)
+	tAssert(t, ks[0] == KVPair{Key: "/cache/addr", Value: "10.0.0.2"}, ks)
+
+	// the new keys win
+	store.Set("/database/host", "10.0.0.3")
+	v, _ = store.GetValue("/database/host")
+	tAssert(t, v == "10.0.0.3", v)
+
+	// not a parent of /cachex
+	tAssert(t, !store.Exists("/cachex/addr"))
+	tAssert(t, !store.Exists("/database/port"))
+
+	tAssert(t, validKeyAlias("/database", "/db"))
+	tAssert(t, !validKeyAlias("database", "/db") && !validKeyAlias("/db/", "/db"))
+}
+
+func TestTemplateResourceKeyAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-alias-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`{{getv "/database/host"}}:{{getv "/database/port"}}`), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	res := &TemplateResource{
+		Src:    src,
+		Dest:   dest,
+		Prefix: "/app",
+		Keys:   []string{"/database"},
+		KeyAliases: map[string]string{
+			"/database": "/db",
+		},
+	}
+	tAssert(t, len(res.getAbsKeys()) == 2 && res.getAbsKeys()[1] == "/app/db", res.getAbsKeys())
+
+	// half migrated
+	client := mapBackendClient{"/app/db/host": "10.0.0.1", "/app/database/port": "3306", "/app/db/port": "3307"}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, res)
+	tAssert(t, err == nil, err)
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+
+	data, err := ioutil.ReadFile(dest)
+	tAssert(t, err == nil && string(data) == "10.0.0.1:3306", string(data), err)
+}
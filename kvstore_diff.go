@@ -0,0 +1,97 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// KeyChangeType describes how a key differs between two store snapshots.
+type KeyChangeType string
+
+const (
+	KeyAdded    KeyChangeType = "added"
+	KeyRemoved  KeyChangeType = "removed"
+	KeyModified KeyChangeType = "modified"
+)
+
+// KeyChange is a single difference reported by DiffStores.
+type KeyChange struct {
+	Type     KeyChangeType
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+func (p KeyChange) String() string {
+	switch p.Type {
+	case KeyAdded:
+		return fmt.Sprintf("+ %s = %q", p.Key, p.NewValue)
+	case KeyRemoved:
+		return fmt.Sprintf("- %s = %q", p.Key, p.OldValue)
+	default:
+		return fmt.Sprintf("~ %s = %q => %q", p.Key, p.OldValue, p.NewValue)
+	}
+}
+
+// DiffStores returns the keys added, removed or modified between the old
+// and new store, sorted by key. A nil store is treated as empty.
+func DiffStores(old, new *KVStore) []KeyChange {
+	oldMap := old.snapshot()
+	newMap := new.snapshot()
+
+	var changes []KeyChange
+	for k, v := range newMap {
+		if oldValue, ok := oldMap[k]; !ok {
+			changes = append(changes, KeyChange{Type: KeyAdded, Key: k, NewValue: v})
+		} else if oldValue != v {
+			changes = append(changes, KeyChange{Type: KeyModified, Key: k, OldValue: oldValue, NewValue: v})
+		}
+	}
+	for k, v := range oldMap {
+		if _, ok := newMap[k]; !ok {
+			changes = append(changes, KeyChange{Type: KeyRemoved, Key: k, OldValue: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// snapshot returns a copy of the key/value pairs held by the store.
+func (p *KVStore) snapshot() map[string]string {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	m := make(map[string]string, len(p.m))
+	now := time.Now()
+	for k, kv := range p.m {
+		if !p.isExpired(k, now) {
+			m[k] = kv.Value
+		}
+	}
+	return m
+}
+
+// clone returns a new store holding a copy of the key/value pairs.
+func (p *KVStore) clone() *KVStore {
+	q := NewKVStore()
+	q.ignoreCase = p.ignoreCase
+	q.maxValueSize = p.maxValueSize
+	q.maxStoreSize = p.maxStoreSize
+	for k, v := range p.snapshot() {
+		q.m[k] = KVPair{k, v}
+		q.size += len(k) + len(v)
+	}
+	return q
+}
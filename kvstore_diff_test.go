@@ -0,0 +1,47 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffStores(t *testing.T) {
+	old := NewKVStore()
+	old.Set("/app/port", "80")
+	old.Set("/app/host", "localhost")
+	old.Set("/app/user", "admin")
+
+	new := NewKVStore()
+	new.Set("/app/port", "8080")
+	new.Set("/app/user", "admin")
+	new.Set("/app/pass", "secret")
+
+	want := []KeyChange{
+		{Type: KeyRemoved, Key: "/app/host", OldValue: "localhost"},
+		{Type: KeyAdded, Key: "/app/pass", NewValue: "secret"},
+		{Type: KeyModified, Key: "/app/port", OldValue: "80", NewValue: "8080"},
+	}
+
+	got := DiffStores(old, new)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffStores = %v, want %v", got, want)
+	}
+}
+
+func TestDiffStores_nil(t *testing.T) {
+	s := NewKVStore()
+	s.Set("/app/port", "80")
+
+	if got := DiffStores(s, s); len(got) != 0 {
+		t.Fatalf("DiffStores(s, s) = %v, want empty", got)
+	}
+
+	want := []KeyChange{{Type: KeyAdded, Key: "/app/port", NewValue: "80"}}
+	if got := DiffStores(nil, s); !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffStores(nil, s) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MergeStrategy decides which value wins when both stores hold a key
+// with different values.
+type MergeStrategy int
+
+const (
+	// MergePreferLeft keeps the value already in the receiver store.
+	MergePreferLeft MergeStrategy = iota
+	// MergePreferRight takes the value from the other store.
+	MergePreferRight
+	// MergeErrorOnConflict fails without modifying the receiver store.
+	MergeErrorOnConflict
+)
+
+func (s MergeStrategy) String() string {
+	switch s {
+	case MergePreferLeft:
+		return "prefer-left"
+	case MergePreferRight:
+		return "prefer-right"
+	case MergeErrorOnConflict:
+		return "error-on-conflict"
+	}
+	return "invalid"
+}
+
+// Merge copies the key/values of other into the store, resolving
+// conflicting keys with strategy. The store is unchanged if a size limit
+// is exceeded.
+func (p *KVStore) Merge(other *KVStore, strategy MergeStrategy) error {
+	m := other.snapshot()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	exists := func(k string) (KVPair, bool) {
+		kv, ok := p.m[k]
+		return kv, ok && !p.isExpired(k, now)
+	}
+
+	switch strategy {
+	case MergePreferLeft, MergePreferRight:
+	case MergeErrorOnConflict:
+		var conflicts []string
+		for k, v := range m {
+			k = p.normalizeKey(k)
+			if kv, ok := exists(k); ok && kv.Value != v {
+				conflicts = append(conflicts, k)
+			}
+		}
+		if len(conflicts) > 0 {
+			sort.Strings(conflicts)
+			return fmt.Errorf("libconfd: merge conflict on keys: %s", strings.Join(conflicts, ", "))
+		}
+	default:
+		return fmt.Errorf("libconfd: invalid merge strategy: %d", int(strategy))
+	}
+
+	// the replaced pairs are restored if a size limit is hit
+	type oldPair struct {
+		kv      KVPair
+		ok      bool
+		expires time.Time
+		expired bool
+	}
+	olds := make(map[string]oldPair)
+	for k, v := range m {
+		k = p.normalizeKey(k)
+		if _, ok := exists(k); ok && strategy == MergePreferLeft {
+			continue
+		}
+		if _, ok := olds[k]; !ok {
+			kv, ok := p.m[k]
+			t, expired := p.expires[k]
+			olds[k] = oldPair{kv: kv, ok: ok, expires: t, expired: expired}
+		}
+		if err := p.set(k, v); err != nil {
+			for k, old := range olds {
+				if kv, ok := p.m[k]; ok {
+					p.size -= len(kv.Key) + len(kv.Value)
+					delete(p.m, k)
+				}
+				delete(p.expires, k)
+				if old.ok {
+					p.m[k] = old.kv
+					p.size += len(old.kv.Key) + len(old.kv.Value)
+				}
+				if old.expired {
+					p.expires[k] = old.expires
+				}
+			}
+			p.updateSizeMetrics()
+			return err
+		}
+	}
+	return nil
+}
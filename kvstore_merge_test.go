@@ -0,0 +1,82 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKVStore_merge(t *testing.T) {
+	newStores := func() (left, right *KVStore) {
+		left, right = NewKVStore(), NewKVStore()
+		left.Set("/app/port", "80")
+		left.Set("/app/host", "localhost")
+		right.Set("/app/port", "8080")
+		right.Set("/app/user", "admin")
+		return
+	}
+
+	var tests = []struct {
+		strategy MergeStrategy
+		hasErr   bool
+		want     map[string]string
+	}{
+		{MergePreferLeft, false, map[string]string{
+			"/app/port": "80", "/app/host": "localhost", "/app/user": "admin",
+		}},
+		{MergePreferRight, false, map[string]string{
+			"/app/port": "8080", "/app/host": "localhost", "/app/user": "admin",
+		}},
+		{MergeErrorOnConflict, true, map[string]string{
+			"/app/port": "80", "/app/host": "localhost",
+		}},
+	}
+
+	for _, tt := range tests {
+		left, right := newStores()
+		err := left.Merge(right, tt.strategy)
+		if (err != nil) != tt.hasErr {
+			t.Errorf("Merge(%v) err = %v", tt.strategy, err)
+		}
+		if got := left.snapshot(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Merge(%v) = %v, want %v", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestKVStore_mergeNoConflict(t *testing.T) {
+	left, right := NewKVStore(), NewKVStore()
+	left.Set("/app/port", "80")
+	right.Set("/app/port", "80")
+	right.Set("/app/user", "admin")
+
+	if err := left.Merge(right, MergeErrorOnConflict); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := left.GetValue("/app/user"); v != "admin" {
+		t.Fatalf("GetValue(%q) = %q", "/app/user", v)
+	}
+}
+
+func TestKVStore_mergeSizeLimit(t *testing.T) {
+	left, right := NewKVStore(WithStoreSizeLimit(40)), NewKVStore()
+	left.Set("/app/port", "80")
+	right.Set("/app/port", "8080")
+	right.Set("/app/a", "1111")
+	right.Set("/app/b", "2222")
+	right.Set("/app/c", "3333")
+
+	// the pairs merged before the limit are rolled back
+	if err := left.Merge(right, MergePreferRight); err == nil {
+		t.Fatal("expect store size error")
+	}
+	if got := left.snapshot(); !reflect.DeepEqual(got, map[string]string{"/app/port": "80"}) {
+		t.Fatalf("snapshot() = %v", got)
+	}
+	if got := left.Size(); got != len("/app/port80") {
+		t.Fatalf("Size() = %d, want %d", got, len("/app/port80"))
+	}
+}
@@ -7,7 +7,9 @@ package libconfd
 import (
 	"path"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 var tKVStore_gettests = []struct {
@@ -304,3 +306,158 @@ func TestKVStore_listDirForSamePrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestKVStore_ignoreCase(t *testing.T) {
+	s := NewKVStore(WithIgnoreCaseKeys())
+	s.Set("/APP/Port", "8080")
+	s.Set("/app/HOST", "localhost")
+
+	want := KVPair{"/app/port", "8080"}
+	for _, key := range []string{"/app/port", "/APP/PORT", "/App/Port"} {
+		got, ok := s.Get(key)
+		if !ok || got != want {
+			t.Errorf("Get(%q) = %v, %v, want %v, %v", key, got, ok, want, true)
+		}
+	}
+
+	kvs, err := s.GetAll("/APP/*")
+	if err != nil || len(kvs) != 2 {
+		t.Errorf("GetAll(%q) = %v, %v", "/APP/*", kvs, err)
+	}
+
+	if got := s.List("/App"); !reflect.DeepEqual(got, []string{"host", "port"}) {
+		t.Errorf("List(%q) = %v", "/App", got)
+	}
+
+	s.Del("/APP/HOST")
+	if s.Exists("/app/host") {
+		t.Errorf("Del(%q) failed", "/APP/HOST")
+	}
+}
+
+func TestKVStore_range(t *testing.T) {
+	s := NewKVStore()
+	for k, v := range tKVStore_getalltestinput {
+		s.Set(k, v)
+	}
+
+	got := make(map[string]string)
+	s.Range("/app/upstream/", func(kv KVPair) bool {
+		got[kv.Key] = kv.Value
+		return true
+	})
+	want := map[string]string{
+		"/app/upstream/host1":        "203.0.113.0.1:8080",
+		"/app/upstream/host1/domain": "app.example.com",
+		"/app/upstream/host2":        "203.0.113.0.2:8080",
+		"/app/upstream/host2/domain": "app.example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Range(%q) = %v, want %v", "/app/upstream/", got, want)
+	}
+
+	var n int
+	s.Range("/", func(kv KVPair) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("Range did not stop, n = %d", n)
+	}
+}
+
+func TestKVStore_sizeLimit(t *testing.T) {
+	s := NewKVStore(WithValueSizeLimit(4), WithStoreSizeLimit(32))
+
+	if err := s.TrySet("/app/port", "8080"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TrySet("/app/host", "localhost"); err == nil {
+		t.Fatal("expect value size error")
+	}
+	s.Set("/app/host", "localhost")
+	if s.Exists("/app/host") {
+		t.Fatal("oversized value should not be stored")
+	}
+
+	if err := s.TrySet("/app/user", "root"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Size(); got != 26 {
+		t.Fatalf("Size() = %d, want %d", got, 26)
+	}
+	if err := s.TrySet("/app/pass", "1234"); err == nil {
+		t.Fatal("expect store size error")
+	}
+
+	// replace does not count the old value twice
+	if err := s.TrySet("/app/user", "toor"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Del("/app/user")
+	if got := s.Size(); got != 13 {
+		t.Fatalf("Size() = %d, want %d", got, 13)
+	}
+}
+
+func TestKVStore_ttl(t *testing.T) {
+	s := NewKVStore()
+	s.SetWithTTL("/app/lease", "leased", time.Millisecond)
+	s.SetWithTTL("/app/port", "80", 0)
+
+	if !s.Exists("/app/lease") {
+		t.Fatal("leased key should exist before expiry")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if s.Exists("/app/lease") {
+		t.Fatal("leased key should expire")
+	}
+	if got := s.List("/app"); !reflect.DeepEqual(got, []string{"port"}) {
+		t.Fatalf("List(%q) = %v", "/app", got)
+	}
+	if s.Len() != 1 || s.Size() != len("/app/port80") {
+		t.Fatalf("Len() = %d, Size() = %d, the expired key is counted", s.Len(), s.Size())
+	}
+
+	// Set without ttl clears the expiry
+	s.SetWithTTL("/app/port", "80", time.Millisecond)
+	s.Set("/app/port", "8080")
+	time.Sleep(10 * time.Millisecond)
+	if !s.Exists("/app/port") {
+		t.Fatal("Set should clear the expiry")
+	}
+
+	if s.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", s.Len())
+	}
+}
+
+func TestKVStore_getAllUnsorted(t *testing.T) {
+	s := NewKVStore()
+	for k, v := range tKVStore_getalltestinput {
+		s.Set(k, v)
+	}
+
+	ks, err := s.GetAllUnsorted("/app/upstream/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(ks, func(i, j int) bool {
+		return ks[i].Key < ks[j].Key
+	})
+	want, _ := s.GetAll("/app/upstream/*")
+	if !reflect.DeepEqual(ks, want) {
+		t.Errorf("GetAllUnsorted = %v, want %v", ks, want)
+	}
+
+	vs, err := s.GetAllValues("/app/*/host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vs, []string{"203.0.113.0.1:8080", "app.example.com"}) {
+		t.Errorf("GetAllValues = %v", vs)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"path"
+	"strings"
+)
+
+// StoreView is a read-only view of a KVStore scoped under a key prefix.
+// Keys passed to and returned by a StoreView are relative to the prefix.
+// The view shares data with the underlying store, nothing is copied.
+type StoreView struct {
+	store  *KVStore
+	prefix string
+}
+
+// WithPrefix returns a view of the store scoped under prefix.
+func (p *KVStore) WithPrefix(prefix string) StoreView {
+	return StoreView{
+		store:  p,
+		prefix: path.Join("/", prefix),
+	}
+}
+
+// WithPrefix returns a nested view scoped under prefix.
+func (p StoreView) WithPrefix(prefix string) StoreView {
+	return p.store.WithPrefix(path.Join(p.prefix, prefix))
+}
+
+// Prefix returns the absolute prefix of the view.
+func (p StoreView) Prefix() string {
+	return p.prefix
+}
+
+func (p StoreView) Exists(key string) bool {
+	return p.store.Exists(p.absKey(key))
+}
+
+func (p StoreView) Get(key string) (kv KVPair, ok bool) {
+	if kv, ok = p.store.Get(p.absKey(key)); ok {
+		kv.Key = p.relKey(kv.Key)
+	}
+	return
+}
+
+func (p StoreView) GetValue(key string, v ...string) (s string, ok bool) {
+	return p.store.GetValue(p.absKey(key), v...)
+}
+
+func (p StoreView) GetAll(pattern string) ([]KVPair, error) {
+	ks, err := p.store.GetAll(p.absKey(pattern))
+	if err != nil {
+		return nil, err
+	}
+	for i := range ks {
+		ks[i].Key = p.relKey(ks[i].Key)
+	}
+	return ks, nil
+}
+
+func (p StoreView) GetAllValues(pattern string) ([]string, error) {
+	return p.store.GetAllValues(p.absKey(pattern))
+}
+
+func (p StoreView) List(filePath string) []string {
+	return p.store.List(p.absKey(filePath))
+}
+
+func (p StoreView) ListDir(filePath string) []string {
+	return p.store.ListDir(p.absKey(filePath))
+}
+
+func (p StoreView) absKey(key string) string {
+	s := path.Join(p.prefix, key)
+	if strings.HasSuffix(key, "/") && !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return s
+}
+
+func (p StoreView) relKey(key string) string {
+	if p.prefix == "/" {
+		return key
+	}
+	return path.Join("/", strings.TrimPrefix(key, p.prefix))
+}
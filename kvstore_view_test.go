@@ -0,0 +1,47 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStoreView(t *testing.T) {
+	s := NewKVStore()
+	for k, v := range tKVStore_listTestMap {
+		s.Set(k, v)
+	}
+
+	view := s.WithPrefix("/deis")
+	tAssert(t, view.Prefix() == "/deis", view.Prefix())
+
+	kv, ok := view.Get("/database/user")
+	tAssert(t, ok && kv == KVPair{"/database/user", "user"}, kv)
+
+	v, ok := view.GetValue("/database/missing", "default")
+	tAssert(t, ok && v == "default", v)
+
+	tAssert(t, view.Exists("/services/key"))
+	tAssert(t, !view.Exists("/deis/services/key"))
+
+	kvs, err := view.GetAll("/database/*")
+	tAssert(t, err == nil, err)
+	tAssert(t, reflect.DeepEqual(kvs, []KVPair{
+		{"/database/pass", "pass"},
+		{"/database/user", "user"},
+	}), kvs)
+
+	got := view.WithPrefix("services").List("/")
+	tAssert(t, reflect.DeepEqual(got, []string{"key", "notaservice", "srv1", "srv2"}), got)
+
+	got = view.ListDir("/services")
+	tAssert(t, reflect.DeepEqual(got, []string{"notaservice", "srv1", "srv2"}), got)
+
+	// views share data with the store
+	s.Set("/deis/database/user", "admin")
+	v, _ = view.GetValue("/database/user")
+	tAssert(t, v == "admin", v)
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfdtest
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"openpitrix.io/libconfd"
+)
+
+func init() {
+	// the test packages importing libconfdtest share the -update flag
+	if flag.Lookup("update") == nil {
+		flag.Bool("update", false, "update the golden files of libconfdtest.RenderGolden")
+	}
+}
+
+func updateGolden() bool {
+	f := flag.Lookup("update")
+	return f != nil && f.Value.String() == "true"
+}
+
+// RenderGolden renders the template resource resourceTOML, such as
+// "confd/conf.d/nginx.toml", with the key/values of the JSON object file
+// snapshotJSON, and compares the output with the golden file. The golden
+// file is written instead if the test runs with -update:
+//
+//	go test -run TestGolden -update
+//
+// The confdir is the parent dir of the conf.d dir of resourceTOML.
+// The Hostname and RenderTime of the template data change every run, the
+// templates using them can not be compared.
+func RenderGolden(t *testing.T, resourceTOML, snapshotJSON, goldenPath string) {
+	t.Helper()
+
+	values, err := libconfd.LoadKeyValuesFile(snapshotJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := filepath.Abs(resourceTOML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &libconfd.Config{ConfDir: filepath.Dir(filepath.Dir(path))}
+
+	got, err := libconfd.RenderTemplateResource(cfg, path, values)
+	if err != nil {
+		t.Fatalf("%s: %v", filepath.Base(resourceTOML), err)
+	}
+
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(goldenPath, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expect, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if !bytes.Equal(got, expect) {
+		t.Fatalf("%s: output differs from %s, run with -update to accept it\ngot:\n%s\nexpect:\n%s",
+			filepath.Base(resourceTOML), goldenPath, got, expect,
+		)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package libconfdtest provides helpers to test the libconfd templates
+// with Go tests.
+//
+// Example:
+//
+//	func TestNginxTemplate(t *testing.T) {
+//		libconfdtest.Run(t, []libconfdtest.Case{
+//			{
+//				Name:     "port",
+//				Template: `listen {{getv "/nginx/port"}};`,
+//				Values:   map[string]string{"/nginx/port": "80"},
+//				Expect:   `listen 80;`,
+//			},
+//			{
+//				Name:     "missing port",
+//				Template: `listen {{getv "/nginx/port"}};`,
+//				WantErr:  true,
+//			},
+//		})
+//	}
+package libconfdtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"openpitrix.io/libconfd"
+)
+
+// NewStore returns a KVStore of the key/values.
+func NewStore(values map[string]string) *libconfd.KVStore {
+	store := libconfd.NewKVStore()
+	for k, v := range values {
+		store.Set(k, v)
+	}
+	return store
+}
+
+// NewFuncMap returns the standard template funcs reading the store,
+// pgpPrivateKey is used by the cget* funcs and may be nil.
+func NewFuncMap(store *libconfd.KVStore, pgpPrivateKey []byte) template.FuncMap {
+	return template.FuncMap(libconfd.NewTemplateFunc(store, pgpPrivateKey).FuncMap)
+}
+
+// Execute executes the template text with the standard template funcs
+// reading the key/values, data is the data of the template, such as a
+// *libconfd.TemplateData, and may be nil.
+func Execute(text string, values map[string]string, data interface{}) (string, error) {
+	tmpl, err := template.New("").Funcs(NewFuncMap(NewStore(values), nil)).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ExecuteFile is like Execute, but the template is read from the file,
+// such as "confd/templates/nginx.conf.tmpl".
+func ExecuteFile(filename string, values map[string]string, data interface{}) (string, error) {
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return Execute(string(text), values, data)
+}
+
+// Case is a case of Run, the template is Template, or read from
+// TemplateFile if Template is empty.
+type Case struct {
+	Name         string
+	Template     string
+	TemplateFile string
+	Values       map[string]string
+	Data         interface{}
+	Expect       string
+	WantErr      bool
+}
+
+// Run runs every case as a subtest of t, the output must be Expect, or
+// the template must fail if WantErr is set.
+func Run(t *testing.T, cases []Case) {
+	t.Helper()
+
+	for i, c := range cases {
+		name := c.Name
+		if name == "" {
+			name = filepath.Base(c.TemplateFile)
+		}
+
+		c := c
+		t.Run(name, func(t *testing.T) {
+			var got string
+			var err error
+			if c.Template != "" || c.TemplateFile == "" {
+				got, err = Execute(c.Template, c.Values, c.Data)
+			} else {
+				got, err = ExecuteFile(c.TemplateFile, c.Values, c.Data)
+			}
+
+			if c.WantErr {
+				if err == nil {
+					t.Fatalf("case %d: expect error, got %q", i, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("case %d: %v", i, err)
+			}
+			if got != c.Expect {
+				t.Fatalf("case %d: expect %q, got %q", i, c.Expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfdtest
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"openpitrix.io/libconfd"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfdtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.conf.tmpl")
+	if err := ioutil.WriteFile(file, []byte(`{{range gets "/app/*"}}{{base .Key}}={{.Value}};{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Run(t, []Case{
+		{
+			Name:     "getv",
+			Template: `port={{getv "/app/port"}}`,
+			Values:   map[string]string{"/app/port": "80"},
+			Expect:   "port=80",
+		},
+		{
+			Name:     "missing key",
+			Template: `port={{getv "/app/port"}}`,
+			WantErr:  true,
+		},
+		{
+			Name:     "data",
+			Template: `# {{.ResourceName}}`,
+			Data:     &libconfd.TemplateData{ResourceName: "app.toml"},
+			Expect:   "# app.toml",
+		},
+		{
+			TemplateFile: file,
+			Values:       map[string]string{"/app/port": "80", "/app/host": "a.b"},
+			Expect:       "host=a.b;port=80;",
+		},
+	})
+}
+
+func TestRenderGolden(t *testing.T) {
+	RenderGolden(t, "testdata/confd/conf.d/app.toml", "testdata/values.json", "testdata/app.conf.golden")
+
+	dir, err := ioutil.TempDir("", "libconfdtest-golden-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	golden := filepath.Join(dir, "app.conf.golden")
+	flag.Set("update", "true")
+	RenderGolden(t, "testdata/confd/conf.d/app.toml", "testdata/values.json", golden)
+	flag.Set("update", "false")
+
+	data, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, _ := ioutil.ReadFile("testdata/app.conf.golden")
+	if string(data) != string(expect) {
+		t.Fatalf("expect %q, got %q", expect, data)
+	}
+}
@@ -62,8 +62,18 @@ type Logger interface {
 	// Level: DEBUG < INFO < WARN < ERROR < PANIC < FATAL
 	GetLevel() string
 	SetLevel(new string) (old string)
+
+	// V reports whether the DEBUG output of verbosity level v is enabled:
+	//	if logger.V(2) { logger.Debugf("GetValues: %v", values) }
+	// Verbosity: 0 (default) < 1 < 2 < 3 < 4 (most verbose)
+	V(v int) bool
+	GetVerbosity() int
+	SetVerbosity(new int) (old int)
 }
 
+// MaxVerbosity is the most verbose level of Logger.V.
+const MaxVerbosity = 4
+
 type logLevelType uint32
 
 const (
@@ -117,7 +127,8 @@ func (level logLevelType) String() string {
 }
 
 type stdLogger struct {
-	level logLevelType
+	level     logLevelType
+	verbosity int32
 	*log.Logger
 }
 
@@ -159,6 +170,16 @@ func (p *stdLogger) SetLevel(new string) (old string) {
 	return p.setLevelByName(new)
 }
 
+func (p *stdLogger) V(v int) bool {
+	return p.getLevel() <= logDebugLevel && v <= p.GetVerbosity()
+}
+func (p *stdLogger) GetVerbosity() int {
+	return int(atomic.LoadInt32(&p.verbosity))
+}
+func (p *stdLogger) SetVerbosity(new int) (old int) {
+	return int(atomic.SwapInt32(&p.verbosity, int32(new)))
+}
+
 func (p *stdLogger) Assert(condition bool, v ...interface{}) {
 	if l := logDebugLevel; p.getLevel() <= l && !condition {
 		p.Output(2, "[ASSERT] "+fmt.Sprint(v...))
@@ -0,0 +1,328 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Log components which can have their own level, see SetLevelFor.
+const (
+	LogProcessor = "processor"
+	LogBackend   = "backend"
+	LogTemplate  = "template"
+	LogCommand   = "command"
+)
+
+var (
+	processorLogger = GetLoggerFor(LogProcessor)
+	backendLogger   = GetLoggerFor(LogBackend)
+	templateLogger  = GetLoggerFor(LogTemplate)
+	commandLogger   = GetLoggerFor(LogCommand)
+)
+
+var (
+	componentLoggerMutex sync.Mutex
+	componentLoggerMap   = map[string]*componentLogger{}
+)
+
+// GetLoggerFor returns the logger of the named component. It writes to
+// the logger returned by GetLogger, filtered by the component level.
+func GetLoggerFor(component string) Logger {
+	componentLoggerMutex.Lock()
+	defer componentLoggerMutex.Unlock()
+
+	p, ok := componentLoggerMap[component]
+	if !ok {
+		p = &componentLogger{name: component, level: new(componentLevel)}
+		componentLoggerMap[component] = p
+	}
+	return p
+}
+
+// SetLevelFor sets the level of the named component, an empty level
+// makes the component follow the level of the global logger.
+//
+// A component level below the global level only takes effect with the
+// builtin loggers (NewStdLogger, NewFuncLogger and the adapters based
+// on it), other loggers still filter by their own level.
+func SetLevelFor(component, level string) (old string) {
+	p := GetLoggerFor(component).(*componentLogger)
+	old = p.getLevelName()
+	if level == "" {
+		p.setLevel(logUnknownLevel)
+		return
+	}
+	p.SetLevel(level)
+	return
+}
+
+// GetLevelFor returns the level of the named component, or empty
+// string if it follows the level of the global logger.
+func GetLevelFor(component string) string {
+	return GetLoggerFor(component).(*componentLogger).getLevelName()
+}
+
+// rawOutputer is implemented by the builtin loggers, rawOutput writes
+// the message regardless of the logger level. calldepth is relative to
+// the caller of rawOutput, the same as log.Output.
+type rawOutputer interface {
+	rawOutput(calldepth int, level logLevelType, s string)
+}
+
+func (p *stdLogger) rawOutput(calldepth int, level logLevelType, s string) {
+	p.Output(calldepth+1, "["+level.String()+"] "+s)
+}
+
+func (p *funcLogger) rawOutput(calldepth int, level logLevelType, s string) {
+	p.output(level.String(), trimNewline(s))
+}
+
+// logField is a key/value pair prefixed to every message of a
+// contextual logger, see withLogFields.
+type logField struct {
+	Key   string
+	Value interface{}
+}
+
+// logFieldCycle changes on every run, it is left out of the
+// deduplication key so repeated errors are still summarized.
+const logFieldCycle = "cycle"
+
+// withLogFields returns a child of l which prefixes every message with
+// fields, e.g. "[resource=nginx.toml cycle=3] msg". The child shares the
+// level of l. Loggers not created by GetLoggerFor are returned unchanged.
+func withLogFields(l Logger, fields ...logField) Logger {
+	p, ok := l.(*componentLogger)
+	if !ok {
+		return l
+	}
+	q := &componentLogger{
+		name:   p.name,
+		level:  p.level,
+		fields: append(append([]logField{}, p.fields...), fields...),
+	}
+	for _, f := range q.fields {
+		s := fmt.Sprintf("%s=%v", f.Key, f.Value)
+		if q.prefix != "" {
+			q.prefix += " "
+		}
+		q.prefix += s
+		if f.Key != logFieldCycle {
+			q.dedupKey += "\x00" + s
+		}
+	}
+	q.prefix = "[" + q.prefix + "] "
+	return q
+}
+
+type componentLogger struct {
+	name     string
+	level    *componentLevel
+	fields   []logField
+	prefix   string
+	dedupKey string
+}
+
+type componentLevel struct {
+	mu    sync.Mutex
+	level logLevelType
+}
+
+func (p *componentLogger) getLevel() logLevelType {
+	p.level.mu.Lock()
+	defer p.level.mu.Unlock()
+	return p.level.level
+}
+func (p *componentLogger) setLevel(level logLevelType) logLevelType {
+	p.level.mu.Lock()
+	defer p.level.mu.Unlock()
+	old := p.level.level
+	p.level.level = level
+	return old
+}
+func (p *componentLogger) getLevelName() string {
+	if level := p.getLevel(); level.Valid() {
+		return level.String()
+	}
+	return ""
+}
+
+func (p *componentLogger) minLevel(l Logger) logLevelType {
+	if level := p.getLevel(); level.Valid() {
+		return level
+	}
+	return newLogLevel(l.GetLevel())
+}
+
+func (p *componentLogger) log(level logLevelType, s string) {
+	l := GetLogger()
+	if level < p.minLevel(l) {
+		return
+	}
+
+	now := time.Now()
+	entries := []LogEntry{{
+		Time:      now,
+		Level:     level.String(),
+		Component: p.name,
+		Message:   s,
+		Fields:    p.fieldMap(),
+	}}
+	prefixes := []string{p.prefix}
+
+	if level == logWarnLevel || level == logErrorLevel {
+		ok, summaries := logDedupFilter.check(p.name+p.dedupKey+"\x00"+s, &logDedupEntry{
+			level:     level,
+			component: p.name,
+			prefix:    p.prefix,
+			msg:       s,
+			fields:    entries[0].Fields,
+		}, now)
+		if !ok {
+			return
+		}
+		for _, e := range summaries {
+			entries = append(entries, LogEntry{
+				Time:      now,
+				Level:     e.level.String(),
+				Component: e.component,
+				Message:   e.summary(GetLogRepeatInterval()),
+				Fields:    e.fields,
+				Repeated:  e.count,
+			})
+			prefixes = append(prefixes, e.prefix)
+		}
+	}
+
+	for i, e := range entries {
+		msg := prefixes[i] + e.Message
+		if o, ok := l.(rawOutputer); ok {
+			o.rawOutput(3, newLogLevel(e.Level), msg)
+		} else {
+			switch newLogLevel(e.Level) {
+			case logDebugLevel:
+				l.Debug(msg)
+			case logInfoLevel:
+				l.Info(msg)
+			case logWarnLevel:
+				l.Warning(msg)
+			default:
+				l.Error(msg)
+			}
+		}
+		fireLogHooks(e)
+	}
+}
+
+func (p *componentLogger) fieldMap() map[string]interface{} {
+	if len(p.fields) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(p.fields))
+	for _, f := range p.fields {
+		m[f.Key] = f.Value
+	}
+	return m
+}
+
+func (p *componentLogger) GetLevel() string {
+	return p.minLevel(GetLogger()).String()
+}
+func (p *componentLogger) SetLevel(new string) (old string) {
+	level := newLogLevel(new)
+	if !level.Valid() {
+		panic("invalid level: " + new)
+	}
+	return p.setLevel(level).String()
+}
+
+// V also requires the component level to be DEBUG, the verbosity is
+// shared with the global logger.
+func (p *componentLogger) V(v int) bool {
+	l := GetLogger()
+	return p.minLevel(l) <= logDebugLevel && v <= l.GetVerbosity()
+}
+func (p *componentLogger) GetVerbosity() int {
+	return GetLogger().GetVerbosity()
+}
+func (p *componentLogger) SetVerbosity(new int) (old int) {
+	return GetLogger().SetVerbosity(new)
+}
+
+func (p *componentLogger) Assert(condition bool, v ...interface{}) {
+	if !condition && p.minLevel(GetLogger()) <= logDebugLevel {
+		p.log(logFatalLevel, "[ASSERT] "+fmt.Sprint(v...))
+		os.Exit(1)
+	}
+}
+func (p *componentLogger) Assertln(condition bool, v ...interface{}) {
+	if !condition && p.minLevel(GetLogger()) <= logDebugLevel {
+		p.log(logFatalLevel, "[ASSERT] "+fmt.Sprintln(v...))
+		os.Exit(1)
+	}
+}
+func (p *componentLogger) Assertf(condition bool, format string, v ...interface{}) {
+	if !condition && p.minLevel(GetLogger()) <= logDebugLevel {
+		p.log(logFatalLevel, "[ASSERT] "+fmt.Sprintf(format, v...))
+		os.Exit(1)
+	}
+}
+
+func (p *componentLogger) Debug(v ...interface{})   { p.log(logDebugLevel, fmt.Sprint(v...)) }
+func (p *componentLogger) Debugln(v ...interface{}) { p.log(logDebugLevel, fmt.Sprintln(v...)) }
+func (p *componentLogger) Debugf(format string, v ...interface{}) {
+	p.log(logDebugLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *componentLogger) Info(v ...interface{})   { p.log(logInfoLevel, fmt.Sprint(v...)) }
+func (p *componentLogger) Infoln(v ...interface{}) { p.log(logInfoLevel, fmt.Sprintln(v...)) }
+func (p *componentLogger) Infof(format string, v ...interface{}) {
+	p.log(logInfoLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *componentLogger) Warning(v ...interface{})   { p.log(logWarnLevel, fmt.Sprint(v...)) }
+func (p *componentLogger) Warningln(v ...interface{}) { p.log(logWarnLevel, fmt.Sprintln(v...)) }
+func (p *componentLogger) Warningf(format string, v ...interface{}) {
+	p.log(logWarnLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *componentLogger) Error(v ...interface{})   { p.log(logErrorLevel, fmt.Sprint(v...)) }
+func (p *componentLogger) Errorln(v ...interface{}) { p.log(logErrorLevel, fmt.Sprintln(v...)) }
+func (p *componentLogger) Errorf(format string, v ...interface{}) {
+	p.log(logErrorLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *componentLogger) Panic(v ...interface{}) {
+	s := fmt.Sprint(v...)
+	p.log(logPanicLevel, s)
+	panic(s)
+}
+func (p *componentLogger) Panicln(v ...interface{}) {
+	s := fmt.Sprintln(v...)
+	p.log(logPanicLevel, s)
+	panic(s)
+}
+func (p *componentLogger) Panicf(format string, v ...interface{}) {
+	s := fmt.Sprintf(format, v...)
+	p.log(logPanicLevel, s)
+	panic(s)
+}
+
+func (p *componentLogger) Fatal(v ...interface{}) {
+	p.log(logFatalLevel, fmt.Sprint(v...))
+	os.Exit(1)
+}
+func (p *componentLogger) Fatalln(v ...interface{}) {
+	p.log(logFatalLevel, fmt.Sprintln(v...))
+	os.Exit(1)
+}
+func (p *componentLogger) Fatalf(format string, v ...interface{}) {
+	p.log(logFatalLevel, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
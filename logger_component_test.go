@@ -0,0 +1,59 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSetLevelFor(t *testing.T) {
+	var buf bytes.Buffer
+	old := SetLogger(NewStdLogger(&buf, "", "WARN", 1))
+	defer SetLogger(old)
+
+	l := GetLoggerFor("test-component")
+
+	l.Debug("skipped")
+	l.Warning("follow global")
+
+	SetLevelFor("test-component", "DEBUG")
+	tAssert(t, GetLevelFor("test-component") == "DEBUG")
+
+	l.Debug("component debug")
+	GetLogger().Debug("global debug skipped")
+
+	SetLevelFor("test-component", "")
+	tAssert(t, GetLevelFor("test-component") == "")
+	l.Debug("skipped again")
+
+	got := buf.String()
+	tAssert(t, strings.Count(got, "\n") == 2, got)
+	tAssert(t, strings.Contains(got, "[WARN] follow global"), got)
+	tAssert(t, strings.Contains(got, "[DEBUG] component debug"), got)
+}
+
+func TestWithLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	old := SetLogger(NewStdLogger(&buf, "", "INFO", 1))
+	defer SetLogger(old)
+
+	l := withLogFields(GetLoggerFor("test-fields"),
+		logField{Key: "resource", Value: "nginx.toml"},
+		logField{Key: logFieldCycle, Value: 3},
+	)
+	l.Info("hello")
+
+	SetLevelFor("test-fields", "WARN")
+	defer SetLevelFor("test-fields", "")
+	l.Info("skipped")
+
+	got := buf.String()
+	tAssert(t, strings.Count(got, "\n") == 1, got)
+	tAssert(t, strings.Contains(got, "[INFO] [resource=nginx.toml cycle=3] hello"), got)
+
+	tAssert(t, withLogFields(old) == old)
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var logDedupFilter = &logDedup{entries: make(map[string]*logDedupEntry)}
+
+// SetLogRepeatInterval sets the window used to deduplicate WARN and ERROR
+// messages. A message is logged in full the first time, repeats within
+// the window are dropped and summarized as "(repeated N times in d)"
+// once the window elapses. Zero disables deduplication.
+func SetLogRepeatInterval(d time.Duration) (old time.Duration) {
+	return logDedupFilter.setInterval(d)
+}
+
+// GetLogRepeatInterval returns the current deduplication window.
+func GetLogRepeatInterval() time.Duration {
+	return logDedupFilter.getInterval()
+}
+
+type logDedup struct {
+	mu       sync.Mutex
+	interval time.Duration
+	entries  map[string]*logDedupEntry
+}
+
+type logDedupEntry struct {
+	level     logLevelType
+	component string
+	prefix    string
+	msg       string
+	fields    map[string]interface{}
+	first     time.Time
+	count     int
+}
+
+func (p *logDedup) setInterval(d time.Duration) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.interval
+	p.interval = d
+	if d <= 0 {
+		p.entries = make(map[string]*logDedupEntry)
+	}
+	return old
+}
+
+func (p *logDedup) getInterval() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval
+}
+
+// check reports whether the message e identified by key should be logged,
+// and returns the summaries of messages whose window elapsed with dropped
+// repeats.
+func (p *logDedup) check(key string, e *logDedupEntry, now time.Time) (ok bool, summaries []*logDedupEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.interval <= 0 {
+		return true, nil
+	}
+
+	key = e.level.String() + "\x00" + key
+	if last, exists := p.entries[key]; exists && now.Sub(last.first) < p.interval {
+		last.count++
+		return false, nil
+	}
+
+	for k, last := range p.entries {
+		if now.Sub(last.first) < p.interval {
+			continue
+		}
+		if last.count > 0 {
+			summaries = append(summaries, last)
+		}
+		delete(p.entries, k)
+	}
+
+	e.first = now
+	e.count = 0
+	p.entries[key] = e
+	return true, summaries
+}
+
+func (p *logDedupEntry) summary(interval time.Duration) string {
+	return fmt.Sprintf("%s (repeated %d times in %v)", trimNewline(p.msg), p.count, interval)
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogDedup(t *testing.T) {
+	p := &logDedup{entries: make(map[string]*logDedupEntry)}
+	now := time.Now()
+
+	ok, _ := p.check("backend\x00backend down", &logDedupEntry{level: logErrorLevel, msg: "backend down"}, now)
+	tAssert(t, ok, "disabled")
+
+	p.setInterval(10 * time.Minute)
+
+	ok, summaries := p.check("backend\x00backend down", &logDedupEntry{level: logErrorLevel, msg: "backend down"}, now)
+	tAssert(t, ok && len(summaries) == 0)
+
+	for i := 0; i < 240; i++ {
+		ok, _ = p.check("backend\x00backend down", &logDedupEntry{level: logErrorLevel, msg: "backend down"}, now.Add(time.Second))
+		tAssert(t, !ok)
+	}
+
+	ok, _ = p.check("backend\x00backend down", &logDedupEntry{level: logWarnLevel, msg: "backend down"}, now.Add(time.Second))
+	tAssert(t, ok, "other level")
+	ok, _ = p.check("template\x00backend down", &logDedupEntry{level: logErrorLevel, msg: "backend down"}, now.Add(time.Second))
+	tAssert(t, ok, "other component")
+
+	ok, summaries = p.check("backend\x00backend down", &logDedupEntry{level: logErrorLevel, msg: "backend down"}, now.Add(11*time.Minute))
+	tAssert(t, ok)
+	tAssert(t, len(summaries) == 1, summaries)
+	tAssert(t, summaries[0].summary(10*time.Minute) == "backend down (repeated 240 times in 10m0s)",
+		summaries[0].summary(10*time.Minute),
+	)
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// NewFuncLogger creates a Logger which passes every message at or above
+// level to output. The level passed to output is one of DEBUG, INFO, WARN,
+// ERROR, PANIC, FATAL, and the message has no trailing newline.
+// If level is empty string, use DEBUG and leave filtering to output.
+//
+// It is the building block for routing libconfd logs into another
+// logging stack, see NewSlogLogger.
+func NewFuncLogger(level string, output func(level, msg string)) Logger {
+	if level == "" {
+		level = "DEBUG"
+	}
+	p := &funcLogger{output: output}
+	p.SetLevel(level)
+	return p
+}
+
+type funcLogger struct {
+	level     logLevelType
+	verbosity int32
+	output    func(level, msg string)
+}
+
+func (p *funcLogger) getLevel() logLevelType {
+	return logLevelType(atomic.LoadUint32((*uint32)(&p.level)))
+}
+
+func (p *funcLogger) log(level logLevelType, msg string) {
+	if p.getLevel() <= level {
+		p.output(level.String(), trimNewline(msg))
+	}
+}
+
+func (p *funcLogger) GetLevel() string {
+	return p.getLevel().String()
+}
+func (p *funcLogger) SetLevel(new string) (old string) {
+	level := newLogLevel(new)
+	if !level.Valid() {
+		panic("invalid level: " + new)
+	}
+	return logLevelType(atomic.SwapUint32((*uint32)(&p.level), uint32(level))).String()
+}
+
+func (p *funcLogger) V(v int) bool {
+	return p.getLevel() <= logDebugLevel && v <= p.GetVerbosity()
+}
+func (p *funcLogger) GetVerbosity() int {
+	return int(atomic.LoadInt32(&p.verbosity))
+}
+func (p *funcLogger) SetVerbosity(new int) (old int) {
+	return int(atomic.SwapInt32(&p.verbosity, int32(new)))
+}
+
+func (p *funcLogger) Assert(condition bool, v ...interface{}) {
+	if !condition && p.getLevel() <= logDebugLevel {
+		p.output(logFatalLevel.String(), "[ASSERT] "+fmt.Sprint(v...))
+		os.Exit(1)
+	}
+}
+func (p *funcLogger) Assertln(condition bool, v ...interface{}) {
+	p.Assert(condition, trimNewline(fmt.Sprintln(v...)))
+}
+func (p *funcLogger) Assertf(condition bool, format string, v ...interface{}) {
+	p.Assert(condition, fmt.Sprintf(format, v...))
+}
+
+func (p *funcLogger) Debug(v ...interface{})   { p.log(logDebugLevel, fmt.Sprint(v...)) }
+func (p *funcLogger) Debugln(v ...interface{}) { p.log(logDebugLevel, fmt.Sprintln(v...)) }
+func (p *funcLogger) Debugf(format string, v ...interface{}) {
+	p.log(logDebugLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *funcLogger) Info(v ...interface{})   { p.log(logInfoLevel, fmt.Sprint(v...)) }
+func (p *funcLogger) Infoln(v ...interface{}) { p.log(logInfoLevel, fmt.Sprintln(v...)) }
+func (p *funcLogger) Infof(format string, v ...interface{}) {
+	p.log(logInfoLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *funcLogger) Warning(v ...interface{})   { p.log(logWarnLevel, fmt.Sprint(v...)) }
+func (p *funcLogger) Warningln(v ...interface{}) { p.log(logWarnLevel, fmt.Sprintln(v...)) }
+func (p *funcLogger) Warningf(format string, v ...interface{}) {
+	p.log(logWarnLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *funcLogger) Error(v ...interface{})   { p.log(logErrorLevel, fmt.Sprint(v...)) }
+func (p *funcLogger) Errorln(v ...interface{}) { p.log(logErrorLevel, fmt.Sprintln(v...)) }
+func (p *funcLogger) Errorf(format string, v ...interface{}) {
+	p.log(logErrorLevel, fmt.Sprintf(format, v...))
+}
+
+func (p *funcLogger) Panic(v ...interface{}) {
+	s := fmt.Sprint(v...)
+	p.log(logPanicLevel, s)
+	panic(s)
+}
+func (p *funcLogger) Panicln(v ...interface{}) {
+	s := fmt.Sprintln(v...)
+	p.log(logPanicLevel, s)
+	panic(s)
+}
+func (p *funcLogger) Panicf(format string, v ...interface{}) {
+	s := fmt.Sprintf(format, v...)
+	p.log(logPanicLevel, s)
+	panic(s)
+}
+
+func (p *funcLogger) Fatal(v ...interface{}) {
+	p.output(logFatalLevel.String(), fmt.Sprint(v...))
+	os.Exit(1)
+}
+func (p *funcLogger) Fatalln(v ...interface{}) {
+	p.output(logFatalLevel.String(), trimNewline(fmt.Sprintln(v...)))
+	os.Exit(1)
+}
+func (p *funcLogger) Fatalf(format string, v ...interface{}) {
+	p.output(logFatalLevel.String(), fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+func trimNewline(s string) string {
+	return strings.TrimSuffix(s, "\n")
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewFuncLogger(t *testing.T) {
+	var got []string
+	l := NewFuncLogger("INFO", func(level, msg string) {
+		got = append(got, level+": "+msg)
+	})
+
+	l.Debug("debug")
+	l.Infoln("info", 1)
+	l.Warningf("warn %d", 2)
+
+	if old := l.SetLevel("ERROR"); old != "INFO" {
+		t.Fatalf("SetLevel returns %q, want %q", old, "INFO")
+	}
+	l.Warning("skipped")
+	l.Error("error")
+
+	want := []string{"INFO: info 1", "WARN: warn 2", "ERROR: error"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got = %q, want = %q", got, want)
+	}
+}
+
+func TestLoggerVerbosity(t *testing.T) {
+	l := NewFuncLogger("DEBUG", func(level, msg string) {})
+
+	tAssert(t, l.V(0))
+	tAssert(t, !l.V(1))
+
+	tAssert(t, l.SetVerbosity(2) == 0)
+	tAssert(t, l.V(1) && l.V(2) && !l.V(3))
+
+	l.SetLevel("INFO")
+	tAssert(t, !l.V(0) && !l.V(1))
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"sync"
+	"time"
+)
+
+// LogEntry is a log message passed to the log hooks.
+type LogEntry struct {
+	Time      time.Time
+	Level     string // DEBUG/INFO/WARN/ERROR/PANIC/FATAL
+	Component string // processor/backend/template/command
+	Message   string // without the fields prefix
+
+	// context of the message, e.g. resource and cycle
+	Fields map[string]interface{}
+
+	// number of dropped repeats if the entry is a summary of a
+	// deduplicated message, see SetLogRepeatInterval
+	Repeated int
+}
+
+// LogHook is called synchronously for every libconfd log message at or
+// above Level (all messages if empty), before FATAL messages exit the
+// process.
+type LogHook struct {
+	Level string
+	Fn    func(entry LogEntry)
+}
+
+var (
+	logHooksMutex sync.Mutex
+	logHooks      []LogHook
+)
+
+// SetLogHooks replaces the installed log hooks.
+func SetLogHooks(hooks ...LogHook) (old []LogHook) {
+	logHooksMutex.Lock()
+	defer logHooksMutex.Unlock()
+	old, logHooks = logHooks, append([]LogHook{}, hooks...)
+	return
+}
+
+func fireLogHooks(entry LogEntry) {
+	logHooksMutex.Lock()
+	hooks := logHooks
+	logHooksMutex.Unlock()
+
+	level := newLogLevel(entry.Level)
+	for _, h := range hooks {
+		if h.Fn != nil && newLogLevel(h.Level) <= level {
+			h.Fn(entry)
+		}
+	}
+}
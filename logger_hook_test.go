@@ -0,0 +1,31 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestLogHook(t *testing.T) {
+	oldLogger := SetLogger(NewStdLogger(ioutil.Discard, "", "INFO", 1))
+	defer SetLogger(oldLogger)
+
+	var entries []LogEntry
+	old := SetLogHooks(LogHook{Level: "ERROR", Fn: func(entry LogEntry) {
+		entries = append(entries, entry)
+	}})
+	defer SetLogHooks(old...)
+
+	l := withLogFields(GetLoggerFor("test-hook"), logField{Key: "resource", Value: "nginx.toml"})
+	l.Info("skipped")
+	l.Errorf("backend %s down", "etcd")
+
+	tAssert(t, len(entries) == 1, entries)
+	tAssert(t, entries[0].Level == "ERROR", entries[0])
+	tAssert(t, entries[0].Component == "test-hook", entries[0])
+	tAssert(t, entries[0].Message == "backend etcd down", entries[0])
+	tAssert(t, entries[0].Fields["resource"] == "nginx.toml", entries[0])
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultRotateMaxSize    = 100 << 20
+	DefaultRotateMaxBackups = 5
+)
+
+var _ io.WriteCloser = (*RotateWriter)(nil)
+
+// RotateWriter is a log file which is rotated when it grows beyond
+// MaxSize bytes. The old files are named Filename.1 (the newest) to
+// Filename.MaxBackups, with a ".gz" suffix if Compress is set.
+//
+// Example:
+//
+//	w := libconfd.NewRotateWriter("/var/log/confd.log", 10<<20, 3, true)
+//	libconfd.SetLogger(libconfd.NewStdLogger(w, "", "INFO", 0))
+type RotateWriter struct {
+	Filename   string
+	MaxSize    int64 // 0 uses DefaultRotateMaxSize
+	MaxBackups int   // 0 uses DefaultRotateMaxBackups
+	Compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotateWriter(filename string, maxSize int64, maxBackups int, compress bool) *RotateWriter {
+	return &RotateWriter{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		Compress:   compress,
+	}
+}
+
+func (p *RotateWriter) Write(b []byte) (n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.file == nil {
+		if err = p.open(); err != nil {
+			return 0, err
+		}
+	}
+	if p.size > 0 && p.size+int64(len(b)) > p.maxSize() {
+		if err = p.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = p.file.Write(b)
+	p.size += int64(n)
+	return
+}
+
+// Rotate closes the current file and starts a new one.
+func (p *RotateWriter) Rotate() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rotate()
+}
+
+func (p *RotateWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+func (p *RotateWriter) maxSize() int64 {
+	if p.MaxSize > 0 {
+		return p.MaxSize
+	}
+	return DefaultRotateMaxSize
+}
+
+func (p *RotateWriter) maxBackups() int {
+	if p.MaxBackups > 0 {
+		return p.MaxBackups
+	}
+	return DefaultRotateMaxBackups
+}
+
+func (p *RotateWriter) backupName(i int) string {
+	name := fmt.Sprintf("%s.%d", p.Filename, i)
+	if p.Compress {
+		name += ".gz"
+	}
+	return name
+}
+
+func (p *RotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(p.Filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	p.file = f
+	p.size = fi.Size()
+	return nil
+}
+
+func (p *RotateWriter) rotate() error {
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			return err
+		}
+		p.file = nil
+	}
+
+	n := p.maxBackups()
+	os.Remove(p.backupName(n))
+	for i := n - 1; i >= 1; i-- {
+		if fileNotExists(p.backupName(i)) {
+			continue
+		}
+		if err := os.Rename(p.backupName(i), p.backupName(i+1)); err != nil {
+			return err
+		}
+	}
+
+	if !fileNotExists(p.Filename) {
+		if p.Compress {
+			if err := gzipFile(p.Filename, p.backupName(1)); err != nil {
+				return err
+			}
+		} else if err := os.Rename(p.Filename, p.backupName(1)); err != nil {
+			return err
+		}
+	}
+
+	return p.open()
+}
+
+// gzipFile compresses src into dst and removes src.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		zw.Close()
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
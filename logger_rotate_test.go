@@ -0,0 +1,60 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-rotate-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "confd.log")
+	w := NewRotateWriter(name, 10, 2, false)
+	defer w.Close()
+
+	for _, s := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := w.Write([]byte(s))
+		tAssert(t, err == nil, err)
+	}
+
+	data, _ := ioutil.ReadFile(name)
+	tAssert(t, string(data) == "dddddddd\n", string(data))
+	data, _ = ioutil.ReadFile(name + ".1")
+	tAssert(t, string(data) == "cccccccc\n", string(data))
+	data, _ = ioutil.ReadFile(name + ".2")
+	tAssert(t, string(data) == "bbbbbbbb\n", string(data))
+	tAssert(t, fileNotExists(name+".3"))
+}
+
+func TestRotateWriter_compress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-rotate-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "confd.log")
+	w := NewRotateWriter(name, 0, 0, true)
+	defer w.Close()
+
+	_, err = w.Write([]byte("hello\n"))
+	tAssert(t, err == nil, err)
+	tAssert(t, w.Rotate() == nil)
+
+	f, err := os.Open(name + ".1.gz")
+	tAssert(t, err == nil, err)
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	tAssert(t, err == nil, err)
+	data, _ := ioutil.ReadAll(zr)
+	tAssert(t, string(data) == "hello\n", string(data))
+	tAssert(t, !fileNotExists(name))
+}
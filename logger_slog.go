@@ -0,0 +1,38 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package libconfd
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger creates a Logger which writes to l.
+// PANIC and FATAL messages are logged above slog.LevelError.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return NewFuncLogger("", func(level, msg string) {
+		l.Log(context.Background(), slogLevel(level), msg)
+	})
+}
+
+func slogLevel(level string) slog.Level {
+	switch newLogLevel(level) {
+	case logDebugLevel:
+		return slog.LevelDebug
+	case logInfoLevel:
+		return slog.LevelInfo
+	case logWarnLevel:
+		return slog.LevelWarn
+	case logErrorLevel:
+		return slog.LevelError
+	case logPanicLevel:
+		return slog.LevelError + 2
+	default:
+		return slog.LevelError + 4
+	}
+}
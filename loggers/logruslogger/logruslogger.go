@@ -0,0 +1,32 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package logruslogger provides a logrus adapter for the libconfd Logger.
+package logruslogger
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"openpitrix.io/libconfd"
+)
+
+// NewLogrusLogger creates a libconfd.Logger which writes to l,
+// l can be a *logrus.Logger or a *logrus.Entry.
+//
+// PANIC and FATAL are logged at logrus' ErrorLevel, libconfd panics
+// or exits by itself after the message is written.
+func NewLogrusLogger(l logrus.FieldLogger) libconfd.Logger {
+	return libconfd.NewFuncLogger("", func(level, msg string) {
+		switch level {
+		case "DEBUG":
+			l.Debug(msg)
+		case "INFO":
+			l.Info(msg)
+		case "WARN":
+			l.Warn(msg)
+		default:
+			l.Error(msg)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+// Package zaplogger provides a zap adapter for the libconfd Logger.
+package zaplogger
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"openpitrix.io/libconfd"
+)
+
+// NewZapLogger creates a libconfd.Logger which writes to l.
+//
+// PANIC is logged at zap's DPanicLevel and FATAL at zap's ErrorLevel,
+// libconfd panics or exits by itself after the message is written.
+func NewZapLogger(l *zap.Logger) libconfd.Logger {
+	l = l.WithOptions(zap.AddCallerSkip(3))
+	return libconfd.NewFuncLogger("", func(level, msg string) {
+		if ce := l.Check(zapLevel(level), msg); ce != nil {
+			ce.Write()
+		}
+	})
+}
+
+func zapLevel(level string) zapcore.Level {
+	switch level {
+	case "DEBUG":
+		return zapcore.DebugLevel
+	case "INFO":
+		return zapcore.InfoLevel
+	case "WARN":
+		return zapcore.WarnLevel
+	case "PANIC":
+		return zapcore.DPanicLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var metrics = NewMetrics()
+
+func GetMetrics() *Metrics {
+	return metrics
+}
+
+func SetMetrics(new *Metrics) (old *Metrics) {
+	old, metrics = metrics, new
+	return
+}
+
+// Metrics is a minimal registry of counters and gauges safe for
+// concurrent access.
+//
+// A metric name may carry Prometheus style labels, for example
+// `libconfd_store_keys{resource="nginx.toml"}`.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]int64),
+	}
+}
+
+// Add adds delta to the counter name.
+func (p *Metrics) Add(name string, delta int64) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.counters[name] += delta
+}
+
+// Inc increments the counter name by one.
+func (p *Metrics) Inc(name string) {
+	p.Add(name, 1)
+}
+
+// Set sets the gauge name to value.
+func (p *Metrics) Set(name string, value int64) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.gauges[name] = value
+}
+
+// Get returns the value of the counter or gauge name.
+func (p *Metrics) Get(name string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v, ok := p.counters[name]; ok {
+		return v
+	}
+	return p.gauges[name]
+}
+
+// Snapshot returns a copy of all counters and gauges.
+func (p *Metrics) Snapshot() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m := make(map[string]int64, len(p.counters)+len(p.gauges))
+	for k, v := range p.counters {
+		m[k] = v
+	}
+	for k, v := range p.gauges {
+		m[k] = v
+	}
+	return m
+}
+
+// snapshotByType returns a copy of the counters and the gauges.
+func (p *Metrics) snapshotByType() (counters, gauges map[string]int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counters = make(map[string]int64, len(p.counters))
+	for k, v := range p.counters {
+		counters[k] = v
+	}
+	gauges = make(map[string]int64, len(p.gauges))
+	for k, v := range p.gauges {
+		gauges[k] = v
+	}
+	return counters, gauges
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (p *Metrics) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	var buf bytes.Buffer
+	writeMetricFamilies(&buf, "counter", p.counters)
+	writeMetricFamilies(&buf, "gauge", p.gauges)
+	p.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+func (p *Metrics) String() string {
+	var buf bytes.Buffer
+	p.WriteTo(&buf)
+	return buf.String()
+}
+
+func writeMetricFamilies(w io.Writer, typ string, m map[string]int64) {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var lastFamily string
+	for _, name := range names {
+		family := name
+		if idx := strings.Index(name, "{"); idx >= 0 {
+			family = name[:idx]
+		}
+		if family != lastFamily {
+			fmt.Fprintf(w, "# TYPE %s %s\n", family, typ)
+			lastFamily = family
+		}
+		fmt.Fprintf(w, "%s %d\n", name, m[name])
+	}
+}
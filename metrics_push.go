@@ -0,0 +1,407 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMetricsPushTimeout is the timeout of pushing the metrics.
+const DefaultMetricsPushTimeout = 10 * time.Second
+
+// the start of the cumulative counters of OTLP
+var metricsStartTime = time.Now()
+
+// MetricsPusher sends the metrics to a collector after every processing
+// cycle, for the onetime and cron-style runs having no long-lived process
+// to scrape.
+type MetricsPusher interface {
+	PushMetrics(m *Metrics) error
+}
+
+// MetricsPushConfig configures a builtin metrics pusher in the config
+// file:
+//
+//	[metrics-push.gateway]
+//	type = "pushgateway"
+//	url = "http://pushgateway.example.com:9091"
+//	job = "confd-web"
+//
+//	[metrics-push.statsd]
+//	type = "statsd"
+//	addr = "127.0.0.1:8125"
+//	prefix = "confd."
+//
+//	[metrics-push.otel]
+//	type = "otlp"
+//	url = "http://otel-collector.example.com:4318/v1/metrics"
+//
+// The pushgateway pusher replaces the group of the job and the hostname
+// instance. The statsd pusher sends the counters as the gauges of their
+// totals, the labels as the DogStatsD tags. The otlp pusher posts the
+// OTLP/HTTP JSON.
+type MetricsPushConfig struct {
+	Type    string            `toml:"type" json:"type"`     // pushgateway/statsd/otlp
+	URL     string            `toml:"url" json:"url"`       // pushgateway or otlp
+	Addr    string            `toml:"addr" json:"addr"`     // statsd, host:port of udp
+	Job     string            `toml:"job" json:"job"`       // pushgateway, default is libconfd
+	Prefix  string            `toml:"prefix" json:"prefix"` // statsd, prefix of the names
+	Headers map[string]string `toml:"headers" json:"headers"`
+}
+
+// Valid checks the metrics push config.
+func (p *MetricsPushConfig) Valid() error {
+	switch p.Type {
+	case "pushgateway", "otlp":
+		u, err := url.Parse(p.URL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid url of %s pusher: %s", p.Type, p.URL)
+		}
+	case "statsd":
+		if _, _, err := net.SplitHostPort(p.Addr); err != nil {
+			return fmt.Errorf("invalid addr of statsd pusher: %v", err)
+		}
+	default:
+		return fmt.Errorf("invalid type %q", p.Type)
+	}
+	return nil
+}
+
+// NewMetricsPusher creates the builtin metrics pusher of the config.
+func (p *MetricsPushConfig) NewMetricsPusher() (MetricsPusher, error) {
+	if err := p.Valid(); err != nil {
+		return nil, err
+	}
+
+	switch p.Type {
+	case "pushgateway":
+		hostname, _ := os.Hostname()
+		return &PushgatewayPusher{URL: p.URL, Job: p.Job, Instance: hostname, Headers: p.Headers}, nil
+	case "statsd":
+		return &StatsDPusher{Addr: p.Addr, Prefix: p.Prefix}, nil
+	default:
+		return &OTLPPusher{URL: p.URL, Headers: p.Headers}, nil
+	}
+}
+
+// PushgatewayPusher puts the metrics in the Prometheus text format to
+// the Pushgateway, replacing the metrics of the job and instance.
+type PushgatewayPusher struct {
+	URL      string
+	Job      string // libconfd if empty
+	Instance string // the instance label if set
+	Headers  map[string]string
+}
+
+func (p *PushgatewayPusher) PushMetrics(m *Metrics) error {
+	job := p.Job
+	if job == "" {
+		job = "libconfd"
+	}
+	u := strings.TrimSuffix(p.URL, "/") + "/metrics/job/" + url.PathEscape(job)
+	if p.Instance != "" {
+		u += "/instance/" + url.PathEscape(p.Instance)
+	}
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	return pushMetricsHTTP("PUT", u, "text/plain; version=0.0.4", p.Headers, buf.Bytes())
+}
+
+// StatsDPusher sends the metrics as the gauges to the StatsD server by
+// UDP, the labels of a metric are sent as the DogStatsD tags.
+type StatsDPusher struct {
+	Addr   string
+	Prefix string
+}
+
+// the max payload of a StatsD packet, within the common MTU
+const maxStatsDPacket = 1432
+
+func (p *StatsDPusher) PushMetrics(m *Metrics) error {
+	conn, err := net.DialTimeout("udp", p.Addr, DefaultMetricsPushTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	snapshot := m.Snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		family, labels := parseMetricName(name)
+		line := fmt.Sprintf("%s%s:%d|g", p.Prefix, family, snapshot[name])
+		if len(labels) > 0 {
+			var tags []string
+			for _, l := range labels {
+				tags = append(tags, l[0]+":"+l[1])
+			}
+			line += "|#" + strings.Join(tags, ",")
+		}
+
+		if buf.Len() > 0 && buf.Len()+1+len(line) > maxStatsDPacket {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPPusher posts the metrics in the OTLP/HTTP JSON encoding to the
+// collector, such as http://localhost:4318/v1/metrics. The counters are
+// cumulative sums since the start of the process.
+type OTLPPusher struct {
+	URL     string
+	Headers map[string]string
+}
+
+func (p *OTLPPusher) PushMetrics(m *Metrics) error {
+	data, err := json.Marshal(newOTLPMetrics(m, time.Now()))
+	if err != nil {
+		return err
+	}
+	return pushMetricsHTTP("POST", p.URL, "application/json", p.Headers, data)
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             string          `json:"asInt"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+}
+
+// newOTLPMetrics returns the ExportMetricsServiceRequest of the metrics.
+func newOTLPMetrics(m *Metrics, now time.Time) interface{} {
+	counters, gauges := m.snapshotByType()
+	hostname, _ := os.Hostname()
+
+	var families []*otlpMetric
+	for _, x := range []struct {
+		values  map[string]int64
+		counter bool
+	}{
+		{counters, true},
+		{gauges, false},
+	} {
+		names := make([]string, 0, len(x.values))
+		for name := range x.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		byFamily := make(map[string]*otlpMetric)
+		for _, name := range names {
+			family, labels := parseMetricName(name)
+			metric, ok := byFamily[family]
+			if !ok {
+				metric = &otlpMetric{Name: family}
+				if x.counter {
+					metric.Sum = &otlpSum{AggregationTemporality: 2, IsMonotonic: true} // cumulative
+				} else {
+					metric.Gauge = new(otlpGauge)
+				}
+				byFamily[family] = metric
+				families = append(families, metric)
+			}
+
+			point := otlpDataPoint{
+				Attributes:   newOTLPAttributes(labels),
+				TimeUnixNano: strconv.FormatInt(now.UnixNano(), 10),
+				AsInt:        strconv.FormatInt(x.values[name], 10),
+			}
+			if x.counter {
+				point.StartTimeUnixNano = strconv.FormatInt(metricsStartTime.UnixNano(), 10)
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, point)
+			} else {
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, point)
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": newOTLPAttributes([][2]string{
+						{"service.name", "libconfd"},
+						{"host.name", hostname},
+					}),
+				},
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{
+						"scope":   map[string]string{"name": "openpitrix.io/libconfd"},
+						"metrics": families,
+					},
+				},
+			},
+		},
+	}
+}
+
+func newOTLPAttributes(labels [][2]string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for _, l := range labels {
+		var attr otlpAttribute
+		attr.Key, attr.Value.StringValue = l[0], l[1]
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// parseMetricName splits the name like `family{k="v",k2="v2"}` into the
+// family and the labels.
+func parseMetricName(name string) (family string, labels [][2]string) {
+	i := strings.Index(name, "{")
+	if i < 0 || !strings.HasSuffix(name, "}") {
+		return name, nil
+	}
+	family, s := name[:i], name[i+1:len(name)-1]
+
+	for s != "" {
+		j := strings.Index(s, "=")
+		if j < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:j])
+		quoted, err := strconv.QuotedPrefix(s[j+1:])
+		if err != nil {
+			break
+		}
+		value, _ := strconv.Unquote(quoted)
+		labels = append(labels, [2]string{key, value})
+		s = strings.TrimPrefix(s[j+1+len(quoted):], ",")
+	}
+	return family, labels
+}
+
+// pushMetricsHTTP sends the data, the status other than 2xx is an error.
+func pushMetricsHTTP(method, url, contentType string, headers map[string]string, data []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: DefaultMetricsPushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push metrics %s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// getMetricsPushers returns the pushers registered by WithMetricsPusher
+// and the builtin pushers of the metrics-push of Config, sorted by name.
+func (p *Config) getMetricsPushers() (names []string, pushers []MetricsPusher, err error) {
+	for name := range p.MetricsPusherPlugins {
+		names = append(names, name)
+	}
+	for name := range p.MetricsPush {
+		if _, ok := p.MetricsPusherPlugins[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if pusher, ok := p.MetricsPusherPlugins[name]; ok {
+			pushers = append(pushers, pusher)
+			continue
+		}
+		c := p.MetricsPush[name]
+		pusher, err := c.NewMetricsPusher()
+		if err != nil {
+			return nil, nil, fmt.Errorf("metrics pusher %s: %v", name, err)
+		}
+		pushers = append(pushers, pusher)
+	}
+	return names, pushers, nil
+}
+
+// pushMetrics pushes the metrics by the pushers of the config after a
+// cycle, the failures are logged and never fail the sync.
+func (p *Processor) pushMetrics(call *Call) {
+	if len(call.Config.MetricsPush) == 0 && len(call.Config.MetricsPusherPlugins) == 0 {
+		return
+	}
+
+	p.pushMutex.Lock()
+	defer p.pushMutex.Unlock()
+
+	names, pushers, err := call.Config.getMetricsPushers()
+	if err != nil {
+		processorLogger.Warning(err)
+		return
+	}
+	for i, pusher := range pushers {
+		if err := pusher.PushMetrics(GetMetrics()); err != nil {
+			GetMetrics().Inc(fmt.Sprintf("libconfd_metrics_push_errors_total{pusher=%q}", names[i]))
+			processorLogger.Warningf("push metrics by %s failed: %v", names[i], err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tNewPushMetrics() *Metrics {
+	m := NewMetrics()
+	m.Add(`libconfd_notify_errors_total{notifier="ops"}`, 2)
+	m.Inc("libconfd_renders_total")
+	m.Set(`libconfd_store_keys{resource="nginx.toml"}`, 5)
+	return m
+}
+
+func TestParseMetricName(t *testing.T) {
+	family, labels := parseMetricName(`a_total{x="1",y="a,\"b\""}`)
+	tAssert(t, family == "a_total", family)
+	tAssert(t, reflect.DeepEqual(labels, [][2]string{{"x", "1"}, {"y", `a,"b"`}}), labels)
+
+	family, labels = parseMetricName("b_total")
+	tAssert(t, family == "b_total" && labels == nil, family, labels)
+}
+
+func TestPushgatewayPusher(t *testing.T) {
+	var method, path, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+	}))
+	defer ts.Close()
+
+	pusher, err := (&MetricsPushConfig{Type: "pushgateway", URL: ts.URL, Job: "web"}).NewMetricsPusher()
+	tAssert(t, err == nil, err)
+	pusher.(*PushgatewayPusher).Instance = "host-1"
+
+	tAssert(t, pusher.PushMetrics(tNewPushMetrics()) == nil)
+	tAssert(t, method == "PUT" && path == "/metrics/job/web/instance/host-1", method, path)
+	tAssert(t, strings.Contains(body, "libconfd_renders_total 1\n"), body)
+}
+
+func TestStatsDPusher(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	tAssert(t, err == nil, err)
+	defer conn.Close()
+
+	pusher := &StatsDPusher{Addr: conn.LocalAddr().String(), Prefix: "confd."}
+	tAssert(t, pusher.PushMetrics(tNewPushMetrics()) == nil)
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	tAssert(t, err == nil, err)
+	tAssert(t, string(buf[:n]) == strings.Join([]string{
+		"confd.libconfd_notify_errors_total:2|g|#notifier:ops",
+		"confd.libconfd_renders_total:1|g",
+		"confd.libconfd_store_keys:5|g|#resource:nginx.toml",
+	}, "\n"), string(buf[:n]))
+}
+
+func TestOTLPPusher(t *testing.T) {
+	var req struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []otlpMetric `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+	}))
+	defer ts.Close()
+
+	pusher := &OTLPPusher{URL: ts.URL + "/v1/metrics"}
+	tAssert(t, pusher.PushMetrics(tNewPushMetrics()) == nil)
+
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	tAssert(t, len(metrics) == 3, metrics)
+	tAssert(t, metrics[0].Name == "libconfd_notify_errors_total" && metrics[0].Sum != nil, metrics[0])
+	tAssert(t, metrics[0].Sum.DataPoints[0].AsInt == "2", metrics[0].Sum.DataPoints)
+	tAssert(t, metrics[0].Sum.DataPoints[0].Attributes[0].Key == "notifier", metrics[0].Sum.DataPoints)
+	tAssert(t, metrics[2].Name == "libconfd_store_keys" && metrics[2].Gauge != nil, metrics[2])
+}
+
+type tMetricsPusher struct {
+	pushed int
+}
+
+func (p *tMetricsPusher) PushMetrics(m *Metrics) error {
+	p.pushed++
+	return nil
+}
+
+func TestProcessorPushMetrics(t *testing.T) {
+	pusher := new(tMetricsPusher)
+	cfg := newDefaultConfig().applyOptions(WithMetricsPusher("test", pusher))
+
+	p := NewProcessor()
+	defer p.Close()
+
+	p.pushMetrics(&Call{Config: cfg})
+	tAssert(t, pusher.pushed == 1, pusher.pushed)
+
+	c := &MetricsPushConfig{Type: "statsd", Addr: "no-port"}
+	tAssert(t, c.Valid() != nil, "expect the error of invalid addr")
+}
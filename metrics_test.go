@@ -0,0 +1,56 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"testing"
+)
+
+func TestMetrics_store(t *testing.T) {
+	m := NewMetrics()
+	s := NewKVStore(WithStoreMetrics(m, "app.toml"))
+
+	s.Set("/app/port", "80")
+	s.Get("/app/port")
+	s.Get("/app/host")
+	s.GetAll("/app/*")
+	s.List("/missing")
+	s.Purge()
+
+	var tests = []struct {
+		name string
+		want int64
+	}{
+		{`libconfd_store_get_hits_total{resource="app.toml"}`, 1},
+		{`libconfd_store_get_misses_total{resource="app.toml"}`, 1},
+		{`libconfd_store_getall_hits_total{resource="app.toml"}`, 1},
+		{`libconfd_store_list_misses_total{resource="app.toml"}`, 1},
+		{`libconfd_store_purges_total{resource="app.toml"}`, 1},
+		{`libconfd_store_keys{resource="app.toml"}`, 0},
+		{`libconfd_store_size_bytes{resource="app.toml"}`, 0},
+	}
+	for _, tt := range tests {
+		if got := m.Get(tt.name); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMetrics_writeTo(t *testing.T) {
+	m := NewMetrics()
+	m.Inc(`libconfd_test_total{a="1"}`)
+	m.Add(`libconfd_test_total{a="2"}`, 2)
+	m.Set("libconfd_test_gauge", 3)
+
+	want := `# TYPE libconfd_test_total counter
+libconfd_test_total{a="1"} 1
+libconfd_test_total{a="2"} 2
+# TYPE libconfd_test_gauge gauge
+libconfd_test_gauge 3
+`
+	if got := m.String(); got != want {
+		t.Fatalf("got = %q, want = %q", got, want)
+	}
+}
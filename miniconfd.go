@@ -7,21 +7,28 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 
 	"openpitrix.io/libconfd"
-	_ "openpitrix.io/libconfd/backends/etcdv3"
+	_ "openpitrix.io/libconfd/backends/all"
 )
 
 func main() {
 	app := cli.NewApp()
 	app.Name = "miniconfd"
 	app.Usage = "miniconfd is simple confd, only support toml/etcd backend."
-	app.Version = "0.1.0"
+	app.Version = libconfd.Version
 
 	app.UsageText = `miniconfd [global options] command [options] [args...]
 
@@ -30,27 +37,82 @@ EXAMPLE:
    miniconfd info
    miniconfd make target
    miniconfd getv key
+   miniconfd keys
+   miniconfd check
    miniconfd tour
 
-   miniconfd run`
+   miniconfd run
+
+Every flag can be set by the MINICONFD_* env, e.g. MINICONFD_LOG_LEVEL
+for --log-level. Precedence: flag > env > config file.`
 
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "config",
 			Value:  "confd.toml",
-			Usage:  "miniconfd config file",
-			EnvVar: "MINICONFD_CONFILE_FILE",
+			Usage:  "miniconfd config file (TOML, YAML or JSON)",
+			EnvVar: "MINICONFD_CONFIG,MINICONFD_CONFILE_FILE",
 		},
 		cli.StringFlag{
 			Name:   "backend-config",
 			Value:  "confd-backend.toml",
-			Usage:  "miniconfd backend config file",
-			EnvVar: "MINICONFD_BACKEND_CONFILE_FILE",
+			Usage:  "miniconfd backend config file, if no [backend] in config file",
+			EnvVar: "MINICONFD_BACKEND_CONFIG,MINICONFD_BACKEND_CONFILE_FILE",
+		},
+		cli.StringSliceFlag{
+			Name:   "confdir",
+			Usage:  "override the confdir of config file, can be repeated, the later overrides the earlier",
+			EnvVar: "MINICONFD_CONFDIR",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Usage:  "override the log-level of config file",
+			EnvVar: "MINICONFD_LOG_LEVEL",
+		},
+		cli.StringFlag{
+			Name:   "log-file",
+			Usage:  "write logs to the rotated file instead of stderr",
+			EnvVar: "MINICONFD_LOG_FILE",
+		},
+		cli.Int64Flag{
+			Name:   "log-file-max-size",
+			Value:  libconfd.DefaultRotateMaxSize,
+			Usage:  "rotate the log file beyond the size in bytes",
+			EnvVar: "MINICONFD_LOG_FILE_MAX_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "log-file-max-backups",
+			Value:  libconfd.DefaultRotateMaxBackups,
+			Usage:  "max number of the rotated log files",
+			EnvVar: "MINICONFD_LOG_FILE_MAX_BACKUPS",
+		},
+		cli.BoolFlag{
+			Name:   "log-file-compress",
+			Usage:  "gzip the rotated log files",
+			EnvVar: "MINICONFD_LOG_FILE_COMPRESS",
+		},
+		cli.BoolFlag{
+			Name:  "help-json",
+			Usage: "print all the commands and flags in JSON",
 		},
 	}
 
 	app.Before = func(context *cli.Context) error {
-		flag.Parse()
+		flag.CommandLine.Parse(nil) // the flags are parsed by cli
+
+		if context.GlobalBool("help-json") {
+			printHelpJSON(context.App)
+			os.Exit(0)
+		}
+
+		if name := context.GlobalString("log-file"); name != "" {
+			w := libconfd.NewRotateWriter(name,
+				context.GlobalInt64("log-file-max-size"),
+				context.GlobalInt("log-file-max-backups"),
+				context.GlobalBool("log-file-compress"),
+			)
+			libconfd.SetLogger(libconfd.NewStdLogger(w, "", "", 0))
+		}
 		return nil
 	}
 
@@ -61,9 +123,7 @@ EXAMPLE:
 			ArgsUsage: "[regexp]",
 
 			Action: func(c *cli.Context) {
-				cfg := libconfd.MustLoadConfig(c.GlobalString("config"))
-
-				backendConfig := libconfd.MustLoadBackendConfig(c.GlobalString("backend-config"))
+				cfg, backendConfig := loadConfig(c)
 				backendClient := libconfd.MustNewBackendClient(backendConfig)
 
 				libconfd.NewApplication(cfg, backendClient).List(c.Args().First())
@@ -76,9 +136,7 @@ EXAMPLE:
 			ArgsUsage: "[name...]",
 
 			Action: func(c *cli.Context) {
-				cfg := libconfd.MustLoadConfig(c.GlobalString("config"))
-
-				backendConfig := libconfd.MustLoadBackendConfig(c.GlobalString("backend-config"))
+				cfg, backendConfig := loadConfig(c)
 				backendClient := libconfd.MustNewBackendClient(backendConfig)
 
 				libconfd.NewApplication(cfg, backendClient).Info(c.Args()...)
@@ -91,26 +149,81 @@ EXAMPLE:
 			Usage:     "make template target, not run any command",
 			ArgsUsage: "[target...]",
 
-			Action: func(c *cli.Context) {
-				cfg := libconfd.MustLoadConfig(c.GlobalString("config"))
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "bundle",
+					Usage:  "render into the tar.gz file instead of the targets, \"-\" is the stdout",
+					EnvVar: "MINICONFD_BUNDLE",
+				},
+			},
 
-				backendConfig := libconfd.MustLoadBackendConfig(c.GlobalString("backend-config"))
+			Action: func(c *cli.Context) {
+				cfg, backendConfig := loadConfig(c)
 				backendClient := libconfd.MustNewBackendClient(backendConfig)
 
+				if s := c.String("bundle"); s != "" {
+					libconfd.NewApplication(cfg, backendClient).Bundle(s, c.Args()...)
+					return
+				}
 				libconfd.NewApplication(cfg, backendClient).Make(c.Args()...)
 				return
 			},
 		},
 
+		{
+			Name:      "rollback",
+			Usage:     "restore the archived version and reload, or flip the symlink target to the previous version, a running daemon renders the resource again at its next cycle",
+			ArgsUsage: "name...",
+
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:   "n",
+					Value:  1,
+					Usage:  "restore the n-th version before the latest archived version",
+					EnvVar: "MINICONFD_ROLLBACK_N",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				if len(c.Args()) == 0 {
+					libconfd.GetLogger().Fatal("missing name")
+				}
+				cfg := &loadMasterConfig(c).Config
+				libconfd.NewApplication(cfg, nil).Rollback(c.Int("n"), c.Args()...)
+			},
+		},
+
+		{
+			Name:      "approve",
+			Usage:     "approve the pending change of the template resource by its id",
+			ArgsUsage: "name id",
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "host",
+					Usage:  "host of the pending change, default is the local hostname",
+					EnvVar: "MINICONFD_APPROVE_HOST",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				if len(c.Args()) != 2 {
+					libconfd.GetLogger().Fatal("missing name or id")
+				}
+				cfg, backendConfig := loadConfig(c)
+				backendClient := libconfd.MustNewBackendClient(backendConfig)
+
+				libconfd.NewApplication(cfg, backendClient).Approve(c.String("host"), c.Args()[0], c.Args()[1])
+			},
+		},
+
 		{
 			Name:      "getv",
 			Usage:     "get values from backend by keys",
 			ArgsUsage: "key",
 
 			Action: func(c *cli.Context) {
-				cfg := libconfd.MustLoadConfig(c.GlobalString("config"))
-
-				backendConfig := libconfd.MustLoadBackendConfig(c.GlobalString("backend-config"))
+				cfg, backendConfig := loadConfig(c)
 				backendClient := libconfd.MustNewBackendClient(backendConfig)
 
 				libconfd.NewApplication(cfg, backendClient).GetValues(c.Args()...)
@@ -118,6 +231,233 @@ EXAMPLE:
 			},
 		},
 
+		{
+			Name:  "keys",
+			Usage: "print key/values from backend, secret values are masked",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "prefix",
+					Value:  "/",
+					Usage:  "key prefix",
+					EnvVar: "MINICONFD_PREFIX",
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "table",
+					Usage:  "output format, table or json",
+					EnvVar: "MINICONFD_FORMAT",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				cfg, backendConfig := loadConfig(c)
+				backendClient := libconfd.MustNewBackendClient(backendConfig)
+
+				libconfd.NewApplication(cfg, backendClient).Keys(c.String("prefix"), c.String("format"))
+				return
+			},
+		},
+
+		{
+			Name:  "watch",
+			Usage: "print the changes of backend key/values, secret values are masked",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "prefix",
+					Value:  "/",
+					Usage:  "key prefix",
+					EnvVar: "MINICONFD_PREFIX",
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "text",
+					Usage:  "output format, text or json",
+					EnvVar: "MINICONFD_FORMAT",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				cfg, backendConfig := loadConfig(c)
+				backendClient := libconfd.MustNewBackendClient(backendConfig)
+
+				libconfd.NewApplication(cfg, backendClient).Watch(c.String("prefix"), c.String("format"))
+				return
+			},
+		},
+
+		{
+			Name:  "check",
+			Usage: "check template resources and templates, not use the backend",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "values",
+					Usage:  "execute templates with the key/values of the JSON file",
+					EnvVar: "MINICONFD_VALUES",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				cfg := &loadMasterConfig(c).Config
+				libconfd.NewApplication(cfg, nil).Check(c.String("values"))
+				return
+			},
+		},
+
+		{
+			Name:  "validate-backend",
+			Usage: "check the backend config with a probe read and watch",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:   "timeout",
+					Value:  5,
+					Usage:  "timeout in seconds of the probe read and watch",
+					EnvVar: "MINICONFD_TIMEOUT",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				cfg, backendConfig := loadConfig(c)
+				libconfd.NewApplication(cfg, nil).ValidateBackend(
+					backendConfig, time.Duration(c.Int("timeout"))*time.Second,
+				)
+			},
+		},
+
+		{
+			Name:      "init",
+			Usage:     "create a template resource and template",
+			ArgsUsage: "name",
+
+			Action: func(c *cli.Context) {
+				if c.Args().First() == "" {
+					libconfd.GetLogger().Fatal("missing name")
+				}
+				cfg := &loadMasterConfig(c).Config
+				libconfd.NewApplication(cfg, nil).Init(c.Args().First())
+			},
+		},
+
+		{
+			Name:      "encrypt",
+			Usage:     "encrypt value for cget/cgetv, read stdin if no value",
+			ArgsUsage: "[value]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "pubkey",
+					Usage:  "armored PGP public keyring file, not used in the aes-gcm crypt mode",
+					EnvVar: "MINICONFD_PUBKEY",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				cfg := &loadMasterConfig(c).Config
+				libconfd.NewApplication(cfg, nil).Encrypt(c.String("pubkey"), c.Args().First())
+			},
+		},
+		{
+			Name:      "decrypt",
+			Usage:     "decrypt value of cget/cgetv, read stdin if no value",
+			ArgsUsage: "[value]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "seckey",
+					Usage:  "armored PGP secret keyring file, default is the key of config",
+					EnvVar: "MINICONFD_SECKEY",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				cfg := &loadMasterConfig(c).Config
+				libconfd.NewApplication(cfg, nil).Decrypt(c.String("seckey"), c.Args().First())
+			},
+		},
+
+		{
+			Name:      "supervise",
+			Usage:     "render templates, then run the command and reload it on changes",
+			ArgsUsage: "-- command [args...]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:   "watch",
+					Usage:  "re-render with watch mode, default is interval mode",
+					EnvVar: "MINICONFD_WATCH",
+				},
+				cli.IntFlag{
+					Name:   "interval",
+					Usage:  "backend polling interval in seconds (interval mode)",
+					EnvVar: "MINICONFD_INTERVAL",
+				},
+				cli.StringFlag{
+					Name:   "reload-signal",
+					Value:  "SIGHUP",
+					Usage:  "signal sent to the command after templates updated, empty for none",
+					EnvVar: "MINICONFD_RELOAD_SIGNAL",
+				},
+				cli.BoolFlag{
+					Name:   "restart",
+					Usage:  "restart the command after templates updated, instead of the reload signal",
+					EnvVar: "MINICONFD_RESTART",
+				},
+				cli.DurationFlag{
+					Name:   "kill-timeout",
+					Value:  libconfd.DefaultKillTimeout,
+					Usage:  "time to wait for the command to exit after SIGTERM, before it is killed",
+					EnvVar: "MINICONFD_KILL_TIMEOUT",
+				},
+			},
+
+			Action: func(c *cli.Context) {
+				if len(c.Args()) == 0 {
+					libconfd.GetLogger().Fatal("missing command")
+				}
+
+				cfg, backendConfig := loadConfig(c)
+				backendClient := libconfd.MustNewBackendClient(backendConfig)
+
+				cfg.Onetime = false
+				if c.IsSet("watch") {
+					cfg.Watch = c.Bool("watch")
+				}
+				if c.IsSet("interval") {
+					cfg.Interval = c.Int("interval")
+				}
+
+				var reloadSignal os.Signal
+				if s := c.String("reload-signal"); s != "" {
+					sig, ok := signalMap[strings.ToUpper(s)]
+					if !ok {
+						libconfd.GetLogger().Fatalf("unknown signal: %s", s)
+					}
+					reloadSignal = sig
+				}
+
+				libconfd.NewApplication(cfg, backendClient).Supervise(
+					reloadSignal, c.Bool("restart"), c.Duration("kill-timeout"),
+					c.Args().First(), c.Args().Tail()...,
+				)
+			},
+		},
+
+		{
+			Name:      "completion",
+			Usage:     "print the shell completion script",
+			ArgsUsage: "bash|zsh|fish",
+
+			Action: func(c *cli.Context) {
+				switch shell := c.Args().First(); shell {
+				case "bash":
+					fmt.Print(bashCompletion(c.App))
+				case "zsh":
+					fmt.Print("autoload -U +X bashcompinit && bashcompinit\n")
+					fmt.Print(bashCompletion(c.App))
+				case "fish":
+					fmt.Print(fishCompletion(c.App))
+				default:
+					libconfd.GetLogger().Fatalf("unknown shell %q", shell)
+				}
+			},
+		},
+
 		{
 			Name:  "tour",
 			Usage: "show more examples",
@@ -131,36 +471,133 @@ EXAMPLE:
 			Usage: "run confd service",
 			Flags: []cli.Flag{
 				cli.BoolFlag{
-					Name:  "once",
-					Usage: "run with onetime flag",
+					Name:   "once",
+					Usage:  "run with onetime flag",
+					EnvVar: "MINICONFD_ONCE",
+				},
+				cli.BoolFlag{
+					Name:   "noop",
+					Usage:  "run with noop flag",
+					EnvVar: "MINICONFD_NOOP",
 				},
 				cli.BoolFlag{
-					Name:  "noop",
-					Usage: "run with noop flag",
+					Name:   "watch",
+					Usage:  "run with watch mode",
+					EnvVar: "MINICONFD_WATCH",
+				},
+				cli.BoolFlag{
+					Name:   "offline",
+					Usage:  "run with the last saved snapshot, not the backend",
+					EnvVar: "MINICONFD_OFFLINE",
+				},
+				cli.IntFlag{
+					Name:   "interval",
+					Usage:  "backend polling interval in seconds (interval mode)",
+					EnvVar: "MINICONFD_INTERVAL",
 				},
 				cli.BoolFlag{
-					Name:  "watch",
-					Usage: "run with watch mode",
+					Name:   "start-stale",
+					Usage:  "keep the target files and retry if the backend is unavailable at start",
+					EnvVar: "MINICONFD_START_STALE",
+				},
+				cli.StringFlag{
+					Name:   "backend",
+					Usage:  "backend type, one of " + strings.Join(libconfd.BackendClientTypes(), "/"),
+					EnvVar: "MINICONFD_BACKEND",
+				},
+				cli.BoolFlag{
+					Name:   "init",
+					Usage:  "render once, exit with error if any template failed (init container)",
+					EnvVar: "MINICONFD_INIT",
+				},
+				cli.BoolFlag{
+					Name:   "sidecar",
+					Usage:  "never run once, keep the templates updated (sidecar container)",
+					EnvVar: "MINICONFD_SIDECAR",
+				},
+				cli.StringSliceFlag{
+					Name:   "node",
+					Usage:  "backend address, can be repeated",
+					EnvVar: "MINICONFD_NODE",
 				},
 			},
 
 			Action: func(c *cli.Context) {
-				cfg := libconfd.MustLoadConfig(c.GlobalString("config"))
-
-				backendConfig := libconfd.MustLoadBackendConfig(c.GlobalString("backend-config"))
-				backendClient := libconfd.MustNewBackendClient(backendConfig)
-
-				libconfd.NewApplication(cfg, backendClient).Run(
+				backendOpt := func(cfg *libconfd.BackendConfig) {
+					if s := c.String("backend"); s != "" {
+						cfg.Type = s
+					}
+					if nodes := c.StringSlice("node"); len(nodes) > 0 {
+						cfg.Host = nodes
+					}
+				}
+
+				cfg, backendConfig := loadConfig(c)
+				backendClient := libconfd.MustNewBackendClient(backendConfig, backendOpt)
+
+				if c.Bool("init") && c.Bool("sidecar") {
+					libconfd.GetLogger().Fatal("init and sidecar are exclusive")
+				}
+
+				opts := []libconfd.Options{
 					func(cfg *libconfd.Config) {
-						cfg.Onetime = c.Bool("once")
+						if c.IsSet("once") {
+							cfg.Onetime = c.Bool("once")
+						}
 					},
 					func(cfg *libconfd.Config) {
-						cfg.Noop = c.Bool("noop")
+						if c.IsSet("noop") {
+							cfg.Noop = c.Bool("noop")
+						}
 					},
 					func(cfg *libconfd.Config) {
-						cfg.Watch = c.Bool("watch")
+						if c.IsSet("watch") {
+							cfg.Watch = c.Bool("watch")
+						}
 					},
-				)
+					func(cfg *libconfd.Config) {
+						if c.Bool("offline") {
+							cfg.Offline = true
+						}
+					},
+					func(cfg *libconfd.Config) {
+						if c.IsSet("interval") {
+							cfg.Interval = c.Int("interval")
+						}
+					},
+					func(cfg *libconfd.Config) {
+						if c.Bool("start-stale") {
+							cfg.StartStale = true
+						}
+					},
+				}
+
+				confd := libconfd.NewApplication(cfg, backendClient)
+				confd.SetReloader(func() (*libconfd.Config, libconfd.BackendClient, error) {
+					newCfg, newBackendConfig, err := readConfig(c)
+					if err != nil {
+						return nil, nil, err
+					}
+					// keep the client (and its watches) if the backend is not changed
+					if reflect.DeepEqual(newBackendConfig, backendConfig) {
+						return newCfg, backendClient, nil
+					}
+					newBackendClient, err := libconfd.NewBackendClient(newBackendConfig, backendOpt)
+					if err != nil {
+						return nil, nil, err
+					}
+					backendConfig, backendClient = newBackendConfig, newBackendClient
+					return newCfg, newBackendClient, nil
+				})
+
+				switch {
+				case c.Bool("init"):
+					confd.RunInitContainer(opts...)
+				case c.Bool("sidecar"):
+					confd.RunSidecar(opts...)
+				default:
+					confd.Run(opts...)
+				}
 				return
 			},
 		},
@@ -173,21 +610,271 @@ EXAMPLE:
 	app.Run(os.Args)
 }
 
+// loadMasterConfig loads the config file, global flags override the
+// file values.
+func loadMasterConfig(c *cli.Context) *libconfd.MasterConfig {
+	p, err := readMasterConfig(c)
+	if err != nil {
+		libconfd.GetLogger().Fatal(err)
+	}
+	return p
+}
+
+// readMasterConfig is like loadMasterConfig, but returns the error.
+func readMasterConfig(c *cli.Context) (*libconfd.MasterConfig, error) {
+	p, err := libconfd.LoadMasterConfig(c.GlobalString("config"))
+	if err != nil {
+		return nil, err
+	}
+	if c.GlobalIsSet("log-level") {
+		p.LogLevel = c.GlobalString("log-level")
+	}
+	if dirs := c.GlobalStringSlice("confdir"); len(dirs) > 0 {
+		var absdirs []string
+		for _, dir := range dirs {
+			absdir, err := filepath.Abs(dir)
+			if err != nil {
+				return nil, err
+			}
+			absdirs = append(absdirs, absdir)
+		}
+		p.ConfDir, p.ConfDirs = absdirs[0], absdirs[1:]
+	}
+	return p, nil
+}
+
+type helpFlag struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Usage  string `json:"usage"`
+	Value  string `json:"value,omitempty"`
+	EnvVar string `json:"env,omitempty"`
+}
+
+type helpCommand struct {
+	Name      string     `json:"name"`
+	Usage     string     `json:"usage"`
+	ArgsUsage string     `json:"args_usage,omitempty"`
+	Flags     []helpFlag `json:"flags"`
+}
+
+type helpApp struct {
+	Name     string        `json:"name"`
+	Usage    string        `json:"usage"`
+	Version  string        `json:"version"`
+	Flags    []helpFlag    `json:"flags"`
+	Commands []helpCommand `json:"commands"`
+}
+
+func newHelpFlags(flags []cli.Flag) []helpFlag {
+	var s []helpFlag
+	for _, f := range flags {
+		var p helpFlag
+		switch f := f.(type) {
+		case cli.BoolFlag:
+			p = helpFlag{Name: f.Name, Type: "bool", Usage: f.Usage, EnvVar: f.EnvVar}
+		case cli.StringFlag:
+			p = helpFlag{Name: f.Name, Type: "string", Usage: f.Usage, EnvVar: f.EnvVar, Value: f.Value}
+		case cli.IntFlag:
+			p = helpFlag{Name: f.Name, Type: "int", Usage: f.Usage, EnvVar: f.EnvVar, Value: fmt.Sprint(f.Value)}
+		case cli.Int64Flag:
+			p = helpFlag{Name: f.Name, Type: "int", Usage: f.Usage, EnvVar: f.EnvVar, Value: fmt.Sprint(f.Value)}
+		case cli.StringSliceFlag:
+			p = helpFlag{Name: f.Name, Type: "string-slice", Usage: f.Usage, EnvVar: f.EnvVar}
+		default:
+			p = helpFlag{Name: f.GetName(), Type: "unknown"}
+		}
+		p.Name = flagName(p.Name)
+		s = append(s, p)
+	}
+	return s
+}
+
+// flagName returns the long name of the flag, e.g. "config" of "config, c".
+func flagName(name string) string {
+	return strings.TrimSpace(strings.Split(name, ",")[0])
+}
+
+func printHelpJSON(app *cli.App) {
+	p := helpApp{
+		Name:    app.Name,
+		Usage:   app.Usage,
+		Version: app.Version,
+		Flags:   newHelpFlags(app.Flags),
+	}
+	for _, cmd := range app.Commands {
+		p.Commands = append(p.Commands, helpCommand{
+			Name:      cmd.Name,
+			Usage:     cmd.Usage,
+			ArgsUsage: cmd.ArgsUsage,
+			Flags:     newHelpFlags(cmd.Flags),
+		})
+	}
+
+	data, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		libconfd.GetLogger().Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+func bashCompletion(app *cli.App) string {
+	var buf bytes.Buffer
+
+	var names, valueFlags []string
+	for _, f := range newHelpFlags(app.Flags) {
+		names = append(names, "--"+f.Name)
+		if f.Type != "bool" {
+			valueFlags = append(valueFlags, "--"+f.Name, "-"+f.Name)
+		}
+	}
+	for _, cmd := range app.Commands {
+		names = append(names, cmd.Name)
+	}
+
+	fmt.Fprintf(&buf, "_%s() {\n", app.Name)
+	fmt.Fprintf(&buf, "\tlocal cur cmd i\n")
+	fmt.Fprintf(&buf, "\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(&buf, "\tcmd=\"\"\n")
+	fmt.Fprintf(&buf, "\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(&buf, "\t\tcase \"${COMP_WORDS[i]}\" in\n")
+	if len(valueFlags) > 0 {
+		fmt.Fprintf(&buf, "\t\t%s) ((i++)) ;;\n", strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprintf(&buf, "\t\t-*) ;;\n")
+	fmt.Fprintf(&buf, "\t\t*) cmd=\"${COMP_WORDS[i]}\"; break ;;\n")
+	fmt.Fprintf(&buf, "\t\tesac\n")
+	fmt.Fprintf(&buf, "\tdone\n")
+	fmt.Fprintf(&buf, "\tcase \"$cmd\" in\n")
+	fmt.Fprintf(&buf, "\t\"\") COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", strings.Join(names, " "))
+	for _, cmd := range app.Commands {
+		var flags []string
+		for _, f := range newHelpFlags(cmd.Flags) {
+			flags = append(flags, "--"+f.Name)
+		}
+		fmt.Fprintf(&buf, "\t%s) COMPREPLY=($(compgen -f -W \"%s\" -- \"$cur\")) ;;\n", cmd.Name, strings.Join(flags, " "))
+	}
+	fmt.Fprintf(&buf, "\tesac\n")
+	fmt.Fprintf(&buf, "}\n")
+	fmt.Fprintf(&buf, "complete -F _%s %s\n", app.Name, app.Name)
+
+	return buf.String()
+}
+
+func fishCompletion(app *cli.App) string {
+	var buf bytes.Buffer
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+
+	for _, f := range newHelpFlags(app.Flags) {
+		fmt.Fprintf(&buf, "complete -c %s -n '__fish_use_subcommand' -l %s -d '%s'\n",
+			app.Name, f.Name, quote(f.Usage),
+		)
+	}
+	for _, cmd := range app.Commands {
+		fmt.Fprintf(&buf, "complete -c %s -f -n '__fish_use_subcommand' -a %s -d '%s'\n",
+			app.Name, cmd.Name, quote(cmd.Usage),
+		)
+		for _, f := range newHelpFlags(cmd.Flags) {
+			fmt.Fprintf(&buf, "complete -c %s -n '__fish_seen_subcommand_from %s' -l %s -d '%s'\n",
+				app.Name, cmd.Name, f.Name, quote(f.Usage),
+			)
+		}
+	}
+
+	return buf.String()
+}
+
+var signalMap = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// loadConfig loads the config file, global flags override the file values.
+// The backend is the [backend] section of the config file, or the
+// backend-config file if not set.
+func loadConfig(c *cli.Context) (*libconfd.Config, *libconfd.BackendConfig) {
+	cfg, backendConfig, err := readConfig(c)
+	if err != nil {
+		libconfd.GetLogger().Fatal(err)
+	}
+	return cfg, backendConfig
+}
+
+// readConfig is like loadConfig, but returns the error.
+func readConfig(c *cli.Context) (*libconfd.Config, *libconfd.BackendConfig, error) {
+	p, err := readMasterConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.NamedBackends, err = p.NewNamedBackends(); err != nil {
+		return nil, nil, err
+	}
+	if p.Backend.Type != "" {
+		return &p.Config, &p.Backend, nil
+	}
+	backendConfig, err := libconfd.LoadBackendConfig(c.GlobalString("backend-config"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &p.Config, backendConfig, nil
+}
+
 const tourTopic = `
 miniconfd list
 miniconfd info simple
 
 miniconfd make simple
 miniconfd make simple.windows
+miniconfd make -bundle confd.tar.gz
+miniconfd rollback simple
+miniconfd rollback -n 2 simple
+miniconfd approve simple 3f2a9c1d5e7b8a60
 
 miniconfd getv /
 miniconfd getv /key
 miniconfd getv / /key
 
+miniconfd keys
+miniconfd keys -prefix /services -format json
+miniconfd watch -prefix /services
+miniconfd watch -prefix /services -format json
+
+miniconfd encrypt -pubkey pubring.gpg p@sSw0rd
+miniconfd decrypt -seckey secring.gpg hQEMA...
+echo -n p@sSw0rd | miniconfd encrypt -pubkey pubring.gpg
+miniconfd --config aes-gcm.toml encrypt p@sSw0rd
+
+miniconfd init nginx.conf
+
+miniconfd check
+miniconfd check -values values.json
+miniconfd validate-backend
+miniconfd validate-backend -timeout 10
+miniconfd --confdir confd --confdir confd-prod list
+
 miniconfd run
 miniconfd run -once
 miniconfd run -noop
 miniconfd run -once -noop
+miniconfd run -once -offline
+miniconfd --config /etc/miniconfd/miniconfd.yaml --log-level DEBUG run
+miniconfd run -interval 30
+miniconfd run -watch -start-stale
+kill -HUP $(pidof miniconfd) # reload the config and conf.d
+miniconfd run -init
+miniconfd run -sidecar -watch
+
+miniconfd supervise -- nginx -g "daemon off;"
+miniconfd supervise -watch -reload-signal SIGHUP -- haproxy -f haproxy.cfg
+miniconfd supervise -restart -kill-timeout 10s -- ./app -config app.conf
+miniconfd run -once -backend libconfd-backend-env
+miniconfd run -watch -backend libconfd-backend-etcdv3 -node 127.0.0.1:2379
+
+miniconfd --help-json
+miniconfd completion bash > /etc/bash_completion.d/miniconfd
+miniconfd completion fish > ~/.config/fish/completions/miniconfd.fish
 
 GOOS=windows miniconfd list
 LIBCONFD_GOOS=windows miniconfd list
@@ -0,0 +1,105 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tMiniconfdFlags returns the flags of miniconfd.go by the command name,
+// "" for the global flags, and the EnvVar of the flags.
+func tMiniconfdFlags(t *testing.T) map[string]map[string]string {
+	f, err := parser.ParseFile(token.NewFileSet(), "miniconfd.go", nil, 0)
+	tAssert(t, err == nil, err)
+
+	fields := func(lit *ast.CompositeLit) map[string]string {
+		m := make(map[string]string)
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, _ := kv.Key.(*ast.Ident)
+			if v, ok := kv.Value.(*ast.BasicLit); ok && key != nil && v.Kind == token.STRING {
+				m[key.Name], _ = strconv.Unquote(v.Value)
+			}
+		}
+		return m
+	}
+	flagsOf := func(node ast.Node) map[string]string {
+		m := make(map[string]string)
+		ast.Inspect(node, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			sel, ok := lit.Type.(*ast.SelectorExpr)
+			if !ok || !strings.HasSuffix(sel.Sel.Name, "Flag") {
+				return true
+			}
+			v := fields(lit)
+			m[v["Name"]] = v["EnvVar"]
+			return false
+		})
+		return m
+	}
+
+	flags := make(map[string]map[string]string)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			// app.Flags = []cli.Flag{...}
+			if sel, ok := n.Lhs[0].(*ast.SelectorExpr); ok && sel.Sel.Name == "Flags" {
+				flags[""] = flagsOf(n.Rhs[0])
+				return false
+			}
+		case *ast.CompositeLit:
+			// the elements of app.Commands = []cli.Command{...}
+			if _, ok := n.Type.(*ast.ArrayType); !ok {
+				return true
+			}
+			for _, elt := range n.Elts {
+				if cmd, ok := elt.(*ast.CompositeLit); ok && cmd.Type == nil {
+					if name := fields(cmd)["Name"]; name != "" {
+						flags[name] = flagsOf(cmd)
+					}
+				}
+			}
+		}
+		return true
+	})
+	return flags
+}
+
+func TestMiniconfdFlagsEnvVar(t *testing.T) {
+	flags := tMiniconfdFlags(t)
+	for _, name := range []string{
+		"", "list", "info", "make", "rollback", "approve", "getv", "keys",
+		"watch", "check", "validate-backend", "init", "encrypt", "decrypt",
+		"supervise", "completion", "tour", "run",
+	} {
+		_, ok := flags[name]
+		tAssert(t, ok, "missing command ", name)
+	}
+	tAssert(t, len(flags["make"]) > 0 && len(flags["rollback"]) > 0 && len(flags["approve"]) > 0)
+
+	// --help-json prints the help only, as --help
+	delete(flags[""], "help-json")
+
+	for cmd, m := range flags {
+		for name, env := range m {
+			for _, s := range strings.Split(env, ",") {
+				tAssertf(t, strings.HasPrefix(s, "MINICONFD_"),
+					"flag %q of command %q: invalid EnvVar %q", name, cmd, env,
+				)
+			}
+		}
+	}
+}
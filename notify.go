@@ -0,0 +1,312 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The events sent to the notifiers of the template resource.
+const (
+	NotifyEventUpdated      = "updated"       // dest rewritten and reloaded
+	NotifyEventCheckFailed  = "check_failed"  // check_cmd failed, dest untouched
+	NotifyEventReloadFailed = "reload_failed" // dest rewritten, reload failed
+	NotifyEventStale        = "stale"         // values older than max_staleness, dest untouched
+
+	NotifyEventPendingApproval = "pending_approval" // dest waiting for approval
+)
+
+// DefaultNotifyTimeout is the timeout of sending a notification.
+const DefaultNotifyTimeout = 10 * time.Second
+
+// NotifyEvent is the change event sent to the notifiers.
+type NotifyEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Resource string    `json:"resource"`
+	Dest     string    `json:"dest"`
+	Hostname string    `json:"hostname"`
+	Error    string    `json:"error,omitempty"`
+
+	// the keys changed since the last render, see changedKeys
+	ChangedKeys []string `json:"changed_keys,omitempty"`
+
+	// the store lookups of the render, if debug of the resource is set
+	Trace []LookupTrace `json:"trace,omitempty"`
+}
+
+// String returns the one line message of the event.
+func (e *NotifyEvent) String() string {
+	var s string
+	switch e.Type {
+	case NotifyEventUpdated:
+		s = fmt.Sprintf("%s updated by %s", e.Dest, e.Resource)
+	case NotifyEventCheckFailed:
+		s = fmt.Sprintf("%s check failed by %s", e.Dest, e.Resource)
+	case NotifyEventReloadFailed:
+		s = fmt.Sprintf("%s reload failed by %s", e.Dest, e.Resource)
+	case NotifyEventPendingApproval:
+		s = fmt.Sprintf("%s pending approval by %s", e.Dest, e.Resource)
+	case NotifyEventStale:
+		s = fmt.Sprintf("%s not updated by %s, stale values", e.Dest, e.Resource)
+	default:
+		s = fmt.Sprintf("%s %s by %s", e.Dest, e.Type, e.Resource)
+	}
+	if e.Hostname != "" {
+		s += " on " + e.Hostname
+	}
+	if e.Error != "" {
+		s += ": " + e.Error
+	}
+	return s
+}
+
+// Notifier sends the change events of the template resources naming it
+// by notify = ["name"], the notifier is registered by WithNotifier or
+// configured in the notifiers of Config.
+type Notifier interface {
+	Notify(e *NotifyEvent) error
+}
+
+// NotifierConfig configures a builtin notifier in the config file:
+//
+//	[notifiers.ops-slack]
+//	type = "slack"
+//	url = "https://hooks.slack.com/services/..."
+//	events = ["reload_failed", "check_failed"]
+//
+//	[notifiers.audit]
+//	type = "http"
+//	url = "https://audit.example.com/confd"
+//	headers = { Authorization = "Bearer xxx" }
+//
+//	[notifiers.ops-mail]
+//	type = "email"
+//	smtp-addr = "smtp.example.com:587"
+//	from = "confd@example.com"
+//	to = ["ops@example.com"]
+//
+// The events not listed are not sent, all events are sent if empty.
+type NotifierConfig struct {
+	Type    string            `toml:"type" json:"type"` // slack/http/email
+	URL     string            `toml:"url" json:"url"`   // slack webhook or http endpoint
+	Headers map[string]string `toml:"headers" json:"headers"`
+	Events  []string          `toml:"events" json:"events"`
+
+	SMTPAddr string   `toml:"smtp-addr" json:"smtp-addr"`
+	Username string   `toml:"username" json:"username"`
+	Password string   `toml:"password" json:"password"`
+	From     string   `toml:"from" json:"from"`
+	To       []string `toml:"to" json:"to"`
+}
+
+// Valid checks the notifier config.
+func (p *NotifierConfig) Valid() error {
+	switch p.Type {
+	case "slack", "http":
+		if p.URL == "" {
+			return fmt.Errorf("missing url of %s notifier", p.Type)
+		}
+	case "email":
+		if p.SMTPAddr == "" || p.From == "" || len(p.To) == 0 {
+			return fmt.Errorf("email notifier requires smtp-addr, from and to")
+		}
+	default:
+		return fmt.Errorf("invalid type %q", p.Type)
+	}
+	for _, s := range p.Events {
+		switch s {
+		case NotifyEventUpdated, NotifyEventCheckFailed, NotifyEventReloadFailed, NotifyEventPendingApproval, NotifyEventStale:
+		default:
+			return fmt.Errorf("invalid event %q", s)
+		}
+	}
+	return nil
+}
+
+// NewNotifier creates the builtin notifier of the config.
+func (p *NotifierConfig) NewNotifier() (Notifier, error) {
+	if err := p.Valid(); err != nil {
+		return nil, err
+	}
+
+	var n Notifier
+	switch p.Type {
+	case "slack":
+		n = &SlackNotifier{WebhookURL: p.URL}
+	case "http":
+		n = &HTTPNotifier{URL: p.URL, Headers: p.Headers}
+	case "email":
+		n = &EmailNotifier{
+			Addr:     p.SMTPAddr,
+			Username: p.Username,
+			Password: p.Password,
+			From:     p.From,
+			To:       p.To,
+		}
+	}
+	if len(p.Events) > 0 {
+		n = &eventFilterNotifier{Notifier: n, events: p.Events}
+	}
+	return n, nil
+}
+
+type eventFilterNotifier struct {
+	Notifier
+	events []string
+}
+
+func (p *eventFilterNotifier) Notify(e *NotifyEvent) error {
+	if !strInStrList(e.Type, p.events) {
+		return nil
+	}
+	return p.Notifier.Notify(e)
+}
+
+// SlackNotifier posts the message of the event to the Slack incoming
+// webhook.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (p *SlackNotifier) Notify(e *NotifyEvent) error {
+	data, err := json.Marshal(map[string]string{"text": e.String()})
+	if err != nil {
+		return err
+	}
+	return postNotify(p.WebhookURL, nil, data)
+}
+
+// HTTPNotifier posts the event as JSON to the URL.
+type HTTPNotifier struct {
+	URL     string
+	Headers map[string]string
+}
+
+func (p *HTTPNotifier) Notify(e *NotifyEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return postNotify(p.URL, p.Headers, data)
+}
+
+// postNotify posts the JSON data, the status other than 2xx is an error.
+func postNotify(url string, headers map[string]string, data []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: DefaultNotifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notify %s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// EmailNotifier mails the message of the event by the SMTP server, the
+// PLAIN auth is used if Username is set.
+type EmailNotifier struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (p *EmailNotifier) Notify(e *NotifyEvent) error {
+	var auth smtp.Auth
+	if p.Username != "" {
+		host := p.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", p.Username, p.Password, host)
+	}
+	return smtp.SendMail(p.Addr, auth, p.From, p.To, p.message(e))
+}
+
+func (p *EmailNotifier) message(e *NotifyEvent) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", p.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(p.To, ", "))
+	fmt.Fprintf(&buf, "Subject: [confd] %s %s\r\n", e.Resource, e.Type)
+	fmt.Fprintf(&buf, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "\r\n%s\r\n", e.String())
+	return buf.Bytes()
+}
+
+// getNotifier returns the notifier registered by WithNotifier, or the
+// builtin notifier of the notifiers of Config.
+func (p *Config) getNotifier(name string) (Notifier, error) {
+	if n, ok := p.NotifierPlugins[name]; ok {
+		return n, nil
+	}
+	if c, ok := p.Notifiers[name]; ok {
+		return c.NewNotifier()
+	}
+	return nil, fmt.Errorf("notifier %q not found", name)
+}
+
+// notify sends the event to the notifiers of the template resource, the
+// failures are logged and never fail the sync.
+func (p *TemplateResourceProcessor) notify(call *Call, typ string, err error) {
+	if len(p.Notify) == 0 {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	e := &NotifyEvent{
+		Time:     time.Now(),
+		Type:     typ,
+		Resource: filepath.Base(p.path),
+		Dest:     p.Dest,
+		Hostname: hostname,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	for _, c := range p.lastChanges {
+		e.ChangedKeys = append(e.ChangedKeys, c.Key)
+	}
+	if p.Debug {
+		e.Trace = p.lastTrace
+	}
+
+	for _, name := range p.Notify {
+		n, err := call.Config.getNotifier(name)
+		if err != nil {
+			p.logger.Warning(err)
+			continue
+		}
+		if err := n.Notify(e); err != nil {
+			GetMetrics().Inc(fmt.Sprintf("libconfd_notify_errors_total{notifier=%q}", name))
+			p.logger.Warningf("notify %s failed: %v", name, err)
+		}
+	}
+}
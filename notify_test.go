@@ -0,0 +1,119 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tNotifier []*NotifyEvent
+
+func (p *tNotifier) Notify(e *NotifyEvent) error {
+	*p = append(*p, e)
+	return nil
+}
+
+func TestNotifiers(t *testing.T) {
+	var bodies []string
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		authorization = r.Header.Get("Authorization")
+		if r.URL.Path == "/fail" {
+			http.Error(w, "bad token", http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+
+	e := &NotifyEvent{
+		Type:     NotifyEventReloadFailed,
+		Resource: "nginx.toml",
+		Dest:     "/etc/nginx/nginx.conf",
+		Hostname: "web1",
+		Error:    "exit status 1",
+	}
+	tAssert(t, e.String() == "/etc/nginx/nginx.conf reload failed by nginx.toml on web1: exit status 1", e.String())
+
+	slack, err := (&NotifierConfig{Type: "slack", URL: ts.URL}).NewNotifier()
+	tAssert(t, err == nil, err)
+	tAssert(t, slack.Notify(e) == nil)
+	tAssert(t, len(bodies) == 1 && strings.Contains(bodies[0], `"text":"/etc/nginx/nginx.conf reload failed`), bodies)
+
+	hook, err := (&NotifierConfig{
+		Type:    "http",
+		URL:     ts.URL,
+		Headers: map[string]string{"Authorization": "Bearer x"},
+	}).NewNotifier()
+	tAssert(t, err == nil, err)
+	tAssert(t, hook.Notify(e) == nil)
+	tAssert(t, authorization == "Bearer x", authorization)
+
+	var got NotifyEvent
+	tAssert(t, json.Unmarshal([]byte(bodies[1]), &got) == nil, bodies[1])
+	tAssert(t, got.Type == NotifyEventReloadFailed && got.Error == "exit status 1", got)
+
+	// the events not listed are dropped
+	filtered, err := (&NotifierConfig{Type: "http", URL: ts.URL, Events: []string{NotifyEventUpdated}}).NewNotifier()
+	tAssert(t, err == nil, err)
+	tAssert(t, filtered.Notify(e) == nil)
+	tAssert(t, len(bodies) == 2, bodies)
+
+	err = (&HTTPNotifier{URL: ts.URL + "/fail"}).Notify(e)
+	tAssert(t, err != nil && strings.Contains(err.Error(), "bad token"), err)
+
+	msg := string((&EmailNotifier{From: "confd@example.com", To: []string{"ops@example.com"}}).message(e))
+	tAssert(t, strings.Contains(msg, "Subject: [confd] nginx.toml reload_failed\r\n"), msg)
+
+	for _, c := range []NotifierConfig{
+		{Type: "pager"},
+		{Type: "slack"},
+		{Type: "email", SMTPAddr: "smtp.example.com:25"},
+		{Type: "http", URL: ts.URL, Events: []string{"deleted"}},
+	} {
+		tAssert(t, c.Valid() != nil, c)
+	}
+}
+
+func TestTemplateResourceNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-notify-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/port"}}`), 0644) == nil)
+
+	var ops tNotifier
+	cfg := newDefaultConfig().applyOptions(WithNotifier("ops", &ops))
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := mapBackendClient{"/app/port": "8080"}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:    src,
+		Dest:   dest,
+		Prefix: "/app",
+		Keys:   []string{"/port"},
+		Notify: []string{"ops", "missing"},
+	})
+	tAssert(t, err == nil, err)
+	call := &Call{Config: cfg, Client: client}
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, len(ops) == 1, ops)
+	tAssert(t, ops[0].Type == NotifyEventUpdated && ops[0].Resource == "app.toml" && ops[0].Dest == dest, ops[0])
+	tAssert(t, strings.Join(ops[0].ChangedKeys, ",") == "/port", ops[0].ChangedKeys)
+
+	// dest in sync, nothing sent
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, len(ops) == 1, ops)
+}
@@ -5,6 +5,7 @@
 package libconfd
 
 import (
+	"io/fs"
 	"text/template"
 )
 
@@ -43,6 +44,75 @@ func WithWatchMode() Options {
 	}
 }
 
+func WithMaxValueSize(n int) Options {
+	return func(opt *Config) {
+		opt.MaxValueSize = n
+	}
+}
+
+func WithMaxStoreSize(n int) Options {
+	return func(opt *Config) {
+		opt.MaxStoreSize = n
+	}
+}
+
+func WithSnapshotFile(path string) Options {
+	return func(opt *Config) {
+		opt.SnapshotFile = path
+	}
+}
+
+func WithOfflineMode() Options {
+	return func(opt *Config) {
+		opt.Offline = true
+	}
+}
+
+func WithStartStale() Options {
+	return func(opt *Config) {
+		opt.StartStale = true
+	}
+}
+
+func WithBackendCallTimeout(seconds int) Options {
+	return func(opt *Config) {
+		opt.BackendCallTimeout = seconds
+	}
+}
+
+func WithLogLevelFor(component, level string) Options {
+	return func(opt *Config) {
+		if opt.LogLevels == nil {
+			opt.LogLevels = make(map[string]string)
+		}
+		opt.LogLevels[component] = level
+	}
+}
+
+func WithVerbosity(v int) Options {
+	return func(opt *Config) {
+		opt.Verbosity = v
+	}
+}
+
+func WithLogRepeatInterval(interval int) Options {
+	return func(opt *Config) {
+		opt.LogRepeatInterval = interval
+	}
+}
+
+func WithRedactKeys(patterns ...string) Options {
+	return func(opt *Config) {
+		opt.RedactKeys = append(opt.RedactKeys, patterns...)
+	}
+}
+
+func WithIgnoreKeyCase() Options {
+	return func(opt *Config) {
+		opt.IgnoreKeyCase = true
+	}
+}
+
 func WithFuncMap(maps ...template.FuncMap) Options {
 	return func(opt *Config) {
 		if opt.FuncMap == nil {
@@ -85,3 +155,178 @@ func WithHookOnError(fn func(trName string, err error)) Options {
 		opt.HookOnError = fn
 	}
 }
+
+func WithHookOnCommand(fn func(trName string, result *CommandResult)) Options {
+	return func(opt *Config) {
+		opt.HookOnCommand = fn
+	}
+}
+
+func WithHookSet(name string, hooks HookSet) Options {
+	return func(opt *Config) {
+		if opt.HookSets == nil {
+			opt.HookSets = make(map[string]HookSet)
+		}
+		opt.HookSets[name] = hooks
+	}
+}
+
+func WithPublisher(name string, pub Publisher) Options {
+	return func(opt *Config) {
+		if opt.PublisherPlugins == nil {
+			opt.PublisherPlugins = make(map[string]Publisher)
+		}
+		opt.PublisherPlugins[name] = pub
+	}
+}
+
+func WithNotifier(name string, n Notifier) Options {
+	return func(opt *Config) {
+		if opt.NotifierPlugins == nil {
+			opt.NotifierPlugins = make(map[string]Notifier)
+		}
+		opt.NotifierPlugins[name] = n
+	}
+}
+
+func WithCommandRunner(runner CommandRunner) Options {
+	return func(opt *Config) {
+		opt.CommandRunner = runner
+	}
+}
+
+func WithShell(shell ...string) Options {
+	return func(opt *Config) {
+		opt.Shell = append([]string{}, shell...)
+	}
+}
+
+func WithDNSServer(server string, timeout int) Options {
+	return func(opt *Config) {
+		opt.DNSServer = server
+		opt.DNSTimeout = timeout
+	}
+}
+
+func WithFuncProfile(profile string) Options {
+	return func(opt *Config) {
+		opt.FuncProfile = profile
+	}
+}
+
+func WithAESKeyFile(path string) Options {
+	return func(opt *Config) {
+		opt.CryptMode = CryptModeAESGCM
+		opt.AESKeyFile = path
+	}
+}
+
+func WithAESKeyProvider(fn func() ([]byte, error)) Options {
+	return func(opt *Config) {
+		opt.CryptMode = CryptModeAESGCM
+		opt.AESKeyProvider = fn
+	}
+}
+
+func WithMetricsPusher(name string, pusher MetricsPusher) Options {
+	return func(opt *Config) {
+		if opt.MetricsPusherPlugins == nil {
+			opt.MetricsPusherPlugins = make(map[string]MetricsPusher)
+		}
+		opt.MetricsPusherPlugins[name] = pusher
+	}
+}
+
+func WithNamedBackend(name string, client BackendClient) Options {
+	return func(opt *Config) {
+		if opt.NamedBackends == nil {
+			opt.NamedBackends = make(map[string]BackendClient)
+		}
+		opt.NamedBackends[name] = client
+	}
+}
+
+func WithConfFS(fsys fs.FS) Options {
+	return func(opt *Config) {
+		opt.ConfFS = fsys
+	}
+}
+
+func WithTemplateFS(fsys fs.FS) Options {
+	return func(opt *Config) {
+		opt.TemplateFS = fsys
+	}
+}
+
+func WithBackendLayers(names ...string) Options {
+	return func(opt *Config) {
+		opt.BackendLayers = names
+	}
+}
+
+func WithHookOnUpdated(fn func(trName, dest string)) Options {
+	return func(opt *Config) {
+		opt.HookOnUpdated = fn
+	}
+}
+
+func WithLockDest(timeout int) Options {
+	return func(opt *Config) {
+		opt.LockDest = true
+		opt.LockTimeout = timeout
+	}
+}
+
+func WithStageDir(dir string) Options {
+	return func(opt *Config) {
+		opt.StageDir = dir
+	}
+}
+
+func WithDestRoot(dir string) Options {
+	return func(opt *Config) {
+		opt.DestRoot = dir
+	}
+}
+
+func WithRenderCacheDir(dir string) Options {
+	return func(opt *Config) {
+		opt.RenderCacheDir = dir
+	}
+}
+
+func WithStopGracePeriod(seconds int) Options {
+	return func(opt *Config) {
+		opt.StopGracePeriod = seconds
+	}
+}
+
+func WithWatchMaxRestarts(n int) Options {
+	return func(opt *Config) {
+		opt.WatchMaxRestarts = n
+	}
+}
+
+func WithStatusPrefix(prefix string) Options {
+	return func(opt *Config) {
+		opt.StatusPrefix = prefix
+	}
+}
+
+func WithMaxCommandOutput(n int) Options {
+	return func(opt *Config) {
+		opt.MaxCommandOutput = n
+	}
+}
+
+func WithAuditLog(filename string) Options {
+	return func(opt *Config) {
+		opt.AuditLog = filename
+	}
+}
+
+func WithLogHook(level string, fn func(entry LogEntry)) Options {
+	return func(opt *Config) {
+		opt.LogHooks = append(opt.LogHooks, LogHook{Level: level, Fn: fn})
+	}
+}
@@ -6,8 +6,12 @@
 package libconfd
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +20,44 @@ type Call struct {
 	Client BackendClient
 	Error  error
 	Done   chan *Call
+
+	reload chan *Call          // new config and client sent by Processor.Reload
+	stale  bool                // the backend was unavailable at start
+	cycle  *cycleBackendClient // GetValues results shared in the current cycle
+
+	ctx context.Context // canceled by Processor.Stop, cancels the backend calls
+
+	grouped bool   // the call runs the resources of group only
+	group   string // see Config.Groups
+
+	holds     *resourceHolds     // the resources rolled back by Processor.Rollback
+	approvals *resourceApprovals // the tokens given by Processor.Approve
+	readOnly  *int32             // set by Processor.SetReadOnly
+	renders   *renderLimiter     // see Config.Concurrency, shared by the groups of the call
+	limiter   *rateLimiter       // see Config.BackendRateLimit, shared by the groups of the call
+	aesKey    []byte             // resolved once by newCall, see Config.CryptMode
+}
+
+// context returns the context of the backend calls of the call.
+func (call *Call) context() context.Context {
+	if call.ctx == nil {
+		return context.Background()
+	}
+	return call.ctx
+}
+
+// backendContext returns the context of a GetValues call, bound to the
+// deadline of backend-call-timeout.
+func (call *Call) backendContext() (context.Context, context.CancelFunc) {
+	if call.Config != nil && call.Config.BackendCallTimeout > 0 {
+		return context.WithTimeout(call.context(), time.Duration(call.Config.BackendCallTimeout)*time.Second)
+	}
+	return context.WithCancel(call.context())
+}
+
+// isReadOnly reports whether the target files must not be modified.
+func (call *Call) isReadOnly() bool {
+	return call.readOnly != nil && atomic.LoadInt32(call.readOnly) != 0
 }
 
 func (call *Call) done() {
@@ -25,7 +67,7 @@ func (call *Call) done() {
 	default:
 		// We don't want to block here. It is the caller's responsibility to make
 		// sure the channel has enough buffer space. See comment in Go().
-		logger.Debugln("libconfd: discarding Call reply due to insufficient Done chan capacity")
+		processorLogger.Debugln("libconfd: discarding Call reply due to insufficient Done chan capacity")
 	}
 }
 
@@ -33,13 +75,43 @@ type Processor struct {
 	pendingMutex sync.Mutex
 	pending      []*Call
 
+	runningMutex sync.Mutex
+	running      []*Call
+
 	closeChan chan bool
+	closeOnce sync.Once
+	ctx       context.Context // canceled with closeChan
+	cancel    context.CancelFunc
 	wg        sync.WaitGroup
+
+	degraded    int32
+	readOnly    int32
+	gracePeriod int64 // the max StopGracePeriod of the calls, in nanoseconds
+
+	holds     *resourceHolds
+	approvals *resourceApprovals
+
+	watchersMutex sync.Mutex
+	watchers      map[string]*WatcherStatus // by the path of the resource
+
+	publishMutex sync.Mutex
+	pushMutex    sync.Mutex
+
+	clientsMutex sync.Mutex
+	clients      []BackendClient // closed by Stop, see closeBackendClient
 }
 
+// DefaultStopGracePeriod is the time Processor.Stop waits for the target
+// file syncs and reload commands in progress, if stop-grace-period is not set.
+const DefaultStopGracePeriod = 30 * time.Second
+
+// errProcessorStopped is returned by the template resources interrupted
+// by Processor.Stop before their target files are touched.
+var errProcessorStopped = errors.New("libconfd: processor is stopped")
+
 func (p *Processor) isClosing() bool {
 	if p.closeChan == nil {
-		logger.Panic("closeChan is nil")
+		processorLogger.Panic("closeChan is nil")
 	}
 	select {
 	case <-p.closeChan:
@@ -79,14 +151,95 @@ func (p *Processor) clearPendingCall() {
 	p.pending = p.pending[:0]
 }
 
-func (p *Processor) checkBackendClient(client BackendClient) error {
-	_, err := client.GetValues([]string{"/"})
-	return err
+func (p *Processor) addRunningCall(call *Call) {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+
+	p.running = append(p.running, call)
+}
+func (p *Processor) removeRunningCall(call *Call) {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+
+	for i, x := range p.running {
+		if x == call {
+			p.running = append(p.running[:i], p.running[i+1:]...)
+			return
+		}
+	}
+}
+
+// runningCallOf returns a call of the config and client of the running
+// call owning the template resource name, with the resource and its path.
+// The reloads replace the config and client of the running calls under
+// runningMutex.
+func (p *Processor) runningCallOf(name string) (*Call, *TemplateResource, string, error) {
+	type runningCall struct {
+		grouped bool
+		group   string
+		cfg     *Config
+		client  BackendClient
+		aesKey  []byte
+	}
+
+	p.runningMutex.Lock()
+	calls := make([]runningCall, 0, len(p.running))
+	for _, x := range p.running {
+		calls = append(calls, runningCall{x.grouped, x.group, x.Config, x.Client, x.aesKey})
+	}
+	p.runningMutex.Unlock()
+
+	if len(calls) == 0 {
+		return nil, nil, "", fmt.Errorf("libconfd: no running call")
+	}
+	for _, x := range calls {
+		path := resourcePathOf(x.cfg, name)
+		res, err := LoadTemplateResourceFile(x.cfg.ConfDir, path)
+		if err != nil {
+			continue
+		}
+		if x.grouped {
+			group := res.Group
+			if _, ok := x.cfg.Groups[group]; !ok {
+				group = ""
+			}
+			if group != x.group {
+				continue
+			}
+		}
+		call := &Call{
+			Config:    x.cfg,
+			Client:    x.client,
+			ctx:       p.ctx,
+			holds:     p.holds,
+			approvals: p.approvals,
+			readOnly:  &p.readOnly,
+			aesKey:    x.aesKey,
+		}
+		return call, res, path, nil
+	}
+	return nil, nil, "", fmt.Errorf("libconfd: no running call of %s", name)
+}
+
+// checkBackendClient gets the root of the backend of the call, canceled
+// as the other backend calls.
+func (p *Processor) checkBackendClient(call *Call) error {
+	ctx, cancel := call.backendContext()
+	defer cancel()
+
+	_, _, err := getValuesContext(ctx, call.Client, []string{"/"})
+	return contextError(ctx, err)
 }
 
 func NewProcessor() *Processor {
+	ctx, cancel := context.WithCancel(context.Background())
 	p := &Processor{
 		closeChan: make(chan bool),
+		ctx:       ctx,
+		cancel:    cancel,
+		holds:     newResourceHolds(),
+		approvals: newResourceApprovals(),
+		watchers:  make(map[string]*WatcherStatus),
 	}
 
 	p.wg.Add(1)
@@ -107,8 +260,8 @@ func NewProcessor() *Processor {
 
 			p.wg.Add(1)
 			go func() {
-				logger.Debugln("process start")
-				defer logger.Debugln("process done")
+				processorLogger.Debugln("process start")
+				defer processorLogger.Debugln("process done")
 
 				defer p.wg.Done()
 				defer call.done()
@@ -122,32 +275,117 @@ func NewProcessor() *Processor {
 }
 
 func (p *Processor) Go(cfg *Config, client BackendClient, opts ...Options) *Call {
+	call, err := p.newCall(cfg, client, opts...)
+	if err != nil {
+		call.Error = err
+		call.done()
+		return call
+	}
+
+	p.setGracePeriod(call.Config)
+	p.addPendingCall(call)
+	return call
+}
+
+// Reload sends the new config and client to the calls running in
+// interval or watch mode, the template resources are reloaded without
+// restarting the calls. In watch mode, the watches of the unchanged
+// template resources are kept if only the resources changed.
+// The hooks and the FuncMap of the kept watches are not updated.
+func (p *Processor) Reload(cfg *Config, client BackendClient, opts ...Options) error {
+	call, err := p.newCall(cfg, client, opts...)
+	if err != nil {
+		return err
+	}
+
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+
+	for _, x := range p.running {
+		// drop the pending reload not handled yet
+		select {
+		case <-x.reload:
+		default:
+		}
+		x.reload <- call
+	}
+	return nil
+}
+
+// newCall makes a call with the config and client, and applies the
+// log settings of the config.
+func (p *Processor) newCall(cfg *Config, client BackendClient, opts ...Options) (*Call, error) {
 	if client == nil {
-		logger.Panic("client is nil")
+		processorLogger.Panic("client is nil")
 	}
 
 	call := new(Call)
 
 	call.Config = cfg.Clone().applyOptions(opts...)
+	p.addClient(client)
+	for _, c := range call.Config.NamedBackends {
+		p.addClient(c)
+	}
 	call.Client = client
 	call.Done = make(chan *Call, 10) // buffered.
+	call.reload = make(chan *Call, 1)
+	call.ctx = p.ctx
+	call.holds = p.holds
+	call.approvals = p.approvals
+	call.readOnly = &p.readOnly
+
+	if err := call.Config.Valid(); err != nil {
+		return call, err
+	}
 
-	if err := cfg.Valid(); err != nil {
-		call.Error = err
-		call.done()
-		return call
+	// the key is resolved once, the template resources of the call get
+	// it from the call
+	key, err := call.Config.resolveAESKey()
+	if err != nil {
+		return call, err
+	}
+	call.aesKey = key
+
+	if call.Config.Offline {
+		call.Client = p.getSnapshot(call.Config.SnapshotFile)
+	} else if len(call.Config.BackendLayers) > 0 {
+		layered, err := newLayeredBackendClient(call.Client, call.Config.BackendLayers, call.Config.NamedBackends)
+		if err != nil {
+			return call, err
+		}
+		call.Client = layered
+	}
+
+	call.renders = newRenderLimiter()
+	call.renders.setLimit(call.Config.Concurrency)
+	call.limiter = newRateLimiter(call.Config.BackendRateLimit, call.Config.BackendRateBurst)
+	if call.Config.BackendRateLimit > 0 {
+		call.Client = newRateLimitedClient(call.Client, call.limiter)
 	}
 
 	logger.SetLevel(cfg.LogLevel)
+	logger.SetVerbosity(call.Config.Verbosity)
+	for component, level := range call.Config.LogLevels {
+		SetLevelFor(component, level)
+	}
+	SetLogRepeatInterval(time.Duration(call.Config.LogRepeatInterval) * time.Second)
+	if len(call.Config.LogHooks) > 0 {
+		SetLogHooks(call.Config.LogHooks...)
+	}
 
-	if err := p.checkBackendClient(client); err != nil {
-		call.Error = err
-		call.done()
-		return call
+	if err := p.checkBackendClient(call); err != nil {
+		if !call.Config.StartStale || call.Config.Onetime {
+			return call, err
+		}
+		processorLogger.Warning("backend unavailable, start stale: ", err)
+		call.stale = true
 	}
 
-	p.addPendingCall(call)
-	return call
+	if !call.Config.Offline && call.Config.SnapshotFile != "" {
+		call.Client = newSnapshotRecorder(call.Client, p.getSnapshot(call.Config.SnapshotFile))
+	}
+
+	return call, nil
 }
 
 func (p *Processor) Run(cfg *Config, client BackendClient, opts ...Options) error {
@@ -155,7 +393,7 @@ func (p *Processor) Run(cfg *Config, client BackendClient, opts ...Options) erro
 		return err
 	}
 	if client == nil {
-		logger.Panic("client is nil")
+		processorLogger.Panic("client is nil")
 	}
 
 	logger.SetLevel(cfg.LogLevel)
@@ -164,48 +402,269 @@ func (p *Processor) Run(cfg *Config, client BackendClient, opts ...Options) erro
 	return call.Error
 }
 
+// Stop stops the processor: the pending calls fail and the backend
+// calls in progress are canceled at once, but the target file syncs and
+// reload commands in progress are waited for, so the target files are
+// never left half-written. It waits at most the largest StopGracePeriod
+// of the calls, and returns an error if they are not finished in time.
+// The backend clients of the calls implementing io.Closer are closed.
+func (p *Processor) Stop() error {
+	p.closeOnce.Do(func() {
+		close(p.closeChan)
+		p.cancel()
+	})
+
+	done := make(chan bool)
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	grace := time.Duration(atomic.LoadInt64(&p.gracePeriod))
+	if grace <= 0 {
+		grace = DefaultStopGracePeriod
+	}
+	select {
+	case <-done:
+		p.closeClients()
+		return nil
+	case <-time.After(grace):
+		processorLogger.Warningf("processor not stopped in %v, give up waiting", grace)
+		p.closeClients()
+		return fmt.Errorf("libconfd: processor not stopped in %v", grace)
+	}
+}
+
+// sameBackendClient reports whether a and b are the same client, the
+// clients of the uncomparable types are never the same.
+func sameBackendClient(a, b BackendClient) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// addClient keeps the client of a call, closed by Stop.
+func (p *Processor) addClient(client BackendClient) {
+	p.clientsMutex.Lock()
+	defer p.clientsMutex.Unlock()
+
+	// the clients of the uncomparable types, such as mapBackendClient,
+	// are skipped, the clients holding connections are pointers
+	if !reflect.TypeOf(client).Comparable() {
+		return
+	}
+	for _, c := range p.clients {
+		if sameBackendClient(c, client) {
+			return
+		}
+	}
+	p.clients = append(p.clients, client)
+}
+
+// getSnapshot returns the snapshot of the file shared by the calls, the
+// file is kept open until Stop.
+func (p *Processor) getSnapshot(dbFile string) *SnapshotBackend {
+	p.clientsMutex.Lock()
+	defer p.clientsMutex.Unlock()
+
+	for _, c := range p.clients {
+		if x, ok := c.(*SnapshotBackend); ok && x.DBFile == dbFile {
+			return x
+		}
+	}
+	x := NewSnapshotBackendClient(dbFile)
+	p.clients = append(p.clients, x)
+	return x
+}
+
+// closeClients closes the backend clients of the calls, the errors are
+// logged only.
+func (p *Processor) closeClients() {
+	p.clientsMutex.Lock()
+	clients := p.clients
+	p.clients = nil
+	p.clientsMutex.Unlock()
+
+	for _, c := range clients {
+		if err := closeBackendClient(c); err != nil {
+			processorLogger.Warningf("close backend %s failed: %v", c.Type(), err)
+		}
+	}
+}
+
+// Close is the same as Stop.
 func (p *Processor) Close() error {
-	close(p.closeChan)
-	p.wg.Wait()
-	return nil
+	return p.Stop()
+}
+
+// setGracePeriod keeps the largest StopGracePeriod of the calls.
+func (p *Processor) setGracePeriod(cfg *Config) {
+	grace := int64(time.Duration(cfg.StopGracePeriod) * time.Second)
+	for {
+		old := atomic.LoadInt64(&p.gracePeriod)
+		if grace <= old || atomic.CompareAndSwapInt64(&p.gracePeriod, old, grace) {
+			return
+		}
+	}
 }
 
 func (p *Processor) process(call *Call) {
-	switch {
-	case call.Config.Onetime:
+	if call.Config.Onetime {
 		p.runOnce(call)
-	case call.Config.Watch:
+		return
+	}
+
+	if call.stale && !p.waitBackendClient(call) {
+		return
+	}
+
+	if len(call.Config.Groups) > 0 && !call.grouped {
+		p.runGroups(call)
+		return
+	}
+
+	p.addRunningCall(call)
+	defer p.removeRunningCall(call)
+
+	if call.Config.Watch {
 		p.runInWatchMode(call)
-	default:
+	} else {
 		p.runInIntervalMode(call)
 	}
 }
 
+// Degraded reports whether the processor is waiting for the backend,
+// which was unavailable at start, see Config.StartStale.
+func (p *Processor) Degraded() bool {
+	return atomic.LoadInt32(&p.degraded) != 0
+}
+
+// SetReadOnly switches the read-only mode of all the template resources
+// at runtime, they are rendered and compared like noop, but the target
+// files are not modified and no command is run.
+func (p *Processor) SetReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+	if atomic.SwapInt32(&p.readOnly, v) != v {
+		processorLogger.Infof("read-only mode: %v", readOnly)
+	}
+	GetMetrics().Set("libconfd_read_only", int64(v))
+}
+
+// ReadOnly reports whether the read-only mode is set by SetReadOnly.
+func (p *Processor) ReadOnly() bool {
+	return atomic.LoadInt32(&p.readOnly) != 0
+}
+
+func (p *Processor) setDegraded(degraded bool) {
+	var v int32
+	if degraded {
+		v = 1
+	}
+	atomic.StoreInt32(&p.degraded, v)
+	GetMetrics().Set("libconfd_degraded", int64(v))
+}
+
+// waitBackendClient retries the backend of the stale call every interval
+// until it is available, no target file is touched before that.
+// It returns false if the processor is closed.
+func (p *Processor) waitBackendClient(call *Call) bool {
+	p.setDegraded(true)
+
+	interval := time.Duration(call.Config.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	for {
+		select {
+		case <-time.After(interval):
+		case <-p.closeChan:
+			return false
+		}
+
+		if err := p.checkBackendClient(call); err != nil {
+			processorLogger.Warning("backend unavailable, retry later: ", err)
+			continue
+		}
+
+		processorLogger.Info("backend available, leave stale mode")
+		p.setDegraded(false)
+		return true
+	}
+}
+
 func (p *Processor) runOnce(call *Call) {
-	ts, err := MakeAllTemplateResourceProcessor(call.Config, call.Client)
+	ts, err := makeAllTemplateResourceProcessor(call.Config, call.Client, call.aesKey)
 	if err != nil {
-		logger.Error(err)
+		processorLogger.Error(err)
 		call.Error = err
 		return
 	}
 
-	for _, t := range ts {
-		if p.isClosing() {
+	call.cycle = newCycleBackendClient(call.Client)
+	defer func() { call.cycle = nil }()
+
+	updated := p.processAll(call, ts)
+	p.pushMetrics(call)
+	if p.isClosing() {
+		return
+	}
+	if updated {
+		p.publishBundle(call)
+	}
+
+	return
+}
+
+// processAll processes the template resources of a cycle in the order of
+// the priority, at most Config.Concurrency of them at the same time. It
+// reports whether a target file is updated.
+func (p *Processor) processAll(call *Call, ts []*TemplateResourceProcessor) bool {
+	var wg sync.WaitGroup
+	var updated int32
+
+	process := func(t *TemplateResourceProcessor) {
+		defer call.renders.release()
+
+		if err := t.Process(call); err != nil {
+			withLogFields(processorLogger, t.logFields()...).Error(err)
 			return
 		}
+		if t.updated {
+			atomic.StoreInt32(&updated, 1)
+		}
+	}
 
-		if err := t.Process(call); err != nil {
-			logger.Error(err)
+	for _, t := range ts {
+		if p.isClosing() || !call.renders.acquire(t.Priority, p.closeChan) {
+			break
+		}
+		if call.Config.Concurrency <= 1 {
+			process(t)
+			continue
 		}
+		wg.Add(1)
+		go func(t *TemplateResourceProcessor) {
+			defer wg.Done()
+			process(t)
+		}(t)
 	}
 
-	return
+	wg.Wait()
+	return atomic.LoadInt32(&updated) != 0
 }
 
 func (p *Processor) runInIntervalMode(call *Call) {
-	ts, err := MakeAllTemplateResourceProcessor(call.Config, call.Client)
+	ts, err := p.makeTemplateResourceProcessor(call, call)
 	if err != nil {
-		logger.Warning(err)
+		processorLogger.Warning(err)
 		call.Error = err
 		return
 	}
@@ -215,44 +674,132 @@ func (p *Processor) runInIntervalMode(call *Call) {
 			return
 		}
 
-		for _, t := range ts {
-			if p.isClosing() {
-				return
-			}
+		call.cycle = newCycleBackendClient(call.Client)
+		updated := p.processAll(call, ts)
+		call.cycle = nil
+		p.pushMetrics(call)
+		if p.isClosing() {
+			return
+		}
+		if updated {
+			p.publishBundle(call)
+		}
 
-			if err := t.Process(call); err != nil {
-				logger.Error(err)
+		select {
+		case <-time.After(time.Duration(call.Config.Interval) * time.Second):
+		case <-p.closeChan:
+			return
+		case r := <-call.reload:
+			r = groupReload(call, r)
+			newTs, err := p.makeTemplateResourceProcessor(call, r)
+			if err != nil {
+				processorLogger.Error("reload failed: ", err)
 				continue
 			}
+			p.runningMutex.Lock()
+			call.Config, call.Client, call.limiter, call.aesKey = r.Config, r.Client, r.limiter, r.aesKey
+			p.runningMutex.Unlock()
+			call.renders.setLimit(call.Config.Concurrency)
+			ts = newTs
+			processorLogger.Infof("reloaded %d template resources", len(ts))
 		}
-
-		time.Sleep(time.Duration(call.Config.Interval) * time.Second)
 	}
 }
 
+// watchMonitor is a template resource watched by monitorPrefix.
+type watchMonitor struct {
+	t        *TemplateResourceProcessor
+	stopChan chan bool
+	status   *WatcherStatus
+}
+
 func (p *Processor) runInWatchMode(call *Call) {
-	ts, err := MakeAllTemplateResourceProcessor(call.Config, call.Client)
+	ts, err := p.makeTemplateResourceProcessor(call, call)
 	if err != nil {
-		logger.Warning(err)
+		processorLogger.Warning(err)
 		return
 	}
 
 	var wg sync.WaitGroup
-	var stopChan = make(chan bool)
+	var monitors = make(map[string]*watchMonitor)
+
+	renders := call.renders
+	start := func(t *TemplateResourceProcessor, call *Call) {
+		call.ctx = p.ctx
+		call.renders = renders
+		call.holds = p.holds
+		call.approvals = p.approvals
+		call.readOnly = &p.readOnly
+		m := &watchMonitor{t: t, stopChan: make(chan bool)}
+		monitors[t.path] = m
+		p.addWatcher(m)
 
-	for i := 0; i < len(ts); i++ {
 		wg.Add(1)
-		go func(t *TemplateResourceProcessor) {
+		go func() {
 			defer wg.Done()
-			p.monitorPrefix(t, &wg, stopChan, call)
-		}(ts[i])
+			p.superviseMonitor(m, call)
+		}()
+	}
+	stop := func(m *watchMonitor) {
+		close(m.stopChan)
+		delete(monitors, m.t.path)
+		p.removeWatcher(m)
+	}
+
+	// the monitors have their own calls, call is updated by reload
+	for i := 0; i < len(ts); i++ {
+		start(ts[i], &Call{Config: call.Config, Client: call.Client})
 	}
 
 	for {
-		time.Sleep(time.Second / 2)
+		select {
+		case <-time.After(time.Second / 2):
+		case <-p.closeChan:
+		case r := <-call.reload:
+			r = groupReload(call, r)
+			newTs, err := p.makeTemplateResourceProcessor(call, r)
+			if err != nil {
+				processorLogger.Error("reload failed: ", err)
+				continue
+			}
+
+			restartAll := !sameBackendClient(r.Client, call.Client) || !r.Config.sameProcessConfig(call.Config)
+			p.runningMutex.Lock()
+			call.Config, call.Client, call.limiter, call.aesKey = r.Config, r.Client, r.limiter, r.aesKey
+			p.runningMutex.Unlock()
+			call.renders.setLimit(call.Config.Concurrency)
+
+			var kept, started, stopped int
+			newPaths := make(map[string]bool)
+			for _, t := range newTs {
+				newPaths[t.path] = true
+				if m, ok := monitors[t.path]; ok {
+					if !restartAll && reflect.DeepEqual(m.t.TemplateResource, t.TemplateResource) {
+						kept++
+						continue
+					}
+					stop(m)
+					stopped++
+				}
+				start(t, &Call{Config: r.Config, Client: r.Client})
+				started++
+			}
+			for path, m := range monitors {
+				if !newPaths[path] {
+					stop(m)
+					stopped++
+				}
+			}
+
+			processorLogger.Infof("reloaded template resources: %d kept, %d started, %d stopped",
+				kept, started, stopped,
+			)
+		}
 
 		if p.isClosing() {
-			close(stopChan)
+			for _, m := range monitors {
+				stop(m)
+			}
 			break
 		}
 	}
@@ -261,26 +808,85 @@ func (p *Processor) runInWatchMode(call *Call) {
 	return
 }
 
+// monitorPrefix renders the template resource whenever its keys change,
+// until stopChan or the processor is closed. It returns the error of the
+// watch, the watcher is restarted by superviseMonitor.
 func (p *Processor) monitorPrefix(
 	t *TemplateResourceProcessor,
-	wg *sync.WaitGroup, stopChan chan bool,
+	stopChan chan bool,
 	call *Call,
-) {
-	keys := t.getAbsKeys()
+	onWatch func(),
+) error {
+	keys := t.getWatchKeys()
+
+	ctx, cancel := contextWithStop(call.context(), stopChan)
+	defer cancel()
+
+	if c, ok := getEventWatchClient(t.client); ok {
+		return p.monitorEvents(ctx, c, t, keys, stopChan, call, onWatch)
+	}
 
 	for {
-		if p.isClosing() {
-			return
+		if p.isClosing() || ctx.Err() != nil {
+			return nil
 		}
 
-		index, err := t.client.WatchPrefix(t.Prefix, keys, t.lastIndex, stopChan)
+		index, err := t.watchKeys(ctx, keys)
+		if ctx.Err() != nil {
+			return nil
+		}
 		if err != nil {
-			logger.Error(err)
+			return err
 		}
+		onWatch()
 
 		t.lastIndex = index
-		if err := t.Process(call); err != nil {
-			logger.Error(err)
+		if !p.renderWatched(t, stopChan, call) {
+			return nil
 		}
 	}
 }
+
+// renderWatched renders the watched template resource, it returns false
+// if stopped before the render.
+func (p *Processor) renderWatched(t *TemplateResourceProcessor, stopChan chan bool, call *Call) bool {
+	if !call.renders.acquire(t.Priority, stopChan) {
+		return false
+	}
+	err := t.Process(call)
+	call.renders.release()
+	if err != nil {
+		withLogFields(processorLogger, t.logFields()...).Error(err)
+	}
+	p.pushMetrics(call)
+	if t.updated {
+		p.publishBundle(call)
+	}
+	return true
+}
+
+// watchKeys waits for the changes of the keys until ctx is done, by the
+// exact keys if WatchKeysExact is set and the backend supports it.
+func (t *TemplateResourceProcessor) watchKeys(ctx context.Context, keys []string) (uint64, error) {
+	if t.WatchKeysExact {
+		if c, ok := getExactWatchClient(t.client); ok {
+			stopChan, cancel := stopChanOf(ctx)
+			defer cancel()
+
+			index, err := c.WatchKeys(t.getWatchPrefix(), keys, t.lastIndex, stopChan)
+			return index, backendError(err)
+		}
+		t.logger.Debug("exact watch not supported by backend ", t.client.Type())
+	}
+	index, err := AsBackendClientV2(t.client).WatchPrefixContext(ctx, t.getWatchPrefix(), keys, t.lastIndex)
+	return index, backendError(err)
+}
+
+func isStopped(stopChan chan bool) bool {
+	select {
+	case <-stopChan:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// errWatchEventsClosed is the error of the event stream closed by the
+// backend, the watcher is restarted by superviseMonitor.
+var errWatchEventsClosed = errors.New("libconfd: watch events closed")
+
+// getEventWatchClient returns the BackendEventClient of the client,
+// through the wrappers of the client. The layers of a MultiBackendClient
+// are watched by WatchPrefix.
+func getEventWatchClient(client BackendClient) (BackendEventClient, bool) {
+	for ; client != nil; client = unwrapBackendClient(client) {
+		if x, ok := client.(*rateLimitedClient); ok {
+			// the events of the wrapped client, rate limited
+			if _, ok := getEventWatchClient(x.BackendClient); !ok {
+				return nil, false
+			}
+			return x, true
+		}
+		if c, ok := backendClientImpl(client).(BackendEventClient); ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// monitorEvents renders the template resource at start, and again
+// whenever the events match its keys, the events sent during a render
+// are coalesced into the next one.
+func (p *Processor) monitorEvents(
+	ctx context.Context,
+	c BackendEventClient,
+	t *TemplateResourceProcessor,
+	keys []string,
+	stopChan chan bool,
+	call *Call,
+	onWatch func(),
+) error {
+	events, err := c.WatchEvents(ctx, t.getWatchPrefix(), keys)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return backendError(err)
+	}
+	onWatch()
+
+	// the changes before the stream are not sent
+	if !p.renderWatched(t, stopChan, call) {
+		return nil
+	}
+
+	for {
+		var changed []string
+		var closed bool
+		select {
+		case e, ok := <-events:
+			if !ok {
+				closed = true
+				break
+			}
+			if MatchWatchKey(e.Key, keys, t.WatchKeysExact) {
+				changed = append(changed, e.Key)
+			}
+		drain:
+			for !closed {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						closed = true
+						break drain
+					}
+					if MatchWatchKey(e.Key, keys, t.WatchKeysExact) {
+						changed = append(changed, e.Key)
+					}
+				default:
+					break drain
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+
+		if ctx.Err() != nil || p.isClosing() {
+			return nil
+		}
+		if len(changed) > 0 {
+			t.logger.Debugf("watch events: %v", changed)
+			GetMetrics().Inc(fmt.Sprintf("libconfd_watch_event_renders_total{resource=%q}", filepath.Base(t.path)))
+			if !p.renderWatched(t, stopChan, call) {
+				return nil
+			}
+		}
+		if closed {
+			return backendError(errWatchEventsClosed)
+		}
+	}
+}
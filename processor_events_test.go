@@ -0,0 +1,125 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tEventClient streams the events of events, and counts the GetValues.
+type tEventClient struct {
+	mapBackendClient
+	events chan KVEvent
+	gets   int32
+}
+
+func (p *tEventClient) GetValues(keys []string) (map[string]string, error) {
+	atomic.AddInt32(&p.gets, 1)
+	return p.mapBackendClient.GetValues(keys)
+}
+
+func (p *tEventClient) WatchEvents(ctx context.Context, prefix string, keys []string) (<-chan KVEvent, error) {
+	return p.events, nil
+}
+
+func TestMonitorEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-events-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/app/port"}}`), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := &tEventClient{mapBackendClient: mapBackendClient{"/app/port": "80"}, events: make(chan KVEvent)}
+	newProcessor := func(exact bool) *TemplateResourceProcessor {
+		p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, newRateLimitedClient(client, newRateLimiter(0, 0)), &TemplateResource{
+			Src:            src,
+			Dest:           filepath.Join(dir, "app.conf"),
+			Keys:           []string{"/app/port"},
+			WatchKeysExact: exact,
+		})
+		tAssert(t, err == nil, err)
+		return p
+	}
+	waitGets := func(n int32) {
+		for i := 0; i < 100 && atomic.LoadInt32(&client.gets) < n; i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		time.Sleep(time.Millisecond * 50)
+		tAssert(t, atomic.LoadInt32(&client.gets) == n, atomic.LoadInt32(&client.gets), n)
+	}
+
+	p := NewProcessor()
+	defer p.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.monitorPrefix(newProcessor(true), make(chan bool), &Call{Config: cfg, renders: newRenderLimiter()}, func() {})
+	}()
+
+	// rendered at start, and only by the events of the keys
+	waitGets(1)
+	client.events <- KVEvent{Type: KVEventPut, Key: "/app/port2", Value: "1"}
+	client.events <- KVEvent{Type: KVEventPut, Key: "/other", Value: "1"}
+	waitGets(1)
+	client.events <- KVEvent{Type: KVEventDelete, Key: "/app/port"}
+	waitGets(2)
+
+	// restarted if the stream is closed
+	close(client.events)
+	select {
+	case err := <-done:
+		tAssert(t, errors.Is(err, errWatchEventsClosed) && errors.Is(err, ErrBackendUnavailable), err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor not exited")
+	}
+
+	// stopped
+	client.events = make(chan KVEvent)
+	stopChan := make(chan bool)
+	go func() {
+		done <- p.monitorPrefix(newProcessor(false), stopChan, &Call{Config: cfg, renders: newRenderLimiter()}, func() {})
+	}()
+	waitGets(3)
+	client.events <- KVEvent{Type: KVEventPut, Key: "/app/port2", Value: "1"}
+	waitGets(4)
+	close(stopChan)
+	select {
+	case err := <-done:
+		tAssert(t, err == nil, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor not stopped")
+	}
+}
+
+func TestDiffWatchValues(t *testing.T) {
+	events := diffWatchValues(
+		map[string]string{"/app/a": "1", "/app/b": "2", "/db/c": "3"},
+		map[string]string{"/app/a": "1", "/app/b": "3", "/app/d": "4"},
+		[]string{"/app"},
+	)
+	tAssert(t, len(events) == 2, events)
+	tAssert(t, events[0] == KVEvent{Type: KVEventPut, Key: "/app/b", Value: "3"}, events)
+	tAssert(t, events[1] == KVEvent{Type: KVEventPut, Key: "/app/d", Value: "4"}, events)
+
+	events = diffWatchValues(map[string]string{"/app/a": "1"}, nil, []string{"/app"})
+	tAssert(t, len(events) == 1 && events[0].Type == KVEventDelete, events)
+
+	_, ok := getEventWatchClient(newRateLimitedClient(&tEventClient{}, newRateLimiter(0, 0)))
+	tAssert(t, ok)
+	_, ok = getEventWatchClient(NewMultiBackendClient(&tEventClient{}))
+	tAssert(t, !ok)
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ResourceGroup is the mode of the template resources naming the group
+// by group = "name", the groups run independently in the same Processor,
+// such as the secrets watched and the log configs polled hourly:
+//
+//	[groups.secrets]
+//	mode = "watch"
+//
+//	[groups.logs]
+//	mode = "interval"
+//	interval = 3600
+//
+// The resources without a group, or of an unknown group, run in the mode
+// of Config. The groups and their modes are fixed when the call starts,
+// the resources of a group removed by Processor.Reload move to the
+// default group.
+type ResourceGroup struct {
+	Mode     string `toml:"mode" json:"mode"`         // watch or interval, default is the mode of Config
+	Interval int    `toml:"interval" json:"interval"` // default is the interval of Config
+}
+
+// Valid checks the group.
+func (p *ResourceGroup) Valid() error {
+	switch p.Mode {
+	case "", "watch", "interval":
+	default:
+		return fmt.Errorf("invalid mode %q", p.Mode)
+	}
+	if p.Interval < 0 {
+		return fmt.Errorf("invalid interval: %d", p.Interval)
+	}
+	return nil
+}
+
+// apply returns the config of the group.
+func (p *ResourceGroup) apply(cfg *Config) *Config {
+	q := cfg.Clone()
+	switch p.Mode {
+	case "watch":
+		q.Watch = true
+	case "interval":
+		q.Watch = false
+	}
+	if p.Interval > 0 {
+		q.Interval = p.Interval
+	}
+	return q
+}
+
+// newGroupCall returns the call of the group, the config of call is
+// replaced by the config of the group.
+func newGroupCall(call *Call, group string) *Call {
+	sub := *call
+	sub.grouped, sub.group = true, group
+	sub.stale = false
+	sub.reload = make(chan *Call, 1)
+	if g, ok := call.Config.Groups[group]; ok {
+		sub.Config = g.apply(call.Config)
+	}
+	return &sub
+}
+
+// groupReload returns the reload of the grouped call with the config of
+// its group.
+func groupReload(call, r *Call) *Call {
+	if !call.grouped {
+		return r
+	}
+	x := *r
+	if g, ok := r.Config.Groups[call.group]; ok {
+		x.Config = g.apply(r.Config)
+	}
+	return &x
+}
+
+// runGroups runs every group and the default group in its own mode, and
+// returns after all of them stopped.
+func (p *Processor) runGroups(call *Call) {
+	names := []string{""}
+	for name := range call.Config.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		sub := newGroupCall(call, name)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.process(sub)
+		}()
+	}
+	wg.Wait()
+}
+
+// makeTemplateResourceProcessor makes the template resources of the
+// group of call, with the config, client and AES key of r, the call
+// itself or its reload.
+func (p *Processor) makeTemplateResourceProcessor(call, r *Call) ([]*TemplateResourceProcessor, error) {
+	cfg := r.Config
+	ts, err := makeAllTemplateResourceProcessor(cfg, r.Client, r.aesKey)
+	if err != nil || !call.grouped {
+		return ts, err
+	}
+
+	var selected []*TemplateResourceProcessor
+	for _, t := range ts {
+		group := t.Group
+		if _, ok := cfg.Groups[group]; !ok {
+			group = ""
+		}
+		if group == call.group {
+			selected = append(selected, t)
+		}
+	}
+	return selected, nil
+}
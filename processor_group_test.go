@@ -0,0 +1,84 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResourceGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-groups-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "conf.d"), 0755) == nil)
+	for name, group := range map[string]string{
+		"app":    "",
+		"secret": "secrets",
+		"log":    "logs",
+		"other":  "unknown",
+	} {
+		data := "[template]\nsrc = \"" + name + ".tmpl\"\ndest = \"" + name + ".conf\"\ngroup = \"" + group + "\"\n"
+		tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", name+".toml"), []byte(data), 0644) == nil)
+	}
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Interval = 10
+	cfg.Groups = map[string]ResourceGroup{
+		"secrets": {Mode: "watch"},
+		"logs":    {Mode: "interval", Interval: 3600},
+	}
+	tAssert(t, cfg.Valid() == nil)
+
+	p := &Processor{}
+	call := &Call{Config: cfg, Client: mapBackendClient(nil), stale: true}
+
+	names := func(ts []*TemplateResourceProcessor) (ss []string) {
+		for _, t := range ts {
+			ss = append(ss, filepath.Base(t.path))
+		}
+		return
+	}
+
+	ts, err := p.makeTemplateResourceProcessor(call, call)
+	tAssert(t, err == nil && len(ts) == 4, err, names(ts))
+
+	for _, v := range []struct {
+		group    string
+		watch    bool
+		interval int
+		expect   []string
+	}{
+		{"", false, 10, []string{"app.toml", "other.toml"}},
+		{"secrets", true, 10, []string{"secret.toml"}},
+		{"logs", false, 3600, []string{"log.toml"}},
+	} {
+		sub := newGroupCall(call, v.group)
+		tAssert(t, sub.grouped && !sub.stale && sub.group == v.group)
+		tAssert(t, sub.Config.Watch == v.watch && sub.Config.Interval == v.interval, v.group, sub.Config.Watch, sub.Config.Interval)
+
+		ts, err := p.makeTemplateResourceProcessor(sub, sub)
+		tAssert(t, err == nil, err)
+		tAssert(t, len(ts) == len(v.expect), v.group, names(ts))
+		for i, s := range names(ts) {
+			tAssert(t, s == v.expect[i], v.group, names(ts))
+		}
+	}
+
+	// the resources of the removed group move to the default group
+	sub := newGroupCall(call, "")
+	newCfg := cfg.Clone()
+	delete(newCfg.Groups, "logs")
+	r := groupReload(sub, &Call{Config: newCfg, Client: call.Client})
+	ts, err = p.makeTemplateResourceProcessor(sub, r)
+	tAssert(t, err == nil && len(ts) == 3, err, names(ts))
+
+	cfg.Groups["logs"] = ResourceGroup{Mode: "poll"}
+	tAssert(t, cfg.Valid() != nil)
+}
@@ -0,0 +1,306 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tFlakyClient fails GetValues until ok is set.
+type tFlakyClient struct {
+	mapBackendClient
+	ok int32
+}
+
+func (p *tFlakyClient) GetValues(keys []string) (map[string]string, error) {
+	if atomic.LoadInt32(&p.ok) == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return p.mapBackendClient.GetValues(keys)
+}
+
+func TestProcessorStartStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-stale-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR", Interval: 0}
+	client := &tFlakyClient{mapBackendClient: mapBackendClient{}}
+
+	p := NewProcessor()
+	defer p.Close()
+
+	call := p.Go(cfg, client, WithOnetimeMode())
+	tAssert(t, (<-call.Done).Error != nil)
+
+	call = p.Go(cfg, client, WithIntervalMode(), WithStartStale())
+	tAssert(t, call.Error == nil, call.Error)
+
+	for i := 0; i < 50 && !p.Degraded(); i++ {
+		time.Sleep(time.Second / 10)
+	}
+	tAssert(t, p.Degraded())
+
+	atomic.StoreInt32(&client.ok, 1)
+	for i := 0; i < 50 && p.Degraded(); i++ {
+		time.Sleep(time.Second / 10)
+	}
+	tAssert(t, !p.Degraded())
+}
+
+// tBlockingClient blocks GetValues until release is closed, or sent to.
+type tBlockingClient struct {
+	mapBackendClient
+	release chan bool
+}
+
+func (p *tBlockingClient) GetValues(keys []string) (map[string]string, error) {
+	<-p.release
+	return p.mapBackendClient.GetValues(keys)
+}
+
+// tSyncingClient blocks the GetValues of the syncs until release is
+// closed, started is sent to first. The backend check is not blocked.
+type tSyncingClient struct {
+	mapBackendClient
+	started chan bool
+	release chan bool
+}
+
+func (p *tSyncingClient) GetValues(keys []string) (map[string]string, error) {
+	return p.getValues(context.Background(), keys)
+}
+
+func (p *tSyncingClient) getValues(ctx context.Context, keys []string) (map[string]string, error) {
+	if len(keys) == 1 && keys[0] == "/" {
+		return p.mapBackendClient.GetValues(keys)
+	}
+	p.started <- true
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.mapBackendClient.GetValues(keys)
+}
+
+// tSyncingContextClient is the tSyncingClient canceled with ctx.
+type tSyncingContextClient struct {
+	*tSyncingClient
+}
+
+func (p tSyncingContextClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	return p.getValues(ctx, keys)
+}
+
+func (_ tSyncingContextClient) WatchPrefixContext(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	<-ctx.Done()
+	return waitIndex, ctx.Err()
+}
+
+func TestProcessorStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-stop-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "app.toml"), []byte(fmt.Sprintf(`
+[template]
+src = "app.tmpl"
+dest = %q
+prefix = "/app"
+keys = ["/port"]
+`, filepath.ToSlash(filepath.Join(dir, "app.conf")))), 0644) == nil)
+
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR", StopGracePeriod: 5}
+	waitSync := func(started chan bool) {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("sync not started")
+		}
+	}
+
+	// the backend call of the sync in progress is canceled
+	client := &tSyncingClient{mapBackendClient: mapBackendClient{"/app/port": "80"}, started: make(chan bool, 1), release: make(chan bool)}
+	defer close(client.release)
+
+	p := NewProcessor()
+	call := p.Go(cfg, tSyncingContextClient{client}, WithIntervalMode())
+	tAssert(t, call.Error == nil, call.Error)
+	waitSync(client.started)
+
+	start := time.Now()
+	tAssert(t, p.Stop() == nil)
+	tAssert(t, time.Since(start) < 2*time.Second, time.Since(start))
+	_, err = os.Stat(filepath.Join(dir, "app.conf"))
+	tAssert(t, os.IsNotExist(err), err)
+
+	// the call of an old client is not canceled, given up after the grace period
+	cfg.StopGracePeriod = 1
+	p = NewProcessor()
+	p.Go(cfg, client, WithIntervalMode())
+	waitSync(client.started)
+	tAssert(t, p.Stop() != nil)
+}
+
+func TestProcessorReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-readonly-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	for _, name := range []string{"app", "risky"} {
+		noop := name == "risky"
+		tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", name+".toml"), []byte(fmt.Sprintf(`
+[template]
+src = "app.tmpl"
+dest = %q
+prefix = "/app"
+keys = ["/port"]
+noop = %v
+`, filepath.ToSlash(filepath.Join(dir, name+".conf")), noop)), 0644) == nil)
+	}
+	readDest := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name+".conf"))
+		return string(data)
+	}
+
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR"}
+	p := NewProcessor()
+	defer p.Close()
+
+	// the read-only mode skips all the resources
+	p.SetReadOnly(true)
+	tAssert(t, p.ReadOnly() && p.Status().ReadOnly)
+	tAssert(t, p.Run(cfg, mapBackendClient{"/app/port": "80"}, WithOnetimeMode()) == nil)
+	tAssert(t, readDest("app") == "" && readDest("risky") == "")
+
+	// the noop resource only
+	p.SetReadOnly(false)
+	tAssert(t, p.Run(cfg, mapBackendClient{"/app/port": "80"}, WithOnetimeMode()) == nil)
+	tAssert(t, readDest("app") == "port=80", readDest("app"))
+	tAssert(t, readDest("risky") == "", readDest("risky"))
+}
+
+// tMapWatchClient is an uncomparable client, the first watch returns at
+// once, the others block until stopped.
+type tMapWatchClient struct {
+	mapBackendClient
+}
+
+func (_ tMapWatchClient) WatchEnabled() bool {
+	return true
+}
+
+func (_ tMapWatchClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	<-stopChan
+	return waitIndex, nil
+}
+
+func TestProcessorReloadUncomparableClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-reload-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "app.toml"), []byte(fmt.Sprintf(`
+[template]
+src = "app.tmpl"
+dest = %q
+prefix = "/app"
+keys = ["/port"]
+`, filepath.ToSlash(dest))), 0644) == nil)
+	waitDest := func(want string) {
+		var data []byte
+		for i := 0; i < 100; i++ {
+			if data, _ = ioutil.ReadFile(dest); string(data) == want {
+				return
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+		t.Fatalf("dest = %q, want %q", data, want)
+	}
+
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR", Watch: true}
+	p := NewProcessor()
+	defer p.Close()
+
+	p.Go(cfg, tMapWatchClient{mapBackendClient{"/app/port": "80"}})
+	waitDest("port=80")
+
+	// the new client restarts the watches
+	tAssert(t, p.Reload(cfg, tMapWatchClient{mapBackendClient{"/app/port": "8080"}}) == nil)
+	waitDest("port=8080")
+}
+
+func TestProcessorConcurrencyPerCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-concurrency-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+
+	p := NewProcessor()
+	defer p.Close()
+
+	cfg.Concurrency = 2
+	a, err := p.newCall(cfg, mapBackendClient{})
+	tAssert(t, err == nil, err)
+	cfg.Concurrency = 5
+	b, err := p.newCall(cfg, mapBackendClient{})
+	tAssert(t, err == nil, err)
+
+	// the limits of the calls are kept apart
+	tAssert(t, a.renders != b.renders)
+	tAssert(t, a.renders.limit == 2 && b.renders.limit == 5, a.renders.limit, b.renders.limit)
+}
+
+func TestProcessorRateLimitPerCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-ratelimit-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+
+	p := NewProcessor()
+	defer p.Close()
+
+	cfg.BackendRateLimit = 60
+	a, err := p.newCall(cfg, mapBackendClient{})
+	tAssert(t, err == nil, err)
+	cfg.BackendRateLimit = 0
+	b, err := p.newCall(cfg, mapBackendClient{})
+	tAssert(t, err == nil, err)
+
+	// the call without a limit keeps the limit of the other one
+	_, ok := a.Client.(*rateLimitedClient)
+	tAssert(t, ok && a.limiter != b.limiter && a.limiter.rate == 1, a.limiter.rate)
+	_, ok = b.Client.(*rateLimitedClient)
+	tAssert(t, !ok)
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWatchMaxRestarts is the number of the consecutive restarts of a
+// failed watcher before it is given up, if watch-max-restarts is not set.
+const DefaultWatchMaxRestarts = 10
+
+// the backoff of the watcher restarts, doubled per consecutive failure
+var (
+	watchRestartBackoff    = time.Second
+	watchRestartMaxBackoff = time.Minute
+)
+
+// The states of the watchers.
+const (
+	WatcherRunning    = "running"
+	WatcherRestarting = "restarting"
+	WatcherFailed     = "failed" // given up, until the resource is reloaded
+)
+
+// WatcherStatus is the state of the watcher of a template resource in
+// watch mode.
+type WatcherStatus struct {
+	Resource  string `json:"resource"`
+	State     string `json:"state"`
+	Restarts  int    `json:"restarts"` // consecutive restarts
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ProcessorStatus is returned by Processor.Status.
+type ProcessorStatus struct {
+	Degraded bool            `json:"degraded"`
+	ReadOnly bool            `json:"read_only"` // see Processor.SetReadOnly
+	Watchers []WatcherStatus `json:"watchers"`  // watch mode only, sorted by resource
+}
+
+// Status returns the status of the processor, the failed watchers are
+// reported instead of silently losing the watch of their resources.
+func (p *Processor) Status() ProcessorStatus {
+	status := ProcessorStatus{Degraded: p.Degraded(), ReadOnly: p.ReadOnly()}
+
+	p.watchersMutex.Lock()
+	for _, w := range p.watchers {
+		status.Watchers = append(status.Watchers, *w)
+	}
+	p.watchersMutex.Unlock()
+
+	sort.Slice(status.Watchers, func(i, j int) bool {
+		return status.Watchers[i].Resource < status.Watchers[j].Resource
+	})
+	return status
+}
+
+// setWatcherStatus sets the status of the watcher m, unless it has been
+// replaced by another watcher of the same resource.
+func (p *Processor) setWatcherStatus(m *watchMonitor, state string, restarts int, err error) {
+	p.watchersMutex.Lock()
+	defer p.watchersMutex.Unlock()
+
+	if p.watchers[m.t.path] != m.status {
+		return
+	}
+	m.status.State = state
+	m.status.Restarts = restarts
+	if err != nil {
+		m.status.LastError = err.Error()
+	}
+
+	var failed int64
+	for _, w := range p.watchers {
+		if w.State == WatcherFailed {
+			failed++
+		}
+	}
+	GetMetrics().Set("libconfd_watchers_failed", failed)
+}
+
+func (p *Processor) addWatcher(m *watchMonitor) {
+	p.watchersMutex.Lock()
+	defer p.watchersMutex.Unlock()
+
+	m.status = &WatcherStatus{Resource: filepath.Base(m.t.path), State: WatcherRunning}
+	p.watchers[m.t.path] = m.status
+}
+
+func (p *Processor) removeWatcher(m *watchMonitor) {
+	p.watchersMutex.Lock()
+	defer p.watchersMutex.Unlock()
+
+	if p.watchers[m.t.path] == m.status {
+		delete(p.watchers, m.t.path)
+	}
+}
+
+// superviseMonitor runs monitorPrefix of the watcher m, and restarts it
+// with backoff if it exits on the backend error or panic. The watcher is
+// given up after the max consecutive restarts, a watch succeeded resets
+// the count.
+func (p *Processor) superviseMonitor(m *watchMonitor, call *Call) {
+	maxRestarts := call.Config.WatchMaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = DefaultWatchMaxRestarts
+	}
+
+	var restarts int32
+	onWatch := func() {
+		if atomic.SwapInt32(&restarts, 0) != 0 {
+			p.setWatcherStatus(m, WatcherRunning, 0, nil)
+		}
+	}
+
+	for {
+		err := p.runMonitor(m, call, onWatch)
+		if err == nil || p.isClosing() || isStopped(m.stopChan) {
+			return
+		}
+
+		n := int(atomic.AddInt32(&restarts, 1))
+		log := withLogFields(processorLogger, m.t.logFields()...)
+		if n > maxRestarts {
+			log.Errorf("watcher failed after %d restarts, given up: %v", maxRestarts, err)
+			p.setWatcherStatus(m, WatcherFailed, n-1, err)
+			return
+		}
+
+		backoff := watchRestartBackoff << uint(n-1)
+		if backoff > watchRestartMaxBackoff || backoff <= 0 {
+			backoff = watchRestartMaxBackoff
+		}
+		log.Warningf("watcher exited, restart %d/%d in %v: %v", n, maxRestarts, backoff, err)
+		p.setWatcherStatus(m, WatcherRestarting, n, err)
+		GetMetrics().Inc(fmt.Sprintf("libconfd_watcher_restarts_total{resource=%q}", filepath.Base(m.t.path)))
+
+		select {
+		case <-time.After(backoff):
+		case <-m.stopChan:
+			return
+		case <-p.closeChan:
+			return
+		}
+
+		// render at once after restart, the changes during the outage
+		// were not watched
+		m.t.lastIndex = 0
+		p.setWatcherStatus(m, WatcherRunning, n, nil)
+	}
+}
+
+// runMonitor runs monitorPrefix, the panic is returned as error.
+func (p *Processor) runMonitor(m *watchMonitor, call *Call, onWatch func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.monitorPrefix(m.t, m.stopChan, call, onWatch)
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tFlakyWatchClient fails the first fails watches, then the watches
+// return at once once, and block until stopped.
+type tFlakyWatchClient struct {
+	mapBackendClient
+	fails int32
+	calls int32
+}
+
+func (p *tFlakyWatchClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	n := atomic.AddInt32(&p.calls, 1)
+	if p.fails < 0 || n <= p.fails {
+		return 0, errors.New("watch failed")
+	}
+	if n == p.fails+1 {
+		return 1, nil
+	}
+	<-stopChan
+	return 0, nil
+}
+
+func TestSuperviseMonitor(t *testing.T) {
+	backoff := watchRestartBackoff
+	watchRestartBackoff = time.Millisecond
+	defer func() { watchRestartBackoff = backoff }()
+
+	cfg := newDefaultConfig().applyOptions(WithWatchMaxRestarts(2))
+	p := NewProcessor()
+	defer p.Close()
+
+	newMonitor := func(client BackendClient) *watchMonitor {
+		tr, err := NewTemplateResourceProcessor("/confd/conf.d/app.toml", cfg, client, &TemplateResource{
+			Src:  "/confd/templates/missing.tmpl",
+			Dest: "/tmp/app.conf",
+		})
+		tAssert(t, err == nil, err)
+		m := &watchMonitor{t: tr, stopChan: make(chan bool)}
+		p.addWatcher(m)
+		return m
+	}
+
+	// given up after the max restarts
+	m := newMonitor(&tFlakyWatchClient{fails: -1})
+	p.superviseMonitor(m, &Call{Config: cfg, renders: newRenderLimiter()})
+
+	status := p.Status()
+	tAssert(t, len(status.Watchers) == 1, status)
+	w := status.Watchers[0]
+	tAssert(t, w.Resource == "app.toml" && w.State == WatcherFailed && w.Restarts == 2, w)
+	tAssert(t, w.LastError == "watch failed", w)
+
+	// a watch succeeded resets the restarts
+	client := &tFlakyWatchClient{fails: 2}
+	m = newMonitor(client)
+	done := make(chan bool)
+	go func() {
+		p.superviseMonitor(m, &Call{Config: cfg, renders: newRenderLimiter()})
+		close(done)
+	}()
+
+	for i := 0; i < 100 && atomic.LoadInt32(&client.calls) < 4; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	w = p.Status().Watchers[0]
+	tAssert(t, w.State == WatcherRunning && w.Restarts == 0, w)
+
+	close(m.stopChan)
+	<-done
+	p.removeWatcher(m)
+	tAssert(t, len(p.Status().Watchers) == 0)
+}
+
+// tExactWatchClient records the watch calls by WatchKeys.
+type tExactWatchClient struct {
+	mapBackendClient
+	exact int32
+}
+
+func (p *tExactWatchClient) WatchKeys(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	atomic.AddInt32(&p.exact, 1)
+	return waitIndex + 1, nil
+}
+
+func TestWatchKeysExact(t *testing.T) {
+	tAssert(t, MatchWatchKey("/app/port2", []string{"/app/port"}, false))
+	tAssert(t, !MatchWatchKey("/app/port2", []string{"/app/port"}, true))
+	tAssert(t, MatchWatchKey("/app/port", []string{"/app/host", "/app/port"}, true))
+
+	cfg := newDefaultConfig()
+	client := &tExactWatchClient{mapBackendClient: mapBackendClient{}}
+	newProcessor := func(exact bool) *TemplateResourceProcessor {
+		p, err := NewTemplateResourceProcessor("/confd/conf.d/app.toml", cfg, &snapshotRecorder{BackendClient: client}, &TemplateResource{
+			Src:            "/confd/templates/app.tmpl",
+			Dest:           "/tmp/app.conf",
+			Keys:           []string{"/port"},
+			WatchKeysExact: exact,
+		})
+		tAssert(t, err == nil, err)
+		return p
+	}
+
+	// the exact watch through the wrappers of the client
+	tr := newProcessor(true)
+	index, err := tr.watchKeys(context.Background(), tr.getAbsKeys())
+	tAssert(t, err == nil && index == 1 && client.exact == 1, index, err)
+
+	// the prefix watch of mapBackendClient is not supported
+	tr = newProcessor(false)
+	_, err = tr.watchKeys(context.Background(), tr.getAbsKeys())
+	tAssert(t, err != nil && client.exact == 1, err)
+}
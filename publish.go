@@ -0,0 +1,205 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPublishTimeout is the timeout of uploading a bundle.
+const DefaultPublishTimeout = 30 * time.Second
+
+// Publisher uploads the tar.gz bundle of RenderBundle, for the targets
+// pulling the config rather than running an agent. The bundle is
+// rendered and published whenever a target file is updated.
+type Publisher interface {
+	Publish(bundle []byte) error
+}
+
+// PublisherConfig configures a builtin publisher in the config file:
+//
+//	[publishers.artifacts]
+//	type = "http"
+//	url = "https://artifacts.example.com/confd/web.tar.gz"
+//	headers = { Authorization = "Bearer xxx" }
+//
+//	[publishers.config-server]
+//	type = "sftp"
+//	url = "sftp://deploy@config.example.com:22/srv/confd/web.tar.gz"
+//	key-file = "/etc/confd/id_ed25519"
+//	known-hosts = "/etc/confd/known_hosts"
+//
+// The http publisher sends the bundle by the method, PUT if empty. The
+// sftp publisher writes a temp file and renames it to the path of url,
+// the host key is always verified by the known-hosts file.
+type PublisherConfig struct {
+	Type    string            `toml:"type" json:"type"` // http/sftp
+	URL     string            `toml:"url" json:"url"`
+	Method  string            `toml:"method" json:"method"`
+	Headers map[string]string `toml:"headers" json:"headers"`
+
+	Username   string `toml:"username" json:"username"`
+	Password   string `toml:"password" json:"password"`
+	KeyFile    string `toml:"key-file" json:"key-file"`
+	KnownHosts string `toml:"known-hosts" json:"known-hosts"`
+}
+
+// Valid checks the publisher config.
+func (p *PublisherConfig) Valid() error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	switch p.Type {
+	case "http":
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid url of http publisher: %s", p.URL)
+		}
+	case "sftp":
+		if u.Scheme != "sftp" || u.Host == "" || u.Path == "" {
+			return fmt.Errorf("invalid url of sftp publisher: %s", p.URL)
+		}
+		if p.KnownHosts == "" {
+			return fmt.Errorf("sftp publisher requires known-hosts")
+		}
+		if p.Password == "" && p.KeyFile == "" {
+			return fmt.Errorf("sftp publisher requires password or key-file")
+		}
+	default:
+		return fmt.Errorf("invalid type %q", p.Type)
+	}
+	return nil
+}
+
+// NewPublisher creates the builtin publisher of the config.
+func (p *PublisherConfig) NewPublisher() (Publisher, error) {
+	if err := p.Valid(); err != nil {
+		return nil, err
+	}
+
+	switch p.Type {
+	case "sftp":
+		return newSFTPPublisher(p)
+	default:
+		return &HTTPPublisher{
+			URL:      p.URL,
+			Method:   p.Method,
+			Headers:  p.Headers,
+			Username: p.Username,
+			Password: p.Password,
+		}, nil
+	}
+}
+
+// HTTPPublisher uploads the bundle to the URL, the basic auth is used if
+// Username is set.
+type HTTPPublisher struct {
+	URL      string
+	Method   string // PUT if empty
+	Headers  map[string]string
+	Username string
+	Password string
+}
+
+func (p *HTTPPublisher) Publish(bundle []byte) error {
+	method := p.Method
+	if method == "" {
+		method = "PUT"
+	}
+	req, err := http.NewRequest(method, p.URL, bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	client := &http.Client{Timeout: DefaultPublishTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("publish %s: %s: %s", p.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// getPublishers returns the publishers registered by WithPublisher and
+// the builtin publishers of the publishers of Config, sorted by name.
+func (p *Config) getPublishers() (names []string, publishers []Publisher, err error) {
+	for name := range p.PublisherPlugins {
+		names = append(names, name)
+	}
+	for name := range p.Publishers {
+		if _, ok := p.PublisherPlugins[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if pub, ok := p.PublisherPlugins[name]; ok {
+			publishers = append(publishers, pub)
+			continue
+		}
+		c := p.Publishers[name]
+		pub, err := c.NewPublisher()
+		if err != nil {
+			return nil, nil, fmt.Errorf("publisher %s: %v", name, err)
+		}
+		publishers = append(publishers, pub)
+	}
+	return names, publishers, nil
+}
+
+// publishBundle renders the bundle of all the template resources and
+// uploads it by the publishers of the config, the failures are logged
+// and never fail the sync.
+func (p *Processor) publishBundle(call *Call) {
+	if len(call.Config.Publishers) == 0 && len(call.Config.PublisherPlugins) == 0 {
+		return
+	}
+
+	// the watches publish concurrently, the last one has the latest values
+	p.publishMutex.Lock()
+	defer p.publishMutex.Unlock()
+
+	names, publishers, err := call.Config.getPublishers()
+	if err != nil {
+		processorLogger.Warning(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := RenderBundle(&buf, call.Config, call.Client); err != nil {
+		GetMetrics().Inc("libconfd_bundle_render_errors_total")
+		processorLogger.Warning("render bundle failed: ", err)
+		return
+	}
+	for i, pub := range publishers {
+		if err := pub.Publish(buf.Bytes()); err != nil {
+			GetMetrics().Inc(fmt.Sprintf("libconfd_publish_errors_total{publisher=%q}", names[i]))
+			processorLogger.Warningf("publish %s failed: %v", names[i], err)
+			continue
+		}
+		processorLogger.Infof("published bundle (%d bytes) by %s", buf.Len(), names[i])
+	}
+}
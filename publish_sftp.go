@@ -0,0 +1,126 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPPublisher uploads the bundle to Path of the SFTP server. The bundle
+// is written to Path + ".tmp" first, then renamed to Path.
+type SFTPPublisher struct {
+	Addr   string // host:port
+	Path   string
+	Config *ssh.ClientConfig
+}
+
+func newSFTPPublisher(c *PublisherConfig) (*SFTPPublisher, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	user := c.Username
+	if user == "" && u.User != nil {
+		user = u.User.Username()
+	}
+
+	hostKeyCallback, err := knownhosts.New(c.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	var auths []ssh.AuthMethod
+	if c.KeyFile != "" {
+		data, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.KeyFile, err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		auths = append(auths, ssh.Password(c.Password))
+	}
+
+	return &SFTPPublisher{
+		Addr: addr,
+		Path: u.Path,
+		Config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auths,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         DefaultPublishTimeout,
+		},
+	}, nil
+}
+
+func (p *SFTPPublisher) Publish(bundle []byte) error {
+	conn, err := net.DialTimeout("tcp", p.Addr, DefaultPublishTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(DefaultPublishTimeout))
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, p.Addr, p.Config)
+	if err != nil {
+		return err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	s, err := sftp.NewClient(client)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return sftpUpload(s, p.Path, bundle)
+}
+
+// sftpUpload writes content to name + ".tmp", then replaces name by it,
+// by the posix-rename extension of OpenSSH if supported, the plain rename
+// of SFTP fails if name exists.
+func sftpUpload(c *sftp.Client, name string, content []byte) error {
+	tmp := name + ".tmp"
+	f, err := c.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("sftp: open %s: %v", tmp, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("sftp: write %s: %v", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("sftp: close %s: %v", tmp, err)
+	}
+
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		err = c.PosixRename(tmp, name)
+	} else {
+		c.Remove(name)
+		err = c.Rename(tmp, name)
+	}
+	if err != nil {
+		return fmt.Errorf("sftp: rename %s: %v", tmp, err)
+	}
+	return nil
+}
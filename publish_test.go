@@ -0,0 +1,153 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+func TestPublisherConfig(t *testing.T) {
+	for _, c := range []PublisherConfig{
+		{Type: "http", URL: "https://example.com/a.tar.gz"},
+		{Type: "sftp", URL: "sftp://u@example.com/a.tar.gz", KeyFile: "id", KnownHosts: "known_hosts"},
+	} {
+		tAssert(t, c.Valid() == nil, c)
+	}
+	for _, c := range []PublisherConfig{
+		{Type: "ftp", URL: "ftp://example.com/a.tar.gz"},
+		{Type: "http", URL: "sftp://example.com/a.tar.gz"},
+		{Type: "sftp", URL: "sftp://u@example.com/a.tar.gz", KeyFile: "id"},
+		{Type: "sftp", URL: "sftp://u@example.com/a.tar.gz", KnownHosts: "known_hosts"},
+	} {
+		tAssert(t, c.Valid() != nil, c)
+	}
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	var method, auth string
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, auth = r.Method, r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	c := &PublisherConfig{Type: "http", URL: ts.URL, Headers: map[string]string{"Authorization": "Bearer x"}}
+	pub, err := c.NewPublisher()
+	tAssert(t, err == nil, err)
+	tAssert(t, pub.Publish([]byte("bundle")) == nil)
+	tAssert(t, method == "PUT" && auth == "Bearer x" && string(body) == "bundle", method, auth, body)
+}
+
+// tPublisher records the entries of the bundles published.
+type tPublisher struct {
+	bundles []map[string]string
+}
+
+func (p *tPublisher) Publish(bundle []byte) error {
+	gr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data, _ := ioutil.ReadAll(tr)
+		entries[hdr.Name] = string(data)
+	}
+	p.bundles = append(p.bundles, entries)
+	return nil
+}
+
+func TestProcessorPublishBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-publish-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "app.toml"), []byte(`
+[template]
+src = "app.tmpl"
+dest = "`+filepath.ToSlash(dest)+`"
+prefix = "/app"
+keys = ["/port"]
+`), 0644) == nil)
+
+	pub := &tPublisher{}
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR"}
+	client := mapBackendClient{"/app/port": "8080"}
+
+	p := NewProcessor()
+	defer p.Close()
+
+	// published after the dest is updated only
+	for i := 0; i < 2; i++ {
+		tAssert(t, p.Run(cfg, client, WithOnetimeMode(), WithPublisher("test", pub)) == nil)
+	}
+	tAssert(t, len(pub.bundles) == 1, pub.bundles)
+
+	name := filepath.ToSlash(dest[len(filepath.VolumeName(dest)):])[1:]
+	tAssert(t, pub.bundles[0][name] == "port=8080", pub.bundles)
+}
+
+func TestSFTPUpload(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server := sftp.NewRequestServer(tPipe{sr, sw}, sftp.InMemHandler())
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(cr, cw)
+	tAssert(t, err == nil, err)
+	defer client.Close()
+	defer server.Close() // first, the client waits for the end of the server
+
+	// the old bundle is replaced
+	content := bytes.Repeat([]byte("x"), 1<<16)
+	tAssert(t, sftpUpload(client, "/a.tar.gz", []byte("old")) == nil)
+	tAssert(t, sftpUpload(client, "/a.tar.gz", content) == nil)
+
+	f, err := client.Open("/a.tar.gz")
+	tAssert(t, err == nil, err)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	tAssert(t, err == nil && bytes.Equal(data, content), err, len(data))
+
+	_, err = client.Stat("/a.tar.gz.tmp")
+	tAssert(t, os.IsNotExist(err), err)
+}
+
+// tPipe is the io.ReadWriteCloser of the two ends of the pipes.
+type tPipe struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p tPipe) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"path"
+	"sync"
+)
+
+// RedactedValue replaces secret values in logs and diffs.
+const RedactedValue = "******"
+
+// Redactor masks the values of secret keys before they are logged.
+//
+// A key is secret if it or its last element matches one of the glob
+// patterns (see path.Match), or if it was read by cget/cgets/cgetv/cgetvs.
+// A nil Redactor masks nothing.
+type Redactor struct {
+	mu       sync.Mutex
+	patterns map[string]bool
+}
+
+func NewRedactor(patterns ...string) *Redactor {
+	p := &Redactor{patterns: make(map[string]bool)}
+	for _, s := range patterns {
+		p.patterns[s] = true
+	}
+	return p
+}
+
+// AddPattern marks the keys matching pattern as secret.
+func (p *Redactor) AddPattern(pattern string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.patterns[pattern] = true
+}
+
+// IsSecret reports whether the value of key must be masked.
+func (p *Redactor) IsSecret(key string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for pattern := range p.patterns {
+		if pattern == key {
+			return true
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns value, or RedactedValue if key is secret.
+func (p *Redactor) Value(key, value string) string {
+	if p.IsSecret(key) {
+		return RedactedValue
+	}
+	return value
+}
+
+// Change returns c with the secret values masked.
+func (p *Redactor) Change(c KeyChange) KeyChange {
+	if p.IsSecret(c.Key) {
+		if c.OldValue != "" {
+			c.OldValue = RedactedValue
+		}
+		if c.NewValue != "" {
+			c.NewValue = RedactedValue
+		}
+	}
+	return c
+}
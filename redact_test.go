@@ -0,0 +1,31 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	p := NewRedactor("*password*", "/secrets/*")
+
+	tAssert(t, p.IsSecret("/db/password"))
+	tAssert(t, p.IsSecret("/db/admin_password"))
+	tAssert(t, p.IsSecret("/secrets/token"))
+	tAssert(t, !p.IsSecret("/db/user"))
+
+	tAssert(t, p.Value("/db/password", "123456") == RedactedValue)
+	tAssert(t, p.Value("/db/user", "root") == "root")
+
+	p.AddPattern("/api/key")
+	tAssert(t, p.IsSecret("/api/key"))
+
+	c := p.Change(KeyChange{Type: KeyAdded, Key: "/api/key", NewValue: "abc"})
+	tAssert(t, c.OldValue == "" && c.NewValue == RedactedValue, c)
+
+	var nilRedactor *Redactor
+	nilRedactor.AddPattern("*")
+	tAssert(t, nilRedactor.Value("/db/password", "123456") == "123456")
+}
@@ -12,6 +12,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -23,17 +24,40 @@ type _TemplateResourceConfig struct {
 
 // TemplateResource is the representation of a parsed template resource.
 type TemplateResource struct {
-	Src           string      `toml:"src" json:"src"`
-	Dest          string      `toml:"dest" json:"dest"`
-	Prefix        string      `toml:"prefix" json:"prefix"`
-	Keys          []string    `toml:"keys" json:"keys"`
-	Mode          string      `toml:"mode" json:"mode"`
-	Gid           int         `toml:"gid" json:"gid"`
-	Uid           int         `toml:"uid" json:"uid"`
-	CheckCmd      string      `toml:"check_cmd" json:"check_cmd"`
-	ReloadCmd     string      `toml:"reload_cmd" json:"reload_cmd"`
-	FileMode      os.FileMode `toml:"file_mode" json:"file_mode"`
-	PGPPrivateKey []byte      `toml:"pgp_private_key" json:"pgp_private_key"`
+	Src             string            `toml:"src" json:"src"`
+	SrcKey          string            `toml:"src_key" json:"src_key"` // backend key of the template, instead of src
+	Dest            string            `toml:"dest" json:"dest"`
+	Prefix          string            `toml:"prefix" json:"prefix"`
+	Keys            []string          `toml:"keys" json:"keys"`
+	Mode            string            `toml:"mode" json:"mode"`
+	Gid             int               `toml:"gid" json:"gid"`
+	Uid             int               `toml:"uid" json:"uid"`
+	CheckCmd        string            `toml:"check_cmd" json:"check_cmd"`
+	ReloadCmd       string            `toml:"reload_cmd" json:"reload_cmd"`
+	ReloadService   string            `toml:"reload_service" json:"reload_service"`
+	StageDir        string            `toml:"stage_dir" json:"stage_dir"`
+	Strategy        string            `toml:"strategy" json:"strategy"`
+	StoreDir        string            `toml:"store_dir" json:"store_dir"`
+	KeepVersions    int               `toml:"keep_versions" json:"keep_versions"`
+	KeyRules        []KeyRule         `toml:"key_rules" json:"key_rules"`
+	Transforms      []ValueTransform  `toml:"transforms" json:"transforms"`
+	FuncProfile     string            `toml:"func_profile" json:"func_profile"`
+	Hooks           string            `toml:"hooks" json:"hooks"`                       // name of the HookSet
+	Group           string            `toml:"group" json:"group"`                       // name of the ResourceGroup
+	Notify          []string          `toml:"notify" json:"notify"`                     // names of the Notifiers
+	RequireApproval bool              `toml:"require_approval" json:"require_approval"` // see PendingChange
+	WatchKeysExact  bool              `toml:"watch_keys_exact" json:"watch_keys_exact"` // see BackendExactWatchClient
+	Noop            bool              `toml:"noop" json:"noop"`                         // noop of this resource only
+	AllowPartial    bool              `toml:"allow_partial" json:"allow_partial"`       // render with the partial values, see PartialError
+	Priority        string            `toml:"priority" json:"priority"`                 // high/normal/low, see Config.Concurrency
+	Debug           bool              `toml:"debug" json:"debug"`                       // trace the store lookups, see LookupTrace
+	CreateDestDirs  bool              `toml:"create_dest_dirs" json:"create_dest_dirs"` // create the missing parents of dest
+	DestDirMode     string            `toml:"dest_dir_mode" json:"dest_dir_mode"`       // mode of the created dirs, default is 0755
+	DestDirOwner    string            `toml:"dest_dir_owner" json:"dest_dir_owner"`     // uid:gid of the created dirs, default is uid/gid
+	MaxStaleness    int               `toml:"max_staleness" json:"max_staleness"`       // max age in seconds of the values, see BackendSnapshotClient
+	KeyAliases      map[string]string `toml:"key_aliases" json:"key_aliases"`           // "/new/path" -> "/legacy/path", see WithKeyAliases
+	FileMode        os.FileMode       `toml:"file_mode" json:"file_mode"`
+	PGPPrivateKey   []byte            `toml:"pgp_private_key" json:"pgp_private_key"`
 }
 
 var _LIBCONFD_GOOS = func() string {
@@ -47,7 +71,14 @@ var _LIBCONFD_GOOS = func() string {
 }()
 
 func isTemplateResourceFileShouldBeBuilt(abspath string) bool {
-	basename := filepath.Base(abspath)
+	return isTemplateResourceShouldBeBuilt(filepath.Base(abspath), func() ([]byte, error) {
+		return ioutil.ReadFile(abspath)
+	})
+}
+
+// isTemplateResourceShouldBeBuilt checks the template resource file by
+// its basename and the build tags of its content read by readFile.
+func isTemplateResourceShouldBeBuilt(basename string, readFile func() ([]byte, error)) bool {
 
 	if strings.HasPrefix(basename, "_") {
 		return false
@@ -74,7 +105,7 @@ func isTemplateResourceFileShouldBeBuilt(abspath string) bool {
 		}
 	}
 
-	if data, err := ioutil.ReadFile(abspath); err == nil {
+	if data, err := readFile(); err == nil {
 		if bytes.Contains(data, []byte("# +build ignore")) {
 			return false
 		}
@@ -89,27 +120,50 @@ func isTemplateResourceFileShouldBeBuilt(abspath string) bool {
 }
 
 func ListTemplateResource(confdir string) ([]*TemplateResource, []string, error) {
-	if !dirExists(confdir) {
-		return nil, nil, fmt.Errorf("confdir '%s' does not exist", confdir)
-	}
+	return ListLayeredTemplateResource(confdir)
+}
 
-	globpaths, err := filepath.Glob(filepath.Join(confdir, "conf.d", "*.toml"))
-	if err != nil {
-		return nil, nil, err
-	}
+// ListLayeredTemplateResource is like ListTemplateResource, but lists the
+// template resources of all the confdirs. A resource of the later confdir
+// overrides the resource of the same file name in the earlier confdirs.
+// The resources are sorted by file name.
+func ListLayeredTemplateResource(confdirs ...string) ([]*TemplateResource, []string, error) {
+	var pathMap = make(map[string]string)
+	var basenames []string
+
+	for _, confdir := range confdirs {
+		if !dirExists(confdir) {
+			return nil, nil, fmt.Errorf("confdir '%s' does not exist", confdir)
+		}
+
+		globpaths, err := filepath.Glob(filepath.Join(confdir, "conf.d", "*.toml"))
+		if err != nil {
+			return nil, nil, err
+		}
 
-	var paths []string
-	for _, s := range globpaths {
-		if isTemplateResourceFileShouldBeBuilt(s) {
-			paths = append(paths, s)
+		for _, s := range globpaths {
+			if !isTemplateResourceFileShouldBeBuilt(s) {
+				continue
+			}
+			basename := filepath.Base(s)
+			if _, ok := pathMap[basename]; !ok {
+				basenames = append(basenames, basename)
+			}
+			pathMap[basename] = s
 		}
 	}
+	sort.Strings(basenames)
 
-	var lastError error
+	var paths = make([]string, len(basenames))
+	for i, basename := range basenames {
+		paths[i] = pathMap[basename]
+	}
+
+	var err, lastError error
 	var tcs = make([]*TemplateResource, len(paths))
 
 	for i, s := range paths {
-		tcs[i], err = LoadTemplateResourceFile(confdir, s)
+		tcs[i], err = LoadTemplateResourceFile(filepath.Dir(filepath.Dir(s)), s)
 		if err != nil {
 			lastError = err
 		}
@@ -121,6 +175,8 @@ func ListTemplateResource(confdir string) ([]*TemplateResource, []string, error)
 	return tcs, paths, nil
 }
 
+// LoadTemplateResourceFile loads the template resource file, the fields
+// not set in the file are inherited from the DefaultsFileName of its dir.
 func LoadTemplateResourceFile(confdir, name string) (*TemplateResource, error) {
 	if !filepath.IsAbs(name) {
 		name = filepath.Join(confdir, "conf.d", name)
@@ -133,14 +189,35 @@ func LoadTemplateResourceFile(confdir, name string) (*TemplateResource, error) {
 		},
 	}
 
-	_, err := toml.DecodeFile(name, p)
+	md, err := toml.DecodeFile(name, p)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults, err := loadTemplateResourceDefaults(filepath.Dir(name))
 	if err != nil {
 		return nil, err
 	}
+	if defaults != nil {
+		p.TemplateResource.inherit(defaults, md)
+	}
 
+	p.TemplateResource.expandEnv()
 	return &p.TemplateResource, nil
 }
 
+// expandEnv replaces ${VAR} and ${VAR:-default} in dest, prefix,
+// check_cmd and reload_cmd by the environment variables,
+// ${LIBCONFD_CONFDIR} is replaced by the processor.
+func (p *TemplateResource) expandEnv() {
+	const confdir = "LIBCONFD_CONFDIR"
+
+	p.Dest = expandEnvVars(p.Dest, confdir)
+	p.Prefix = expandEnvVars(p.Prefix, confdir)
+	p.CheckCmd = expandEnvVars(p.CheckCmd, confdir)
+	p.ReloadCmd = expandEnvVars(p.ReloadCmd, confdir)
+}
+
 func (p *TemplateResource) TomlString() string {
 	q := _TemplateResourceConfig{
 		TemplateResource: *p,
@@ -173,5 +250,29 @@ func (p *TemplateResource) getAbsKeys() []string {
 	for i, k := range p.Keys {
 		s[i] = path.Join(p.Prefix, k)
 	}
-	return s
+	return append(s, p.getAliasKeys(s)...)
+}
+
+// getWatchKeys returns the keys and the src_key watched by the resource.
+func (p *TemplateResource) getWatchKeys() []string {
+	keys := p.getAbsKeys()
+	if p.SrcKey != "" {
+		keys = append(keys, p.SrcKey)
+	}
+	return keys
+}
+
+// getWatchPrefix returns the prefix of the watch, the common parent of
+// prefix and src_key if src_key is not under prefix.
+func (p *TemplateResource) getWatchPrefix() string {
+	if p.SrcKey == "" || strings.HasPrefix(p.SrcKey, strings.TrimSuffix(p.Prefix, "/")+"/") {
+		return p.Prefix
+	}
+	a := strings.Split(strings.Trim(p.Prefix, "/"), "/")
+	b := strings.Split(strings.Trim(p.SrcKey, "/"), "/")
+	var common []string
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		common = append(common, a[i])
+	}
+	return "/" + strings.Join(common, "/")
 }
@@ -0,0 +1,187 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// getArchiveDir returns the dir of the archived versions of Dest.
+func (p *TemplateResourceProcessor) getArchiveDir(cfg *Config) string {
+	return filepath.Join(cfg.ArchiveDir, strings.TrimSuffix(filepath.Base(p.path), ".toml"))
+}
+
+// archiveDest copies the updated Dest into the archive dir, and removes
+// the old versions beyond Config.ArchiveVersions.
+func (p *TemplateResourceProcessor) archiveDest(cfg *Config) error {
+	dir := p.getArchiveDir(cfg)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	base := filepath.Base(p.Dest)
+	version := filepath.Join(dir, base+"."+time.Now().Format(symlinkVersionLayout))
+	if err := copyFile(p.Dest, version, 0600); err != nil {
+		os.Remove(version)
+		return err
+	}
+
+	keep := cfg.ArchiveVersions
+	if keep <= 0 {
+		keep = DefaultKeepVersions
+	}
+	return pruneSymlinkVersions(dir, base, version, keep)
+}
+
+// restoreArchive restores the n-th version before the latest archived
+// version of Dest, and runs the reload command.
+// It returns the path of the restored version.
+func (p *TemplateResourceProcessor) restoreArchive(call *Call, n int) (string, error) {
+	if call.Config.ArchiveDir == "" {
+		return "", fmt.Errorf("archive-dir is not set")
+	}
+	if n < 1 {
+		return "", fmt.Errorf("invalid version %d, must be >= 1", n)
+	}
+
+	versions, err := listSymlinkVersions(p.getArchiveDir(call.Config), filepath.Base(p.Dest))
+	if err != nil {
+		return "", err
+	}
+	i := len(versions) - 1 - n
+	if i < 0 {
+		return "", fmt.Errorf("only %d versions of %s archived", len(versions), p.Dest)
+	}
+	version := versions[i]
+
+	if err := p.setFileMode(call); err != nil {
+		return "", err
+	}
+
+	// restored as a render, staged, locked and checked in the dest root
+	temp, err := p.openStageFile()
+	if err != nil {
+		return "", err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := copyFile(version, temp.Name(), p.FileMode); err != nil {
+		return "", err
+	}
+	os.Chmod(temp.Name(), p.FileMode)
+	os.Chown(temp.Name(), p.Uid, p.Gid)
+
+	unlock, err := p.lockDest(call)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if err := p.writeDest(temp.Name()); err != nil {
+		return "", err
+	}
+	p.logger.Info("Target config " + p.Dest + " rolled back to " + version)
+
+	if !p.syncOnly && (strings.TrimSpace(p.ReloadCmd) != "" || p.ReloadService != "") {
+		if err := p.doReload(call); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+// resourceHolds are the template resources rolled back by
+// Processor.Rollback, they are not synced until their values change.
+type resourceHolds struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newResourceHolds() *resourceHolds {
+	return &resourceHolds{paths: make(map[string]bool)}
+}
+
+func (p *resourceHolds) hold(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paths[path] = true
+}
+
+// check reports whether the resource is held, the hold is released if
+// the values changed.
+func (p *resourceHolds) check(path string, changed bool) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paths[path] {
+		return false
+	}
+	if changed {
+		delete(p.paths, path)
+		return false
+	}
+	return true
+}
+
+// RollbackTemplateResource restores the n-th version before the latest
+// archived version of the target config file of the template resource
+// name, see Config.ArchiveDir, and runs its reload command.
+// It returns the path of the restored version. No hold is placed, so a
+// Processor running the resource in another process, such as a daemon,
+// renders the backend values again at its next cycle, see
+// Processor.Rollback to roll back the running resources.
+func RollbackTemplateResource(cfg *Config, client BackendClient, name string, n int) (string, error) {
+	path := resourcePathOf(cfg, name)
+	res, err := LoadTemplateResourceFile(cfg.ConfDir, path)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := NewTemplateResourceProcessor(path, cfg, client, res)
+	if err != nil {
+		return "", err
+	}
+	return p.restoreArchive(&Call{Config: cfg, Client: client}, n)
+}
+
+// resourcePathOf returns the path of the template resource name, such
+// as "nginx" or "nginx.toml".
+func resourcePathOf(cfg *Config, name string) string {
+	if !strings.HasSuffix(name, ".toml") {
+		name += ".toml"
+	}
+	if !filepath.IsAbs(name) {
+		name = cfg.lookupFile("conf.d", name)
+	}
+	return name
+}
+
+// Rollback restores the n-th version before the latest archived version
+// of the target config file of the template resource name, and runs its
+// reload command, with the config and client of the running call of the
+// resource. The resource is not synced again until its values change in
+// the backend, so the rollback is not overwritten by the bad values.
+func (p *Processor) Rollback(name string, n int) (string, error) {
+	call, res, path, err := p.runningCallOf(name)
+	if err != nil {
+		return "", err
+	}
+
+	t := newTemplateResourceProcessor(path, call.Config, call.Client, res, call.aesKey)
+	version, err := t.restoreArchive(call, n)
+	if version != "" {
+		p.holds.hold(path)
+	}
+	return version, err
+}
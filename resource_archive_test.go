@@ -0,0 +1,125 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-archive-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/port"}}`), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	cfg.ArchiveDir = filepath.Join(dir, "archive")
+	cfg.ArchiveVersions = 2
+
+	client := mapBackendClient{"/app/port": "80"}
+	call := &Call{Config: cfg, Client: client, holds: newResourceHolds()}
+
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:    src,
+		Dest:   dest,
+		Prefix: "/app",
+		Keys:   []string{"/port"},
+	})
+	tAssert(t, err == nil, err)
+	readDest := func() string {
+		data, err := ioutil.ReadFile(dest)
+		tAssert(t, err == nil, err)
+		return string(data)
+	}
+
+	for _, port := range []string{"80", "8080", "8081"} {
+		client["/app/port"] = port
+		tAssert(t, p.Process(call) == nil)
+		tAssert(t, readDest() == "port="+port, readDest())
+	}
+
+	versions, err := listSymlinkVersions(p.getArchiveDir(cfg), "app.conf")
+	tAssert(t, err == nil && len(versions) == 2, err, versions)
+
+	_, err = p.restoreArchive(call, 2)
+	tAssert(t, err != nil)
+
+	version, err := p.restoreArchive(call, 1)
+	tAssert(t, err == nil && version == versions[0], err, version)
+	tAssert(t, readDest() == "port=8080", readDest())
+
+	// the held resource is not synced until the values change
+	call.holds.hold(p.path)
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=8080", readDest())
+
+	client["/app/port"] = "8082"
+	tAssert(t, p.Process(call) == nil)
+	tAssert(t, readDest() == "port=8082", readDest())
+}
+
+func TestProcessorRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-rollback-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "app.toml"), []byte(fmt.Sprintf(`
+[template]
+src = "app.tmpl"
+dest = %q
+prefix = "/app"
+keys = ["/port"]
+group = "slow"
+`, filepath.ToSlash(dest))), 0644) == nil)
+	readDest := func() string {
+		data, _ := ioutil.ReadFile(dest)
+		return string(data)
+	}
+	waitDest := func(want string) {
+		for i := 0; i < 100 && readDest() != want; i++ {
+			time.Sleep(time.Millisecond * 50)
+		}
+		tAssert(t, readDest() == want, readDest(), want)
+	}
+
+	cfg := &Config{
+		ConfDir:    dir,
+		LogLevel:   "ERROR",
+		Interval:   3600,
+		ArchiveDir: filepath.Join(dir, "archive"),
+		StageDir:   filepath.Join(dir, "stage"),
+		Groups:     map[string]ResourceGroup{"slow": {Mode: "interval"}},
+	}
+	p := NewProcessor()
+	defer p.Close()
+
+	_, err = p.Rollback("app", 1)
+	tAssert(t, err != nil)
+
+	p.Go(cfg, mapBackendClient{"/app/port": "80"})
+	waitDest("port=80")
+	tAssert(t, p.Reload(cfg, mapBackendClient{"/app/port": "8080"}) == nil)
+	waitDest("port=8080")
+
+	// by the call of the group of the resource
+	_, err = p.Rollback("app", 1)
+	tAssert(t, err == nil, err)
+	tAssert(t, readDest() == "port=80", readDest())
+}
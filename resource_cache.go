@@ -0,0 +1,136 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// volatileFuncs are the template funcs and data whose results do not
+// depend on the template and the store only, the templates using them
+// are never cached by the render cache.
+var volatileFuncs = []string{
+	"RenderTime",
+	"changedKeys",
+	"datetime",
+	"fileExists",
+	"getFrom",
+	"getenv",
+	"getsFrom",
+	"keyChanged",
+	"keyChanges",
+	"lookupIP",
+	"lookupSRV",
+	"mustLookupIP",
+	"mustLookupSRV",
+	"service",
+	"services",
+}
+
+// renderCacheKey returns the hash of the template, the store and the
+// secret keys, the rendered output is cached by the key. ok is false if
+// the template is not cacheable.
+// The funcs of Config.FuncMap are not cacheable, and the funcs set by
+// Config.FuncMapUpdater must depend on their args only.
+func (p *TemplateResourceProcessor) renderCacheKey(call *Call) (key string, ok bool, err error) {
+	src, err := p.readTemplate()
+	if err != nil {
+		return "", false, err
+	}
+
+	names := append([]string{}, volatileFuncs...)
+	for name := range call.Config.FuncMap {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	re, err := regexp.Compile(`\b(` + strings.Join(names, "|") + `)\b`)
+	if err != nil {
+		return "", false, err
+	}
+	if re.Match(src) {
+		return "", false, nil
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s\x00", len(src), src)
+
+	values := p.store.snapshot()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%d:%s=%d:%s\x00", len(k), k, len(values[k]), values[k])
+	}
+
+	data := p.newTemplateData()
+	fmt.Fprintf(h, "%q %q %q %q %q\x00", data.ResourceName, data.Prefix, data.Src, data.Dest, data.Hostname)
+
+	h.Write(p.templateFunc.PGPPrivateKey)
+	h.Write([]byte{0})
+	h.Write(p.templateFunc.AESKey)
+
+	return fmt.Sprintf("%x", h.Sum(nil)), true, nil
+}
+
+// getRenderCacheFile returns the cache file of the rendered output.
+func (p *TemplateResourceProcessor) getRenderCacheFile(dir, key string) string {
+	return filepath.Join(dir, filepath.Base(p.path)+"."+key)
+}
+
+// readRenderCache copies the cached output into w, ok is false if
+// the output is not cached.
+func (p *TemplateResourceProcessor) readRenderCache(dir, key string, w io.Writer) (ok bool, err error) {
+	f, err := os.Open(p.getRenderCacheFile(dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeRenderCache saves the rendered output, and removes the outputs
+// cached before for the resource.
+func (p *TemplateResourceProcessor) writeRenderCache(dir, key, rendered string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	name := p.getRenderCacheFile(dir, key)
+	temp := name + ".tmp"
+	if err := copyFile(rendered, temp, 0600); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, name); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	olds, err := filepath.Glob(filepath.Join(dir, filepath.Base(p.path)+".*"))
+	if err != nil {
+		return err
+	}
+	for _, s := range olds {
+		if s != name && len(filepath.Ext(s)) == 1+sha256.Size*2 {
+			os.Remove(s)
+		}
+	}
+	return nil
+}
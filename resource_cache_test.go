@@ -0,0 +1,79 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-render-cache-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.RenderCacheDir = filepath.Join(dir, "cache")
+	call := &Call{Config: cfg}
+
+	src := filepath.Join(dir, "app.tmpl")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/app/port"}}`), 0644) == nil)
+
+	newProcessor := func() *TemplateResourceProcessor {
+		p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, mapBackendClient(nil), &TemplateResource{
+			Src:  src,
+			Dest: filepath.Join(dir, "app.conf"),
+		})
+		tAssert(t, err == nil, err)
+		p.store.Set("/app/port", "80")
+		tAssert(t, p.setFileMode(call) == nil)
+		return p
+	}
+	render := func(p *TemplateResourceProcessor) string {
+		tAssert(t, p.createStageFile(call) == nil)
+		defer os.Remove(p.stageFile.Name())
+		data, err := ioutil.ReadFile(p.stageFile.Name())
+		tAssert(t, err == nil, err)
+		return string(data)
+	}
+	hits := func() int64 {
+		return GetMetrics().Get(`libconfd_render_cache_hits_total{resource="app.toml"}`)
+	}
+
+	p := newProcessor()
+	key, ok, err := p.renderCacheKey(call)
+	tAssert(t, err == nil && ok, err)
+
+	n := hits()
+	tAssert(t, render(p) == "port=80")
+	tAssert(t, hits() == n)
+
+	// the cached output is used without rendering
+	data, err := ioutil.ReadFile(p.getRenderCacheFile(cfg.RenderCacheDir, key))
+	tAssert(t, err == nil && string(data) == "port=80", err)
+	tAssert(t, ioutil.WriteFile(p.getRenderCacheFile(cfg.RenderCacheDir, key), []byte("cached"), 0600) == nil)
+
+	p = newProcessor()
+	tAssert(t, render(p) == "cached")
+	tAssert(t, hits() == n+1)
+	tAssert(t, p.stageMd5 == fmt.Sprintf("%x", md5.Sum([]byte("cached"))), p.stageMd5)
+
+	// the changed value renders again, the old entry is removed
+	p.store.Set("/app/port", "8080")
+	tAssert(t, render(p) == "port=8080")
+	tAssert(t, hits() == n+1)
+	files, _ := filepath.Glob(filepath.Join(cfg.RenderCacheDir, "app.toml.*"))
+	tAssert(t, len(files) == 1, files)
+
+	// the volatile funcs are not cached
+	tAssert(t, ioutil.WriteFile(src, []byte(`host={{getenv "HOSTNAME"}}`), 0644) == nil)
+	_, ok, err = p.renderCacheKey(call)
+	tAssert(t, err == nil && !ok, err)
+}
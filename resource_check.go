@@ -0,0 +1,212 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// CheckTemplateResources validates every template resource of the config:
+// the resource TOML is decoded and checked, and its template is parsed.
+// If values is not nil, the template is also executed with values as
+// the backend key/values. It returns all the errors found.
+func CheckTemplateResources(cfg *Config, values map[string]string) []error {
+	var errs []error
+
+	_, paths, err := ListLayeredTemplateResource(cfg.GetConfDirs()...)
+	if err != nil && len(paths) == 0 {
+		return []error{err}
+	}
+
+	for _, path := range paths {
+		if err := checkTemplateResource(cfg, path, values); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", filepath.Base(path), err))
+		}
+	}
+	return errs
+}
+
+func checkTemplateResource(cfg *Config, path string, values map[string]string) error {
+	res, err := LoadTemplateResourceFile(cfg.ConfDir, path)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(res.Src) == "" && res.SrcKey == "" {
+		return fmt.Errorf("missing src")
+	}
+	if res.Src != "" && res.SrcKey != "" {
+		return fmt.Errorf("src and src_key are exclusive")
+	}
+	if strings.TrimSpace(res.Dest) == "" {
+		return fmt.Errorf("missing dest")
+	}
+
+	switch res.Strategy {
+	case "", SyncStrategyRename, SyncStrategySymlink:
+	default:
+		return fmt.Errorf("invalid strategy %q", res.Strategy)
+	}
+	if !validPriority(res.Priority) {
+		return fmt.Errorf("invalid priority %q", res.Priority)
+	}
+	if _, err := res.getDestDirMode(); err != nil {
+		return err
+	}
+	if _, _, err := res.getDestDirOwner(); err != nil {
+		return err
+	}
+	if res.MaxStaleness < 0 {
+		return fmt.Errorf("invalid max_staleness: %d", res.MaxStaleness)
+	}
+	for k, v := range res.KeyAliases {
+		if !validKeyAlias(k, v) {
+			return fmt.Errorf("invalid key_aliases %q = %q", k, v)
+		}
+	}
+	if res.ReloadService != "" && _LIBCONFD_GOOS != "windows" {
+		return fmt.Errorf("reload_service is only supported on windows")
+	}
+	if err := validFuncProfile(res.FuncProfile); err != nil {
+		return err
+	}
+	for i := range res.KeyRules {
+		if err := res.KeyRules[i].Valid(); err != nil {
+			return err
+		}
+	}
+
+	client := mapBackendClient(values)
+	call := &Call{Config: cfg, Client: client}
+
+	p, err := NewTemplateResourceProcessor(path, cfg, client, res)
+	if err != nil {
+		return err
+	}
+	p.updateFuncMap(call)
+
+	if err := p.setFileMode(call); err != nil {
+		return fmt.Errorf("invalid mode %q: %v", res.Mode, err)
+	}
+	if p.SrcKey == "" && p.templateNotExists() {
+		return fmt.Errorf("missing template: %s", p.Src)
+	}
+	for _, rule := range p.KeyRules {
+		if rule.Schema == "" {
+			continue
+		}
+		if _, err := loadJSONSchemaFile(rule.Schema); err != nil {
+			return fmt.Errorf("invalid schema of key rule %s: %v", rule.Key, err)
+		}
+	}
+
+	// the template of src_key is parsed after it is fetched
+	if p.SrcKey == "" {
+		if _, err := p.parseTemplate(); err != nil {
+			return err
+		}
+	}
+	if values == nil {
+		return nil
+	}
+
+	if err := p.setVars(call); err != nil {
+		return err
+	}
+	if err := p.checkKeyRules(); err != nil {
+		return err
+	}
+	tmpl, err := p.parseTemplate()
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(ioutil.Discard, p.newTemplateData())
+}
+
+// RenderTemplateResource renders the template resource of path with
+// values as the backend key/values, and returns the output, the target
+// config file is not touched. The relative path is in the conf.d dir of
+// confdir.
+func RenderTemplateResource(cfg *Config, path string, values map[string]string) ([]byte, error) {
+	res, err := LoadTemplateResourceFile(cfg.ConfDir, path)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.ConfDir, "conf.d", path)
+	}
+
+	client := mapBackendClient(values)
+	call := &Call{Config: cfg, Client: client}
+
+	p, err := NewTemplateResourceProcessor(path, cfg, client, res)
+	if err != nil {
+		return nil, err
+	}
+	p.updateFuncMap(call)
+
+	if err := p.setVars(call); err != nil {
+		return nil, err
+	}
+	if err := p.checkKeyRules(); err != nil {
+		return nil, err
+	}
+	tmpl, err := p.parseTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p.newTemplateData()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadKeyValuesFile loads a JSON object of key/values, such as
+// {"/app/port": "80"}.
+func LoadKeyValuesFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// mapBackendClient is a read only backend of fixed key/values.
+type mapBackendClient map[string]string
+
+func (_ mapBackendClient) Type() string {
+	return "libconfd-backend-internal-map"
+}
+
+func (_ mapBackendClient) WatchEnabled() bool {
+	return false
+}
+
+func (_ mapBackendClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return 0, fmt.Errorf("do not support watch")
+}
+
+func (p mapBackendClient) GetValues(keys []string) (map[string]string, error) {
+	m := make(map[string]string)
+	for k, v := range p {
+		for _, key := range keys {
+			if strings.HasPrefix(k, key) {
+				m[k] = v
+				break
+			}
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckTemplateResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-check-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"conf.d/good.toml": `
+[template]
+src = "good.tmpl"
+dest = "good.conf"
+keys = ["/app"]
+`,
+		"conf.d/bad-tmpl.toml": `
+[template]
+src = "bad.tmpl"
+dest = "bad.conf"
+`,
+		"conf.d/no-src.toml": `
+[template]
+dest = "no-src.conf"
+`,
+		"templates/good.tmpl": `port = {{getv "/app/port"}}`,
+		"templates/bad.tmpl":  `{{getv "/app/port"`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		tAssert(t, os.MkdirAll(filepath.Dir(path), 0755) == nil)
+		tAssert(t, ioutil.WriteFile(path, []byte(content), 0644) == nil)
+	}
+
+	cfg := &Config{ConfDir: dir}
+
+	errs := CheckTemplateResources(cfg, nil)
+	tAssert(t, len(errs) == 2, errs)
+	tAssert(t, strings.HasPrefix(errs[0].Error(), "bad-tmpl.toml:"), errs[0])
+	tAssert(t, strings.HasPrefix(errs[1].Error(), "no-src.toml:"), errs[1])
+
+	// getv fails without the key
+	errs = CheckTemplateResources(cfg, map[string]string{})
+	tAssert(t, len(errs) == 3, errs)
+
+	errs = CheckTemplateResources(cfg, map[string]string{"/app/port": "80"})
+	tAssert(t, len(errs) == 2, errs)
+}
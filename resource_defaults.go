@@ -0,0 +1,66 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultsFileName is the file in conf.d whose fields are inherited by
+// the template resources of the same dir, unless they set the fields:
+//
+//	# conf.d/_defaults.toml
+//	[template]
+//	prefix = "/prod/web"
+//	uid = 0
+//	gid = 0
+//	mode = "0644"
+//	reload_cmd = "systemctl reload nginx"
+//
+// It is never loaded as a template resource, like the other files
+// starting with "_".
+const DefaultsFileName = "_defaults.toml"
+
+// loadTemplateResourceDefaults loads the DefaultsFileName of the conf.d
+// dir, it returns nil if the file does not exist.
+func loadTemplateResourceDefaults(dir string) (*TemplateResource, error) {
+	name := filepath.Join(dir, DefaultsFileName)
+	if !fileExists(name) {
+		return nil, nil
+	}
+
+	p := &_TemplateResourceConfig{
+		TemplateResource: TemplateResource{
+			Gid: -1,
+			Uid: -1,
+		},
+	}
+	if _, err := toml.DecodeFile(name, p); err != nil {
+		return nil, err
+	}
+	return &p.TemplateResource, nil
+}
+
+// inherit copies the fields of defaults not defined in the template
+// resource file decoded with md.
+func (p *TemplateResource) inherit(defaults *TemplateResource, md toml.MetaData) {
+	v := reflect.ValueOf(p).Elem()
+	d := reflect.ValueOf(defaults).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if !md.IsDefined("template", name) {
+			v.Field(i).Set(d.Field(i))
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateResourceDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-defaults-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	confd := filepath.Join(dir, "conf.d")
+	tAssert(t, os.MkdirAll(confd, 0755) == nil)
+
+	write := func(name, s string) {
+		tAssert(t, ioutil.WriteFile(filepath.Join(confd, name), []byte(s), 0644) == nil)
+	}
+	write(DefaultsFileName, `
+[template]
+prefix = "/prod"
+uid = 0
+mode = "0600"
+keys = ["/web"]
+reload_cmd = "systemctl reload nginx"
+`)
+	write("a.toml", `
+[template]
+src = "a.tmpl"
+dest = "/etc/a.conf"
+`)
+	write("b.toml", `
+[template]
+src = "b.tmpl"
+dest = "/etc/b.conf"
+prefix = "/dev"
+uid = 1000
+keys = ["/api"]
+reload_cmd = ""
+`)
+
+	a, err := LoadTemplateResourceFile(dir, "a.toml")
+	tAssert(t, err == nil, err)
+	tAssert(t, a.Src == "a.tmpl" && a.Prefix == "/prod" && a.Uid == 0 && a.Gid == -1, a)
+	tAssert(t, a.Mode == "0600" && strings.Join(a.Keys, ",") == "/web", a)
+	tAssert(t, a.ReloadCmd == "systemctl reload nginx", a.ReloadCmd)
+
+	// the fields set in the file override the defaults, even if empty
+	b, err := LoadTemplateResourceFile(dir, "b.toml")
+	tAssert(t, err == nil, err)
+	tAssert(t, b.Prefix == "/dev" && b.Uid == 1000 && b.Mode == "0600", b)
+	tAssert(t, strings.Join(b.Keys, ",") == "/api" && b.ReloadCmd == "", b)
+
+	// the defaults file is not a template resource
+	tcs, _, err := ListTemplateResource(dir)
+	tAssert(t, err == nil, err)
+	tAssert(t, len(tcs) == 2, tcs)
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DefaultDestDirMode is the mode of the dirs created by create_dest_dirs.
+const DefaultDestDirMode os.FileMode = 0755
+
+// getDestDirMode returns the mode of dest_dir_mode.
+func (p *TemplateResource) getDestDirMode() (os.FileMode, error) {
+	if p.DestDirMode == "" {
+		return DefaultDestDirMode, nil
+	}
+	mode, err := strconv.ParseUint(p.DestDirMode, 0, 32)
+	if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid dest_dir_mode %q", p.DestDirMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// getDestDirOwner returns the uid and gid of dest_dir_owner, or the
+// uid/gid of the resource if not set.
+func (p *TemplateResource) getDestDirOwner() (uid, gid int, err error) {
+	if p.DestDirOwner == "" {
+		return p.Uid, p.Gid, nil
+	}
+	ss := strings.Split(p.DestDirOwner, ":")
+	if len(ss) == 2 {
+		uid, err = strconv.Atoi(ss[0])
+		if err == nil {
+			gid, err = strconv.Atoi(ss[1])
+		}
+	}
+	if len(ss) != 2 || err != nil || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("invalid dest_dir_owner %q, expect uid:gid", p.DestDirOwner)
+	}
+	return uid, gid, nil
+}
+
+// makeDestDirs creates dir and its missing parents, by the mode and owner
+// of create_dest_dirs if set.
+func (p *TemplateResourceProcessor) makeDestDirs(dir string) error {
+	if !p.CreateDestDirs {
+		return os.MkdirAll(dir, 0755)
+	}
+
+	mode, err := p.getDestDirMode()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := p.getDestDirOwner()
+	if err != nil {
+		return err
+	}
+
+	// the missing dirs, from the nearest existing parent
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		if err := os.Mkdir(d, mode); err != nil && !os.IsExist(err) {
+			return err
+		}
+		// not masked by the umask
+		if err := os.Chmod(d, mode); err != nil {
+			return err
+		}
+		if runtime.GOOS != "windows" {
+			if err := os.Chown(d, uid, gid); err != nil {
+				p.logger.Warningf("chown %s to %d:%d failed: %v", d, uid, gid, err)
+			}
+		}
+		p.logger.Infof("Created dest dir %s", d)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTemplateResourceCreateDestDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-destdir-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "templates"), 0755) == nil)
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/app/port"}}`), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	client := mapBackendClient{"/app/port": "80"}
+
+	dest := filepath.Join(dir, "run", "app", "app.conf")
+	res := &TemplateResource{
+		Src:  "app.tmpl",
+		Dest: dest,
+		Keys: []string{"/app"},
+	}
+
+	// fails without create_dest_dirs
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, res)
+	tAssert(t, err == nil, err)
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) != nil)
+
+	res.CreateDestDirs = true
+	res.DestDirMode = "0750"
+	p, err = NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, res)
+	tAssert(t, err == nil, err)
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+
+	data, _ := ioutil.ReadFile(dest)
+	tAssert(t, string(data) == "port=80", string(data))
+
+	if runtime.GOOS != "windows" {
+		for _, d := range []string{filepath.Join(dir, "run"), filepath.Join(dir, "run", "app")} {
+			fi, err := os.Stat(d)
+			tAssert(t, err == nil && fi.Mode().Perm() == 0750, d, fi.Mode())
+		}
+	}
+}
+
+func TestTemplateResourceDestDirSettings(t *testing.T) {
+	res := &TemplateResource{Uid: 1, Gid: 2}
+	mode, err := res.getDestDirMode()
+	tAssert(t, err == nil && mode == DefaultDestDirMode, mode, err)
+	uid, gid, err := res.getDestDirOwner()
+	tAssert(t, err == nil && uid == 1 && gid == 2, uid, gid, err)
+
+	res.DestDirOwner = "100:101"
+	uid, gid, err = res.getDestDirOwner()
+	tAssert(t, err == nil && uid == 100 && gid == 101, uid, gid, err)
+
+	for _, s := range []string{"root", "100", "a:b", "-1:0"} {
+		res.DestDirOwner = s
+		_, _, err = res.getDestDirOwner()
+		tAssert(t, err != nil, s)
+	}
+	for _, s := range []string{"abc", "01777777"} {
+		res.DestDirMode = s
+		_, err = res.getDestDirMode()
+		tAssert(t, err != nil, s)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ListTemplateResourceFS is like ListTemplateResource, but lists the
+// *.toml template resource files at the root of fsys, such as the
+// conf.d dir embedded by go:embed, see Config.ConfFS.
+func ListTemplateResourceFS(fsys fs.FS) ([]*TemplateResource, []string, error) {
+	names, err := fs.Glob(fsys, "*.toml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var paths []string
+	for _, name := range names {
+		name := name
+		if isTemplateResourceShouldBeBuilt(name, func() ([]byte, error) {
+			return fs.ReadFile(fsys, name)
+		}) {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+
+	var lastError error
+	var tcs = make([]*TemplateResource, len(paths))
+
+	for i, name := range paths {
+		if tcs[i], err = LoadTemplateResourceFS(fsys, name); err != nil {
+			lastError = fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return tcs, paths, lastError
+}
+
+// LoadTemplateResourceFS is like LoadTemplateResourceFile, but loads the
+// file of fsys, the fields not set in the file are inherited from the
+// DefaultsFileName of its dir in fsys.
+func LoadTemplateResourceFS(fsys fs.FS, name string) (*TemplateResource, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &_TemplateResourceConfig{
+		TemplateResource: TemplateResource{
+			Gid: -1,
+			Uid: -1,
+		},
+	}
+
+	md, err := toml.Decode(string(data), p)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults, err := loadTemplateResourceDefaultsFS(fsys, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	if defaults != nil {
+		p.TemplateResource.inherit(defaults, md)
+	}
+
+	p.TemplateResource.expandEnv()
+	return &p.TemplateResource, nil
+}
+
+// loadTemplateResourceDefaultsFS is like loadTemplateResourceDefaults,
+// but reads the dir of fsys.
+func loadTemplateResourceDefaultsFS(fsys fs.FS, dir string) (*TemplateResource, error) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, DefaultsFileName))
+	if err != nil {
+		return nil, nil
+	}
+
+	p := &_TemplateResourceConfig{
+		TemplateResource: TemplateResource{
+			Gid: -1,
+			Uid: -1,
+		},
+	}
+	if _, err := toml.Decode(string(data), p); err != nil {
+		return nil, err
+	}
+	return &p.TemplateResource, nil
+}
+
+// templateNotExists reports whether the src template is missing, in the
+// templates dir or the Config.TemplateFS.
+func (p *TemplateResourceProcessor) templateNotExists() bool {
+	if p.templateFS != nil {
+		_, err := fs.Stat(p.templateFS, p.Src)
+		return err != nil
+	}
+	return fileNotExists(p.Src)
+}
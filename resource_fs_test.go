@@ -0,0 +1,58 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestListTemplateResourceFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"_defaults.toml":   {Data: []byte("[template]\nmode = \"0600\"\n")},
+		"app.toml":         {Data: []byte("[template]\nsrc = \"app.tmpl\"\ndest = \"app.conf\"\nkeys = [\"/app\"]\n")},
+		"db.toml":          {Data: []byte("# +build ignore\n[template]\nsrc = \"db.tmpl\"\n")},
+		"templates/a.tmpl": {Data: []byte("a")},
+	}
+
+	tcs, paths, err := ListTemplateResourceFS(fsys)
+	tAssert(t, err == nil, err)
+	tAssert(t, len(paths) == 1 && paths[0] == "app.toml", paths)
+	tAssert(t, tcs[0].Src == "app.tmpl" && tcs[0].Mode == "0600", tcs[0])
+}
+
+func TestTemplateResourceFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-fs-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	cfg.ConfFS = fstest.MapFS{
+		"app.toml": {Data: []byte("[template]\nsrc = \"app/main.tmpl\"\ndest = \"app.conf\"\nkeys = [\"/app\"]\n")},
+	}
+	cfg.TemplateFS = fstest.MapFS{
+		"app/main.tmpl": {Data: []byte(`port={{getv "/app/port"}}`)},
+	}
+	tAssert(t, cfg.Validate() == nil, cfg.Validate())
+
+	client := mapBackendClient{"/app/port": "80"}
+	ts, err := MakeAllTemplateResourceProcessor(cfg, client)
+	tAssert(t, err == nil && len(ts) == 1, err)
+	tAssert(t, ts[0].Process(&Call{Config: cfg, Client: client}) == nil)
+
+	data, err := ioutil.ReadFile(filepath.Join(cfg.GetDefaultTemplateOutputDir(), "app.conf"))
+	tAssert(t, err == nil, err)
+	tAssert(t, string(data) == "port=80", string(data))
+
+	// missing in TemplateFS
+	cfg.TemplateFS = fstest.MapFS{}
+	ts, _ = MakeAllTemplateResourceProcessor(cfg, client)
+	tAssert(t, ts[0].Process(&Call{Config: cfg, Client: client}) != nil)
+}
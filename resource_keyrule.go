@@ -0,0 +1,138 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KeyRule declares the expected value of a key of the template resource,
+// it is checked after the values are read and before rendering.
+// The key is relative to the prefix, like the keys of getv.
+//
+// Example:
+//
+//	[[template.key_rules]]
+//	key = "/app/port"
+//	required = true
+//	type = "int"
+//
+//	[[template.key_rules]]
+//	key = "/app/mode"
+//	enum = ["dev", "prod"]
+//
+//	[[template.key_rules]]
+//	key = "/app/upstreams"
+//	schema = "upstreams.json"
+//
+// The relative schema file is searched in the "schemas" dir of confdir.
+type KeyRule struct {
+	Key      string   `toml:"key" json:"key"`
+	Required bool     `toml:"required" json:"required"`
+	Type     string   `toml:"type" json:"type"` // string/int/float/bool/json
+	Enum     []string `toml:"enum" json:"enum"`
+	Regex    string   `toml:"regex" json:"regex"`
+	Schema   string   `toml:"schema" json:"schema"` // JSON Schema file
+}
+
+// Valid checks the rule itself.
+func (p *KeyRule) Valid() error {
+	if p.Key == "" {
+		return fmt.Errorf("missing key of key rule")
+	}
+	switch p.Type {
+	case "", "string", "int", "float", "bool", "json":
+	default:
+		return fmt.Errorf("invalid type %q of key rule %s", p.Type, p.Key)
+	}
+	if p.Regex != "" {
+		if _, err := regexp.Compile(p.Regex); err != nil {
+			return fmt.Errorf("invalid regex of key rule %s: %v", p.Key, err)
+		}
+	}
+	return nil
+}
+
+// Check checks the value of the key, ok is false if the key does not
+// exist. The value in the error message is masked by redactor.
+func (p *KeyRule) Check(value string, ok bool, redactor *Redactor) error {
+	if !ok {
+		if p.Required {
+			return fmt.Errorf("key %s is required", p.Key)
+		}
+		return nil
+	}
+
+	shown := redactor.Value(p.Key, value)
+
+	switch p.Type {
+	case "int":
+		if _, err := strconv.ParseInt(strings.TrimSpace(value), 0, 64); err != nil {
+			return fmt.Errorf("key %s must be an integer, got '%s'", p.Key, shown)
+		}
+	case "float":
+		if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+			return fmt.Errorf("key %s must be a number, got '%s'", p.Key, shown)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("key %s must be a boolean, got '%s'", p.Key, shown)
+		}
+	case "json":
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("key %s must be JSON, got '%s'", p.Key, shown)
+		}
+	}
+
+	if p.Schema != "" {
+		schema, err := loadJSONSchemaFile(p.Schema)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", p.Key, err)
+		}
+		if err := schema.ValidateJSON([]byte(value)); err != nil {
+			return fmt.Errorf("key %s does not match schema %s: %v", p.Key, filepath.Base(p.Schema), err)
+		}
+	}
+
+	if len(p.Enum) > 0 && !strInStrList(value, p.Enum) {
+		return fmt.Errorf("key %s must be one of %s, got '%s'", p.Key, strings.Join(p.Enum, "/"), shown)
+	}
+	if p.Regex != "" {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("key %s must match %s, got '%s'", p.Key, p.Regex, shown)
+		}
+	}
+	return nil
+}
+
+// checkKeyRules checks the values of the store by the KeyRules, all
+// the violations are reported in the error.
+func (p *TemplateResourceProcessor) checkKeyRules() error {
+	var msgs []string
+	for i := range p.KeyRules {
+		rule := &p.KeyRules[i]
+		if err := rule.Valid(); err != nil {
+			msgs = append(msgs, err.Error())
+			continue
+		}
+		value, ok := p.store.GetValue(rule.Key)
+		if err := rule.Check(value, ok, p.redactor); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("key rules failed: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
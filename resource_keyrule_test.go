@@ -0,0 +1,48 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyRule(t *testing.T) {
+	redactor := NewRedactor("password")
+
+	for i, v := range []struct {
+		rule  KeyRule
+		value string
+		ok    bool
+		err   string
+	}{
+		{KeyRule{Key: "/app/port", Required: true}, "", false, "key /app/port is required"},
+		{KeyRule{Key: "/app/port"}, "", false, ""},
+		{KeyRule{Key: "/app/port", Type: "int"}, "8080", true, ""},
+		{KeyRule{Key: "/app/port", Type: "int"}, "abc", true, "key /app/port must be an integer, got 'abc'"},
+		{KeyRule{Key: "/app/ratio", Type: "float"}, "0.5", true, ""},
+		{KeyRule{Key: "/app/debug", Type: "bool"}, "yes", true, "must be a boolean"},
+		{KeyRule{Key: "/app/meta", Type: "json"}, `{"a":1}`, true, ""},
+		{KeyRule{Key: "/app/meta", Type: "json"}, `{a:1}`, true, "must be JSON"},
+		{KeyRule{Key: "/app/mode", Enum: []string{"dev", "prod"}}, "prod", true, ""},
+		{KeyRule{Key: "/app/mode", Enum: []string{"dev", "prod"}}, "test", true, "must be one of dev/prod"},
+		{KeyRule{Key: "/app/host", Regex: `^[a-z.]+$`}, "a.b", true, ""},
+		{KeyRule{Key: "/app/host", Regex: `^[a-z.]+$`}, "A.B", true, "must match"},
+		{KeyRule{Key: "/db/password", Type: "int"}, "secret", true, "got '" + RedactedValue + "'"},
+	} {
+		err := v.rule.Check(v.value, v.ok, redactor)
+		if v.err == "" {
+			tAssert(t, err == nil, i, err)
+			continue
+		}
+		tAssert(t, err != nil && strings.Contains(err.Error(), v.err), i, err)
+		tAssert(t, !strings.Contains(err.Error(), "secret"), i, err)
+	}
+
+	tAssert(t, (&KeyRule{}).Valid() != nil)
+	tAssert(t, (&KeyRule{Key: "/a", Type: "date"}).Valid() != nil)
+	tAssert(t, (&KeyRule{Key: "/a", Regex: "("}).Valid() != nil)
+	tAssert(t, (&KeyRule{Key: "/a", Type: "int", Regex: "^1"}).Valid() == nil)
+}
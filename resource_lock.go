@@ -0,0 +1,61 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DestLockedError is returned if the lock file of the target config
+// file is held by another process (e.g. another libconfd).
+type DestLockedError struct {
+	Dest     string
+	LockFile string
+}
+
+func (p *DestLockedError) Error() string {
+	return fmt.Sprintf("libconfd: %s is locked by another process (%s)", p.Dest, p.LockFile)
+}
+
+// getDestLockFile returns the lock file of dest, in the same dir.
+func getDestLockFile(dest string) string {
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".lock")
+}
+
+// lockDest takes the advisory lock of the lock file of dest, waits at
+// most timeout. It returns a *DestLockedError if the lock is held by
+// another process. The lock is not supported on windows.
+func lockDest(dest string, timeout time.Duration) (unlock func(), err error) {
+	name := getDestLockFile(dest)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, &DestLockedError{Dest: dest, LockFile: name}
+		}
+		time.Sleep(time.Second / 10)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
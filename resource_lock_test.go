@@ -0,0 +1,38 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLockDest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file lock is not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "libconfd-lock-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "app.conf")
+
+	unlock, err := lockDest(dest, 0)
+	tAssert(t, err == nil, err)
+
+	_, err = lockDest(dest, 0)
+	_, ok := err.(*DestLockedError)
+	tAssert(t, ok, err)
+
+	unlock()
+
+	unlock, err = lockDest(dest, 0)
+	tAssert(t, err == nil, err)
+	unlock()
+}
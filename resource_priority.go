@@ -0,0 +1,144 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"sort"
+	"sync"
+)
+
+// The priorities of the template resources, the higher ones are rendered
+// first in a cycle, and take the free slots of Config.Concurrency first.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal" // default
+	PriorityLow    = "low"
+)
+
+// priorityLevel returns the level of the priority, 0 is the highest.
+func priorityLevel(priority string) int {
+	switch priority {
+	case PriorityHigh:
+		return 0
+	case PriorityLow:
+		return 2
+	}
+	return 1
+}
+
+func validPriority(priority string) bool {
+	switch priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return true
+	}
+	return false
+}
+
+// sortByPriority sorts the template resources by the priority, the order
+// of the same priority is kept.
+func sortByPriority(ts []*TemplateResourceProcessor) {
+	sort.SliceStable(ts, func(i, j int) bool {
+		return priorityLevel(ts[i].Priority) < priorityLevel(ts[j].Priority)
+	})
+}
+
+// renderLimiter limits the template resources processed at the same time.
+// The waiting resources are admitted by the priority, and the last slot
+// is kept for the high priority ones, so they never wait behind the slow
+// resources of the lower priorities.
+type renderLimiter struct {
+	mu      sync.Mutex
+	limit   int // 0 is unlimited
+	running int
+	waiting [3][]chan struct{} // by the priority level
+}
+
+func newRenderLimiter() *renderLimiter {
+	return new(renderLimiter)
+}
+
+func (p *renderLimiter) setLimit(limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.limit = limit
+	p.grant()
+}
+
+// acquire waits for a slot of the priority, it returns false if stopChan
+// is closed first.
+func (p *renderLimiter) acquire(priority string, stopChan chan bool) bool {
+	level := priorityLevel(priority)
+
+	p.mu.Lock()
+	if p.canRun(level) && !p.hasWaiting(level) {
+		p.running++
+		p.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	p.waiting[level] = append(p.waiting[level], ch)
+	p.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-stopChan:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, x := range p.waiting[level] {
+		if x == ch {
+			p.waiting[level] = append(p.waiting[level][:i], p.waiting[level][i+1:]...)
+			return false
+		}
+	}
+	// granted while stopping
+	p.running--
+	p.grant()
+	return false
+}
+
+func (p *renderLimiter) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running--
+	p.grant()
+}
+
+func (p *renderLimiter) canRun(level int) bool {
+	if p.limit <= 0 {
+		return true
+	}
+	if level > 0 && p.limit > 1 {
+		return p.running < p.limit-1
+	}
+	return p.running < p.limit
+}
+
+// hasWaiting reports whether the resources of the level or higher are
+// waiting.
+func (p *renderLimiter) hasWaiting(level int) bool {
+	for i := 0; i <= level; i++ {
+		if len(p.waiting[i]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// grant admits the waiting resources in the order of the priority.
+func (p *renderLimiter) grant() {
+	for level := range p.waiting {
+		for len(p.waiting[level]) > 0 && p.canRun(level) {
+			close(p.waiting[level][0])
+			p.waiting[level] = p.waiting[level][1:]
+			p.running++
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortByPriority(t *testing.T) {
+	var ts []*TemplateResourceProcessor
+	for _, s := range []string{"a:low", "b:", "c:high", "d:normal", "e:high"} {
+		kv := strings.Split(s, ":")
+		ts = append(ts, &TemplateResourceProcessor{
+			TemplateResource: TemplateResource{Priority: kv[1]},
+			path:             kv[0] + ".toml",
+		})
+	}
+	sortByPriority(ts)
+
+	var names []string
+	for _, t := range ts {
+		names = append(names, strings.TrimSuffix(filepath.Base(t.path), ".toml"))
+	}
+	tAssert(t, strings.Join(names, ",") == "c,e,b,d,a", names)
+	tAssert(t, validPriority("") && !validPriority("urgent"))
+}
+
+func TestRenderLimiter(t *testing.T) {
+	limiter := newRenderLimiter()
+	limiter.setLimit(2)
+
+	// the last slot is kept for the high priority
+	tAssert(t, limiter.acquire(PriorityLow, nil))
+
+	granted := make(chan string, 3)
+	acquire := func(priority string) {
+		go func() {
+			if limiter.acquire(priority, nil) {
+				granted <- priority
+			}
+		}()
+		time.Sleep(time.Millisecond * 20)
+	}
+	acquire(PriorityLow)
+	acquire(PriorityNormal)
+	select {
+	case priority := <-granted:
+		t.Fatalf("unexpected %s granted", priority)
+	default:
+	}
+
+	acquire(PriorityHigh)
+	tAssert(t, <-granted == PriorityHigh)
+
+	// the waiting normal is admitted before the low one
+	limiter.release()
+	limiter.release()
+	tAssert(t, <-granted == PriorityNormal)
+	limiter.release()
+	tAssert(t, <-granted == PriorityLow)
+
+	// canceled by stopChan
+	stopChan := make(chan bool)
+	close(stopChan)
+	limiter.setLimit(1)
+	tAssert(t, !limiter.acquire(PriorityNormal, stopChan))
+	limiter.release()
+	tAssert(t, limiter.acquire(PriorityNormal, nil))
+}
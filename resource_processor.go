@@ -5,18 +5,23 @@
 package libconfd
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 )
 
 type TemplateResourceProcessor struct {
@@ -25,13 +30,31 @@ type TemplateResourceProcessor struct {
 	path          string
 	client        BackendClient
 	store         *KVStore
+	redactor      *Redactor
 	stageFile     *os.File
+	stageMd5      string
+	srcBody       []byte // the template of SrcKey
 	templateFunc  *TemplateFunc
 	funcMap       template.FuncMap
 	keepStageFile bool
 	lastIndex     uint64
+	lastChanges   []KeyChange
+	lastSuccess   time.Time
+	pending       *PendingChange // waiting for approval
+	partial       *PartialError  // the failed keys of the last setVars, if allow_partial
+	lastTrace     []LookupTrace  // the store lookups of the last render, if debug
+	templateFS    fs.FS          // Src is read from Config.TemplateFS if set
+	updated       bool           // Dest updated by the last Process
 	syncOnly      bool
 	noop          bool
+	destRoot      string
+
+	renderCacheDir string
+
+	cycle         uint64
+	logger        Logger
+	backendLogger Logger
+	commandLogger Logger
 }
 
 func MakeAllTemplateResourceProcessor(
@@ -40,33 +63,70 @@ func MakeAllTemplateResourceProcessor(
 	[]*TemplateResourceProcessor,
 	error,
 ) {
-	logger.Debug("Loading template resources from confdir " + config.ConfDir)
+	aesKey, err := config.resolveAESKey()
+	if err != nil {
+		return nil, err
+	}
+	return makeAllTemplateResourceProcessor(config, client, aesKey)
+}
 
-	tcs, paths, err := ListTemplateResource(config.GetConfigDir())
+// makeAllTemplateResourceProcessor makes the template resources with the
+// resolved AES key, see Call.aesKey.
+func makeAllTemplateResourceProcessor(
+	config *Config, client BackendClient, aesKey []byte,
+) (
+	[]*TemplateResourceProcessor,
+	error,
+) {
+	var tcs []*TemplateResource
+	var paths []string
+	var err error
+	if config.ConfFS != nil {
+		templateLogger.Debug("Loading template resources from ConfFS")
+		tcs, paths, err = ListTemplateResourceFS(config.ConfFS)
+	} else {
+		confdirs := config.GetConfDirs()
+		templateLogger.Debug("Loading template resources from confdir " + strings.Join(confdirs, ", "))
+		tcs, paths, err = ListLayeredTemplateResource(confdirs...)
+	}
 	if err != nil {
 		if len(paths) == 0 {
-			logger.Warning("Found no templates")
+			templateLogger.Warning("Found no templates")
 			return nil, fmt.Errorf("Found no templates")
 		} else {
-			logger.Warning(err) // skip error
+			templateLogger.Warning(err) // skip error
 		}
 	}
 
 	templates := make([]*TemplateResourceProcessor, len(paths))
 	for i, p := range paths {
-		templates[i] = NewTemplateResourceProcessor(
-			p, config, client, tcs[i],
+		templates[i] = newTemplateResourceProcessor(
+			p, config, client, tcs[i], aesKey,
 		)
 	}
+	sortByPriority(templates)
 
 	return templates, nil
 }
 
 // NewTemplateResourceProcessor creates a NewTemplateResourceProcessor.
+// It returns an error if the AES key of the aes-gcm crypt mode is invalid.
 func NewTemplateResourceProcessor(
 	path string, config *Config, client BackendClient, res *TemplateResource,
+) (*TemplateResourceProcessor, error) {
+	aesKey, err := config.resolveAESKey()
+	if err != nil {
+		return nil, err
+	}
+	return newTemplateResourceProcessor(path, config, client, res, aesKey), nil
+}
+
+// newTemplateResourceProcessor creates a NewTemplateResourceProcessor with
+// the resolved AES key, see Call.aesKey.
+func newTemplateResourceProcessor(
+	path string, config *Config, client BackendClient, res *TemplateResource, aesKey []byte,
 ) *TemplateResourceProcessor {
-	logger.Debug("Loading template resource from " + path)
+	templateLogger.Debug("Loading template resource from " + path)
 
 	tr := TemplateResourceProcessor{
 		TemplateResource: *res,
@@ -74,23 +134,54 @@ func NewTemplateResourceProcessor(
 
 	tr.path = path
 	tr.client = client
-	tr.store = NewKVStore()
+	storeOpts := []KVStoreOption{
+		WithValueSizeLimit(config.MaxValueSize),
+		WithStoreSizeLimit(config.MaxStoreSize),
+		WithStoreMetrics(GetMetrics(), filepath.Base(path)),
+	}
+	if config.IgnoreKeyCase {
+		storeOpts = append(storeOpts, WithIgnoreCaseKeys())
+	}
+	if len(res.KeyAliases) > 0 {
+		storeOpts = append(storeOpts, WithKeyAliases(res.KeyAliases))
+	}
+	tr.store = NewKVStore(storeOpts...)
+	tr.redactor = NewRedactor(config.RedactKeys...)
 	tr.keepStageFile = config.KeepStageFile
 	tr.syncOnly = config.SyncOnly
-	tr.noop = config.Noop
+	tr.noop = config.Noop || tr.Noop
+	tr.renderCacheDir = config.RenderCacheDir
+	tr.setLogContext()
+
+	// replace ${LIBCONFD_CONFDIR} before dest is joined
+	tr.Dest = strings.Replace(tr.Dest, `${LIBCONFD_CONFDIR}`, config.ConfDir, -1)
+	tr.CheckCmd = strings.Replace(tr.CheckCmd, `${LIBCONFD_CONFDIR}`, config.ConfDir, -1)
+	tr.ReloadCmd = strings.Replace(tr.ReloadCmd, `${LIBCONFD_CONFDIR}`, config.ConfDir, -1)
 
 	if config.ConfDir != "" {
-		if s := tr.Dest; !filepath.IsAbs(s) {
+		if s := tr.Dest; !isAbsPath(s) {
 			os.MkdirAll(config.GetDefaultTemplateOutputDir(), 0744)
-			tr.Dest = filepath.Join(config.GetDefaultTemplateOutputDir(), s)
-			tr.Dest = filepath.Clean(tr.Dest)
+			tr.Dest = filepath.Join(config.GetDefaultTemplateOutputDir(), filepath.FromSlash(s))
 		}
 	}
+	if tr.Dest != "" {
+		tr.Dest = filepath.Clean(filepath.FromSlash(tr.Dest))
+	}
+	if config.DestRoot != "" {
+		tr.destRoot = config.DestRoot
+		tr.Dest = rootedPath(config.DestRoot, tr.Dest)
+		tr.StoreDir = rootedPath(config.DestRoot, tr.StoreDir)
+	}
 
 	if config.Prefix != "" {
 		tr.Prefix = config.Prefix
 	}
 
+	if tr.StageDir == "" {
+		tr.StageDir = config.StageDir
+	}
+	tr.StageDir = rootedPath(tr.destRoot, tr.StageDir)
+
 	if !strings.HasPrefix(tr.Prefix, "/") {
 		tr.Prefix = "/" + tr.Prefix
 	}
@@ -108,16 +199,35 @@ func NewTemplateResourceProcessor(
 	}
 
 	tr.templateFunc = NewTemplateFunc(tr.store, tr.PGPPrivateKey)
+	tr.templateFunc.Redactor = tr.redactor
+	tr.templateFunc.AESKey = aesKey
+	tr.templateFunc.dns.setServer(config.DNSServer, time.Duration(config.DNSTimeout)*time.Second)
+	tr.templateFunc.backends.setClients(config.NamedBackends)
+	tr.templateFunc.catalog.setClient(client)
+
+	// the funcs are bound to a copy of TemplateFunc, rebind them to
+	// get the Redactor and AESKey
+	_TemplateFunc_initFuncMap(tr.templateFunc)
 	tr.funcMap = tr.templateFunc.FuncMap
 
-	if !filepath.IsAbs(tr.Src) {
-		tr.Src = filepath.Join(config.GetTemplateDir(), tr.Src)
+	// the template of src_key is fetched from the backend
+	if tr.SrcKey == "" {
+		if !isAbsPath(tr.Src) && config.TemplateFS != nil {
+			tr.templateFS = config.TemplateFS
+			tr.Src = filepath.ToSlash(filepath.Clean(tr.Src))
+		} else if !isAbsPath(tr.Src) {
+			tr.Src = config.lookupFile("templates", filepath.FromSlash(tr.Src))
+		} else {
+			tr.Src = filepath.Clean(filepath.FromSlash(tr.Src))
+		}
 	}
 
-	// replace ${LIBCONFD_CONFDIR}
-	tr.Dest = strings.Replace(tr.Dest, `${LIBCONFD_CONFDIR}`, config.ConfDir, -1)
-	tr.CheckCmd = strings.Replace(tr.CheckCmd, `${LIBCONFD_CONFDIR}`, config.ConfDir, -1)
-	tr.ReloadCmd = strings.Replace(tr.ReloadCmd, `${LIBCONFD_CONFDIR}`, config.ConfDir, -1)
+	tr.KeyRules = append([]KeyRule{}, tr.KeyRules...)
+	for i, rule := range tr.KeyRules {
+		if rule.Schema != "" && !filepath.IsAbs(rule.Schema) {
+			tr.KeyRules[i].Schema = config.lookupFile("schemas", rule.Schema)
+		}
+	}
 
 	return &tr
 }
@@ -128,7 +238,16 @@ func NewTemplateResourceProcessor(
 // things up.
 // It returns an error if any.
 func (p *TemplateResourceProcessor) Process(call *Call) (err error) {
-	if fn := call.Config.HookOnError; fn != nil {
+	p.cycle++
+	p.updated = false
+	p.setLogContext()
+	p.templateFunc.dns.reset()
+	p.templateFunc.backends.reset()
+	p.templateFunc.catalog.reset()
+	p.templateFunc.changes.set(nil)
+
+	hooks, hooksErr := p.getHookSet(call)
+	if fn := hooks.OnError; fn != nil {
 		defer func() {
 			if err != nil {
 				fn(p.path, err)
@@ -136,34 +255,87 @@ func (p *TemplateResourceProcessor) Process(call *Call) (err error) {
 		}()
 	}
 
-	if len(call.Config.FuncMap) > 0 {
-		for k, fn := range call.Config.FuncMap {
-			p.funcMap[k] = fn
-		}
-	}
-	if fn := call.Config.FuncMapUpdater; fn != nil {
-		fn(p.funcMap, p.templateFunc)
+	defer func() { p.publishStatus(call, err) }()
+
+	if hooksErr != nil {
+		p.logger.Error(hooksErr)
+		return hooksErr
 	}
 
+	p.updateFuncMap(call)
+
 	if err := p.setFileMode(call); err != nil {
-		logger.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 	if err := p.setVars(call); err != nil {
-		logger.Error(err)
+		if err == errProcessorStopped {
+			p.logger.Info("Skip sync, processor is stopped")
+			return err
+		}
+		p.logger.Error(err)
+		return err
+	}
+	p.templateFunc.changes.set(p.lastChanges)
+
+	if err := p.checkStaleness(call); err != nil {
+		p.logger.Error("Skip sync: ", err)
+		return err
+	}
+	if err := p.checkKeyRules(); err != nil {
+		p.logger.Error(err)
 		return err
 	}
+	if call.holds.check(p.path, len(p.lastChanges) > 0) {
+		p.logger.Info("Skip sync, rolled back until the values change")
+		return nil
+	}
 	if err := p.createStageFile(call); err != nil {
-		logger.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 	if err := p.sync(call); err != nil {
-		logger.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 	return nil
 }
 
+// updateFuncMap adds the template funcs of the config.
+func (p *TemplateResourceProcessor) updateFuncMap(call *Call) {
+	if len(call.Config.FuncMap) > 0 {
+		for k, fn := range call.Config.FuncMap {
+			p.funcMap[k] = fn
+		}
+	}
+	if fn := call.Config.FuncMapUpdater; fn != nil {
+		fn(p.funcMap, p.templateFunc)
+	}
+
+	// the restricted profile of the config or the resource wins
+	if p.FuncProfile == FuncProfileRestricted {
+		applyFuncProfile(p.funcMap, p.FuncProfile)
+	} else {
+		applyFuncProfile(p.funcMap, call.Config.FuncProfile)
+	}
+}
+
+// setLogContext tags the log lines of the resource with its name and
+// the current cycle, so the output of concurrent watches is attributable.
+func (p *TemplateResourceProcessor) setLogContext() {
+	fields := p.logFields()
+	p.logger = withLogFields(templateLogger, fields...)
+	p.backendLogger = withLogFields(backendLogger, fields...)
+	p.commandLogger = withLogFields(commandLogger, fields...)
+}
+
+func (p *TemplateResourceProcessor) logFields() []logField {
+	return []logField{
+		{Key: "resource", Value: filepath.Base(p.path)},
+		{Key: logFieldCycle, Value: p.cycle},
+	}
+}
+
 // setFileMode sets the FileMode.
 func (p *TemplateResourceProcessor) setFileMode(call *Call) error {
 	if p.Mode == "" {
@@ -184,10 +356,10 @@ func (p *TemplateResourceProcessor) setFileMode(call *Call) error {
 
 // setVars sets the Vars for template resource.
 func (p *TemplateResourceProcessor) setVars(call *Call) error {
-	logger.Debugln("prefix:", p.Prefix)
+	p.logger.Debugln("prefix:", p.Prefix)
 
 	absKeys := p.getAbsKeys()
-	logger.Debugf("absKeys: %#v\n", absKeys)
+	p.logger.Debugf("absKeys: %#v\n", absKeys)
 
 	if fn := call.Config.HookAbsKeyAdjuster; fn != nil {
 		for i, key := range absKeys {
@@ -195,18 +367,106 @@ func (p *TemplateResourceProcessor) setVars(call *Call) error {
 		}
 	}
 
-	values, err := p.client.GetValues(absKeys)
+	var values map[string]string
+	var ttls map[string]time.Duration
+	var err error
+
+	// share the values with the other resources of the cycle
+	client := p.client
+	if call.cycle != nil {
+		client = call.cycle
+	}
+
+	p.partial = nil
+	ctx, cancel := call.backendContext()
+	values, ttls, err = getValuesContext(ctx, client, absKeys)
+	err = contextError(ctx, err)
+	cancel()
 	if err != nil {
-		return err
+		var partial *PartialError
+		if !p.AllowPartial || !errors.As(err, &partial) {
+			return backendError(err)
+		}
+		p.partial = partial
+		GetMetrics().Inc(fmt.Sprintf("libconfd_partial_renders_total{resource=%q}", filepath.Base(p.path)))
+		p.logger.Warning("Render with the partial values, degraded: ", err)
+	}
+	if p.SrcKey != "" {
+		if err := p.fetchTemplate(call, client); err != nil {
+			return err
+		}
 	}
 
-	logger.Debugf("GetValues: %#v\n", values)
+	prev := p.store.clone()
+
+	for i := range p.Transforms {
+		if err := p.Transforms[i].Valid(); err != nil {
+			return err
+		}
+	}
 
 	p.store.Purge()
+	redacted := make(map[string]string, len(values))
+	expand := make(map[string]time.Duration)
 	for k, v := range values {
-		p.store.Set(path.Join("/", strings.TrimPrefix(k, p.Prefix)), v)
+		key := path.Join("/", strings.TrimPrefix(k, p.Prefix))
+		v, children, needExpand, err := p.transformValue(key, v)
+		if err != nil {
+			p.store.Purge()
+			return err
+		}
+		if needExpand {
+			expand[key] = ttls[k]
+		}
+		for ck, cv := range children {
+			if err := p.store.SetWithTTL(ck, cv, ttls[k]); err != nil {
+				p.store.Purge()
+				return err
+			}
+		}
+		if err := p.store.SetWithTTL(key, v, ttls[k]); err != nil {
+			p.store.Purge()
+			return err
+		}
+		redacted[k] = p.redactor.Value(key, v)
+	}
+	if len(expand) > 0 {
+		if err := p.expandValues(expand); err != nil {
+			p.store.Purge()
+			return err
+		}
 	}
 
+	if p.backendLogger.V(2) {
+		p.backendLogger.Debugf("GetValues: %#v\n", redacted)
+	}
+	if p.backendLogger.V(3) && len(ttls) > 0 {
+		p.backendLogger.Debugf("GetValues TTLs: %v\n", ttls)
+	}
+
+	p.lastChanges = DiffStores(prev, p.store)
+
+	return nil
+}
+
+// fetchTemplate gets the template of SrcKey from the backend.
+func (p *TemplateResourceProcessor) fetchTemplate(call *Call, client BackendClient) error {
+	key := p.SrcKey
+	if fn := call.Config.HookAbsKeyAdjuster; fn != nil {
+		key = fn(key)
+	}
+	ctx, cancel := call.backendContext()
+	defer cancel()
+
+	values, _, err := getValuesContext(ctx, client, []string{key})
+	if err = contextError(ctx, err); err != nil {
+		return backendError(err)
+	}
+	body, ok := values[key]
+	if !ok {
+		return fmt.Errorf("Missing template key: %s", p.SrcKey)
+	}
+	p.srcBody = []byte(body)
 	return nil
 }
 
@@ -215,30 +475,61 @@ func (p *TemplateResourceProcessor) setVars(call *Call) error {
 // StageFile for the template resource.
 // It returns an error if any.
 func (p *TemplateResourceProcessor) createStageFile(call *Call) error {
-	if fileNotExists(p.Src) {
+	if p.SrcKey == "" && p.templateNotExists() {
 		err := errors.New("Missing template: " + p.Src)
-		logger.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 
-	tmpl, err := template.New(filepath.Base(p.Src)).Funcs(template.FuncMap(p.funcMap)).ParseFiles(p.Src)
-	if err != nil {
-		err := fmt.Errorf("Unable to process template %s, %s", p.Src, err)
-		logger.Error(err)
-		return err
+	// the cached output is not traced
+	var cacheKey string
+	if p.renderCacheDir != "" && !p.Debug {
+		key, ok, err := p.renderCacheKey(call)
+		if err != nil {
+			p.logger.Warning("render cache skipped: ", err)
+		} else if ok {
+			cacheKey = key
+		}
 	}
 
-	// create TempFile in Dest directory to avoid cross-filesystem issues
-	temp, err := ioutil.TempFile(filepath.Dir(p.Dest), "."+filepath.Base(p.Dest))
+	temp, err := p.openStageFile()
 	if err != nil {
-		logger.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 
-	if err = tmpl.Execute(temp, nil); err != nil {
+	// render through a buffer and hash the output on the fly, so the
+	// large outputs are never held in memory or read again to compare
+	h := md5.New()
+	w := bufio.NewWriterSize(io.MultiWriter(temp, h), 64<<10)
+
+	// the cached output of the same template and values skips both the
+	// parsing and the execution of the template
+	var cached bool
+	if cacheKey != "" {
+		cached, err = p.readRenderCache(p.renderCacheDir, cacheKey, w)
+	}
+	if cached && err == nil {
+		GetMetrics().Inc(fmt.Sprintf("libconfd_render_cache_hits_total{resource=%q}", filepath.Base(p.path)))
+		p.logger.Debug("Using cached output of " + p.getSrcName())
+		err = w.Flush()
+	} else if err == nil {
+		var tmpl *template.Template
+		if tmpl, err = p.parseTemplate(); err == nil {
+			if err = tmpl.Execute(w, p.newTemplateData()); err == nil {
+				err = w.Flush()
+			} else {
+				err = templateExecError(filepath.Base(p.path), err)
+			}
+			if p.Debug {
+				p.logTrace()
+			}
+		}
+	}
+	if err != nil {
 		temp.Close()
 		os.Remove(temp.Name())
-		logger.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 	defer temp.Close()
@@ -248,99 +539,284 @@ func (p *TemplateResourceProcessor) createStageFile(call *Call) error {
 	os.Chmod(temp.Name(), p.FileMode)
 	os.Chown(temp.Name(), p.Uid, p.Gid)
 
+	if cacheKey != "" && !cached {
+		if err := p.writeRenderCache(p.renderCacheDir, cacheKey, temp.Name()); err != nil {
+			p.logger.Warning("write render cache failed: ", err)
+		}
+	}
+
 	p.stageFile = temp
+	p.stageMd5 = fmt.Sprintf("%x", h.Sum(nil))
 	return nil
 }
 
+// openStageFile creates the stage file of Dest, in the Dest directory to
+// avoid cross-filesystem issues, unless the StageDir is set.
+func (p *TemplateResourceProcessor) openStageFile() (*os.File, error) {
+	if err := p.mkDestDir(); err != nil {
+		return nil, err
+	}
+
+	stageDir := filepath.Dir(p.Dest)
+	if p.StageDir != "" {
+		stageDir = p.StageDir
+		if err := os.MkdirAll(stageDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.TempFile(stageDir, "."+filepath.Base(p.Dest))
+}
+
+// parseTemplate parses the src template with the template funcs, the
+// store lookups are traced if Debug is set.
+func (p *TemplateResourceProcessor) parseTemplate() (*template.Template, error) {
+	funcMap := p.funcMap
+	if p.Debug {
+		funcMap = p.traceFuncMap()
+		p.lastTrace = nil
+	}
+
+	var tmpl *template.Template
+	var err error
+	if p.SrcKey != "" {
+		tmpl, err = template.New(path.Base(p.SrcKey)).Funcs(template.FuncMap(funcMap)).Parse(string(p.srcBody))
+	} else if p.templateFS != nil {
+		tmpl, err = template.New(path.Base(p.Src)).Funcs(template.FuncMap(funcMap)).ParseFS(p.templateFS, p.Src)
+	} else {
+		tmpl, err = template.New(filepath.Base(p.Src)).Funcs(template.FuncMap(funcMap)).ParseFiles(p.Src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to process template %s, %s", p.getSrcName(), err)
+	}
+	return tmpl, nil
+}
+
+// getSrcName returns SrcKey or Src of the template.
+func (p *TemplateResourceProcessor) getSrcName() string {
+	if p.SrcKey != "" {
+		return p.SrcKey
+	}
+	return p.Src
+}
+
+// readTemplate returns the source of the template.
+func (p *TemplateResourceProcessor) readTemplate() ([]byte, error) {
+	if p.SrcKey != "" {
+		return p.srcBody, nil
+	}
+	if p.templateFS != nil {
+		return fs.ReadFile(p.templateFS, p.Src)
+	}
+	return ioutil.ReadFile(p.Src)
+}
+
+// isNoop reports whether the target file is not modified by the noop of
+// the config or the resource, or the read-only mode of the processor.
+func (p *TemplateResourceProcessor) isNoop(call *Call) bool {
+	return p.noop || call.isReadOnly()
+}
+
 // sync compares the staged and dest config files and attempts to sync them
 // if they differ. sync will run a config check command if set before
 // overwriting the target config file. Finally, sync will run a reload command
 // if set to have the application or service pick up the changes.
+// If only the mode or owner differ, they are fixed without rewriting dest.
 // It returns an error if any.
 func (p *TemplateResourceProcessor) sync(call *Call) error {
 	staged := p.stageFile.Name()
 
 	if p.keepStageFile {
-		logger.Info("Keeping staged file: " + staged)
+		p.logger.Info("Keeping staged file: " + staged)
 	} else {
 		defer os.Remove(staged)
 	}
 
-	logger.Debug("Comparing candidate config to " + p.Dest)
+	unlock, err := p.lockDest(call)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	p.logger.Debug("Comparing candidate config to " + p.Dest)
 
-	isSame, err := p.checkSameConfig(staged, p.Dest)
+	contentEqual, modeEqual, ownerEqual, err := p.compareStageFile()
 	if err != nil {
-		logger.Warning(err)
+		p.logger.Warning(err)
 		return err
 	}
 
 	if p.noop {
-		logger.Warning("Noop mode enabled. " + p.Dest + " will not be modified")
+		p.logger.Warning("Noop mode enabled. " + p.Dest + " will not be modified")
+		return nil
+	}
+	if call.isReadOnly() {
+		p.logger.Warning("Read-only mode enabled. " + p.Dest + " will not be modified")
 		return nil
 	}
-	if isSame {
-		logger.Debug("Target config " + p.Dest + " in sync")
+	if contentEqual {
+		p.pending = nil
+		call.approvals.setPending(p.path, "")
+	}
+	if contentEqual && modeEqual && ownerEqual {
+		p.logger.Debug("Target config " + p.Dest + " in sync")
 		return nil
 	}
+	if contentEqual {
+		return p.syncFilePerms(modeEqual, ownerEqual)
+	}
 
-	logger.Info("Target config " + p.Dest + " out of sync")
+	p.logger.Info("Target config " + p.Dest + " out of sync")
+	for _, c := range p.lastChanges {
+		p.logger.Info("Changed key: " + p.redactor.Change(c).String())
+	}
 	if !p.syncOnly && strings.TrimSpace(p.CheckCmd) != "" {
 		if err := p.doCheckCmd(call); err != nil {
-			return fmt.Errorf("Config check failed: %v", err)
+			p.notify(call, NotifyEventCheckFailed, err)
+			return err
 		}
 	}
-
-	logger.Debug("Overwriting target config " + p.Dest)
-
-	err = os.Rename(staged, p.Dest)
-	if err != nil {
-		logger.Debug("Rename failed - target is likely a mount. Trying to write instead")
-
-		if !strings.Contains(err.Error(), "device or resource busy") {
+	if p.RequireApproval {
+		approved, err := p.checkApproval(call, staged)
+		if err != nil {
+			p.logger.Warning(err)
 			return err
 		}
+		if !approved {
+			return nil
+		}
+	}
 
-		// try to open the file and write to it
+	p.logger.Debug("Overwriting target config " + p.Dest)
 
-		var contents []byte
-		var rerr error
-		contents, rerr = ioutil.ReadFile(staged)
-		if rerr != nil {
-			return rerr
-		}
+	var audit *AuditRecord
+	if call.Config.AuditLog != "" {
+		audit = p.newAuditRecord(staged)
+	}
 
-		err := ioutil.WriteFile(p.Dest, contents, p.FileMode)
-		// make sure owner and group match the temp file, in case the file was created with WriteFile
-		os.Chown(p.Dest, p.Uid, p.Gid)
-		if err != nil {
-			return err
+	if err := p.writeDest(staged); err != nil {
+		return err
+	}
+
+	if call.Config.ArchiveDir != "" {
+		if err := p.archiveDest(call.Config); err != nil {
+			p.logger.Warning("archive failed: ", err)
 		}
 	}
 
-	if !p.syncOnly && strings.TrimSpace(p.ReloadCmd) != "" {
-		if err := p.doReloadCmd(call); err != nil {
+	if !p.syncOnly && (strings.TrimSpace(p.ReloadCmd) != "" || p.ReloadService != "") {
+		if err := p.doReload(call); err != nil {
+			p.writeAuditRecord(call, audit, err.Error())
+			p.notify(call, NotifyEventReloadFailed, err)
 			return err
 		}
+		p.writeAuditRecord(call, audit, "ok")
+	} else {
+		p.writeAuditRecord(call, audit, "skipped")
 	}
 
-	logger.Info("Target config " + p.Dest + " has been updated")
+	p.logger.Info("Target config " + p.Dest + " has been updated")
+	p.updated = true
+	p.notify(call, NotifyEventUpdated, nil)
+	if fn := p.hookSet(call).OnUpdated; fn != nil {
+		fn(p.path, p.Dest)
+	}
 	return nil
 }
 
+// lockDest takes the lock of Dest if Config.LockDest is set, and checks
+// that Dest is in the dest root. unlock must be called after Dest is
+// written.
+func (p *TemplateResourceProcessor) lockDest(call *Call) (unlock func(), err error) {
+	unlock = func() {}
+	if call.Config.LockDest && !p.isNoop(call) {
+		unlock, err = lockDest(p.Dest, time.Duration(call.Config.LockTimeout)*time.Second)
+		if err != nil {
+			if _, ok := err.(*DestLockedError); ok {
+				GetMetrics().Inc(fmt.Sprintf("libconfd_dest_lock_contended_total{resource=%q}", filepath.Base(p.path)))
+			}
+			p.logger.Warning(err)
+			return nil, err
+		}
+	}
+
+	if err := p.checkDestRoot(); err != nil {
+		unlock()
+		p.logger.Error(err)
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// writeDest replaces Dest by the staged file.
+func (p *TemplateResourceProcessor) writeDest(staged string) error {
+	if p.Strategy == SyncStrategySymlink {
+		return p.syncSymlink(staged)
+	}
+	err := renameFile(staged, p.Dest)
+	if err == nil {
+		return nil
+	}
+
+	p.logger.Debug("Rename failed - target is likely a mount or on another device. Trying to write instead")
+	if !errors.Is(err, syscall.EBUSY) && !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// try to open the file and write to it
+	err = copyFile(staged, p.Dest, p.FileMode)
+	// make sure owner and group match the temp file, in case the file was created with WriteFile
+	os.Chown(p.Dest, p.Uid, p.Gid)
+	return err
+}
+
+// newAuditRecord hashes Dest and the staged file before Dest is replaced.
+func (p *TemplateResourceProcessor) newAuditRecord(staged string) *AuditRecord {
+	oldSum, err := fileSHA256(p.Dest)
+	if err != nil {
+		p.logger.Warning(err)
+	}
+	newSum, err := fileSHA256(staged)
+	if err != nil {
+		p.logger.Warning(err)
+	}
+	return &AuditRecord{
+		Resource:  filepath.Base(p.path),
+		Path:      p.Dest,
+		OldSHA256: oldSum,
+		NewSHA256: newSum,
+	}
+}
+
+func (p *TemplateResourceProcessor) writeAuditRecord(call *Call, r *AuditRecord, reload string) {
+	if r == nil {
+		return
+	}
+	r.Time = time.Now()
+	r.Reload = reload
+	if err := WriteAuditRecord(call.Config.AuditLog, r); err != nil {
+		p.logger.Errorf("write audit log failed: %v", err)
+	}
+}
+
 // check executes the check command to validate the staged config file. The
 // command is modified so that any references to src template are substituted
 // with a string representing the full path of the staged file. This allows the
 // check to be run on the staged file before overwriting the destination config
 // file.
-// It returns nil if the check command returns 0 and there are no other errors.
+// It returns nil if the check command returns 0, or an *ErrCheckFailed.
 func (p *TemplateResourceProcessor) doCheckCmd(call *Call) (err error) {
-	if fn := call.Config.HookOnCheckCmdError; fn != nil {
+	if fn := p.hookSet(call).OnCheckCmdError; fn != nil {
 		defer func() {
 			if err != nil {
 				fn(p.path, p.CheckCmd, err)
 			}
 		}()
 	}
+	defer func() {
+		if err != nil {
+			err = checkFailedError(filepath.Base(p.path), err)
+		}
+	}()
 
 	var cmdBuffer bytes.Buffer
 	data := make(map[string]string)
@@ -352,13 +828,13 @@ func (p *TemplateResourceProcessor) doCheckCmd(call *Call) (err error) {
 	if err := tmpl.Execute(&cmdBuffer, data); err != nil {
 		return err
 	}
-	return p.runCommand(cmdBuffer.String())
+	return p.runCommand(call, cmdBuffer.String())
 }
 
 // reload executes the reload command.
 // It returns nil if the reload command returns 0.
 func (p *TemplateResourceProcessor) doReloadCmd(call *Call) (err error) {
-	if fn := call.Config.HookOnReloadCmdError; fn != nil {
+	if fn := p.hookSet(call).OnReloadCmdError; fn != nil {
 		defer func() {
 			if err != nil {
 				fn(p.path, p.ReloadCmd, err)
@@ -366,61 +842,156 @@ func (p *TemplateResourceProcessor) doReloadCmd(call *Call) (err error) {
 		}()
 	}
 
-	return p.runCommand(p.ReloadCmd)
+	return p.runCommand(call, p.ReloadCmd)
+}
+
+// DefaultServiceTimeout is the max time to wait for the ReloadService
+// to stop before it is started again.
+const DefaultServiceTimeout = 30 * time.Second
+
+// doReload runs the reload command, then restarts the ReloadService.
+func (p *TemplateResourceProcessor) doReload(call *Call) error {
+	if strings.TrimSpace(p.ReloadCmd) != "" {
+		if err := p.doReloadCmd(call); err != nil {
+			return err
+		}
+	}
+	if p.ReloadService != "" {
+		if err := p.doReloadService(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doReloadService restarts the ReloadService by the service manager.
+func (p *TemplateResourceProcessor) doReloadService(call *Call) (err error) {
+	if fn := p.hookSet(call).OnReloadCmdError; fn != nil {
+		defer func() {
+			if err != nil {
+				fn(p.path, "restart service "+p.ReloadService, err)
+			}
+		}()
+	}
+
+	p.commandLogger.Debug("Restarting service " + p.ReloadService)
+	if err := restartService(p.ReloadService, DefaultServiceTimeout); err != nil {
+		p.commandLogger.Error(err)
+		return err
+	}
+	p.commandLogger.Info("Service " + p.ReloadService + " restarted")
+	return nil
 }
 
 // runCommand is a shared function used by check and reload
 // to run the given command and log its output.
-// It returns nil if the given cmd returns 0.
+// It returns nil if the given cmd returns 0, or a *CommandError.
 // The command can be run on unix and windows.
-func (_ *TemplateResourceProcessor) runCommand(cmd string) error {
+func (p *TemplateResourceProcessor) runCommand(call *Call, cmd string) error {
 	cmd = strings.TrimSpace(cmd)
 
-	logger.Debug("TemplateResourceProcessor.runCommand: " + cmd)
+	p.commandLogger.Debug("TemplateResourceProcessor.runCommand: " + cmd)
 
-	if _LIBCONFD_GOOS != runtime.GOOS {
-		err := fmt.Errorf("cross GOOS(%s) donot support runCommand!", _LIBCONFD_GOOS)
-		logger.Error(err)
-		return err
+	runner := call.Config.CommandRunner
+	if runner == nil {
+		if _LIBCONFD_GOOS != runtime.GOOS {
+			err := fmt.Errorf("cross GOOS(%s) donot support runCommand!", _LIBCONFD_GOOS)
+			p.commandLogger.Error(err)
+			return err
+		}
+		runner = DefaultCommandRunner
+		if len(call.Config.Shell) > 0 {
+			runner = &ShellRunner{Shell: call.Config.Shell}
+		}
 	}
 
-	var c *exec.Cmd
-	if runtime.GOOS == "windows" {
-		c = exec.Command("cmd", "/C", cmd)
-	} else {
-		c = exec.Command("/bin/sh", "-c", cmd)
+	result, err := runner.RunCommand(cmd, call.Config.MaxCommandOutput)
+	if result == nil {
+		// a custom runner may return no result
+		result = &CommandResult{Cmd: cmd, ExitCode: -1}
+	}
+	if fn := p.hookSet(call).OnCommand; fn != nil {
+		fn(p.path, result)
 	}
 
-	output, err := c.CombinedOutput()
 	if err != nil {
-		logger.Errorf("%q", string(output))
+		p.commandLogger.Errorf("exit code %d in %v, stdout: %q, stderr: %q",
+			result.ExitCode, result.Duration, result.Stdout, result.Stderr,
+		)
 		return err
 	}
 
-	logger.Debugf("%q", string(output))
+	p.commandLogger.Debugf("exit code %d in %v, stdout: %q, stderr: %q",
+		result.ExitCode, result.Duration, result.Stdout, result.Stderr,
+	)
 	return nil
 }
 
-// checkSameConfig reports whether src and dest config files are equal.
-// Two config files are equal when they have the same file contents and
-// Unix permissions. The owner, group, and mode must match.
-// It return false in other cases.
-func (_ *TemplateResourceProcessor) checkSameConfig(src, dest string) (bool, error) {
-	d, err := readFileStat(dest)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+// syncFilePerms fixes the mode and owner of Dest, the contents are
+// in sync, so the file is not rewritten and no command is run.
+func (p *TemplateResourceProcessor) syncFilePerms(modeEqual, ownerEqual bool) error {
+	if !modeEqual {
+		p.logger.Infof("Target config %s mode out of sync, chmod %v", p.Dest, p.FileMode)
+		if err := os.Chmod(p.Dest, p.FileMode); err != nil {
+			return err
 		}
-		return false, err
 	}
+	if !ownerEqual {
+		p.logger.Infof("Target config %s owner out of sync, chown %d:%d", p.Dest, p.Uid, p.Gid)
+		if err := os.Chown(p.Dest, p.Uid, p.Gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	s, err := readFileStat(src)
+// compareStageFile is like compareConfig of the staged file and Dest,
+// but the staged file is not read again, its hash is computed while
+// rendering.
+func (p *TemplateResourceProcessor) compareStageFile() (contentEqual, modeEqual, ownerEqual bool, err error) {
+	d, err := readFileStat(p.Dest)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return false, nil
+			return false, false, false, nil
 		}
-		return false, err
+		return false, false, false, err
 	}
 
-	return d == s, nil
+	// the stage file is closed after rendering, stat it by name
+	f, err := os.Open(p.stageFile.Name())
+	if err != nil {
+		return false, false, false, err
+	}
+	s, err := statFile(f)
+	f.Close()
+	if err != nil {
+		return false, false, false, err
+	}
+
+	contentEqual = p.stageMd5 == d.Md5
+	modeEqual = s.Mode == d.Mode
+	ownerEqual = s.Uid == d.Uid && s.Gid == d.Gid
+	return
+}
+
+// compareConfig compares the src and dest config files by CompareFiles,
+// the results are all false if any of them does not exist.
+func (_ *TemplateResourceProcessor) compareConfig(src, dest string) (contentEqual, modeEqual, ownerEqual bool, err error) {
+	contentEqual, modeEqual, ownerEqual, err = CompareFiles(src, dest)
+	if os.IsNotExist(err) {
+		return false, false, false, nil
+	}
+	return
+}
+
+// checkSameConfig reports whether src and dest config files are equal.
+// Two config files are equal when they have the same file contents and
+// Unix permissions. The owner, group, and mode must match.
+// It return false in other cases.
+func (p *TemplateResourceProcessor) checkSameConfig(src, dest string) (bool, error) {
+	contentEqual, modeEqual, ownerEqual, err := p.compareConfig(src, dest)
+	if err != nil {
+		return false, err
+	}
+	return contentEqual && modeEqual && ownerEqual, nil
 }
@@ -0,0 +1,124 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTemplateResourcePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-paths-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+
+	for i, v := range []struct {
+		dest   string
+		expect string
+	}{
+		{"app.conf", filepath.Join(cfg.GetDefaultTemplateOutputDir(), "app.conf")},
+		{"app/app.conf", filepath.Join(cfg.GetDefaultTemplateOutputDir(), "app", "app.conf")},
+		{"${LIBCONFD_CONFDIR}/app/app.conf", filepath.Join(dir, "app", "app.conf")},
+		{filepath.Join(dir, "app.conf"), filepath.Join(dir, "app.conf")},
+	} {
+		p, err := NewTemplateResourceProcessor("app.toml", cfg, mapBackendClient(nil), &TemplateResource{
+			Src:  "app/app.tmpl",
+			Dest: v.dest,
+		})
+		tAssert(t, err == nil, err)
+		tAssert(t, p.Dest == v.expect, i, p.Dest)
+		tAssert(t, p.Src == filepath.Join(dir, "templates", "app", "app.tmpl"), i, p.Src)
+	}
+
+	tAssert(t, isAbsPath(dir))
+	tAssert(t, !isAbsPath("app.conf"))
+	if runtime.GOOS == "windows" {
+		tAssert(t, isAbsPath(`\app\app.conf`))
+		tAssert(t, isAbsPath(`C:/app/app.conf`))
+		tAssert(t, !isAbsPath(`C:app.conf`))
+	}
+}
+
+func TestTemplateResourceProcessExistingDest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-process-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(dest, []byte("port=80"), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := mapBackendClient{"/app/port": "8080"}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:    src,
+		Dest:   dest,
+		Prefix: "/app",
+		Keys:   []string{"/port"},
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+
+	data, err := ioutil.ReadFile(dest)
+	tAssert(t, err == nil && string(data) == "port=8080", err, string(data))
+}
+
+func TestTemplateResourceSrcKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-srckey-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "app.conf")
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := mapBackendClient{
+		"/app/port":               "8080",
+		"/templates/app.tmpl":     `port={{getv "/port"}}`,
+		"/templates/app.tmpl.bak": `{{getv "/missing"}}`,
+	}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		SrcKey: "/templates/app.tmpl",
+		Dest:   dest,
+		Prefix: "/app",
+		Keys:   []string{"/port"},
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.getWatchPrefix() == "/", p.getWatchPrefix())
+	tAssert(t, len(p.getWatchKeys()) == 2, p.getWatchKeys())
+
+	readDest := func() string {
+		data, err := ioutil.ReadFile(dest)
+		tAssert(t, err == nil, err)
+		return string(data)
+	}
+
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+	tAssert(t, readDest() == "port=8080", readDest())
+
+	// the template is fetched again by the next process
+	client["/templates/app.tmpl"] = `listen={{getv "/port"}}`
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+	tAssert(t, readDest() == "listen=8080", readDest())
+
+	delete(client, "/templates/app.tmpl")
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) != nil)
+
+	p.Prefix, p.SrcKey = "/apps/web", "/apps/templates/web.tmpl"
+	tAssert(t, p.getWatchPrefix() == "/apps", p.getWatchPrefix())
+	p.SrcKey = "/apps/web/web.tmpl"
+	tAssert(t, p.getWatchPrefix() == "/apps/web", p.getWatchPrefix())
+}
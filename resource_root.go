@@ -0,0 +1,69 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rootedPath returns the path under root, ".." of the path never escapes
+// root. The volume name of the path is dropped.
+func rootedPath(root, path string) string {
+	if root == "" || path == "" {
+		return path
+	}
+	path = path[len(filepath.VolumeName(path)):]
+	return filepath.Join(root, filepath.Clean(string(filepath.Separator)+path))
+}
+
+// checkDestRoot checks the dir of Dest, or its nearest existing parent,
+// is still under the dest root after the symlinks are resolved, so a
+// symlink in the root, such as etc -> /etc, can not redirect the writes
+// out of it.
+func (p *TemplateResourceProcessor) checkDestRoot() error {
+	if p.destRoot == "" {
+		return nil
+	}
+
+	root, err := filepath.EvalSymlinks(p.destRoot)
+	if err != nil {
+		return err
+	}
+	// the nearest existing dir, the missing dirs are created in it
+	dir := filepath.Dir(p.Dest)
+	for {
+		if _, err := os.Lstat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return fmt.Errorf("dest %s resolves to %s, out of dest root %s", p.Dest, dir, p.destRoot)
+	}
+	return nil
+}
+
+// mkDestDir creates the dir of Dest under the dest root, which is usually
+// empty when rendering into a staging root, or anywhere if
+// create_dest_dirs is set.
+func (p *TemplateResourceProcessor) mkDestDir() error {
+	if p.destRoot == "" && !p.CreateDestDirs {
+		return nil
+	}
+	if err := p.checkDestRoot(); err != nil {
+		return err
+	}
+	if err := p.makeDestDirs(filepath.Dir(p.Dest)); err != nil {
+		return err
+	}
+	return p.checkDestRoot()
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRootedPath(t *testing.T) {
+	root := filepath.FromSlash("/build/root")
+	for _, tc := range []struct{ path, want string }{
+		{"", ""},
+		{"/etc/nginx/nginx.conf", "/build/root/etc/nginx/nginx.conf"},
+		{"/etc/../../../etc/passwd", "/build/root/etc/passwd"},
+		{"etc/app.conf", "/build/root/etc/app.conf"},
+	} {
+		got := rootedPath(root, filepath.FromSlash(tc.path))
+		tAssert(t, got == filepath.FromSlash(tc.want), tc.path, got)
+	}
+	tAssert(t, rootedPath("", "/etc/app.conf") == "/etc/app.conf")
+}
+
+func TestTemplateResourceDestRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-root-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/port"}}`), 0644) == nil)
+
+	root := filepath.Join(dir, "root")
+	tAssert(t, os.MkdirAll(root, 0755) == nil)
+
+	cfg := newDefaultConfig().applyOptions(WithDestRoot(root))
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := mapBackendClient{"/app/port": "8080"}
+	process := func(dest string) (*TemplateResourceProcessor, error) {
+		p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+			Src:    src,
+			Dest:   dest,
+			Prefix: "/app",
+			Keys:   []string{"/port"},
+		})
+		tAssert(t, err == nil, err)
+		return p, p.Process(&Call{Config: cfg, Client: client})
+	}
+
+	// the missing dirs are created under the root
+	p, err := process("/etc/app/app.conf")
+	tAssert(t, err == nil, err)
+	tAssert(t, p.Dest == filepath.Join(root, "etc", "app", "app.conf"), p.Dest)
+
+	data, err := ioutil.ReadFile(p.Dest)
+	tAssert(t, err == nil && string(data) == "port=8080", err, string(data))
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// a symlink in the root can not redirect the writes out of it
+	outside := filepath.Join(dir, "outside")
+	tAssert(t, os.MkdirAll(outside, 0755) == nil)
+	tAssert(t, os.Symlink(outside, filepath.Join(root, "opt")) == nil)
+
+	_, err = process("/opt/app.conf")
+	tAssert(t, err != nil)
+	tAssert(t, fileNotExists(filepath.Join(outside, "app.conf")))
+
+	_, err = process("/opt/sub/app.conf")
+	tAssert(t, err != nil)
+	tAssert(t, fileNotExists(filepath.Join(outside, "sub")))
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// getSnapshotTime returns the time of the values of the client, through
+// the wrappers of the client, the oldest of the layers of a
+// MultiBackendClient. ok is false if the values are of a live backend.
+func getSnapshotTime(client BackendClient) (t time.Time, ok bool, err error) {
+	for ; client != nil; client = unwrapBackendClient(client) {
+		if c, ok := backendClientImpl(client).(BackendSnapshotClient); ok {
+			t, err = c.SnapshotTime()
+			return t, true, err
+		}
+		if x, ok := client.(*MultiBackendClient); ok {
+			for _, c := range x.layers {
+				lt, lok, err := getSnapshotTime(c)
+				if err != nil {
+					return time.Time{}, true, err
+				}
+				if lok && (!ok || lt.Before(t)) {
+					t, ok = lt, true
+				}
+			}
+			return t, ok, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// checkStaleness returns *ErrStaleValues if the values of the resource
+// are older than max_staleness, the stale event is sent then.
+func (p *TemplateResourceProcessor) checkStaleness(call *Call) error {
+	if p.MaxStaleness <= 0 {
+		return nil
+	}
+
+	t, ok, err := getSnapshotTime(p.client)
+	if !ok {
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("libconfd: unknown age of the values: %v", err)
+		p.notify(call, NotifyEventStale, err)
+		return err
+	}
+
+	maxStaleness := time.Duration(p.MaxStaleness) * time.Second
+	if age := time.Since(t); age > maxStaleness {
+		err := &ErrStaleValues{
+			Resource:     filepath.Base(p.path),
+			Time:         t,
+			Age:          age,
+			MaxStaleness: maxStaleness,
+		}
+		GetMetrics().Inc(fmt.Sprintf("libconfd_stale_values_total{resource=%q}", filepath.Base(p.path)))
+		p.notify(call, NotifyEventStale, err)
+		return err
+	}
+	return nil
+}
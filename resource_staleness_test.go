@@ -0,0 +1,95 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tSnapshotClient serves the values of a copy taken at time.
+type tSnapshotClient struct {
+	mapBackendClient
+	time time.Time
+}
+
+func (p *tSnapshotClient) SnapshotTime() (time.Time, error) {
+	return p.time, nil
+}
+
+func TestTemplateResourceMaxStaleness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-stale-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/app/port"}}`), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(dest, []byte("port=80"), 0644) == nil)
+
+	var ops tNotifier
+	cfg := newDefaultConfig().applyOptions(WithNotifier("ops", &ops))
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := &tSnapshotClient{
+		mapBackendClient: mapBackendClient{"/app/port": "8080"},
+		time:             time.Now().Add(-2 * time.Hour),
+	}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:          src,
+		Dest:         dest,
+		Keys:         []string{"/app"},
+		Notify:       []string{"ops"},
+		MaxStaleness: 3600,
+	})
+	tAssert(t, err == nil, err)
+
+	// too old, dest untouched
+	err = p.Process(&Call{Config: cfg, Client: client})
+	var stale *ErrStaleValues
+	tAssert(t, errors.As(err, &stale), err)
+	tAssert(t, stale.MaxStaleness == time.Hour && stale.Age > time.Hour, stale)
+	tAssert(t, len(ops) == 1 && ops[0].Type == NotifyEventStale, ops)
+
+	data, _ := ioutil.ReadFile(dest)
+	tAssert(t, string(data) == "port=80", string(data))
+
+	// fresh enough
+	client.time = time.Now().Add(-time.Minute)
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+	data, _ = ioutil.ReadFile(dest)
+	tAssert(t, string(data) == "port=8080", string(data))
+
+	// the oldest layer
+	multi := NewMultiBackendClient(mapBackendClient{}, client, &tSnapshotClient{time: time.Now().Add(-time.Hour)})
+	ts, ok, err := getSnapshotTime(newRateLimitedClient(multi, newRateLimiter(0, 0)))
+	tAssert(t, ok && err == nil && time.Since(ts) > 59*time.Minute, ts, ok, err)
+
+	_, ok, _ = getSnapshotTime(mapBackendClient{})
+	tAssert(t, !ok)
+}
+
+func TestSnapshotBackendTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-stale-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	c := NewSnapshotBackendClient(filepath.Join(dir, "snapshot.db"))
+	defer c.Close()
+	_, err = c.SnapshotTime()
+	tAssert(t, err != nil)
+
+	tAssert(t, c.SaveValues([]string{"/app"}, map[string]string{"/app/port": "80"}) == nil)
+	ts, err := c.SnapshotTime()
+	tAssert(t, err == nil && time.Since(ts) < time.Minute, ts, err)
+
+	m, err := c.GetValues([]string{"/"})
+	tAssert(t, err == nil && len(m) == 1, m, err)
+}
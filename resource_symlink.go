@@ -0,0 +1,153 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The strategies to replace the target config file.
+const (
+	// rename the staged file to Dest (default)
+	SyncStrategyRename = "rename"
+
+	// write the versioned files into the store dir, and flip the
+	// symlink Dest to the new version
+	SyncStrategySymlink = "symlink"
+)
+
+// DefaultKeepVersions is the number of the versioned files kept by the
+// symlink strategy if keep_versions is not set.
+const DefaultKeepVersions = 5
+
+const symlinkVersionLayout = "20060102-150405.000000000"
+
+// getStoreDir returns the dir of the versioned files of Dest.
+func (p *TemplateResourceProcessor) getStoreDir() string {
+	if p.StoreDir != "" {
+		return p.StoreDir
+	}
+	return filepath.Join(filepath.Dir(p.Dest), "."+filepath.Base(p.Dest)+".versions")
+}
+
+// syncSymlink copies the staged file into a new version of the store
+// dir, flips the symlink Dest to it, and removes the old versions.
+func (p *TemplateResourceProcessor) syncSymlink(staged string) error {
+	storeDir := p.getStoreDir()
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		return err
+	}
+
+	base := filepath.Base(p.Dest)
+	version := filepath.Join(storeDir, base+"."+time.Now().Format(symlinkVersionLayout))
+	if err := copyFile(staged, version, p.FileMode); err != nil {
+		return err
+	}
+	os.Chmod(version, p.FileMode)
+	os.Chown(version, p.Uid, p.Gid)
+
+	// the relative target keeps the link valid when the dest root is
+	// copied or mounted elsewhere
+	target := version
+	if p.destRoot != "" {
+		if rel, err := filepath.Rel(filepath.Dir(p.Dest), version); err == nil {
+			target = rel
+		}
+	}
+	if err := swapSymlink(target, p.Dest); err != nil {
+		os.Remove(version)
+		return err
+	}
+	p.logger.Debug("Symlink " + p.Dest + " => " + version)
+
+	keep := p.KeepVersions
+	if keep <= 0 {
+		keep = DefaultKeepVersions
+	}
+	if err := pruneSymlinkVersions(storeDir, base, version, keep); err != nil {
+		p.logger.Warning(err)
+	}
+	return nil
+}
+
+// swapSymlink atomically replaces dest by a symlink to target.
+func swapSymlink(target, dest string) error {
+	tempLink := filepath.Join(filepath.Dir(dest),
+		fmt.Sprintf(".%s.link-%d", filepath.Base(dest), time.Now().UnixNano()),
+	)
+	if err := os.Symlink(target, tempLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tempLink, dest); err != nil {
+		os.Remove(tempLink)
+		return err
+	}
+	return nil
+}
+
+// listSymlinkVersions returns the versioned files of base in storeDir,
+// the oldest first.
+func listSymlinkVersions(storeDir, base string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(storeDir, base+".*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, s := range paths {
+		suffix := strings.TrimPrefix(filepath.Base(s), base+".")
+		if _, err := time.Parse(symlinkVersionLayout, suffix); err == nil {
+			versions = append(versions, s)
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// pruneSymlinkVersions removes the old versions beyond keep, current
+// is never removed.
+func pruneSymlinkVersions(storeDir, base, current string, keep int) error {
+	versions, err := listSymlinkVersions(storeDir, base)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(versions)-keep; i++ {
+		if versions[i] == current {
+			continue
+		}
+		if err := os.Remove(versions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackSymlink flips the symlink dest of the symlink strategy to the
+// version before the current one, and returns the path of that version.
+func RollbackSymlink(dest string) (string, error) {
+	current, err := os.Readlink(dest)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(current) {
+		current = filepath.Join(filepath.Dir(dest), current)
+	}
+
+	versions, err := listSymlinkVersions(filepath.Dir(current), filepath.Base(dest))
+	if err != nil {
+		return "", err
+	}
+	for i := len(versions) - 1; i > 0; i-- {
+		if versions[i] == current {
+			return versions[i-1], swapSymlink(versions[i-1], dest)
+		}
+	}
+	return "", fmt.Errorf("libconfd: no version before %s", current)
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSymlinkVersions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink requires privilege on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "libconfd-symlink-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "app.conf")
+	storeDir := filepath.Join(dir, "versions")
+	tAssert(t, os.MkdirAll(storeDir, 0755) == nil)
+
+	// a regular dest file is replaced by the symlink
+	tAssert(t, ioutil.WriteFile(dest, []byte("v0"), 0644) == nil)
+
+	var versions []string
+	now := time.Now()
+	for i, s := range []string{"v1", "v2", "v3"} {
+		version := filepath.Join(storeDir, "app.conf."+now.Add(time.Duration(i)*time.Second).Format(symlinkVersionLayout))
+		tAssert(t, ioutil.WriteFile(version, []byte(s), 0644) == nil)
+		tAssert(t, swapSymlink(version, dest) == nil)
+		versions = append(versions, version)
+	}
+	tAssert(t, ioutil.WriteFile(filepath.Join(storeDir, "app.conf.bak"), nil, 0644) == nil)
+
+	data, err := ioutil.ReadFile(dest)
+	tAssert(t, err == nil, err)
+	tAssert(t, string(data) == "v3", string(data))
+
+	list, err := listSymlinkVersions(storeDir, "app.conf")
+	tAssert(t, err == nil, err)
+	tAssert(t, len(list) == 3, list)
+
+	version, err := RollbackSymlink(dest)
+	tAssert(t, err == nil, err)
+	tAssert(t, version == versions[1], version)
+
+	data, err = ioutil.ReadFile(dest)
+	tAssert(t, err == nil, err)
+	tAssert(t, string(data) == "v2", string(data))
+
+	tAssert(t, pruneSymlinkVersions(storeDir, "app.conf", versions[1], 1) == nil)
+	list, err = listSymlinkVersions(storeDir, "app.conf")
+	tAssert(t, err == nil, err)
+	tAssert(t, len(list) == 2 && list[0] == versions[1], list)
+
+	_, err = RollbackSymlink(dest)
+	tAssert(t, err != nil)
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// LookupTrace is a store lookup of the template of a resource setting
+// debug = true. The traces of a render are logged, and sent with the
+// NotifyEvent of the render.
+type LookupTrace struct {
+	Func     string        `json:"func"`
+	Key      string        `json:"key,omitempty"`
+	Result   string        `json:"result"` // the secret values are masked
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (p LookupTrace) String() string {
+	s := fmt.Sprintf("%s %q = %s (%v)", p.Func, p.Key, p.Result, p.Duration)
+	if p.Error != "" {
+		s += ": " + p.Error
+	}
+	return s
+}
+
+// the template funcs reading the store, traced by debug
+var traceFuncs = []string{
+	"cget", "cgets", "cgetv", "cgetvs",
+	"exists",
+	"get", "gets", "getv", "getvs",
+	"getFrom", "getsFrom",
+	"getallkv", "getallkvTree",
+	"keyChanged",
+	"ls", "lsdir",
+}
+
+// the max length of LookupTrace.Result
+const maxTraceResult = 256
+
+// traceFuncMap returns a copy of the funcs of the resource, the store
+// lookups of which are recorded to p.lastTrace.
+func (p *TemplateResourceProcessor) traceFuncMap() map[string]interface{} {
+	funcMap := make(map[string]interface{}, len(p.funcMap))
+	for k, fn := range p.funcMap {
+		funcMap[k] = fn
+	}
+	for _, name := range traceFuncs {
+		if fn, ok := funcMap[name]; ok {
+			funcMap[name] = p.traceFunc(name, fn)
+		}
+	}
+	return funcMap
+}
+
+// traceFunc wraps fn with the same signature.
+func (p *TemplateResourceProcessor) traceFunc(name string, fn interface{}) interface{} {
+	v := reflect.ValueOf(fn)
+	return reflect.MakeFunc(v.Type(), func(args []reflect.Value) []reflect.Value {
+		start := time.Now()
+
+		var results []reflect.Value
+		if v.Type().IsVariadic() {
+			results = v.CallSlice(args)
+		} else {
+			results = v.Call(args)
+		}
+
+		trace := LookupTrace{Func: name, Duration: time.Since(start)}
+		if len(args) > 0 && args[0].Kind() == reflect.String {
+			trace.Key = args[0].String()
+		}
+		// getFrom/getsFrom(name, key) of the named backends
+		if strings.HasSuffix(name, "From") && len(args) > 1 {
+			trace.Func = fmt.Sprintf("%s(%s)", name, trace.Key)
+			trace.Key = args[1].String()
+		}
+		if n := len(results); n > 0 {
+			trace.Result = p.traceResult(name, trace.Key, results[0].Interface())
+			if last := results[n-1]; last.Type() == errorType && !last.IsNil() {
+				trace.Error = last.Interface().(error).Error()
+			}
+		}
+		p.lastTrace = append(p.lastTrace, trace)
+
+		return results
+	}).Interface()
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// traceResult formats the result of the lookup of key, with the secret
+// values masked. The values of the patterns and the tree are counted
+// only, the keys of them are unknown to mask.
+func (p *TemplateResourceProcessor) traceResult(name, key string, v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case []string:
+		if name == "getvs" || name == "cgetvs" {
+			s = fmt.Sprintf("%d values", len(v))
+		} else {
+			s = fmt.Sprintf("%q", v)
+		}
+	case map[string]interface{}:
+		s = fmt.Sprintf("%d keys", len(v))
+	case string:
+		s = fmt.Sprintf("%q", p.redactor.Value(key, v))
+	case KVPair:
+		s = fmt.Sprintf("%s=%q", v.Key, p.redactor.Value(v.Key, v.Value))
+	case []KVPair:
+		var ss []string
+		for _, kv := range v {
+			ss = append(ss, fmt.Sprintf("%s=%q", kv.Key, p.redactor.Value(kv.Key, kv.Value)))
+		}
+		s = "[" + strings.Join(ss, " ") + "]"
+	default:
+		if p.redactor.IsSecret(key) {
+			s = RedactedValue
+		} else {
+			s = fmt.Sprint(v)
+		}
+	}
+	if len(s) > maxTraceResult {
+		s = s[:maxTraceResult] + "..."
+	}
+	return s
+}
+
+// logTrace logs the lookups of the last render.
+func (p *TemplateResourceProcessor) logTrace() {
+	for _, trace := range p.lastTrace {
+		p.logger.Info("trace: ", trace)
+	}
+}
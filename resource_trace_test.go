@@ -0,0 +1,55 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateResourceDebugTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-trace-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	tAssert(t, os.MkdirAll(filepath.Join(dir, "templates"), 0755) == nil)
+	src := filepath.Join(dir, "templates", "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(
+		`port={{getv "/app/port"}} user={{getv "/db/user" "none"}} password={{getv "/db/password"}}`,
+	), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	cfg.RedactKeys = []string{"*password*"}
+
+	client := mapBackendClient{"/app/port": "80", "/db/password": "s3cret"}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:   "app.tmpl",
+		Dest:  dest,
+		Keys:  []string{"/app", "/db"},
+		Debug: true,
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+
+	data, _ := ioutil.ReadFile(dest)
+	tAssert(t, string(data) == "port=80 user=none password=s3cret", string(data))
+
+	trace := p.lastTrace
+	tAssert(t, len(trace) == 3, trace)
+	tAssert(t, trace[0].Func == "getv" && trace[0].Key == "/app/port" && trace[0].Result == `"80"`, trace[0])
+	tAssert(t, trace[1].Key == "/db/user" && trace[1].Result == `"none"`, trace[1])
+	tAssert(t, trace[2].Key == "/db/password" && trace[2].Result == `"`+RedactedValue+`"`, trace[2])
+
+	// not traced without debug
+	p.Debug = false
+	p.lastTrace = nil
+	tAssert(t, p.Process(&Call{Config: cfg, Client: client}) == nil)
+	tAssert(t, len(p.lastTrace) == 0, p.lastTrace)
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The steps of the ValueTransform.
+const (
+	TransformTrim           = "trim"            // trim the leading and trailing spaces
+	TransformJSONDecode     = "json-decode"     // unquote the JSON string, flatten the JSON object/array
+	TransformBase64Decode   = "base64-decode"   // decode the std or URL base64, padded or not
+	TransformTemplateExpand = "template-expand" // execute the value as template, must be the last step
+)
+
+// ValueTransform declares the steps applied to the values of the keys
+// matching the pattern, after the values are read and before they are
+// stored for rendering. The pattern is a glob matched against the key
+// relative to the prefix, like the keys of getv.
+//
+// Example:
+//
+//	[[template.transforms]]
+//	key = "/certs/*"
+//	steps = ["base64-decode", "trim"]
+//
+//	[[template.transforms]]
+//	key = "/app/config"
+//	steps = ["json-decode"]
+//
+//	[[template.transforms]]
+//	key = "/app/url"
+//	steps = ["template-expand"]
+//
+// The json-decode step unquotes a JSON string, and stores the fields of a
+// JSON object or array as the child keys, such as /app/config/port of
+// {"port": 80}, the value itself is kept. The template-expand step runs
+// after all the other values are stored, so the value can refer to them,
+// such as "http://{{getv "/app/host"}}:80".
+// The transforms matching the same key are applied in order.
+type ValueTransform struct {
+	Key   string   `toml:"key" json:"key"`
+	Steps []string `toml:"steps" json:"steps"`
+}
+
+// Valid checks the transform itself.
+func (p *ValueTransform) Valid() error {
+	if p.Key == "" {
+		return fmt.Errorf("missing key of transform")
+	}
+	if _, err := path.Match(p.Key, "/"); err != nil {
+		return fmt.Errorf("invalid key of transform %s: %v", p.Key, err)
+	}
+	for i, s := range p.Steps {
+		switch s {
+		case TransformTrim, TransformJSONDecode, TransformBase64Decode:
+		case TransformTemplateExpand:
+			if i != len(p.Steps)-1 {
+				return fmt.Errorf("%s must be the last step of transform %s", s, p.Key)
+			}
+		default:
+			return fmt.Errorf("invalid step %q of transform %s", s, p.Key)
+		}
+	}
+	return nil
+}
+
+// match reports whether the key matches the pattern of the transform.
+func (p *ValueTransform) match(key string) bool {
+	ok, _ := path.Match(p.Key, key)
+	return ok
+}
+
+// transformValue applies the steps of the transforms matching key to
+// value, except template-expand. It returns the child keys of json-decode,
+// and whether the value is expanded as template later.
+func (p *TemplateResourceProcessor) transformValue(key, value string) (string, map[string]string, bool, error) {
+	var children map[string]string
+	var expand bool
+
+	for i := range p.Transforms {
+		tr := &p.Transforms[i]
+		if !tr.match(key) {
+			continue
+		}
+		for _, step := range tr.Steps {
+			switch step {
+			case TransformTrim:
+				value = strings.TrimSpace(value)
+
+			case TransformBase64Decode:
+				data, err := decodeBase64(value)
+				if err != nil {
+					return "", nil, false, fmt.Errorf("transform %s: %s: %v", key, step, err)
+				}
+				value = string(data)
+
+			case TransformJSONDecode:
+				s, kvs, err := decodeJSONValue(key, value)
+				if err != nil {
+					return "", nil, false, fmt.Errorf("transform %s: %s: %v", key, step, err)
+				}
+				value = s
+				for k, v := range kvs {
+					if children == nil {
+						children = make(map[string]string)
+					}
+					children[k] = v
+				}
+
+			case TransformTemplateExpand:
+				expand = true
+			}
+		}
+	}
+	return value, children, expand, nil
+}
+
+// expandValues executes the values of the keys as templates, after all
+// the values are stored. The keys map to the TTLs of the values.
+func (p *TemplateResourceProcessor) expandValues(keys map[string]time.Duration) error {
+	expanded := make(map[string]string, len(keys))
+	for key := range keys {
+		value, _ := p.store.GetValue(key)
+		tmpl, err := template.New(key).Funcs(p.funcMap).Parse(value)
+		if err != nil {
+			return fmt.Errorf("transform %s: %s: %v", key, TransformTemplateExpand, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return fmt.Errorf("transform %s: %s: %v", key, TransformTemplateExpand, err)
+		}
+		expanded[key] = buf.String()
+	}
+
+	// the values refer to the values not yet expanded
+	for key, ttl := range keys {
+		if err := p.store.SetWithTTL(key, expanded[key], ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeBase64 decodes the std or URL base64, padded or not.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.URLEncoding,
+		base64.RawStdEncoding, base64.RawURLEncoding,
+	} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	_, err := base64.StdEncoding.DecodeString(s)
+	return nil, err
+}
+
+// decodeJSONValue decodes the JSON value of key. The JSON string is
+// unquoted, the numbers and bools are kept as is, and the JSON object or
+// array is kept and flattened into the child keys.
+func decodeJSONValue(key, value string) (string, map[string]string, error) {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(value))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return "", nil, err
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x, nil, nil
+	case map[string]interface{}, []interface{}:
+		children := make(map[string]string)
+		flattenJSON(key, x, children)
+		return value, children, nil
+	case nil:
+		return "", nil, nil
+	default:
+		return fmt.Sprint(x), nil, nil
+	}
+}
+
+func flattenJSON(key string, v interface{}, kvs map[string]string) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, v := range x {
+			flattenJSON(path.Join(key, k), v, kvs)
+		}
+	case []interface{}:
+		for i, v := range x {
+			flattenJSON(path.Join(key, strconv.Itoa(i)), v, kvs)
+		}
+	case string:
+		kvs[key] = x
+	case nil:
+		kvs[key] = ""
+	default:
+		kvs[key] = fmt.Sprint(x)
+	}
+}
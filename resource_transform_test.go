@@ -0,0 +1,68 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestValueTransforms(t *testing.T) {
+	cert := base64.StdEncoding.EncodeToString([]byte("  -----BEGIN CERTIFICATE-----\n"))
+
+	cfg := newDefaultConfig()
+	cfg.Prefix = ""
+	client := mapBackendClient{
+		"/app/certs/ca":  cert,
+		"/app/config":    `{"port": 80, "hosts": ["a", "b"], "tls": {"on": true}}`,
+		"/app/name":      `"web\n"`,
+		"/app/host":      " example.com ",
+		"/app/url":       `http://{{getv "/host"}}:{{getv "/config/port"}}`,
+		"/app/untouched": " x ",
+	}
+	p, err := NewTemplateResourceProcessor("app.toml", cfg, client, &TemplateResource{
+		Prefix: "/app",
+		Keys:   []string{"/"},
+		Transforms: []ValueTransform{
+			{Key: "/certs/*", Steps: []string{"base64-decode", "trim"}},
+			{Key: "/config", Steps: []string{"json-decode"}},
+			{Key: "/name", Steps: []string{"json-decode", "trim"}},
+			{Key: "/host", Steps: []string{"trim"}},
+			{Key: "/url", Steps: []string{"template-expand"}},
+		},
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.setVars(&Call{Config: cfg, Client: client}) == nil)
+
+	for k, v := range map[string]string{
+		"/certs/ca":       "-----BEGIN CERTIFICATE-----",
+		"/config/port":    "80",
+		"/config/hosts/1": "b",
+		"/config/tls/on":  "true",
+		"/name":           "web",
+		"/host":           "example.com",
+		"/url":            "http://example.com:80",
+		"/untouched":      " x ",
+	} {
+		got, ok := p.store.GetValue(k)
+		tAssert(t, ok && got == v, k, got)
+	}
+	got, _ := p.store.GetValue("/config")
+	tAssert(t, strings.HasPrefix(got, `{"port"`), got)
+
+	p.Transforms = []ValueTransform{{Key: "/certs/*", Steps: []string{"json-decode"}}}
+	err = p.setVars(&Call{Config: cfg, Client: client})
+	tAssert(t, err != nil && strings.Contains(err.Error(), "transform /certs/ca: json-decode"), err)
+
+	for _, tr := range []ValueTransform{
+		{Steps: []string{"trim"}},
+		{Key: "/a", Steps: []string{"gzip"}},
+		{Key: "/a", Steps: []string{"template-expand", "trim"}},
+		{Key: "[", Steps: []string{"trim"}},
+	} {
+		tAssert(t, tr.Valid() != nil, tr)
+	}
+}
@@ -71,3 +71,15 @@ func secconfEncode(data []byte, keyring io.Reader) ([]byte, error) {
 	}
 	return buffer.Bytes(), nil
 }
+
+// SecconfEncode encodes data with all public keys found in the armored
+// keyring, the result is the value format read by cget/cgetv.
+func SecconfEncode(data []byte, keyring io.Reader) ([]byte, error) {
+	return secconfEncode(data, keyring)
+}
+
+// SecconfDecode decodes the data encoded by SecconfEncode with the
+// armored secret keyring.
+func SecconfDecode(data []byte, secertKeyring io.Reader) ([]byte, error) {
+	return secconfDecode(data, secertKeyring)
+}
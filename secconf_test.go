@@ -30,3 +30,12 @@ func TestSecconfEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestSecconfEncode(t *testing.T) {
+	encoded, err := SecconfEncode([]byte("p@sSw0rd"), bytes.NewBufferString(tSecconf_pubring))
+	tAssert(t, err == nil, err)
+
+	decoded, err := SecconfDecode(encoded, bytes.NewBufferString(tSecconf_secring))
+	tAssert(t, err == nil, err)
+	tAssert(t, string(decoded) == "p@sSw0rd", string(decoded))
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// The schemes of the secure values read by cget/cgets/cgetv/cgetvs.
+const (
+	// secconf: base64(gpg(gzip(data))), with the PGP keyrings (default)
+	CryptModePGP = "pgp"
+
+	// base64(nonce + aes-gcm(data)), with a 128/192/256 bits AES key,
+	// for the FIPS builds without OpenPGP
+	CryptModeAESGCM = "aes-gcm"
+)
+
+// GCMEncode encrypts data by AES-GCM with key, the result is the value
+// format read by the cget* funcs in the aes-gcm crypt mode.
+func GCMEncode(data, key []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, data, nil)
+
+	buf := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(buf, sealed)
+	return buf, nil
+}
+
+// GCMDecode decrypts the data encoded by GCMEncode with key.
+func GCMDecode(data, key []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("libconfd: invalid aes-gcm value")
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadAESKeyFile loads the AES key file, the key is the raw 16/24/32
+// bytes, or encoded by hex or base64.
+func LoadAESKeyFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseAESKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+func parseAESKey(data []byte) ([]byte, error) {
+	isKeySize := func(b []byte) bool {
+		return len(b) == 16 || len(b) == 24 || len(b) == 32
+	}
+
+	if isKeySize(data) {
+		return data, nil
+	}
+
+	s := string(bytes.TrimSpace(data))
+	if b, err := hex.DecodeString(s); err == nil && isKeySize(b) {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && isKeySize(b) {
+		return b, nil
+	}
+	return nil, fmt.Errorf("invalid AES key, must be 16, 24 or 32 bytes")
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGCMEncode(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	data, err := GCMEncode([]byte("p@sSw0rd"), key)
+	tAssert(t, err == nil, err)
+
+	plain, err := GCMDecode(data, key)
+	tAssert(t, err == nil, err)
+	tAssert(t, string(plain) == "p@sSw0rd", string(plain))
+
+	_, err = GCMDecode(data, []byte("fedcba9876543210fedcba9876543210"))
+	tAssert(t, err != nil)
+	_, err = GCMEncode(data, []byte("short"))
+	tAssert(t, err != nil)
+
+	store := NewKVStore()
+	store.Set("/db/password", string(data))
+
+	fn := NewTemplateFunc(store, nil)
+	fn.AESKey = key
+	v, err := fn.Cgetv("/db/password")
+	tAssert(t, err == nil, err)
+	tAssert(t, v == "p@sSw0rd", v)
+
+	_, err = NewTemplateFunc(store, nil).Cgetv("/db/password")
+	tAssert(t, err != nil)
+}
+
+func TestAESKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-aes-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	key := []byte("0123456789abcdef")
+	path := filepath.Join(dir, "aes.key")
+	tAssert(t, ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600) == nil)
+
+	cfg := &Config{CryptMode: CryptModeAESGCM, AESKeyFile: path}
+	v, err := cfg.GetAESKey()
+	tAssert(t, err == nil, err)
+	tAssert(t, string(v) == string(key), v)
+
+	cfg.AESKeyProvider = func() ([]byte, error) { return key, nil }
+	cfg.AESKeyFile = ""
+	v, err = cfg.GetAESKey()
+	tAssert(t, err == nil && string(v) == string(key), err)
+
+	_, err = parseAESKey([]byte("not a key"))
+	tAssert(t, err != nil)
+}
+
+func TestProcessorAESKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-aes-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"conf.d", "templates"} {
+		tAssert(t, os.MkdirAll(filepath.Join(dir, name), 0755) == nil)
+	}
+	tAssert(t, ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(`port={{getv "/port"}}`), 0644) == nil)
+	for _, name := range []string{"app", "web"} {
+		tAssert(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", name+".toml"), []byte(fmt.Sprintf(`
+[template]
+src = "app.tmpl"
+dest = %q
+prefix = "/app"
+keys = ["/port"]
+`, filepath.ToSlash(filepath.Join(dir, name+".conf")))), 0644) == nil)
+	}
+
+	// the provider is called once per call
+	var calls int
+	var providerErr error
+	provider := func() ([]byte, error) {
+		calls++
+		return []byte("0123456789abcdef"), providerErr
+	}
+	cfg := &Config{ConfDir: dir, LogLevel: "ERROR", CryptMode: CryptModeAESGCM, AESKeyProvider: provider}
+	client := mapBackendClient{"/app/port": "80"}
+
+	p := NewProcessor()
+	defer p.Close()
+	err = p.Run(cfg, client, WithOnetimeMode())
+	tAssert(t, err == nil, err)
+	tAssert(t, calls == 1, calls)
+
+	// the call fails if the key is not resolved
+	providerErr = errors.New("kms unavailable")
+	err = p.Run(cfg, client, WithOnetimeMode())
+	tAssert(t, err != nil && calls == 2, err, calls)
+
+	// and so does a template resource out of a call
+	_, err = NewTemplateResourceProcessor(filepath.Join(dir, "conf.d", "app.toml"), cfg, client, &TemplateResource{Src: "app.tmpl"})
+	tAssert(t, err != nil && calls == 3, err, calls)
+	_, err = RenderTemplateResource(cfg, "app.toml", nil)
+	tAssert(t, err != nil, err)
+}
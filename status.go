@@ -0,0 +1,110 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Version is the version of libconfd, reported in the RenderStatus.
+const Version = "0.1.0"
+
+// RenderStatus is published by the Processor to the backend under
+// Config.StatusPrefix, one JSON value per host and template resource:
+//
+//	<status-prefix>/<hostname>/<resource name>
+//
+// so the dashboards can compare the checksums of the same resource across
+// the fleet to find the drifted hosts.
+type RenderStatus struct {
+	Host        string    `json:"host"`
+	Resource    string    `json:"resource"`
+	Dest        string    `json:"dest"`
+	LastSuccess time.Time `json:"last_success"` // zero if never succeeded
+	Checksum    string    `json:"checksum"`     // SHA256 of dest, empty if not exists
+	Version     string    `json:"version"`      // libconfd Version
+	Degraded    bool      `json:"degraded"`     // rendered with the partial values
+	FailedKeys  []string  `json:"failed_keys,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// getStoreWriter returns the StoreWriter of the client.
+func getStoreWriter(client BackendClient) (StoreWriter, bool) {
+	for ; client != nil; client = unwrapBackendClient(client) {
+		if c, ok := backendClientImpl(client).(StoreWriter); ok {
+			return c, true
+		}
+		if x, ok := client.(*MultiBackendClient); ok {
+			// the first layer accepting writes
+			for _, c := range x.layers {
+				if w, ok := getStoreWriter(c); ok {
+					return w, true
+				}
+			}
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// getStatusKey returns the key of the RenderStatus of the resource.
+func getStatusKey(prefix, hostname, trName string) string {
+	return path.Join("/", prefix, hostname, filepath.Base(trName))
+}
+
+// publishStatus writes the RenderStatus of the cycle to the backend, the
+// failures are logged and never fail the cycle.
+func (p *TemplateResourceProcessor) publishStatus(call *Call, processErr error) {
+	if call.Config.StatusPrefix == "" || p.isNoop(call) || processErr == errProcessorStopped {
+		return
+	}
+
+	w, ok := getStoreWriter(p.client)
+	if !ok {
+		p.logger.Debugf("backend %s does not support writing, status not published", p.client.Type())
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	status := &RenderStatus{
+		Host:     hostname,
+		Resource: filepath.Base(p.path),
+		Dest:     p.Dest,
+		Version:  Version,
+	}
+	if processErr != nil {
+		status.Error = processErr.Error()
+	} else {
+		p.lastSuccess = time.Now()
+	}
+	status.LastSuccess = p.lastSuccess
+	if p.partial != nil {
+		status.Degraded = true
+		status.FailedKeys = p.partial.Keys()
+	}
+
+	sum, err := fileSHA256(p.Dest)
+	if err != nil {
+		p.logger.Warning(err)
+	}
+	status.Checksum = sum
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		p.logger.Warning(err)
+		return
+	}
+
+	key := getStatusKey(call.Config.StatusPrefix, hostname, p.path)
+	if err := w.SetValues(map[string]string{key: string(data)}); err != nil {
+		GetMetrics().Inc(fmt.Sprintf("libconfd_status_publish_errors_total{resource=%q}", filepath.Base(p.path)))
+		p.logger.Warningf("publish status %s failed: %v", key, err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tWriterClient struct {
+	mapBackendClient
+	written map[string]string
+}
+
+func (p *tWriterClient) SetValues(values map[string]string) error {
+	for k, v := range values {
+		p.written[k] = v
+	}
+	return nil
+}
+
+func TestPublishStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-status-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	tAssert(t, ioutil.WriteFile(src, []byte(`port={{getv "/port"}}`), 0644) == nil)
+
+	cfg := newDefaultConfig().applyOptions(WithStatusPrefix("/confd/status"))
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+
+	client := &tWriterClient{
+		mapBackendClient: mapBackendClient{"/app/port": "8080"},
+		written:          make(map[string]string),
+	}
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, client, &TemplateResource{
+		Src:    src,
+		Dest:   dest,
+		Prefix: "/app",
+		Keys:   []string{"/port"},
+	})
+	tAssert(t, err == nil, err)
+	call := &Call{Config: cfg, Client: client}
+	tAssert(t, p.Process(call) == nil)
+
+	hostname, _ := os.Hostname()
+	key := getStatusKey("/confd/status", hostname, "app.toml")
+
+	var status RenderStatus
+	tAssert(t, json.Unmarshal([]byte(client.written[key]), &status) == nil, client.written)
+	sum, _ := fileSHA256(dest)
+	tAssert(t, status.Resource == "app.toml" && status.Dest == dest, status)
+	tAssert(t, status.Checksum == sum && status.Version == Version && status.Error == "", status)
+	tAssert(t, !status.LastSuccess.IsZero(), status)
+	lastSuccess := status.LastSuccess
+
+	// the failure keeps the last success time
+	tAssert(t, os.Remove(src) == nil)
+	tAssert(t, p.Process(call) != nil)
+
+	status = RenderStatus{}
+	tAssert(t, json.Unmarshal([]byte(client.written[key]), &status) == nil, client.written)
+	tAssert(t, status.Error != "" && status.LastSuccess.Equal(lastSuccess), status)
+	tAssert(t, status.Checksum == sum, status)
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TemplateData is the data of the template evaluation, so the generated
+// files can embed a provenance header:
+//
+//	# generated by libconfd from {{.ResourceName}} on {{.Hostname}}
+//	# at {{.RenderTime.Format "2006-01-02T15:04:05Z07:00"}}
+type TemplateData struct {
+	ResourceName string    // file name of the template resource, such as nginx.toml
+	Prefix       string    // prefix of the keys
+	Src          string    // path of the template
+	Dest         string    // path of the target config file
+	Hostname     string    // empty if unknown
+	RenderTime   time.Time // the time the rendering starts
+}
+
+// newTemplateData returns the data of the template evaluation.
+func (p *TemplateResourceProcessor) newTemplateData() *TemplateData {
+	hostname, _ := os.Hostname()
+	return &TemplateData{
+		ResourceName: filepath.Base(p.path),
+		Prefix:       p.Prefix,
+		Src:          p.Src,
+		Dest:         p.Dest,
+		Hostname:     hostname,
+		RenderTime:   time.Now(),
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-tmpl-data-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	tAssert(t, ioutil.WriteFile(src, []byte(
+		`{{.ResourceName}} {{.Prefix}} {{.Hostname}} {{if .RenderTime.IsZero}}zero{{else}}now{{end}}`,
+	), 0644) == nil)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir = dir
+	cfg.Prefix = ""
+	call := &Call{Config: cfg}
+
+	p, err := NewTemplateResourceProcessor(filepath.Join(dir, "app.toml"), cfg, mapBackendClient(nil), &TemplateResource{
+		Src:    src,
+		Dest:   filepath.Join(dir, "app.conf"),
+		Prefix: "/app",
+	})
+	tAssert(t, err == nil, err)
+	tAssert(t, p.setFileMode(call) == nil)
+	tAssert(t, p.createStageFile(call) == nil)
+	defer os.Remove(p.stageFile.Name())
+
+	hostname, _ := os.Hostname()
+	data, err := ioutil.ReadFile(p.stageFile.Name())
+	tAssert(t, err == nil, err)
+	tAssert(t, string(data) == "app.toml /app "+hostname+" now", string(data))
+}
@@ -8,6 +8,7 @@ package libconfd
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -25,6 +26,18 @@ type TemplateFunc struct {
 	FuncMap       map[string]interface{}
 	Store         *KVStore
 	PGPPrivateKey []byte
+
+	// AES key of the cget* funcs in the aes-gcm crypt mode,
+	// the PGPPrivateKey is not used if set
+	AESKey []byte
+
+	// keys read by cget* are marked as secret
+	Redactor *Redactor
+
+	dns      *templateDNS
+	backends *templateBackends
+	catalog  *templateCatalog
+	changes  *templateChanges
 }
 
 var _TemplateFunc_initFuncMap func(p *TemplateFunc) = nil
@@ -34,6 +47,10 @@ func NewTemplateFunc(store *KVStore, pgpPrivateKey []byte) *TemplateFunc {
 		FuncMap:       map[string]interface{}{},
 		Store:         store,
 		PGPPrivateKey: pgpPrivateKey,
+		dns:           newTemplateDNS(),
+		backends:      newTemplateBackends(),
+		catalog:       newTemplateCatalog(),
+		changes:       new(templateChanges),
 	}
 
 	if _TemplateFunc_initFuncMap == nil {
@@ -82,22 +99,142 @@ func (p TemplateFunc) Getvs(pattern string) ([]string, error) {
 	return p.Store.GetAllValues(pattern)
 }
 
+// Getallkv returns all the KVPairs loaded for the resource, sorted by key.
+func (p TemplateFunc) Getallkv() []KVPair {
+	var kvs []KVPair
+	p.Store.Range("/", func(kv KVPair) bool {
+		kvs = append(kvs, kv)
+		return true
+	})
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	return kvs
+}
+
+// GetallkvTree returns all the values loaded for the resource as nested
+// maps by the key path, such as {"app": {"port": "80"}} of /app/port.
+// The value of a key having children is kept under the "" key of its map.
+func (p TemplateFunc) GetallkvTree() map[string]interface{} {
+	root := make(map[string]interface{})
+	for _, kv := range p.Getallkv() {
+		node := root
+		names := strings.Split(strings.Trim(kv.Key, "/"), "/")
+		for i, name := range names {
+			if i == len(names)-1 {
+				if child, ok := node[name].(map[string]interface{}); ok {
+					child[""] = kv.Value
+				} else {
+					node[name] = kv.Value
+				}
+				break
+			}
+
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				if v, ok := node[name].(string); ok {
+					child[""] = v
+				}
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return root
+}
+
+// ConfigHash returns the sha256 of the keys and values loaded for the
+// resource, or the keys under the prefixes if any, such as
+// {{configHash "/app" "/db"}}. It changes only if the data changes, so
+// the rendered files can embed it as a fingerprint. The values read by
+// cget* are hashed encrypted as they are stored.
+func (p TemplateFunc) ConfigHash(prefixes ...string) string {
+	var cleaned []string
+	for _, prefix := range prefixes {
+		cleaned = append(cleaned, pathpkg.Clean("/"+prefix))
+	}
+
+	h := sha256.New()
+	for _, kv := range p.Getallkv() {
+		if len(cleaned) > 0 && !matchKeyPrefix(kv.Key, cleaned) {
+			continue
+		}
+		fmt.Fprintf(h, "%d:%s=%d:%s\x00", len(kv.Key), kv.Key, len(kv.Value), kv.Value)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func matchKeyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if key == prefix || prefix == "/" || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// templateChanges holds the key changes of the processing cycle, shared
+// by the copies of TemplateFunc bound to the FuncMap.
+type templateChanges struct {
+	changes []KeyChange
+}
+
+func (p *templateChanges) set(changes []KeyChange) {
+	p.changes = changes
+}
+
+// ChangedKeys returns the keys added, removed or modified since the last
+// render of the resource, sorted by key. All the keys are added at the
+// first render.
+func (p TemplateFunc) ChangedKeys() []string {
+	keys := make([]string, 0, len(p.changes.changes))
+	for _, c := range p.changes.changes {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+// KeyChanges is like ChangedKeys, but returns the old and new values too,
+// the values of the secret keys are masked.
+func (p TemplateFunc) KeyChanges() []KeyChange {
+	changes := make([]KeyChange, 0, len(p.changes.changes))
+	for _, c := range p.changes.changes {
+		changes = append(changes, p.Redactor.Change(c))
+	}
+	return changes
+}
+
+// KeyChanged reports whether the key, or any key under it, changed since
+// the last render of the resource.
+func (p TemplateFunc) KeyChanged(key string) bool {
+	key = pathpkg.Clean("/" + key)
+	for _, c := range p.changes.changes {
+		if c.Key == key || key == "/" || strings.HasPrefix(c.Key, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // ----------------------------------------------------------------------------
 // Crypt func
 // ----------------------------------------------------------------------------
 
 func (p TemplateFunc) Cget(key string) (KVPair, error) {
-	if len(p.PGPPrivateKey) == 0 {
-		return KVPair{}, fmt.Errorf("PGPPrivateKey is empty")
+	if err := p.checkSecretKey(); err != nil {
+		return KVPair{}, err
 	}
 
+	p.Redactor.AddPattern(key)
+
 	kv, err := p.FuncMap["get"].(func(string) (KVPair, error))(key)
 	if err != nil {
 		return KVPair{}, err
 	}
 
 	var b []byte
-	b, err = secconfDecode([]byte(kv.Value), bytes.NewBuffer(p.PGPPrivateKey))
+	b, err = p.decodeSecret([]byte(kv.Value))
 	if err != nil {
 		return KVPair{}, err
 	}
@@ -107,17 +244,19 @@ func (p TemplateFunc) Cget(key string) (KVPair, error) {
 }
 
 func (p TemplateFunc) Cgets(pattern string) ([]KVPair, error) {
-	if len(p.PGPPrivateKey) == 0 {
-		return nil, fmt.Errorf("PGPPrivateKey is empty")
+	if err := p.checkSecretKey(); err != nil {
+		return nil, err
 	}
 
+	p.Redactor.AddPattern(pattern)
+
 	kvs, err := p.FuncMap["gets"].(func(string) ([]KVPair, error))(pattern)
 	if err != nil {
 		return nil, err
 	}
 
 	for i := range kvs {
-		b, err := secconfDecode([]byte(kvs[i].Value), bytes.NewBuffer(p.PGPPrivateKey))
+		b, err := p.decodeSecret([]byte(kvs[i].Value))
 		if err != nil {
 			return nil, err
 		}
@@ -127,17 +266,19 @@ func (p TemplateFunc) Cgets(pattern string) ([]KVPair, error) {
 }
 
 func (p TemplateFunc) Cgetv(key string) (string, error) {
-	if len(p.PGPPrivateKey) == 0 {
-		return "", fmt.Errorf("PGPPrivateKey is empty")
+	if err := p.checkSecretKey(); err != nil {
+		return "", err
 	}
 
+	p.Redactor.AddPattern(key)
+
 	v, err := p.FuncMap["getv"].(func(string, ...string) (string, error))(key)
 	if err != nil {
 		return "", err
 	}
 
 	var b []byte
-	b, err = secconfDecode([]byte(v), bytes.NewBuffer(p.PGPPrivateKey))
+	b, err = p.decodeSecret([]byte(v))
 	if err != nil {
 		return "", err
 	}
@@ -146,17 +287,19 @@ func (p TemplateFunc) Cgetv(key string) (string, error) {
 }
 
 func (p TemplateFunc) Cgetvs(pattern string) ([]string, error) {
-	if len(p.PGPPrivateKey) == 0 {
-		return nil, fmt.Errorf("PGPPrivateKey is empty")
+	if err := p.checkSecretKey(); err != nil {
+		return nil, err
 	}
 
+	p.Redactor.AddPattern(pattern)
+
 	vs, err := p.FuncMap["getvs"].(func(string) ([]string, error))(pattern)
 	if err != nil {
 		return nil, err
 	}
 
 	for i := range vs {
-		b, err := secconfDecode([]byte(vs[i]), bytes.NewBuffer(p.PGPPrivateKey))
+		b, err := p.decodeSecret([]byte(vs[i]))
 		if err != nil {
 			return nil, err
 		}
@@ -165,6 +308,22 @@ func (p TemplateFunc) Cgetvs(pattern string) ([]string, error) {
 	return vs, nil
 }
 
+func (p TemplateFunc) checkSecretKey() error {
+	if len(p.AESKey) == 0 && len(p.PGPPrivateKey) == 0 {
+		return fmt.Errorf("PGPPrivateKey and AESKey are empty")
+	}
+	return nil
+}
+
+// decodeSecret decodes the secure value by the AESKey if set,
+// or by the PGPPrivateKey.
+func (p TemplateFunc) decodeSecret(data []byte) ([]byte, error) {
+	if len(p.AESKey) > 0 {
+		return GCMDecode(data, p.AESKey)
+	}
+	return secconfDecode(data, bytes.NewBuffer(p.PGPPrivateKey))
+}
+
 // ----------------------------------------------------------------------------
 // util func
 // ----------------------------------------------------------------------------
@@ -251,35 +410,28 @@ func (_ TemplateFunc) TrimSuffix(s, suffix string) string {
 	return strings.TrimSuffix(s, suffix)
 }
 
-func (_ TemplateFunc) LookupIP(data string) []string {
-	ips, err := net.LookupIP(data)
-	if err != nil {
-		return nil
-	}
-	// "Cast" IPs into strings and sort the array
-	ipStrings := make([]string, len(ips))
-
-	for i, ip := range ips {
-		ipStrings[i] = ip.String()
-	}
-	sort.Strings(ipStrings)
-	return ipStrings
+// LookupIP returns the sorted IPs of data, or nil if the lookup failed.
+func (p TemplateFunc) LookupIP(data string) []string {
+	ips, _ := p.MustLookupIP(data)
+	return ips
 }
 
-func (_ TemplateFunc) LookupSRV(service, proto, name string) []*net.SRV {
-	_, s, err := net.LookupSRV(service, proto, name)
-	if err != nil {
-		return nil
-	}
+// MustLookupIP is like LookupIP, but the error fails the rendering.
+func (p TemplateFunc) MustLookupIP(data string) ([]string, error) {
+	return p.dns.lookupIP(data)
+}
 
-	sort.Slice(s, func(i, j int) bool {
-		str1 := fmt.Sprintf("%s%d%d%d", s[i].Target, s[i].Port, s[i].Priority, s[i].Weight)
-		str2 := fmt.Sprintf("%s%d%d%d", s[j].Target, s[j].Port, s[j].Priority, s[j].Weight)
-		return str1 < str2
-	})
+// LookupSRV returns the sorted SRV records, or nil if the lookup failed.
+func (p TemplateFunc) LookupSRV(service, proto, name string) []*net.SRV {
+	s, _ := p.MustLookupSRV(service, proto, name)
 	return s
 }
 
+// MustLookupSRV is like LookupSRV, but the error fails the rendering.
+func (p TemplateFunc) MustLookupSRV(service, proto, name string) ([]*net.SRV, error) {
+	return p.dns.lookupSRV(service, proto, name)
+}
+
 func (_ TemplateFunc) FileExists(filepath string) bool {
 	_, err := os.Stat(filepath)
 	return err == nil
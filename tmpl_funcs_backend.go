@@ -0,0 +1,104 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// templateBackends are the named backends read by getFrom and getsFrom,
+// the values are cached until reset, which is called at the start of
+// every cycle.
+type templateBackends struct {
+	mu      sync.Mutex
+	clients map[string]BackendClient
+	values  map[string]map[string]string // name:prefix => values
+}
+
+func newTemplateBackends() *templateBackends {
+	return &templateBackends{
+		clients: make(map[string]BackendClient),
+		values:  make(map[string]map[string]string),
+	}
+}
+
+func (p *templateBackends) setClients(clients map[string]BackendClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clients = make(map[string]BackendClient)
+	for name, client := range clients {
+		p.clients[name] = client
+	}
+	p.values = make(map[string]map[string]string)
+}
+
+// reset drops the cached values.
+func (p *templateBackends) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.values = make(map[string]map[string]string)
+}
+
+// getValues returns the values of the keys with prefix in the backend.
+func (p *templateBackends) getValues(name, prefix string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("backend %q not found", name)
+	}
+
+	cacheKey := name + ":" + prefix
+	if m, ok := p.values[cacheKey]; ok {
+		return m, nil
+	}
+
+	m, err := client.GetValues([]string{prefix})
+	if err != nil {
+		return nil, fmt.Errorf("backend %q: %v", name, err)
+	}
+	p.values[cacheKey] = m
+	return m, nil
+}
+
+// GetFrom returns the value of key in the named backend, see
+// Config.NamedBackends.
+func (p TemplateFunc) GetFrom(name, key string) (string, error) {
+	m, err := p.backends.getValues(name, key)
+	if err != nil {
+		return "", err
+	}
+	v, ok := m[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in backend %q", key, name)
+	}
+	return v, nil
+}
+
+// GetsFrom returns the key/values with prefix in the named backend,
+// sorted by key.
+func (p TemplateFunc) GetsFrom(name, prefix string) ([]KVPair, error) {
+	m, err := p.backends.getValues(name, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := make([]KVPair, 0, len(m))
+	for k, v := range m {
+		if strings.HasPrefix(k, prefix) {
+			kvs = append(kvs, KVPair{Key: k, Value: v})
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	return kvs, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestGetFrom(t *testing.T) {
+	vault := &tCountingClient{mapBackendClient: mapBackendClient{
+		"/secret/db/password": "123456",
+		"/secret/db/user":     "root",
+	}}
+
+	fn := NewTemplateFunc(NewKVStore(), nil)
+	fn.backends.setClients(map[string]BackendClient{"vault": vault})
+
+	tmpl, err := template.New("").Funcs(fn.FuncMap).Parse(
+		`{{getFrom "vault" "/secret/db/password"}}` +
+			`{{range getsFrom "vault" "/secret/db/"}} {{.Key}}={{.Value}}{{end}}` +
+			`{{getFrom "vault" "/secret/db/password"}}`,
+	)
+	tAssert(t, err == nil, err)
+
+	var buf bytes.Buffer
+	tAssert(t, tmpl.Execute(&buf, nil) == nil)
+	tAssert(t, buf.String() == "123456 /secret/db/password=123456 /secret/db/user=root123456", buf.String())
+	tAssert(t, len(vault.keys) == 2, vault.keys)
+
+	_, err = fn.GetFrom("vault", "/secret/db/host")
+	tAssert(t, err != nil && strings.Contains(err.Error(), "not found"), err)
+	_, err = fn.GetFrom("consul", "/secret/db/user")
+	tAssert(t, err != nil && strings.Contains(err.Error(), `backend "consul" not found`), err)
+
+	fn.backends.reset()
+	_, err = fn.GetFrom("vault", "/secret/db/password")
+	tAssert(t, err == nil, err)
+	tAssert(t, len(vault.keys) == 4, vault.keys)
+}
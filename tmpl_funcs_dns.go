@@ -0,0 +1,134 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDNSTimeout is the timeout of a DNS lookup of the template
+// funcs if Config.DNSTimeout is not set.
+const DefaultDNSTimeout = 5 * time.Second
+
+// templateDNS resolves the DNS lookups of the template funcs, the results
+// are cached until reset, which is called at the start of every cycle.
+type templateDNS struct {
+	mu       sync.Mutex
+	resolver *net.Resolver
+	timeout  time.Duration
+	ips      map[string]dnsResult
+	srvs     map[string]dnsResult
+}
+
+type dnsResult struct {
+	value interface{}
+	err   error
+}
+
+func newTemplateDNS() *templateDNS {
+	return &templateDNS{
+		resolver: net.DefaultResolver,
+		timeout:  DefaultDNSTimeout,
+		ips:      make(map[string]dnsResult),
+		srvs:     make(map[string]dnsResult),
+	}
+}
+
+// setServer uses the DNS server (host:port) instead of the system
+// resolver if server is not empty.
+func (p *templateDNS) setServer(server string, timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultDNSTimeout
+	}
+	p.timeout = timeout
+
+	if server == "" {
+		p.resolver = net.DefaultResolver
+		return
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	p.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// reset drops the cached results.
+func (p *templateDNS) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ips = make(map[string]dnsResult)
+	p.srvs = make(map[string]dnsResult)
+}
+
+// lookupIP returns the sorted IPs of host.
+func (p *templateDNS) lookupIP(host string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r, ok := p.ips[host]; ok {
+		ips, _ := r.value.([]string)
+		return ips, r.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var ipStrings []string
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err == nil {
+		ipStrings = make([]string, len(addrs))
+		for i, addr := range addrs {
+			ipStrings[i] = addr.IP.String()
+		}
+		sort.Strings(ipStrings)
+	}
+
+	p.ips[host] = dnsResult{value: ipStrings, err: err}
+	return ipStrings, err
+}
+
+// lookupSRV returns the sorted SRV records of the service.
+func (p *templateDNS) lookupSRV(service, proto, name string) ([]*net.SRV, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := fmt.Sprintf("_%s._%s.%s", service, proto, name)
+	if r, ok := p.srvs[key]; ok {
+		srvs, _ := r.value.([]*net.SRV)
+		return srvs, r.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, s, err := p.resolver.LookupSRV(ctx, service, proto, name)
+	if err == nil {
+		sort.Slice(s, func(i, j int) bool {
+			str1 := fmt.Sprintf("%s%d%d%d", s[i].Target, s[i].Port, s[i].Priority, s[i].Weight)
+			str2 := fmt.Sprintf("%s%d%d%d", s[j].Target, s[j].Port, s[j].Priority, s[j].Weight)
+			return str1 < str2
+		})
+	} else {
+		s = nil
+	}
+
+	p.srvs[key] = dnsResult{value: s, err: err}
+	return s, err
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplateDNS(t *testing.T) {
+	dns := newTemplateDNS()
+
+	ips, err := dns.lookupIP("localhost")
+	tAssert(t, err == nil, err)
+	tAssert(t, len(ips) > 0, ips)
+	tAssert(t, len(dns.ips) == 1)
+
+	// the unreachable server fails the lookups not cached
+	dns.setServer("127.0.0.1:1", time.Second)
+	cached, err := dns.lookupIP("localhost")
+	tAssert(t, err == nil, err)
+	tAssert(t, len(cached) == len(ips), cached)
+
+	_, err = dns.lookupIP("libconfd.invalid")
+	tAssert(t, err != nil)
+	_, err = dns.lookupSRV("http", "tcp", "libconfd.invalid")
+	tAssert(t, err != nil)
+
+	dns.reset()
+	tAssert(t, len(dns.ips) == 0 && len(dns.srvs) == 0)
+
+	fn := NewTemplateFunc(NewKVStore(), nil)
+	fn.dns.setServer("127.0.0.1:1", time.Second)
+	tAssert(t, fn.LookupIP("libconfd.invalid") == nil)
+	_, err = fn.MustLookupIP("libconfd.invalid")
+	tAssert(t, err != nil)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The profiles of the template funcs.
+const (
+	// all the template funcs
+	FuncProfileDefault = "default"
+
+	// the funcs reading the host, such as getenv, fileExists and the
+	// DNS lookups, are disabled, for the template authors not trusted
+	FuncProfileRestricted = "restricted"
+)
+
+// restrictedFuncs are the funcs disabled by FuncProfileRestricted.
+var restrictedFuncs = []string{
+	"fileExists",
+	"getenv",
+	"lookupIP",
+	"lookupSRV",
+	"mustLookupIP",
+	"mustLookupSRV",
+}
+
+// RestrictedFuncs returns the names of the template funcs disabled by
+// FuncProfileRestricted.
+func RestrictedFuncs() []string {
+	names := append([]string{}, restrictedFuncs...)
+	sort.Strings(names)
+	return names
+}
+
+func validFuncProfile(profile string) error {
+	switch profile {
+	case "", FuncProfileDefault, FuncProfileRestricted:
+		return nil
+	}
+	return fmt.Errorf("invalid func profile %q", profile)
+}
+
+// applyFuncProfile replaces the funcs disabled by the profile, the
+// templates still parse, but fail when the funcs are called.
+func applyFuncProfile(m map[string]interface{}, profile string) {
+	if profile != FuncProfileRestricted {
+		return
+	}
+	for _, name := range restrictedFuncs {
+		name := name
+		m[name] = func(...interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("template func %s is disabled by the %s profile", name, profile)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFuncProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-profile-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"conf.d/env.toml": `
+[template]
+src = "env.tmpl"
+dest = "env.conf"
+keys = ["/app"]
+`,
+		"conf.d/restricted.toml": `
+[template]
+src = "env.tmpl"
+dest = "restricted.conf"
+keys = ["/app"]
+func_profile = "restricted"
+`,
+		"conf.d/bad-profile.toml": `
+[template]
+src = "env.tmpl"
+dest = "bad.conf"
+func_profile = "sandbox"
+`,
+		"templates/env.tmpl": `home = {{getenv "HOME"}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		tAssert(t, os.MkdirAll(filepath.Dir(path), 0755) == nil)
+		tAssert(t, ioutil.WriteFile(path, []byte(content), 0644) == nil)
+	}
+
+	values := map[string]string{"/app/port": "80"}
+
+	errs := CheckTemplateResources(&Config{ConfDir: dir}, values)
+	tAssert(t, len(errs) == 2, errs)
+	tAssert(t, strings.Contains(errs[0].Error(), `invalid func profile "sandbox"`), errs[0])
+	tAssert(t, strings.Contains(errs[1].Error(), "template func getenv is disabled by the restricted profile"), errs[1])
+
+	errs = CheckTemplateResources(&Config{ConfDir: dir, FuncProfile: FuncProfileRestricted}, values)
+	tAssert(t, len(errs) == 3, errs)
+
+	cfg := newDefaultConfig()
+	cfg.ConfDir, cfg.FuncProfile = dir, "sandbox"
+	err = cfg.Valid()
+	tAssert(t, err != nil && strings.Contains(err.Error(), "sandbox"), err)
+	tAssert(t, len(RestrictedFuncs()) == len(restrictedFuncs))
+}
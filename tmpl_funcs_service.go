@@ -0,0 +1,207 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ServiceCatalog is an optional interface implemented by the backends
+// having a service catalog, such as consul and kubernetes, it is read by
+// the service and services template funcs.
+type ServiceCatalog interface {
+	// Services returns the names of the services and their tags.
+	Services() (map[string][]string, error)
+
+	// ServiceInstances returns the healthy instances of the service.
+	ServiceInstances(name string) ([]ServiceInstance, error)
+}
+
+// ServiceInstance is an instance of a service in the ServiceCatalog.
+type ServiceInstance struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Node    string            `json:"node"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags"`
+	Meta    map[string]string `json:"meta"`
+}
+
+// HasTag reports whether the instance has the tag.
+func (p ServiceInstance) HasTag(tag string) bool {
+	return strInStrList(tag, p.Tags)
+}
+
+// CatalogService is a service in the ServiceCatalog.
+type CatalogService struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// templateCatalog is the ServiceCatalog read by service and services,
+// the results are cached until reset, which is called at the start of
+// every cycle.
+type templateCatalog struct {
+	mu        sync.Mutex
+	client    BackendClient
+	services  []CatalogService
+	instances map[string][]ServiceInstance
+}
+
+func newTemplateCatalog() *templateCatalog {
+	return &templateCatalog{
+		instances: make(map[string][]ServiceInstance),
+	}
+}
+
+func (p *templateCatalog) setClient(client BackendClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.client = client
+	p.services = nil
+	p.instances = make(map[string][]ServiceInstance)
+}
+
+// reset drops the cached results.
+func (p *templateCatalog) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.services = nil
+	p.instances = make(map[string][]ServiceInstance)
+}
+
+func (p *templateCatalog) catalog() (ServiceCatalog, error) {
+	if c, ok := getServiceCatalog(p.client); ok {
+		return c, nil
+	}
+	if p.client == nil {
+		return nil, fmt.Errorf("backend has no service catalog")
+	}
+	return nil, fmt.Errorf("backend %s has no service catalog", p.client.Type())
+}
+
+func (p *templateCatalog) getServices() ([]CatalogService, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.services != nil {
+		return p.services, nil
+	}
+
+	c, err := p.catalog()
+	if err != nil {
+		return nil, err
+	}
+	m, err := c.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]CatalogService, 0, len(m))
+	for name, tags := range m {
+		tags = append([]string{}, tags...)
+		sort.Strings(tags)
+		services = append(services, CatalogService{Name: name, Tags: tags})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	p.services = services
+	return services, nil
+}
+
+func (p *templateCatalog) getInstances(name string) ([]ServiceInstance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v, ok := p.instances[name]; ok {
+		return v, nil
+	}
+
+	c, err := p.catalog()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := c.ServiceInstances(name)
+	if err != nil {
+		return nil, fmt.Errorf("service %q: %v", name, err)
+	}
+
+	instances = append([]ServiceInstance{}, instances...)
+	sort.SliceStable(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if a.Address != b.Address {
+			return a.Address < b.Address
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.ID < b.ID
+	})
+	p.instances[name] = instances
+	return instances, nil
+}
+
+// getServiceCatalog returns the ServiceCatalog of the client, the clients
+// wrapped by libconfd are unwrapped.
+func getServiceCatalog(client BackendClient) (ServiceCatalog, bool) {
+	for ; client != nil; client = unwrapBackendClient(client) {
+		if c, ok := backendClientImpl(client).(ServiceCatalog); ok {
+			return c, true
+		}
+		if x, ok := client.(*MultiBackendClient); ok {
+			for _, c := range x.layers {
+				if catalog, ok := getServiceCatalog(c); ok {
+					return catalog, true
+				}
+			}
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Service returns the instances of the service having all the tags,
+// sorted by address and port. The backend must be a ServiceCatalog.
+//
+// Example:
+//
+//	upstream web {
+//	{{range service "web" "primary"}}
+//	    server {{.Address}}:{{.Port}};
+//	{{end}}
+//	}
+func (p TemplateFunc) Service(name string, tags ...string) ([]ServiceInstance, error) {
+	instances, err := p.catalog.getInstances(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected = make([]ServiceInstance, 0, len(instances))
+	for _, x := range instances {
+		ok := true
+		for _, tag := range tags {
+			if !x.HasTag(tag) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			selected = append(selected, x)
+		}
+	}
+	return selected, nil
+}
+
+// Services returns the services of the catalog with their tags, sorted
+// by name. The backend must be a ServiceCatalog.
+func (p TemplateFunc) Services() ([]CatalogService, error) {
+	return p.catalog.getServices()
+}
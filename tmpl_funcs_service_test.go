@@ -0,0 +1,69 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// tCatalogClient is a backend with a service catalog.
+type tCatalogClient struct {
+	mapBackendClient
+	services  map[string][]string
+	instances map[string][]ServiceInstance
+	calls     int
+}
+
+func (p *tCatalogClient) Services() (map[string][]string, error) {
+	p.calls++
+	return p.services, nil
+}
+
+func (p *tCatalogClient) ServiceInstances(name string) ([]ServiceInstance, error) {
+	p.calls++
+	return p.instances[name], nil
+}
+
+func TestServiceCatalog(t *testing.T) {
+	client := &tCatalogClient{
+		services: map[string][]string{
+			"web": {"primary", "http"},
+			"db":  nil,
+		},
+		instances: map[string][]ServiceInstance{
+			"web": {
+				{ID: "web-2", Name: "web", Address: "10.0.0.2", Port: 80, Tags: []string{"http"}},
+				{ID: "web-1", Name: "web", Address: "10.0.0.1", Port: 80, Tags: []string{"http", "primary"}},
+			},
+		},
+	}
+
+	fn := NewTemplateFunc(NewKVStore(), nil)
+	fn.catalog.setClient(&snapshotRecorder{BackendClient: client})
+
+	tmpl, err := template.New("").Funcs(fn.FuncMap).Parse(
+		`{{range services}}{{.Name}}{{range .Tags}} {{.}}{{end}};{{end}}` +
+			`{{range service "web"}} {{.Address}}:{{.Port}}{{end}};` +
+			`{{range service "web" "primary"}}{{.ID}}{{end}}`,
+	)
+	tAssert(t, err == nil, err)
+
+	var buf bytes.Buffer
+	tAssert(t, tmpl.Execute(&buf, nil) == nil)
+	tAssert(t, buf.String() == "db;web http primary; 10.0.0.1:80 10.0.0.2:80;web-1", buf.String())
+	tAssert(t, client.calls == 2, client.calls)
+
+	fn.catalog.reset()
+	instances, err := fn.Service("db")
+	tAssert(t, err == nil && len(instances) == 0, err, instances)
+	tAssert(t, client.calls == 3, client.calls)
+
+	fn.catalog.setClient(mapBackendClient(nil))
+	_, err = fn.Services()
+	tAssert(t, err != nil && strings.Contains(err.Error(), "no service catalog"), err)
+}
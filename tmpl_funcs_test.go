@@ -0,0 +1,87 @@
+// Copyright 2018 The OpenPitrix Authors. All rights reserved.
+// Use of this source code is governed by a Apache license
+// that can be found in the LICENSE file.
+
+package libconfd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestGetallkv(t *testing.T) {
+	store := NewKVStore()
+	store.Set("/db/user", "root")
+	store.Set("/app/port", "80")
+	store.Set("/app", "web")
+	store.Set("/app/tls/cert", "a.pem")
+
+	fn := NewTemplateFunc(store, nil)
+
+	tmpl, err := template.New("").Funcs(fn.FuncMap).Parse(
+		`{{range getallkv}}{{.Key}}={{.Value}};{{end}}` +
+			`{{with getallkvTree}}{{index .app ""}} {{.app.port}} {{.app.tls.cert}} {{.db.user}}{{end}}`,
+	)
+	tAssert(t, err == nil, err)
+
+	var buf bytes.Buffer
+	tAssert(t, tmpl.Execute(&buf, nil) == nil)
+	tAssert(t, buf.String() == "/app=web;/app/port=80;/app/tls/cert=a.pem;/db/user=root;web 80 a.pem root", buf.String())
+}
+
+func TestChangedKeys(t *testing.T) {
+	store := NewKVStore()
+	fn := NewTemplateFunc(store, nil)
+	fn.Redactor = NewRedactor("password")
+	_TemplateFunc_initFuncMap(fn)
+
+	tmpl, err := template.New("").Funcs(fn.FuncMap).Parse(
+		`{{join changedKeys ","}}|{{range keyChanges}}{{.}};{{end}}|` +
+			`{{keyChanged "/db"}} {{keyChanged "/app"}} {{keyChanged "/app/port"}}`,
+	)
+	tAssert(t, err == nil, err)
+
+	render := func() string {
+		var buf bytes.Buffer
+		tAssert(t, tmpl.Execute(&buf, nil) == nil)
+		return buf.String()
+	}
+	tAssert(t, render() == "||false false false", render())
+
+	fn.changes.set([]KeyChange{
+		{Type: KeyModified, Key: "/app/port", OldValue: "80", NewValue: "8080"},
+		{Type: KeyAdded, Key: "/db/password", NewValue: "secret"},
+	})
+	s := render()
+	tAssert(t, s == `/app/port,/db/password|~ /app/port = "80" => "8080";+ /db/password = "******";|true true true`, s)
+}
+
+func TestConfigHash(t *testing.T) {
+	store := NewKVStore()
+	store.Set("/app/port", "80")
+	store.Set("/db/user", "root")
+	fn := NewTemplateFunc(store, nil)
+
+	tmpl, err := template.New("").Funcs(fn.FuncMap).Parse(`{{configHash}} {{configHash "/app"}} {{configHash "app/"}}`)
+	tAssert(t, err == nil, err)
+
+	render := func() []string {
+		var buf bytes.Buffer
+		tAssert(t, tmpl.Execute(&buf, nil) == nil)
+		return strings.Fields(buf.String())
+	}
+
+	h := render()
+	tAssert(t, len(h) == 3 && len(h[0]) == 64 && h[0] != h[1] && h[1] == h[2], h)
+	tAssert(t, strings.Join(render(), " ") == strings.Join(h, " "))
+
+	// the hash of /app is not changed by /db
+	store.Set("/db/user", "admin")
+	h2 := render()
+	tAssert(t, h2[0] != h[0] && h2[1] == h[1], h, h2)
+
+	store.Set("/app/port", "8080")
+	tAssert(t, render()[1] != h[1])
+}
@@ -22,6 +22,8 @@ func init() {
 			"cgets":          p.Cgets,
 			"cgetv":          p.Cgetv,
 			"cgetvs":         p.Cgetvs,
+			"changedKeys":    p.ChangedKeys,
+			"configHash":     p.ConfigHash,
 			"contains":       p.Contains,
 			"datetime":       p.Datetime,
 			"dir":            p.Dir,
@@ -29,13 +31,19 @@ func init() {
 			"exists":         p.Exists,
 			"fileExists":     p.FileExists,
 			"get":            p.Get,
+			"getFrom":        p.GetFrom,
+			"getallkv":       p.Getallkv,
+			"getallkvTree":   p.GetallkvTree,
 			"getenv":         p.Getenv,
 			"gets":           p.Gets,
+			"getsFrom":       p.GetsFrom,
 			"getv":           p.Getv,
 			"getvs":          p.Getvs,
 			"join":           p.Join,
 			"json":           p.Json,
 			"jsonArray":      p.JsonArray,
+			"keyChanged":     p.KeyChanged,
+			"keyChanges":     p.KeyChanges,
 			"lookupIP":       p.LookupIP,
 			"lookupSRV":      p.LookupSRV,
 			"ls":             p.Ls,
@@ -43,10 +51,14 @@ func init() {
 			"map":            p.Map,
 			"mod":            p.Mod,
 			"mul":            p.Mul,
+			"mustLookupIP":   p.MustLookupIP,
+			"mustLookupSRV":  p.MustLookupSRV,
 			"parseBool":      p.ParseBool,
 			"replace":        p.Replace,
 			"reverse":        p.Reverse,
 			"seq":            p.Seq,
+			"service":        p.Service,
+			"services":       p.Services,
 			"sortByLength":   p.SortByLength,
 			"sortKVByLength": p.SortKVByLength,
 			"split":          p.Split,
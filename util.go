@@ -5,7 +5,12 @@
 package libconfd
 
 import (
+	"crypto/md5"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 )
@@ -18,6 +23,67 @@ type fileInfo struct {
 	Md5  string
 }
 
+// readFileStat return a fileInfo describing the named file, the
+// contents are hashed by streaming.
+func readFileStat(name string) (fi fileInfo, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return fi, err
+	}
+	defer f.Close()
+
+	if fi, err = statFile(f); err != nil {
+		return
+	}
+
+	h := md5.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return
+	}
+
+	fi.Md5 = fmt.Sprintf("%x", h.Sum(nil))
+	return fi, nil
+}
+
+// copyFile copies the contents of src to dest by streaming, dest is
+// created with perm if not exists, like ioutil.WriteFile.
+func copyFile(src, dest string, perm os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// CompareFiles compares the contents, the mode and the owner (uid and
+// gid) of the files a and b. The owner is always equal on windows.
+func CompareFiles(a, b string) (contentEqual, modeEqual, ownerEqual bool, err error) {
+	fa, err := readFileStat(a)
+	if err != nil {
+		return false, false, false, err
+	}
+	fb, err := readFileStat(b)
+	if err != nil {
+		return false, false, false, err
+	}
+
+	contentEqual = fa.Md5 == fb.Md5
+	modeEqual = fa.Mode == fb.Mode
+	ownerEqual = fa.Uid == fb.Uid && fa.Gid == fb.Gid
+	return
+}
+
 func dirExists(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -69,3 +135,37 @@ func strInStrList(s string, ss []string) bool {
 	}
 	return false
 }
+
+var reEnvVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnvVars replaces ${VAR} and ${VAR:-default} in s by the
+// environment variables, the undefined ${VAR} and the names of keep
+// are left as is, so they can still be handled by the shell or later.
+func expandEnvVars(s string, keep ...string) string {
+	return reEnvVar.ReplaceAllStringFunc(s, func(m string) string {
+		sub := reEnvVar.FindStringSubmatch(m)
+		if strInStrList(sub[1], keep) {
+			return m
+		}
+		if v, ok := os.LookupEnv(sub[1]); ok {
+			return v
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+		return m
+	})
+}
+
+// isAbsPath is like filepath.IsAbs, but the rooted paths without drive
+// letter, such as \app\app.conf or /app/app.conf, are absolute on
+// windows, they are on the current drive.
+func isAbsPath(path string) bool {
+	if filepath.IsAbs(path) {
+		return true
+	}
+	if runtime.GOOS == "windows" && filepath.VolumeName(path) == "" {
+		return strings.HasPrefix(path, `\`) || strings.HasPrefix(path, "/")
+	}
+	return false
+}
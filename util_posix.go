@@ -7,21 +7,14 @@
 package libconfd
 
 import (
-	"crypto/md5"
 	"fmt"
-	"io"
 	"os"
 	"syscall"
+	"time"
 )
 
-// readFileStat return a fileInfo describing the named file.
-func readFileStat(name string) (fi fileInfo, err error) {
-	f, err := os.Open(name)
-	if err != nil {
-		return fi, err
-	}
-	defer f.Close()
-
+// statFile returns a fileInfo of f without the Md5.
+func statFile(f *os.File) (fi fileInfo, err error) {
 	stats, err := f.Stat()
 	if err != nil {
 		return
@@ -30,13 +23,29 @@ func readFileStat(name string) (fi fileInfo, err error) {
 	fi.Uid = stats.Sys().(*syscall.Stat_t).Uid
 	fi.Gid = stats.Sys().(*syscall.Stat_t).Gid
 	fi.Mode = stats.Mode()
+	return fi, nil
+}
 
-	h := md5.New()
-	_, err = io.Copy(h, f)
-	if err != nil {
-		return
+// tryLockFile takes the exclusive flock of f without blocking, ok is
+// false if the lock is held by another process.
+func tryLockFile(f *os.File) (ok bool, err error) {
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
 	}
+	return err == nil, err
+}
 
-	fi.Md5 = fmt.Sprintf("%x", h.Sum(nil))
-	return fi, nil
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// renameFile renames src to dest, replacing dest.
+func renameFile(src, dest string) error {
+	return os.Rename(src, dest)
+}
+
+// restartService is only supported on windows, use reload_cmd instead.
+func restartService(name string, timeout time.Duration) error {
+	return fmt.Errorf("libconfd: reload_service %s is only supported on windows", name)
 }
@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"testing"
 )
 
 //
@@ -115,3 +117,51 @@ func tCreateRecursiveDirs() (rootDir string, exceptedFiles []string, err error)
 
 	return rootDir, exceptedFiles, nil
 }
+
+func TestCompareFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libconfd-compare-")
+	tAssert(t, err == nil, err)
+	defer os.RemoveAll(dir)
+
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	tAssert(t, ioutil.WriteFile(a, []byte("x"), 0644) == nil)
+	tAssert(t, ioutil.WriteFile(b, []byte("x"), 0644) == nil)
+
+	contentEqual, modeEqual, ownerEqual, err := CompareFiles(a, b)
+	tAssert(t, err == nil, err)
+	tAssert(t, contentEqual && modeEqual && ownerEqual)
+
+	if runtime.GOOS != "windows" {
+		tAssert(t, os.Chmod(b, 0600) == nil)
+		contentEqual, modeEqual, ownerEqual, err = CompareFiles(a, b)
+		tAssert(t, err == nil, err)
+		tAssert(t, contentEqual && !modeEqual && ownerEqual)
+	}
+
+	tAssert(t, ioutil.WriteFile(b, []byte("y"), 0600) == nil)
+	contentEqual, _, _, err = CompareFiles(a, b)
+	tAssert(t, err == nil, err)
+	tAssert(t, !contentEqual)
+
+	_, _, _, err = CompareFiles(a, filepath.Join(dir, "c"))
+	tAssert(t, os.IsNotExist(err), err)
+}
+
+func TestExpandEnvVars(t *testing.T) {
+	os.Setenv("LIBCONFD_TEST_APP", "nginx")
+	defer os.Unsetenv("LIBCONFD_TEST_APP")
+	os.Unsetenv("LIBCONFD_TEST_UNDEFINED")
+
+	for i, v := range [][2]string{
+		{"/etc/${LIBCONFD_TEST_APP}/app.conf", "/etc/nginx/app.conf"},
+		{"systemctl reload ${LIBCONFD_TEST_APP}", "systemctl reload nginx"},
+		{"${LIBCONFD_TEST_UNDEFINED:-/tmp}/app.conf", "/tmp/app.conf"},
+		{"${LIBCONFD_TEST_APP:-apache}", "nginx"},
+		{"${LIBCONFD_TEST_UNDEFINED}/app.conf", "${LIBCONFD_TEST_UNDEFINED}/app.conf"},
+		{"make -C ${LIBCONFD_CONFDIR}/apps", "make -C ${LIBCONFD_CONFDIR}/apps"},
+		{"$LIBCONFD_TEST_APP", "$LIBCONFD_TEST_APP"},
+	} {
+		s := expandEnvVars(v[0], "LIBCONFD_CONFDIR")
+		tAssert(t, s == v[1], i, s)
+	}
+}
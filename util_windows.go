@@ -5,33 +5,164 @@
 package libconfd
 
 import (
-	"crypto/md5"
 	"fmt"
-	"io"
 	"os"
+	"syscall"
+	"time"
+	"unsafe"
 )
 
-// readFileStat return a fileInfo describing the named file.
-func readFileStat(name string) (fi fileInfo, err error) {
-	f, err := os.Open(name)
+// statFile returns a fileInfo of f without the Md5.
+func statFile(f *os.File) (fi fileInfo, err error) {
+	stats, err := f.Stat()
 	if err != nil {
 		return
 	}
-	defer f.Close()
 
-	stats, err := f.Stat()
+	fi.Mode = stats.Mode()
+	return fi, nil
+}
+
+// tryLockFile does nothing, the file lock is not supported on windows.
+func tryLockFile(f *os.File) (ok bool, err error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procReplaceFileW = modkernel32.NewProc("ReplaceFileW")
+
+	modadvapi32            = syscall.NewLazyDLL("advapi32.dll")
+	procOpenSCManagerW     = modadvapi32.NewProc("OpenSCManagerW")
+	procOpenServiceW       = modadvapi32.NewProc("OpenServiceW")
+	procControlService     = modadvapi32.NewProc("ControlService")
+	procQueryServiceStatus = modadvapi32.NewProc("QueryServiceStatus")
+	procStartServiceW      = modadvapi32.NewProc("StartServiceW")
+	procCloseServiceHandle = modadvapi32.NewProc("CloseServiceHandle")
+)
+
+const (
+	errorSharingViolation = syscall.Errno(32)
+	errorLockViolation    = syscall.Errno(33)
+	errorServiceNotActive = syscall.Errno(1062)
+
+	replaceFileIgnoreMergeErrors = 0x2
+
+	scManagerConnect = 0x0001
+
+	serviceQueryStatus = 0x0004
+	serviceStart       = 0x0010
+	serviceStop        = 0x0020
+
+	serviceControlStop = 1
+	serviceStopped     = 1
+)
+
+// renameFile renames src to dest, replacing dest. If dest is opened by
+// another process, it retries with ReplaceFile for a while.
+func renameFile(src, dest string) error {
+	err := os.Rename(src, dest)
+	for i := 1; err != nil && i <= 5 && isFileLockedError(err); i++ {
+		time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+		if err = replaceFile(src, dest); err != nil && os.IsNotExist(err) {
+			err = os.Rename(src, dest)
+		}
+	}
+	return err
+}
+
+func isFileLockedError(err error) bool {
+	if e, ok := err.(*os.LinkError); ok {
+		err = e.Err
+	}
+	switch err {
+	case syscall.ERROR_ACCESS_DENIED, errorSharingViolation, errorLockViolation:
+		return true
+	}
+	return false
+}
+
+// replaceFile replaces dest by src with the ReplaceFile api.
+func replaceFile(src, dest string) error {
+	pdest, err := syscall.UTF16PtrFromString(dest)
 	if err != nil {
-		return
+		return err
 	}
+	psrc, err := syscall.UTF16PtrFromString(src)
+	if err != nil {
+		return err
+	}
+	r1, _, e1 := procReplaceFileW.Call(
+		uintptr(unsafe.Pointer(pdest)), uintptr(unsafe.Pointer(psrc)), 0,
+		replaceFileIgnoreMergeErrors, 0, 0,
+	)
+	if r1 == 0 {
+		if e1 == syscall.ERROR_FILE_NOT_FOUND {
+			return &os.PathError{Op: "replace", Path: dest, Err: e1}
+		}
+		return &os.LinkError{Op: "replace", Old: src, New: dest, Err: e1}
+	}
+	return nil
+}
 
-	fi.Mode = stats.Mode()
+// serviceStatus is the SERVICE_STATUS of the service manager api.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
 
-	h := md5.New()
-	_, err = io.Copy(h, f)
+// restartService stops the windows service, waits at most timeout for
+// it to stop, and starts it again.
+func restartService(name string, timeout time.Duration) error {
+	scm, _, e1 := procOpenSCManagerW.Call(0, 0, scManagerConnect)
+	if scm == 0 {
+		return fmt.Errorf("libconfd: open service manager: %v", e1)
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	pname, err := syscall.UTF16PtrFromString(name)
 	if err != nil {
-		return
+		return err
 	}
+	h, _, e1 := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(pname)),
+		serviceQueryStatus|serviceStart|serviceStop,
+	)
+	if h == 0 {
+		return fmt.Errorf("libconfd: open service %s: %v", name, e1)
+	}
+	defer procCloseServiceHandle.Call(h)
 
-	fi.Md5 = fmt.Sprintf("%x", h.Sum(nil))
-	return fi, nil
+	var status serviceStatus
+	r1, _, e1 := procControlService.Call(h, serviceControlStop, uintptr(unsafe.Pointer(&status)))
+	if r1 == 0 && e1 != errorServiceNotActive {
+		return fmt.Errorf("libconfd: stop service %s: %v", name, e1)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for status.CurrentState != serviceStopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("libconfd: stop service %s: timeout after %v", name, timeout)
+		}
+		time.Sleep(time.Second / 4)
+
+		r1, _, e1 := procQueryServiceStatus.Call(h, uintptr(unsafe.Pointer(&status)))
+		if r1 == 0 {
+			return fmt.Errorf("libconfd: query service %s: %v", name, e1)
+		}
+	}
+
+	r1, _, e1 = procStartServiceW.Call(h, 0, 0)
+	if r1 == 0 {
+		return fmt.Errorf("libconfd: start service %s: %v", name, e1)
+	}
+	return nil
 }